@@ -0,0 +1,736 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+var (
+	configPath         = flag.String("config", "", "config path")
+	configRemote       = flag.String("config.remote", "", "url of the nutcracker config on every target host, fetched on startup and reload, e.g. ssh://{host}/etc/nutcracker.yml or http://{host}:8080/nutcracker.yml")
+	configLenient      = flag.Bool("config.lenient", false, "skip the invalid pools of the config instead of refusing to start")
+	twemphost          = flag.String("twemphost", "", "twemproxy host, comma separated to monitor more than one")
+	dnsTTL             = flag.Duration("dns.ttl", 0, "cache the addresses of the host names of the targets and the probes for ttl, e.g. 30s, every dial resolve them when 0")
+	dnsNegativeTTL     = flag.Duration("dns.negative-ttl", 0, "cache the failed lookups for ttl with -dns.ttl, failed lookups are retried on the next dial when 0")
+	sourceAddress      = flag.String("twemphost.source-address", "", "local IP or interface the stats connections are made from, chosen by the system when empty")
+	statsProxy         = flag.String("twemphost.proxy", "", "socks5://[user@]host:port or http://[user@]host:port proxy the stats connections go through, direct when empty")
+	statsProxyPassword = flag.String("twemphost.proxy.password-file", "", "file holding the password of the -twemphost.proxy user, or vault:<path>#<field>, re-read every -secrets.refresh-interval")
+	targetsPath        = flag.String("targets.file", "", "yaml file listing the twemproxy hosts to monitor")
+	targetsAPI         = flag.Bool("targets.api", false, "add and remove targets at runtime with POST /api/v1/targets and DELETE /api/v1/targets/{name}, protect it with -web.config")
+	targetsAPIPersist  = flag.Bool("targets.api-persist", false, "manage the targets of -targets.file through the API and rewrite the file on every change")
+	federateSites      = flag.String("federate.sites", "", "comma separated site=url of downstream exporters whose twemproxy metrics are re-exposed with a site label, e.g. edge-1=http://edge-1:9500/metrics")
+	shardFlag          = flag.String("shard", "", "index/total of this exporter among the replicas sharing the targets, e.g. 2/5")
+	shardMethod        = flag.String("shard.method", "modulo", "how targets are split between shards, modulo or rendezvous")
+	kubeTargets        = flag.String("targets.kubernetes", "", "comma separated kubernetes sources of targets, services and/or crd")
+	ec2Tag             = flag.String("targets.ec2.tag", "", "key=value tag of the EC2 instances to monitor, EC2 discovery is disabled when empty")
+	ec2Region          = flag.String("targets.ec2.region", "", "AWS region of the EC2 discovery, the region of this instance when empty")
+	ec2Port            = flag.Int("targets.ec2.port", 22222, "twemproxy stats port of the discovered EC2 instances")
+	dockerHost         = flag.String("targets.docker.host", "unix:///var/run/docker.sock", "docker daemon used by the docker discovery")
+	dockerImage        = flag.String("targets.docker.image", "", "regexp of the images of the docker containers to monitor")
+	dockerLabel        = flag.String("targets.docker.label", "", "label or label=value of the docker containers to monitor")
+	dockerPort         = flag.Int("targets.docker.port", 22222, "twemproxy stats port of the discovered docker containers")
+	nomadName          = flag.String("targets.nomad.service", "", "Nomad service registered by the twemproxy allocations, Nomad discovery is disabled when empty")
+	nomadTags          = flag.String("targets.nomad.tags", "", "comma separated tags the Nomad service must have")
+	nomadAddress       = flag.String("targets.nomad.address", "", "Nomad API address, NOMAD_ADDR or the local agent when empty")
+	nomadNS            = flag.String("targets.nomad.namespace", "", "Nomad namespace of the service")
+	refreshTime        = flag.Duration("targets.refresh-interval", time.Minute, "interval between refreshes of the polled target discoveries")
+	kubeTargetsNS      = flag.String("targets.kubernetes.namespace", "", "namespace to discover kubernetes targets in, all namespaces when empty")
+	interval           = flag.String("interval", "", "interval of scrap")
+	livenessTime       = flag.Duration("liveness.interval", 0, "interval of a TCP connect check driving twemproxy_up between the full scrapes, e.g. 5s, disabled when 0")
+	quarantineAfter    = flag.Int("quarantine.failures", 0, "consecutive failed scrapes after which a target is only retried every -quarantine.interval, disabled when 0")
+	quarantineInterval = flag.Duration("quarantine.interval", time.Minute*5, "scrape interval of the quarantined targets")
+	probeConfig        = flag.String("probe.config", "", "path to the probe config file with the redis_auth and TLS of the listen and backend probes by pool")
+	webConfig          = flag.String("web.config", "", "path to the web config file for TLS and client certificate auth")
+	webListen          = flag.String("web.listen-address", ":9500", "address to expose the metrics on")
+	webAllow           = flag.String("web.allow-cidr", "", "comma separated networks allowed to access the endpoints, all when empty")
+	webAccessLog       = flag.Bool("web.access-log", false, "log every request to the exporter endpoints")
+	webEnableReload    = flag.Bool("web.enable-reload", false, "serve POST /-/reload even when the web config does not authenticate the requests")
+	auditPath          = flag.String("audit.log", "", "file to write the audit log of admin endpoints to, stderr when empty")
+	runAsUser          = flag.String("user", "", "user to switch to once the listening socket is bound")
+	chrootDir          = flag.String("chroot", "", "directory to chroot into once the listening socket is bound")
+	configMap          = flag.String("config.kubernetes", "", "namespace/name/key of a ConfigMap with the nutcracker config, watched and hot reloaded")
+	pushURL            = flag.String("push.url", "", "Pushgateway to push the metrics to, push is disabled when empty")
+	pushJob            = flag.String("push.job", "twemproxy", "job name used when pushing")
+	pushInterval       = flag.Duration("push.interval", time.Second*15, "interval between pushes")
+	pushElection       = flag.String("push.leader-election", "", "elect a single pushing replica using kubernetes or file, disabled when empty")
+	pushLease          = flag.String("push.lease", "", "namespace/name of the kubernetes Lease for leader election")
+	pushLockFile       = flag.String("push.lock-file", "", "shared file to lock for leader election")
+	pushLeaseTTL       = flag.Duration("push.lease-duration", time.Second*15, "how long leadership is kept without renewal")
+	textfilePath       = flag.String("textfile.path", "", "*.prom file of the node_exporter textfile collector the metrics are written to, disabled when empty")
+	textfileInterval   = flag.Duration("textfile.interval", time.Second*15, "interval between textfile writes")
+	readyTimeout       = flag.Duration("web.readiness-timeout", 0, "report ready after this long even if some targets were never scraped, 0 waits forever")
+	rateMetrics        = flag.Bool("metrics.rates", false, "export per second rates computed from successive scrapes, for consumers without PromQL")
+	tsdbRetention      = flag.Duration("tsdb.retention", 0, "keep the samples of the last retention in memory for /api/v1/query_range, e.g. 6h, disabled when 0")
+	archiveDir         = flag.String("archive.dir", "", "directory appending every raw stats payload to rotating gzipped files, disabled when empty")
+	archiveFileSize    = flag.Int64("archive.file-size", 64<<20, "bytes of an archive file before rotating")
+	archiveMaxSize     = flag.Int64("archive.max-size", 1<<30, "bytes of all the archive files before removing the oldest")
+	archiveRetention   = flag.Duration("archive.retention", time.Hour*24*7, "age of the archive files before removing them")
+	uploadURL          = flag.String("archive.upload-url", "", "bucket the rotated archive files are uploaded to, s3://bucket/prefix or gs://bucket/prefix")
+	uploadEndpoint     = flag.String("archive.upload-endpoint", "", "endpoint of an S3 compatible store, AWS S3 when empty")
+	uploadRegion       = flag.String("archive.upload-region", "us-east-1", "region of the S3 bucket")
+	uploadInterval     = flag.Duration("archive.upload-interval", time.Minute*5, "how often the rotated archive files are uploaded")
+	dropStale          = flag.Bool("scrape.drop-stale", false, "stop exporting the values of a target when its scrape fail, instead of its last successful values")
+	historySize        = flag.Int("debug.history-size", 100, "last scrapes of every target kept for /debug/history")
+	tracingEndpoint    = flag.String("tracing.otlp-endpoint", "", "OTLP/HTTP traces URL of an OpenTelemetry collector the spans of every scrape are sent to, e.g. http://localhost:4318/v1/traces")
+	tracingService     = flag.String("tracing.service-name", "twemproxy_exporter", "service.name of the spans")
+	listenProbe        = flag.Bool("probe.listen", false, "dial the listen address of every pool on every scrape, exported as twemproxy_pool_listen_reachable")
+	backendKeysProbe   = flag.Bool("probe.backend-keys", false, "query the keys of every backend server of the config on every scrape, redis DBSIZE or memcache curr_items")
+	listenPing         = flag.Bool("probe.listen-ping", false, "with -probe.listen, also send a redis PING or a memcache get and wait for the reply")
+	unknownServers     = flag.Bool("metrics.unknown-servers", false, "export the servers in the stats but not in the config, with a configured label on every server metric")
+	serverLabelFlag    = flag.String("metrics.server-label", collector.DefaultServerLabel, "label of the backend servers, e.g. server when monitoring memcache pools")
+	proxyLabelFlag     = flag.String("metrics.proxy-label", "proxy", "label of the name of the targets from the targets file")
+	replicaLabel       = flag.String("metrics.replica", "", "value of a replica label added to every metric, for deduplication of exporters monitoring the same twemproxy")
+	dropHostLabels     = flag.Bool("metrics.drop-host-labels", false, "don't add the labels derived from the exporter host (pod, node, instance_id, zone and the hostname as instance), so the replicas export identical series")
+	poolDetailFlag     = flag.String("metrics.pool-detail", "", "comma separated pool=level, full, summary without the per server series or availability-only, the pools not listed are full")
+	topN               = flag.Int("metrics.top-n", 0, "export the top n servers of every pool by request rate, error rate and in queue requests, disabled when 0")
+	fleetMetrics       = flag.Bool("metrics.fleet", false, "export twemproxy_fleet_pool_* metrics summing every pool over all the targets")
+	availWindow        = flag.Duration("availability.window", 0, "window of the pool and server availability ratios, e.g. 720h for 30d, disabled when 0")
+	baselineState      = flag.String("baselines.state-file", "", "file persisting the last scrape of every target across restarts, so the rates continue where they stopped")
+	availState         = flag.String("availability.state-file", "", "file persisting the availability window across restarts")
+	downtimeState      = flag.String("downtime.state-file", "", "file persisting twemproxy_server_downtime_seconds_total across restarts, the downtime is tracked when set")
+	downtimeRetention  = flag.Duration("downtime.retention", time.Hour*24*35, "how long the downtime of a server no longer seen is kept")
+	anomalyAlpha       = flag.Float64("anomaly.alpha", 0, "weight of the newest sample in the moving averages of the anomaly scores, anomaly detection is disabled when 0")
+	anomalyDevs        = flag.Float64("anomaly.deviations", 3, "standard deviations from the moving average making a signal anomalous")
+	alertsConfig       = flag.String("alerts.config", "", "yaml file with the built-in alert rules and webhooks, alerting is disabled when empty")
+	kafkaBrokers       = flag.String("kafka.brokers", "", "comma separated host:port of Kafka brokers the target, server and pool state changes are published to, disabled when empty")
+	kafkaTopic         = flag.String("kafka.topic", "twemproxy-events", "Kafka topic of the state change events")
+	kafkaClientID      = flag.String("kafka.client-id", kafkaDefaultClient, "client id sent to the Kafka brokers")
+	kafkaPoolRatio     = flag.Float64("kafka.pool-unavailable-ratio", 0.5, "ratio of unavailable servers above which a pool_degraded event is published")
+	natsURL            = flag.String("nats.url", "", "nats://[user@]host:port of a NATS server the parsed stats of every pool are published to, disabled when empty")
+	natsPassword       = flag.String("nats.password-file", "", "file holding the password of the -nats.url user, or the token without user, or vault:<path>#<field>, re-read every -secrets.refresh-interval")
+	natsPrefix         = flag.String("nats.subject-prefix", "twemproxy.stats", "prefix of the subjects, the stats of a pool are published on <prefix>.<pool>")
+	zabbixConfig       = flag.String("zabbix.config", "", "yaml file with the Zabbix server and the item keys of the metrics to send, disabled when empty")
+	zabbixInterval     = flag.Duration("zabbix.interval", time.Minute, "interval between the sends to Zabbix")
+	heartbeatURL       = flag.String("heartbeat.url", "", "url of a dead man's switch, e.g. https://hc-ping.com/<uuid>, requested after successful scrapes, disabled when empty")
+	heartbeatInterval  = flag.Duration("heartbeat.interval", time.Minute, "minimum time between two heartbeats")
+	requireStartup     = flag.Bool("startup.require-scrape", false, "scrape every static target once at startup and exit when one fails")
+	startupGrace       = flag.Duration("startup.grace", 0, "exit when a static target was not scraped successfully within this long after startup, with -startup.require-scrape retry the startup scrape that long instead")
+	maintenancePath    = flag.String("maintenance.file", "", "yaml file of the maintenance windows, rewritten by the admin API")
+	maintenanceAPI     = flag.Bool("maintenance.api", false, "add and remove maintenance windows with POST and DELETE /api/v1/maintenance")
+	scrapeAPIInterval  = flag.Duration("web.scrape-min-interval", time.Second*5, "minimum time between two scrapes of a target forced with POST /-/scrape")
+	podInfoDir         = flag.String("kubernetes.podinfo-dir", sidecarPodInfoDir, "directory of the Downward API volume in sidecar mode")
+	cloudLabel         = flag.Bool("cloud.labels", false, "detect EC2 or GCE and label every metric with region, zone and instance_id")
+	sidecar            bool
+
+	twemphostTLS = TLSConfig{}
+	kafkaTLS     = TLSConfig{}
+
+	hostname string
+
+	// constLabels are attached to every twemproxy metric, e.g. the pod info in sidecar mode
+	constLabels = prometheus.Labels{}
+
+	// serverLabels of the server metrics, from -metrics.server-label and -metrics.unknown-servers
+	serverLabels collector.ServerLabels
+)
+
+func init() {
+	flag.BoolVar(&twemphostTLS.Enabled, "twemphost.tls", false, "connect to twemproxy stats using TLS")
+	flag.StringVar(&twemphostTLS.CAFile, "twemphost.tls-ca", "", "CA certificate to verify the stats endpoint")
+	flag.StringVar(&twemphostTLS.CertFile, "twemphost.tls-cert", "", "client certificate for the stats endpoint")
+	flag.StringVar(&twemphostTLS.KeyFile, "twemphost.tls-key", "", "client key for the stats endpoint")
+	flag.StringVar(&twemphostTLS.ServerName, "twemphost.tls-server-name", "", "server name to verify the stats endpoint certificate against")
+	flag.BoolVar(&twemphostTLS.InsecureSkipVerify, "twemphost.tls-insecure-skip-verify", false, "skip verification of the stats endpoint certificate")
+	flag.BoolVar(&kafkaTLS.Enabled, "kafka.tls", false, "connect to the Kafka brokers using TLS")
+	flag.StringVar(&kafkaTLS.CAFile, "kafka.tls-ca", "", "CA certificate to verify the Kafka brokers")
+	flag.StringVar(&kafkaTLS.CertFile, "kafka.tls-cert", "", "client certificate for the Kafka brokers")
+	flag.StringVar(&kafkaTLS.KeyFile, "kafka.tls-key", "", "client key for the Kafka brokers")
+	flag.StringVar(&kafkaTLS.ServerName, "kafka.tls-server-name", "", "server name to verify the Kafka broker certificates against, the broker host by default")
+	flag.BoolVar(&kafkaTLS.InsecureSkipVerify, "kafka.tls-insecure-skip-verify", false, "skip verification of the Kafka broker certificates")
+	flag.DurationVar(&config.SecretRefreshInterval, "secrets.refresh-interval", config.SecretRefreshInterval, "how often secrets from files and Vault are re-read")
+
+	flag.BoolVar(&sidecar, "kubernetes.sidecar", inKubernetesPod(sidecarPodInfoDir), "label metrics with the pod info from the Downward API, on by default inside a pod")
+
+	var err error
+	hostname, err = os.Hostname()
+	if err != nil {
+		hostname = "unknown_host"
+	}
+}
+
+func main() {
+	// subcommands have their own flag set
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "capture":
+			if err := runCapture(os.Args[2:]); err != nil {
+				log.Fatalf("Capture failed. Error: %s", err.Error())
+			}
+			return
+		case "mock":
+			if err := runMock(os.Args[2:]); err != nil {
+				log.Fatalf("Mock failed. Error: %s", err.Error())
+			}
+			return
+		case "gen-rules":
+			if err := runGenRules(os.Args[2:]); err != nil {
+				log.Fatalf("Cannot generate rules. Error: %s", err.Error())
+			}
+			return
+		case "gen-targets":
+			if err := runGenTargets(os.Args[2:]); err != nil {
+				log.Fatalf("Cannot generate targets. Error: %s", err.Error())
+			}
+			return
+		case "gen-dashboard":
+			if err := runGenDashboard(os.Args[2:]); err != nil {
+				log.Fatalf("Cannot generate dashboard. Error: %s", err.Error())
+			}
+			return
+		case "simulate":
+			if err := runSimulate(os.Args[2:]); err != nil {
+				log.Fatalf("Cannot simulate. Error: %s", err.Error())
+			}
+			return
+		case "check":
+			os.Exit(runCheck(os.Args[2:]))
+		case "check-config":
+			os.Exit(runCheckConfig(os.Args[2:]))
+		case "service":
+			if err := runService(os.Args[2:]); err != nil {
+				log.Fatalf("Service command failed. Error: %s", err.Error())
+			}
+			return
+		}
+	}
+
+	flag.Parse()
+	serverLabels = collector.ServerLabels{Server: *serverLabelFlag, Configured: *unknownServers}
+	proxyLabel = *proxyLabelFlag
+	if *dnsTTL > 0 {
+		hostCache = newDNSCache(*dnsTTL, *dnsNegativeTTL)
+	}
+	var proxyPassword *config.Secret
+	if *statsProxyPassword != "" {
+		var err error
+		proxyPassword, err = config.NewSecretRef(*statsProxyPassword)
+		if err != nil {
+			log.Fatalf("Cannot read the proxy password. Error: %s", err.Error())
+		}
+	}
+	dialer, err := newStatsDialer(*sourceAddress, *statsProxy, proxyPassword, hostCache)
+	if err != nil {
+		log.Fatalf("Cannot create the stats dialer. Error: %s", err.Error())
+	}
+	statsDialer = dialer
+	serviceStop, serviceFinish, err := startService()
+	if err != nil {
+		log.Fatalf("Cannot detect windows service. Error: %s", err.Error())
+	}
+	defer serviceFinish()
+	// canceled on shutdown, stop the uploads and notifications in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *tracingEndpoint != "" {
+		tracing = newTracer(*tracingEndpoint, *tracingService)
+		go tracing.exportEvery(ctx, time.Second*5)
+	}
+
+	// sidecar next to twemproxy should need zero flags
+	if sidecar {
+		for key, val := range kubernetesPodLabels(*podInfoDir) {
+			constLabels[key] = val
+		}
+		if *configPath == "" && *configMap == "" {
+			*configPath = sidecarConfigPath
+		}
+		log.Printf("Running as kubernetes sidecar with labels %v", constLabels)
+	}
+
+	if *cloudLabel {
+		labels := cloudLabels()
+		if len(labels) == 0 {
+			log.Println("No cloud metadata found, not adding cloud labels")
+		}
+		for key, val := range labels {
+			constLabels[key] = val
+		}
+	}
+
+	if *dropHostLabels {
+		for _, name := range hostLabelNames {
+			delete(constLabels, name)
+		}
+	}
+	if *replicaLabel != "" {
+		constLabels["replica"] = *replicaLabel
+	}
+
+	conf, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Cannot start twemproxy exporter. Err: %s", err.Error())
+	}
+	configReloaded(conf, nil)
+	log.Printf("Config: %+v", conf)
+
+	err = openAuditLog(*auditPath)
+	if err != nil {
+		log.Fatalf("Cannot open audit log. Error: %s", err.Error())
+	}
+
+	webConf, err := LoadWebConfig(*webConfig)
+	if err != nil {
+		log.Fatalf("Cannot load web config. Error: %s", err.Error())
+	}
+	probeConf, err = LoadProbeConfig(*probeConfig)
+	if err != nil {
+		log.Fatalf("Cannot load probe config. Error: %s", err.Error())
+	}
+	allowedNets, err := parseCIDRs(*webAllow)
+	if err != nil {
+		log.Fatalf("Cannot parse -web.allow-cidr %s. Error: %s", *webAllow, err.Error())
+	}
+
+	tickerDuration := time.Second * 3
+	if *interval != "" {
+		tickerDuration, err = time.ParseDuration(*interval)
+		if err != nil {
+			log.Fatalf("Cannot parse interval %s. Error: %s", *interval, err.Error())
+		}
+	}
+	tlsConfig, err := twemphostTLS.Build()
+	if err != nil {
+		log.Fatalf("Cannot create TLS config for twemproxy stats. Error: %s", err.Error())
+	}
+	instance := hostname
+	if *dropHostLabels {
+		// the address is the same on both exporters of an HA pair
+		instance = ""
+	}
+	targets, err := staticTargets(*twemphost, *targetsPath, instance)
+	if err != nil {
+		log.Fatalf("Cannot load targets. Error: %s", err.Error())
+	}
+	// don't fall back to localhost when every target is discovered
+	if *twemphost == "" && *targetsPath == "" && (*targetsAPI || *federateSites != "" || *kubeTargets != "" || *ec2Tag != "" || *nomadName != "" || dockerDiscoveryEnabled()) {
+		targets = nil
+	}
+	exporterShard, err := parseShard(*shardFlag, *shardMethod)
+	if err != nil {
+		log.Fatalf("Cannot parse shard. Error: %s", err.Error())
+	}
+	disabled := disabledGroups()
+	if disabled["process"] {
+		unregisterProcessMetrics()
+	}
+	monitorOpts, err := monitorOptions(disabled)
+	if err != nil {
+		log.Fatalf("Cannot parse -metrics.pool-detail. Error: %s", err.Error())
+	}
+	scheduler := NewScheduler(conf, tickerDuration, tlsConfig, monitorOpts...)
+	scheduler.shard = exporterShard
+	if *archiveDir != "" {
+		scheduler.archive, err = newArchiver(*archiveDir, *archiveFileSize, *archiveMaxSize, *archiveRetention)
+		if err != nil {
+			log.Fatalf("Cannot archive stats. Error: %s", err.Error())
+		}
+		defer scheduler.archive.Close()
+		if *uploadURL != "" {
+			uploader, err := newArchiveUploader(*archiveDir, *uploadURL, *uploadEndpoint, *uploadRegion)
+			if err != nil {
+				log.Fatalf("Cannot upload the archive. Error: %s", err.Error())
+			}
+			go uploader.uploadEvery(ctx, scheduler.archive, *uploadInterval)
+		}
+	}
+	if *configRemote != "" {
+		scheduler.remoteConfig, err = newRemoteConfig(*configRemote)
+		if err != nil {
+			log.Fatalf("Cannot fetch remote configs. Error: %s", err.Error())
+		}
+	}
+	err = prometheus.WrapRegistererWith(constLabels, prometheus.DefaultRegisterer).Register(scheduler)
+	if err != nil {
+		log.Fatal("Cannot register Twemproxy metrics ", err.Error())
+	}
+
+	if *configMap != "" {
+		err = watchConfigMapConfig(*configMap, scheduler)
+		if err != nil {
+			log.Fatalf("Cannot watch ConfigMap %s. Error: %s", *configMap, err.Error())
+		}
+	}
+
+	if *pushURL != "" {
+		elector, err := newLeaderElector(*pushElection, *pushLease, *pushLockFile, *pushLeaseTTL)
+		if err != nil {
+			log.Fatalf("Cannot start leader election. Error: %s", err.Error())
+		}
+		go pushMetrics(*pushURL, *pushJob, *pushInterval, elector)
+	}
+
+	if *zabbixConfig != "" {
+		zabbixConf, err := LoadZabbixConfig(*zabbixConfig)
+		if err != nil {
+			log.Fatalf("Cannot load Zabbix config. Error: %s", err.Error())
+		}
+		go sendZabbixEvery(zabbixConf, prometheus.DefaultGatherer, *zabbixInterval)
+	}
+
+	if *textfilePath != "" {
+		go writeTextfileEvery(*textfilePath, *textfileInterval)
+	}
+
+	// bind before anything else, so systemd only get READY=1 when we can serve
+	// socket activated units get the listening socket from systemd instead
+	var listener net.Listener
+	listeners, err := sdListeners()
+	if err != nil {
+		log.Fatalf("Cannot use sockets from systemd. Error: %s", err.Error())
+	}
+	if len(listeners) > 0 {
+		listener = listeners[0]
+		log.Printf("Using socket %s from systemd", listener.Addr())
+	} else {
+		listener, err = net.Listen("tcp", *webListen)
+		if err != nil {
+			log.Fatalf("Cannot listen on %s. Error: %s", *webListen, err.Error())
+		}
+	}
+
+	// everything needing privileges is done, the port is bound and the config is loaded
+	err = dropPrivileges(*runAsUser, *chrootDir)
+	if err != nil {
+		log.Fatalf("Cannot drop privileges. Error: %s", err.Error())
+	}
+
+	var maintenance *maintenanceSchedule
+	if *maintenancePath != "" || *maintenanceAPI {
+		maintenance, err = newMaintenanceSchedule(*maintenancePath)
+		if err != nil {
+			log.Fatalf("Cannot load maintenance windows. Error: %s", err.Error())
+		}
+		err = prometheus.WrapRegistererWith(constLabels, prometheus.DefaultRegisterer).Register(maintenance)
+		if err != nil {
+			log.Fatal("Cannot register maintenance metrics ", err.Error())
+		}
+		scheduler.Observe(maintenance.Observe)
+	}
+
+	if *alertsConfig != "" {
+		alertsConf, err := LoadAlertsConfig(*alertsConfig)
+		if err != nil {
+			log.Fatalf("Cannot load alerts config. Error: %s", err.Error())
+		}
+		scheduler.Observe(maintenance.observer(newAlerter(ctx, alertsConf.Rules, alertsConf.notifiers(), alertsConf.ResolveTimeout).Observe))
+	}
+
+	if *kafkaBrokers != "" {
+		kafkaTLSConfig, err := kafkaTLS.Build()
+		if err != nil {
+			log.Fatalf("Cannot create TLS config for Kafka. Error: %s", err.Error())
+		}
+		producer, err := newKafkaProducer(*kafkaBrokers, *kafkaTopic, *kafkaClientID, kafkaTLSConfig, 0)
+		if err != nil {
+			log.Fatalf("Cannot publish events to Kafka. Error: %s", err.Error())
+		}
+		publisher := newKafkaPublisher(producer, *kafkaPoolRatio)
+		scheduler.Observe(maintenance.observer(publisher.Observe))
+		go publisher.run(ctx)
+	}
+
+	if *heartbeatURL != "" {
+		scheduler.Observe(newHeartbeat(*heartbeatURL, *heartbeatInterval).Observe)
+	}
+
+	if *natsURL != "" {
+		var secret *config.Secret
+		if *natsPassword != "" {
+			secret, err = config.NewSecretRef(*natsPassword)
+			if err != nil {
+				log.Fatalf("Cannot read the NATS password. Error: %s", err.Error())
+			}
+		}
+		publisher, err := newNATSPublisher(*natsURL, *natsPrefix, secret)
+		if err != nil {
+			log.Fatalf("Cannot publish stats to NATS. Error: %s", err.Error())
+		}
+		scheduler.Observe(publisher.Observe)
+		go publisher.run(ctx)
+	}
+
+	if *federateSites != "" {
+		sites, err := parseFederationSites(*federateSites)
+		if err != nil {
+			log.Fatalf("Cannot federate. Error: %s", err.Error())
+		}
+		err = prometheus.WrapRegistererWith(constLabels, prometheus.DefaultRegisterer).Register(newFederationCollector(sites))
+		if err != nil {
+			log.Fatal("Cannot register federation metrics ", err.Error())
+		}
+	}
+
+	if *fleetMetrics {
+		err = prometheus.WrapRegistererWith(constLabels, prometheus.DefaultRegisterer).Register(newFleetCollector(scheduler))
+		if err != nil {
+			log.Fatal("Cannot register fleet metrics ", err.Error())
+		}
+	}
+
+	var availability *availabilityTracker
+	if *availWindow > 0 {
+		availability, err = newAvailabilityTracker(*availWindow, *availState)
+		if err != nil {
+			log.Fatalf("Cannot track availability. Error: %s", err.Error())
+		}
+		err = prometheus.WrapRegistererWith(constLabels, prometheus.DefaultRegisterer).Register(availability)
+		if err != nil {
+			log.Fatal("Cannot register availability metrics ", err.Error())
+		}
+		scheduler.Observe(maintenance.observer(availability.Observe))
+		go availability.saveEvery(time.Minute)
+	}
+
+	var downtime *downtimeLedger
+	if *downtimeState != "" {
+		downtime, err = newDowntimeLedger(*downtimeState, *downtimeRetention)
+		if err != nil {
+			log.Fatalf("Cannot track downtime. Error: %s", err.Error())
+		}
+		err = prometheus.WrapRegistererWith(constLabels, prometheus.DefaultRegisterer).Register(downtime)
+		if err != nil {
+			log.Fatal("Cannot register downtime metrics ", err.Error())
+		}
+		scheduler.Observe(maintenance.observer(downtime.Observe))
+		go downtime.saveEvery(time.Minute)
+	}
+
+	if *anomalyAlpha > 0 {
+		anomalies := newAnomalyDetector(*anomalyAlpha, *anomalyDevs)
+		err = prometheus.WrapRegistererWith(constLabels, prometheus.DefaultRegisterer).Register(anomalies)
+		if err != nil {
+			log.Fatal("Cannot register anomaly metrics ", err.Error())
+		}
+		scheduler.Observe(anomalies.Observe)
+	}
+
+	if *baselineState != "" {
+		err = scheduler.LoadBaselines(*baselineState)
+		if err != nil {
+			log.Fatalf("Cannot load baselines. Error: %s", err.Error())
+		}
+		go scheduler.saveBaselinesEvery(*baselineState, time.Minute)
+	}
+
+	var db *tsdb
+	if *tsdbRetention > 0 {
+		db = newTSDB(*tsdbRetention)
+		scheduler.Observe(db.Observe)
+	}
+
+	// exporting metrics by running a ticker per target
+	var targetStore *targetStore
+	if *targetsAPI {
+		// the targets of the persisted file are managed by the API, the file is rewritten on every change
+		persisted, apiPath := []Target(nil), ""
+		if *targetsAPIPersist {
+			if *targetsPath == "" {
+				log.Fatal("Cannot persist API targets without -targets.file")
+			}
+			static := targets[:0:0]
+			for _, t := range targets {
+				if t.source == "file" {
+					persisted = append(persisted, t)
+				} else {
+					static = append(static, t)
+				}
+			}
+			targets, apiPath = static, *targetsPath
+		}
+		targetStore, err = newTargetStore(scheduler, apiPath, persisted)
+		if err != nil {
+			log.Fatalf("Cannot manage the targets through the API. Error: %s", err.Error())
+		}
+	}
+	// the startup scrape and readiness only wait for the static targets owned by this shard,
+	// discovered ones come and go
+	owned := exporterShard.filter(targets)
+	if *requireStartup {
+		err = requireScrape(ctx, owned, conf, tlsConfig, *startupGrace)
+		if err != nil {
+			log.Fatalf("Startup scrape failed. Error: %s", err.Error())
+		}
+		log.Printf("Startup scrape of %d targets succeeded", len(owned))
+	} else if *startupGrace > 0 {
+		go exitUnscraped(scheduler, owned, *startupGrace)
+	}
+	scheduler.SetSource("static", targets)
+	if *kubeTargets != "" {
+		err = watchKubernetesTargets(*kubeTargets, *kubeTargetsNS, scheduler)
+		if err != nil {
+			log.Fatalf("Cannot discover targets from kubernetes. Error: %s", err.Error())
+		}
+	}
+	if *ec2Tag != "" {
+		ec2, err := newEC2Discovery(*ec2Tag, *ec2Region, *ec2Port)
+		if err != nil {
+			log.Fatalf("Cannot discover targets from EC2. Error: %s", err.Error())
+		}
+		scheduler.PollSource("ec2", *refreshTime, ec2.targets)
+	}
+	if dockerDiscoveryEnabled() {
+		docker, err := newDockerDiscovery(*dockerHost, *dockerImage, *dockerLabel, *dockerPort)
+		if err != nil {
+			log.Fatalf("Cannot discover targets from docker. Error: %s", err.Error())
+		}
+		scheduler.PollSource("docker", *refreshTime, docker.targets)
+	}
+	if *nomadName != "" {
+		nomad := newNomadDiscovery(*nomadAddress, *nomadNS, *nomadName, *nomadTags)
+		scheduler.PollSource("nomad", *refreshTime, nomad.targets)
+	}
+	// the watchdog is only pinged while every scrape loop is ticking, so a wedged loop get the unit restarted
+	if watchdogInterval := sdWatchdogInterval(); watchdogInterval > 0 {
+		go func() {
+			for range time.Tick(watchdogInterval) {
+				if scheduler.Healthy() {
+					sdNotify("WATCHDOG=1")
+				}
+			}
+		}()
+	}
+
+	// expose prometheus endpoint for metrics export
+	errChan := make(chan error)
+	go func() {
+		http.Handle("/metrics", instrumentHandler("metrics", promhttp.Handler()))
+		if targetStore != nil {
+			admin := instrumentHandler("api", targetsAdminHandler(targetStore, webConf.authenticated(), targetsAPIHandler(scheduler)))
+			handleAudited(http.DefaultServeMux, "/api/v1/targets", "targets", admin)
+			handleAudited(http.DefaultServeMux, "/api/v1/targets/", "targets", admin)
+		} else {
+			http.Handle("/api/v1/targets", instrumentHandler("api", targetsAPIHandler(scheduler)))
+		}
+		if maintenance != nil {
+			handler := instrumentHandler("api", maintenanceHandler(maintenance, *maintenanceAPI, webConf.authenticated()))
+			handleAudited(http.DefaultServeMux, "/api/v1/maintenance", "maintenance", handler)
+			handleAudited(http.DefaultServeMux, "/api/v1/maintenance/", "maintenance", handler)
+		}
+		http.Handle("/api/v1/hot", instrumentHandler("api", hotAPIHandler(scheduler)))
+		http.Handle("/debug/history", instrumentHandler("history", historyHandler(scheduler)))
+		http.Handle("/debug/last-parse-failure", instrumentHandler("parse_failure", lastParseFailureHandler()))
+		if db != nil {
+			http.Handle("/api/v1/query_range", instrumentHandler("api", queryRangeHandler(db)))
+		}
+		http.Handle("/readyz", instrumentHandler("readyz", readyHandler(scheduler, owned, *readyTimeout)))
+		if webConf.authenticated() || *webEnableReload {
+			handleAudited(http.DefaultServeMux, "/-/reload", "reload", instrumentHandler("reload", reloadHandler(scheduler, *scrapeAPIInterval)))
+		}
+		handleAudited(http.DefaultServeMux, "/-/scrape", "scrape", instrumentHandler("scrape", scrapeHandler(scheduler, webConf.authenticated(), *scrapeAPIInterval)))
+		handler := webHandler(http.DefaultServeMux, webConf, allowedNets, *webAccessLog)
+		err := serveHTTP(listener, handler, webConf)
+		if err != nil {
+			errChan <- err
+		}
+	}()
+
+	err = sdNotify("READY=1")
+	if err != nil {
+		log.Println("Cannot notify systemd. Error: ", err.Error())
+	}
+
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, shutdownSignals...)
+	select {
+	case <-term:
+		log.Println("Sigterm detected")
+	case <-serviceStop:
+		log.Println("Service stop requested")
+	case err := <-errChan:
+		log.Println("Failed to start twemproxy exporter. Error: ", err.Error())
+	}
+
+	sdNotify("STOPPING=1")
+	// before the monitors are stopped
+	if *baselineState != "" {
+		err = scheduler.SaveBaselines(*baselineState)
+		if err != nil {
+			log.Printf("Cannot save baselines state. Error: %s", err.Error())
+		}
+	}
+	scheduler.Stop()
+	if availability != nil {
+		err = availability.Save()
+		if err != nil {
+			log.Printf("Cannot save availability state. Error: %s", err.Error())
+		}
+	}
+	if downtime != nil {
+		err = downtime.Save()
+		if err != nil {
+			log.Printf("Cannot save downtime state. Error: %s", err.Error())
+		}
+	}
+	log.Println("Twemproxy exporter exited")
+}
+
+// dockerDiscoveryEnabled when containers are selected by image or label
+func dockerDiscoveryEnabled() bool {
+	return *dockerImage != "" || *dockerLabel != ""
+}
+
+// monitorOptions of every target from the flags
+func monitorOptions(disabled map[string]bool) ([]monitor.Option, error) {
+	poolDetail, err := monitor.ParsePoolDetail(*poolDetailFlag)
+	if err != nil {
+		return nil, err
+	}
+	opts := []monitor.Option{
+		monitor.WithLiveness(*livenessTime),
+		monitor.WithQuarantine(*quarantineAfter, *quarantineInterval),
+		monitor.WithRates(*rateMetrics),
+		monitor.WithTopN(*topN),
+		monitor.WithDisabledGroups(disabled),
+		monitor.WithPoolDetail(poolDetail),
+		monitor.WithHistory(*historySize),
+		monitor.WithDropStale(*dropStale),
+	}
+	if *listenProbe {
+		opts = append(opts, monitor.WithListenProbe(*listenPing))
+	}
+	if *backendKeysProbe {
+		opts = append(opts, monitor.WithBackendProbe())
+	}
+	return opts, nil
+}
+
+// loadConfig from the ConfigMap when -config.kubernetes is set, from the -config file otherwise
+func loadConfig() (map[string]config.Config, error) {
+	if *configMap == "" {
+		if *configPath == "" && *configRemote != "" {
+			// every target config is fetched from its host
+			return map[string]config.Config{}, nil
+		}
+		return lenientConfig(config.LoadConfig(*configPath))
+	}
+
+	namespace, name, key, err := parseConfigMapRef(*configMap)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newInClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	content, _, err := client.getConfigMapKey(namespace, name, key)
+	if err != nil {
+		return nil, err
+	}
+	return lenientConfig(config.ParseConfig(content))
+}
+
+// hostLabelNames are the labels derived from the host the exporter runs on, different on every replica
+var hostLabelNames = []string{"pod", "node", "instance_id", "zone"}
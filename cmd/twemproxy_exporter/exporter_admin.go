@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// reloadHandler reload the nutcracker config from its source, at most once every interval like the forced scrapes.
+// Only registered when the web config authenticate the requests or with -web.enable-reload
+func reloadHandler(scheduler *Scheduler, interval time.Duration) http.Handler {
+	limiter := &scrapeLimiter{interval: interval, last: make(map[string]time.Time)}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if wait := limiter.allow("config", time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, fmt.Sprintf("The config was reloaded less than %s ago", interval), http.StatusTooManyRequests)
+			return
+		}
+
+		conf, err := loadConfig()
+		configReloaded(conf, err)
+		if err != nil {
+			log.Println("Failed to reload config: ", err.Error())
+			http.Error(w, fmt.Sprintf("Failed to reload config: %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		scheduler.SetConfig(conf)
+		log.Println("Config reloaded")
+		fmt.Fprintln(w, "Config reloaded")
+	})
+}
+
+// readyHandler report not ready until every target has been scraped successfully once,
+// so traffic is not shifted to an exporter which can't reach its proxies.
+// Once ready it stays ready, a target going down later is reported by the metrics.
+// A timeout above 0 force ready after that long since startup
+func readyHandler(scheduler *Scheduler, targets []Target, timeout time.Duration) http.Handler {
+	start := time.Now()
+	var ready int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&ready) == 1 {
+			fmt.Fprintln(w, "Ready")
+			return
+		}
+		unscraped := scheduler.Unscraped(targets)
+		if len(unscraped) > 0 && (timeout <= 0 || time.Since(start) < timeout) {
+			http.Error(w, fmt.Sprintf("Not scraped yet: %s", strings.Join(unscraped, ", ")), http.StatusServiceUnavailable)
+			return
+		}
+		if len(unscraped) > 0 {
+			log.Printf("Readiness timeout reached, targets never scraped: %s", strings.Join(unscraped, ", "))
+		}
+		atomic.StoreInt32(&ready, 1)
+		fmt.Fprintln(w, "Ready")
+	})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestReadyAfterFirstScrape(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	m := startMockServer(t)
+	defer m.Close()
+
+	targets := []Target{{Address: m.Addr()}}
+	scheduler := NewScheduler(conf, time.Millisecond*20, nil)
+	handler := readyHandler(scheduler, targets, 0)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 before the first scrape, got %d", rec.Code)
+	}
+
+	scheduler.SetTargets(targets)
+	defer scheduler.Stop()
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+		if rec.Code == http.StatusOK {
+			return
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	t.Errorf("Expected 200 once the target was scraped, got %d", rec.Code)
+}
+
+func TestReadyTimeout(t *testing.T) {
+	scheduler := NewScheduler(nil, time.Second, nil)
+	handler := readyHandler(scheduler, []Target{{Address: "127.0.0.1:1"}}, time.Millisecond)
+	time.Sleep(time.Millisecond * 5)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 after the readiness timeout, got %d", rec.Code)
+	}
+}
+
+func TestReloadStatus(t *testing.T) {
+	defer func(path string) { *configPath = path }(*configPath)
+	scheduler := NewScheduler(nil, time.Second, nil)
+	handler := reloadHandler(scheduler, 0)
+	reload := func(path string) int {
+		*configPath = path
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/-/reload", nil))
+		return rec.Code
+	}
+
+	if code := reload("../../files/nutcracker.yml"); code != http.StatusOK {
+		t.Fatalf("Expected the config reloaded, got %d", code)
+	}
+	reloaded := testutil.GatherValue(t, configReloadTimestamp, "twemproxy_exporter_config_last_reload_timestamp_seconds")
+	if testutil.GatherValue(t, configReloadSuccessful, "twemproxy_exporter_config_last_reload_successful") != 1 || reloaded == 0 {
+		t.Errorf("Expected a successful reload with its timestamp, got %f", reloaded)
+	}
+
+	if code := reload("../../files/missing.yml"); code != http.StatusInternalServerError {
+		t.Fatalf("Expected the reload to fail, got %d", code)
+	}
+	if testutil.GatherValue(t, configReloadSuccessful, "twemproxy_exporter_config_last_reload_successful") != 0 {
+		t.Error("Expected the failed reload reported")
+	}
+	if value := testutil.GatherValue(t, configReloadTimestamp, "twemproxy_exporter_config_last_reload_timestamp_seconds"); value != reloaded {
+		t.Errorf("Expected the timestamp of the last successful reload kept, got %f", value)
+	}
+}
+
+func TestReloadLimit(t *testing.T) {
+	defer func(path string) { *configPath = path }(*configPath)
+	*configPath = "../../files/nutcracker.yml"
+	handler := reloadHandler(NewScheduler(nil, time.Second, nil), time.Hour)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/-/reload", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected only POST allowed, got %d", rec.Code)
+	}
+	for i, expected := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/-/reload", nil))
+		if rec.Code != expected {
+			t.Errorf("Expected reload %d to answer %d, got %d", i, expected, rec.Code)
+		}
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected the limited reload to have Retry-After")
+	}
+}
+
+func TestHashConfig(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	same, _ := config.LoadConfig("../../files/nutcracker.yml")
+	hash := hashConfig(conf)
+	if hash == "" || hashConfig(same) != hash {
+		t.Errorf("Expected the same hash for the same config, got %s and %s", hash, hashConfig(same))
+	}
+	pool := same["wallet-oauth-token"]
+	pool.Timeout++
+	same["wallet-oauth-token"] = pool
+	if hashConfig(same) == hash {
+		t.Error("Expected another hash once the timeout changed")
+	}
+
+	configReloaded(conf, nil)
+	if value := testutil.GatherValue(t, configHash.WithLabelValues(hash), "twemproxy_exporter_config_hash"); value != 1 {
+		t.Errorf("Expected the hash of the loaded config exported, got %f", value)
+	}
+}
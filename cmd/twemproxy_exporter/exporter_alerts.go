@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// alert conditions, evaluated on every scrape of every target
+const (
+	// target_down: the stats port cannot be scraped
+	conditionTargetDown = "target_down"
+	// pool_unavailable_ratio: unavailable servers / servers of the pool is above the threshold
+	conditionPoolUnavailable = "pool_unavailable_ratio"
+	// server_unavailable: the server has no connection from twemproxy
+	conditionServerUnavailable = "server_unavailable"
+	// server_ejected: server_ejected_at moved since the previous scrape, resolved on the next scrape
+	conditionServerEjected = "server_ejected"
+	// server_error_rate: errors and timeouts per second of the server is above the threshold
+	conditionServerErrorRate = "server_error_rate"
+)
+
+// alert statuses, same as the Alertmanager webhook
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+// AlertsConfig of the built-in alerting, for exporters running without Prometheus and Alertmanager
+//
+//	webhooks:
+//	  - url: https://hooks.example.com/twemproxy
+//	rules:
+//	  - name: pool_degraded
+//	    condition: pool_unavailable_ratio
+//	    threshold: 0.25
+//	    for: 1m
+//	  - name: ejected
+//	    condition: server_ejected
+type AlertsConfig struct {
+	Webhooks  []WebhookConfig  `yaml:"webhooks"`
+	Slack     *SlackConfig     `yaml:"slack"`
+	PagerDuty *PagerDutyConfig `yaml:"pagerduty"`
+	Rules     []AlertRule      `yaml:"rules"`
+	// ResolveTimeout after which the alerts of a target not scraped anymore, e.g. removed, are resolved
+	ResolveTimeout time.Duration `yaml:"resolve_timeout"`
+}
+
+// defaultResolveTimeout of the alerts of the targets not scraped anymore, above the default -quarantine.interval
+const defaultResolveTimeout = time.Minute * 15
+
+// defaultAlertRules when the config has none, twemproxy or its backends going down and backends getting ejected
+var defaultAlertRules = []AlertRule{
+	{Name: "twemproxy_down", Condition: conditionTargetDown, For: time.Minute},
+	{Name: "pool_down", Condition: conditionPoolUnavailable, Threshold: 0.99},
+	{Name: "backend_down", Condition: conditionServerUnavailable},
+	{Name: "backend_ejected", Condition: conditionServerEjected},
+}
+
+// WebhookConfig is an HTTP endpoint receiving every alert event as JSON
+type WebhookConfig struct {
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// AlertRule fire when its condition hold for the whole For duration
+type AlertRule struct {
+	Name      string        `yaml:"name"`
+	Condition string        `yaml:"condition"`
+	Threshold float64       `yaml:"threshold"`
+	For       time.Duration `yaml:"for"`
+}
+
+// LoadAlertsConfig from yaml
+func LoadAlertsConfig(path string) (AlertsConfig, error) {
+	conf := AlertsConfig{}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return conf, fmt.Errorf("Cannot open: %s. Error: %s", path, err.Error())
+	}
+	err = yaml.Unmarshal(content, &conf)
+	if err != nil {
+		return conf, err
+	}
+	if len(conf.Rules) == 0 {
+		conf.Rules = defaultAlertRules
+	}
+	if conf.ResolveTimeout == 0 {
+		conf.ResolveTimeout = defaultResolveTimeout
+	}
+	for i, rule := range conf.Rules {
+		switch rule.Condition {
+		case conditionTargetDown, conditionPoolUnavailable, conditionServerUnavailable, conditionServerEjected, conditionServerErrorRate:
+		default:
+			return conf, fmt.Errorf("Unknown condition %s in rule %s", rule.Condition, rule.Name)
+		}
+		if rule.Name == "" {
+			conf.Rules[i].Name = rule.Condition
+		}
+	}
+	for _, w := range conf.Webhooks {
+		if w.URL == "" {
+			return conf, fmt.Errorf("Webhook without url in %s", path)
+		}
+	}
+	if conf.Slack != nil && conf.Slack.WebhookURL == nil {
+		return conf, fmt.Errorf("Slack without webhook_url in %s", path)
+	}
+	if conf.PagerDuty != nil && conf.PagerDuty.RoutingKey == nil {
+		return conf, fmt.Errorf("PagerDuty without routing_key in %s", path)
+	}
+	return conf, nil
+}
+
+// notifiers configured, one per webhook, Slack and PagerDuty
+func (c AlertsConfig) notifiers() []notifier {
+	var notifiers []notifier
+	for _, w := range c.Webhooks {
+		notifiers = append(notifiers, newWebhookNotifier(w))
+	}
+	if c.Slack != nil {
+		notifiers = append(notifiers, newSlackNotifier(*c.Slack))
+	}
+	if c.PagerDuty != nil {
+		notifiers = append(notifiers, newPagerDutyNotifier(*c.PagerDuty))
+	}
+	return notifiers
+}
+
+// alertEvent is sent to the notifiers every time an alert start or stop firing
+type alertEvent struct {
+	Status    string    `json:"status"`
+	Rule      string    `json:"rule"`
+	Condition string    `json:"condition"`
+	Target    string    `json:"target"`
+	Pool      string    `json:"pool,omitempty"`
+	Server    string    `json:"server,omitempty"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at,omitempty"`
+}
+
+// key identify the alert across scrapes
+func (e alertEvent) key() string {
+	return strings.Join([]string{e.Rule, e.Target, e.Pool, e.Server}, "\xff")
+}
+
+// notifier deliver alert events, e.g. to a webhook
+type notifier interface {
+	Notify(ctx context.Context, event alertEvent) error
+}
+
+// alertState of an active condition
+type alertState struct {
+	event  alertEvent
+	firing bool
+}
+
+// alerter evaluate the rules on every scrape result and notify on every state change
+type alerter struct {
+	rules     []AlertRule
+	notifiers []notifier
+	events    chan alertEvent
+	ctx       context.Context // of the notifications, canceled on shutdown
+
+	active    map[string]*alertState // by alertEvent key
+	ejectedAt map[string]float64     // last server_ejected_at by target, pool and server
+	seen      map[string]time.Time   // last scrape result by target
+	timeout   time.Duration          // after which the alerts of a target not scraped anymore are resolved
+	mu        sync.Mutex
+}
+
+func newAlerter(ctx context.Context, rules []AlertRule, notifiers []notifier, resolveTimeout time.Duration) *alerter {
+	a := &alerter{
+		ctx:       ctx,
+		rules:     rules,
+		notifiers: notifiers,
+		events:    make(chan alertEvent, 100),
+		active:    make(map[string]*alertState),
+		ejectedAt: make(map[string]float64),
+		seen:      make(map[string]time.Time),
+		timeout:   resolveTimeout,
+	}
+	go a.dispatch()
+	go a.expireEvery(time.Minute)
+	return a
+}
+
+// dispatch the events one by one, a slow notifier must not delay the scrapes
+func (a *alerter) dispatch() {
+	for event := range a.events {
+		for _, n := range a.notifiers {
+			err := n.Notify(a.ctx, event)
+			if err != nil {
+				log.Printf("Cannot notify alert %s on %s. Error: %s", event.Rule, event.Target, err.Error())
+			}
+		}
+	}
+}
+
+func (a *alerter) send(event alertEvent) {
+	select {
+	case a.events <- event:
+	default:
+		log.Printf("Too many pending alert notifications, dropping %s %s on %s", event.Status, event.Rule, event.Target)
+	}
+}
+
+// conditions of the rule holding for the scrape result
+func (a *alerter) conditions(rule AlertRule, result ScrapeResult) []alertEvent {
+	target := result.Target.instanceLabel()
+	base := alertEvent{Rule: rule.Name, Condition: rule.Condition, Target: target, Threshold: rule.Threshold}
+
+	var events []alertEvent
+	switch rule.Condition {
+	case conditionTargetDown:
+		if result.Err != nil {
+			events = append(events, base)
+		}
+		return events
+	}
+	if result.Err != nil {
+		// nothing to say about the pools of an unreachable target
+		return nil
+	}
+
+	for poolName, pool := range result.Stats.Services {
+		switch rule.Condition {
+		case conditionPoolUnavailable:
+			if pool.ExpectedAvailable == 0 {
+				continue
+			}
+			ratio := float64(pool.NotAvailable) / float64(pool.ExpectedAvailable)
+			if ratio > rule.Threshold {
+				e := base
+				e.Pool, e.Value = poolName, ratio
+				events = append(events, e)
+			}
+		case conditionServerUnavailable:
+			for name, server := range pool.Servers {
+				if server.ServerConnections < 1 {
+					e := base
+					e.Pool, e.Server = poolName, name
+					events = append(events, e)
+				}
+			}
+		case conditionServerErrorRate:
+			for name, r := range result.Rates[poolName].Servers {
+				if r.Errors > rule.Threshold {
+					e := base
+					e.Pool, e.Server, e.Value = poolName, name, r.Errors
+					events = append(events, e)
+				}
+			}
+		case conditionServerEjected:
+			for name, server := range pool.Servers {
+				key := strings.Join([]string{target, poolName, name}, "\xff")
+				previous, seen := a.ejectedAt[key]
+				if seen && server.ServerEjectedAt > previous {
+					e := base
+					e.Pool, e.Server, e.Value = poolName, name, server.ServerEjectedAt
+					events = append(events, e)
+				}
+			}
+		}
+	}
+	return events
+}
+
+// Observe a scrape result, fire the conditions holding long enough and resolve the ones gone
+func (a *alerter) Observe(result ScrapeResult) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	target := result.Target.instanceLabel()
+	holding := make(map[string]bool)
+	for _, rule := range a.rules {
+		for _, e := range a.conditions(rule, result) {
+			key := e.key()
+			holding[key] = true
+			state, ok := a.active[key]
+			if !ok {
+				e.StartsAt = result.Time
+				state = &alertState{event: e}
+				a.active[key] = state
+			}
+			state.event.Value = e.Value
+			if !state.firing && result.Time.Sub(state.event.StartsAt) >= rule.For {
+				state.firing = true
+				event := state.event
+				event.Status = alertFiring
+				a.send(event)
+			}
+		}
+	}
+
+	a.seen[target] = result.Time
+	for key, state := range a.active {
+		if state.event.Target != target || holding[key] {
+			continue
+		}
+		// the pools and servers of an unreachable target are unknown, they are only resolved by a successful scrape
+		if result.Err != nil && state.event.Condition != conditionTargetDown {
+			continue
+		}
+		delete(a.active, key)
+		a.resolve(state, result.Time)
+	}
+
+	if result.Err == nil {
+		// rebuilt on every scrape, so the removed servers are forgotten
+		a.forgetEjected(target)
+		for poolName, pool := range result.Stats.Services {
+			for name, server := range pool.Servers {
+				a.ejectedAt[strings.Join([]string{target, poolName, name}, "\xff")] = server.ServerEjectedAt
+			}
+		}
+	}
+}
+
+// resolve the alert, notified only when it was firing
+func (a *alerter) resolve(state *alertState, at time.Time) {
+	if !state.firing {
+		return
+	}
+	event := state.event
+	event.Status = alertResolved
+	event.EndsAt = at
+	a.send(event)
+}
+
+func (a *alerter) forgetEjected(target string) {
+	for key := range a.ejectedAt {
+		if strings.HasPrefix(key, target+"\xff") {
+			delete(a.ejectedAt, key)
+		}
+	}
+}
+
+// expire resolve the alerts of the targets without scrape result for the resolve timeout, e.g. removed ones
+func (a *alerter) expire(now time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for target, last := range a.seen {
+		if now.Sub(last) < a.timeout {
+			continue
+		}
+		for key, state := range a.active {
+			if state.event.Target == target {
+				delete(a.active, key)
+				a.resolve(state, now)
+			}
+		}
+		a.forgetEjected(target)
+		delete(a.seen, target)
+	}
+}
+
+func (a *alerter) expireEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case now := <-ticker.C:
+			a.expire(now)
+		}
+	}
+}
+
+// webhookNotifier POST every event as JSON
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookNotifier(conf WebhookConfig) *webhookNotifier {
+	timeout := conf.Timeout
+	if timeout == 0 {
+		timeout = time.Second * 10
+	}
+	return &webhookNotifier{url: conf.URL, client: &http.Client{Timeout: timeout}}
+}
+
+// Notify the webhook, any non 2xx response is an error
+func (w *webhookNotifier) Notify(ctx context.Context, event alertEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	resp, err := postJSON(ctx, w.client, w.url, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook %s returned %s", w.url, resp.Status)
+	}
+	return nil
+}
+
+// postJSON payload to url, canceled with ctx
+func postJSON(ctx context.Context, client *http.Client, url string, payload []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return client.Do(req)
+}
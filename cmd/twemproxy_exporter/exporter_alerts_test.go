@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+type recordNotifier struct {
+	events []alertEvent
+	mu     sync.Mutex
+}
+
+func (r *recordNotifier) Notify(ctx context.Context, event alertEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *recordNotifier) wait(t *testing.T, count int) []alertEvent {
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		r.mu.Lock()
+		if len(r.events) >= count {
+			events := append([]alertEvent(nil), r.events...)
+			r.mu.Unlock()
+			return events
+		}
+		r.mu.Unlock()
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("Expected %d alert events", count)
+	return nil
+}
+
+func TestAlerterPoolUnavailableFor(t *testing.T) {
+	n := &recordNotifier{}
+	a := newAlerter(context.Background(), []AlertRule{{Name: "degraded", Condition: conditionPoolUnavailable, Threshold: 0.25, For: time.Minute}}, []notifier{n}, time.Hour)
+	target := Target{Address: "proxy:22222"}
+	start := time.Now()
+
+	a.Observe(ScrapeResult{Target: target, Stats: testutil.ExampleStats(t, "alpha"), Time: start})
+	a.Observe(ScrapeResult{Target: target, Stats: testutil.ExampleStats(t, "alpha"), Time: start.Add(time.Second * 30)})
+	a.Observe(ScrapeResult{Target: target, Stats: testutil.ExampleStats(t, "alpha"), Time: start.Add(time.Minute)})
+	a.Observe(ScrapeResult{Target: target, Stats: testutil.ExampleStats(t), Time: start.Add(time.Minute * 2)})
+
+	events := n.wait(t, 2)
+	if len(events) != 2 || events[0].Status != alertFiring || events[1].Status != alertResolved {
+		t.Fatalf("Expected firing then resolved, got %+v", events)
+	}
+	if events[0].Pool != testutil.ExamplePool || events[0].Value != 0.5 || !events[0].StartsAt.Equal(start) {
+		t.Errorf("Unexpected firing event %+v", events[0])
+	}
+}
+
+func TestAlerterTargetDown(t *testing.T) {
+	n := &recordNotifier{}
+	a := newAlerter(context.Background(), []AlertRule{{Name: "down", Condition: conditionTargetDown}}, []notifier{n}, time.Hour)
+	target := Target{Address: "proxy:22222"}
+
+	a.Observe(ScrapeResult{Target: target, Err: errors.New("connection refused"), Time: time.Now()})
+	events := n.wait(t, 1)
+	if events[0].Status != alertFiring || events[0].Target != "proxy:22222" {
+		t.Errorf("Unexpected event %+v", events[0])
+	}
+}
+
+func TestAlerterTargetDownKeepPoolAlerts(t *testing.T) {
+	n := &recordNotifier{}
+	a := newAlerter(context.Background(), []AlertRule{
+		{Name: "down", Condition: conditionTargetDown},
+		{Name: "degraded", Condition: conditionPoolUnavailable, Threshold: 0.25},
+	}, []notifier{n}, time.Hour)
+	target := Target{Address: "proxy:22222"}
+	start := time.Now()
+
+	a.Observe(ScrapeResult{Target: target, Stats: testutil.ExampleStats(t, "alpha"), Time: start})
+	a.Observe(ScrapeResult{Target: target, Err: errors.New("connection refused"), Time: start.Add(time.Second)})
+	a.Observe(ScrapeResult{Target: target, Stats: testutil.ExampleStats(t), Time: start.Add(time.Second * 2)})
+
+	events := n.wait(t, 4)
+	if len(events) != 4 || events[0].Rule != "degraded" || events[1].Rule != "down" {
+		t.Fatalf("Expected the pool then the target alert firing, got %+v", events)
+	}
+	// the pool alert is resolved by the successful scrape only, not while twemproxy is unreachable
+	for _, e := range events[2:] {
+		if e.Status != alertResolved || !e.EndsAt.Equal(start.Add(time.Second*2)) {
+			t.Errorf("Expected the alerts resolved by the successful scrape, got %+v", e)
+		}
+	}
+}
+
+func TestAlerterExpireRemovedTarget(t *testing.T) {
+	n := &recordNotifier{}
+	a := newAlerter(context.Background(), []AlertRule{{Name: "down", Condition: conditionTargetDown}}, []notifier{n}, time.Minute)
+	start := time.Now()
+
+	a.Observe(ScrapeResult{Target: Target{Address: "proxy:22222"}, Err: errors.New("connection refused"), Time: start})
+	a.expire(start.Add(time.Second * 30))
+	a.expire(start.Add(time.Minute))
+
+	events := n.wait(t, 2)
+	if events[1].Status != alertResolved || !events[1].EndsAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected the alert of the removed target resolved, got %+v", events)
+	}
+	if len(a.active) != 0 || len(a.seen) != 0 {
+		t.Errorf("Expected the removed target forgotten, got %d alerts", len(a.active))
+	}
+}
+
+func TestAlerterEjectedByPool(t *testing.T) {
+	n := &recordNotifier{}
+	a := newAlerter(context.Background(), []AlertRule{{Name: "ejected", Condition: conditionServerEjected}}, []notifier{n}, time.Hour)
+	target := Target{Address: "proxy:22222"}
+	ejected := func(sessions, cache float64) stats.TwemproxyStats {
+		return stats.TwemproxyStats{Services: map[string]stats.ServiceStats{
+			"sessions": {Servers: map[string]stats.ServerStats{"redis-1": {ServerConnections: 1, ServerEjectedAt: sessions}}},
+			"cache":    {Servers: map[string]stats.ServerStats{"redis-1": {ServerConnections: 1, ServerEjectedAt: cache}}},
+		}}
+	}
+
+	a.Observe(ScrapeResult{Target: target, Stats: ejected(100, 0), Time: time.Now()})
+	a.Observe(ScrapeResult{Target: target, Stats: ejected(100, 200), Time: time.Now()})
+	events := n.wait(t, 1)
+	if events[0].Pool != "cache" {
+		t.Errorf("Expected only the server of the cache pool ejected, got %+v", events)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+)
+
+// anomalyWarmup samples before a series is scored, the average and variance are meaningless before
+const anomalyWarmup = 20
+
+// anomaly signals of every server
+const (
+	signalInQueue   = "in_queue"
+	signalErrorRate = "error_rate"
+)
+
+// ewma is an exponentially weighted moving average and variance
+type ewma struct {
+	mean     float64
+	variance float64
+	samples  int
+}
+
+// score of x against the history, in standard deviations, then add x to the history
+func (e *ewma) score(x float64, alpha float64) float64 {
+	score := 0.0
+	diff := x - e.mean
+	if e.samples >= anomalyWarmup {
+		score = math.Abs(diff) / math.Max(math.Sqrt(e.variance), 1e-6)
+	}
+
+	if e.samples == 0 {
+		e.mean = x
+	} else {
+		incr := alpha * diff
+		e.mean += incr
+		e.variance = (1 - alpha) * (e.variance + diff*incr)
+	}
+	e.samples++
+	return score
+}
+
+// anomalyKey of a server signal
+type anomalyKey struct {
+	instance string
+	pool     string
+	server   string
+	signal   string
+}
+
+// anomalyDetector score the in queue requests and error rate of every server against their own EWMA,
+// a simple adaptive alerting without external anomaly tooling
+type anomalyDetector struct {
+	alpha      float64
+	deviations float64
+
+	history map[anomalyKey]*ewma
+	scores  map[anomalyKey]float64
+	mu      sync.Mutex
+
+	scoreDesc     *prometheus.Desc
+	anomalousDesc *prometheus.Desc
+}
+
+func newAnomalyDetector(alpha float64, deviations float64) *anomalyDetector {
+	labels := append(serverLabels.Names(), "signal")
+	return &anomalyDetector{
+		alpha:      alpha,
+		deviations: deviations,
+		history:    make(map[anomalyKey]*ewma),
+		scores:     make(map[anomalyKey]float64),
+		scoreDesc: prometheus.NewDesc(prometheus.BuildFQName(collector.Namespace, "server", "anomaly_score"),
+			"Deviation of the signal of backend server from its moving average, in standard deviations", labels, nil),
+		anomalousDesc: prometheus.NewDesc(prometheus.BuildFQName(collector.Namespace, "server", "anomalous"),
+			"1 when the anomaly score of the signal is above -anomaly.deviations", labels, nil),
+	}
+}
+
+func (a *anomalyDetector) observe(key anomalyKey, x float64) {
+	e, ok := a.history[key]
+	if !ok {
+		e = &ewma{}
+		a.history[key] = e
+	}
+	a.scores[key] = e.score(x, a.alpha)
+}
+
+// Observe a scrape result, failed scrapes don't move the averages
+func (a *anomalyDetector) Observe(result ScrapeResult) {
+	if result.Err != nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	instance := result.Target.instanceLabel()
+	for poolName, pool := range result.Stats.Services {
+		for name, server := range pool.Servers {
+			key := anomalyKey{instance: instance, pool: poolName, server: server.HostAlias}
+			key.signal = signalInQueue
+			a.observe(key, server.InQueue)
+			// no rate on the first scrape
+			if r, ok := result.Rates[poolName].Servers[name]; ok {
+				key.signal = signalErrorRate
+				a.observe(key, r.Errors)
+			}
+		}
+	}
+}
+
+// Describe nothing, unchecked like the scheduler
+func (a *anomalyDetector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect the last score of every server signal
+func (a *anomalyDetector) Collect(ch chan<- prometheus.Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, score := range a.scores {
+		anomalous := 0.0
+		if score > a.deviations {
+			anomalous = 1
+		}
+		ch <- prometheus.MustNewConstMetric(a.scoreDesc, prometheus.GaugeValue, score, key.instance, key.pool, key.server, key.signal)
+		ch <- prometheus.MustNewConstMetric(a.anomalousDesc, prometheus.GaugeValue, anomalous, key.instance, key.pool, key.server, key.signal)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestEWMAScore(t *testing.T) {
+	e := &ewma{}
+	for i := 0; i < anomalyWarmup; i++ {
+		// a queue oscillating between 9 and 11
+		x := 9.0
+		if i%2 == 0 {
+			x = 11
+		}
+		if score := e.score(x, 0.1); score != 0 {
+			t.Fatalf("Expected no score during warmup, got %f", score)
+		}
+	}
+	if score := e.score(10, 0.1); score > 3 {
+		t.Errorf("Expected a usual value to score low, got %f", score)
+	}
+	if score := e.score(100, 0.1); score < 3 {
+		t.Errorf("Expected a spike to score high, got %f", score)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+// targetsAPIHandler serve the status of every target as JSON, for consumers without PromQL
+func targetsAPIHandler(scheduler *Scheduler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		monitors := scheduler.Monitors()
+		statuses := make([]monitor.Status, 0, len(monitors))
+		for _, m := range monitors {
+			statuses = append(statuses, m.Status())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"targets": statuses})
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+func TestTargetsAPI(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	mock := startMockServer(t)
+	defer mock.Close()
+
+	scheduler := NewScheduler(conf, time.Hour, nil)
+	scheduler.SetTargets([]Target{{Address: mock.Addr()}})
+	defer scheduler.Stop()
+	m := scheduler.Monitors()[0]
+	for i := 0; i < 2; i++ {
+		err := m.Run(context.Background())
+		if err != nil {
+			t.Fatal("Failed to run monitor: ", err.Error())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	targetsAPIHandler(scheduler).ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/targets", nil))
+	body := struct {
+		Targets []monitor.Status `json:"targets"`
+	}{}
+	err = json.NewDecoder(rec.Body).Decode(&body)
+	if err != nil {
+		t.Fatal("Invalid JSON: ", err.Error())
+	}
+	if len(body.Targets) != 1 || body.Targets[0].Target != mock.Addr() {
+		t.Fatalf("Unexpected targets %+v", body.Targets)
+	}
+	target := body.Targets[0]
+	if target.Stats == nil || target.LastSuccess == nil {
+		t.Error("Expected the last stats of the target")
+	}
+	if target.Rates["wallet-oauth-token"].Servers["alpha"].Requests <= 0 {
+		t.Errorf("Expected request rates of the growing mock counters, got %+v", target.Rates)
+	}
+}
+
+func TestTargetsAdminAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "targets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "targets.yml")
+
+	scheduler := NewScheduler(nil, time.Hour, nil)
+	defer scheduler.Stop()
+	store, err := newTargetStore(scheduler, path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := targetsAdminHandler(store, true, targetsAPIHandler(scheduler))
+
+	rec := httptest.NewRecorder()
+	targetsAdminHandler(store, false, targetsAPIHandler(scheduler)).ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/targets",
+		strings.NewReader(`{"address": "127.0.0.1:1"}`)))
+	if rec.Code != http.StatusForbidden || len(scheduler.Monitors()) != 0 {
+		t.Fatalf("Expected 403 without authentication, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/targets",
+		strings.NewReader(`{"address": "127.0.0.1:1", "instance": "sessions", "labels": {"dc": "dc2"}}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d %s", rec.Code, rec.Body.String())
+	}
+	if len(scheduler.Monitors()) != 1 {
+		t.Fatal("Expected the added target monitored")
+	}
+	persisted, err := loadTargetsFile(path)
+	if err != nil {
+		t.Fatal("Failed to load the persisted targets: ", err.Error())
+	}
+	if len(persisted) != 1 || persisted[0].Instance != "sessions" || persisted[0].Labels["dc"] != "dc2" {
+		t.Errorf("Unexpected persisted targets %+v", persisted)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("DELETE", "/api/v1/targets/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown target, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("DELETE", "/api/v1/targets/sessions", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", rec.Code, rec.Body.String())
+	}
+	if len(scheduler.Monitors()) != 0 {
+		t.Error("Expected the removed target not monitored")
+	}
+}
+
+func TestTargetsPersistGroups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.yml")
+	content := "targets:\n  - address: 127.0.0.1:1\ngroups:\n  - name: cache-a\n    labels: {cluster: cache-a}\n    targets:\n      - address: 127.0.0.1:2\n      - address: 127.0.0.1:3\n"
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, err := loadTargetsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler := NewScheduler(nil, time.Hour, nil)
+	defer scheduler.Stop()
+	store, err := newTargetStore(scheduler, path, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Add(Target{Address: "127.0.0.1:4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := store.Remove("127.0.0.1:3")
+	if !removed || err != nil {
+		t.Fatalf("Expected the grouped target removed, got %t %v", removed, err)
+	}
+
+	f, err := readTargetsFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Groups) != 1 || f.Groups[0].Labels["cluster"] != "cache-a" || len(f.Groups[0].Targets) != 1 || f.Groups[0].Targets[0].Address != "127.0.0.1:2" {
+		t.Errorf("Expected the group kept without the removed target, got %+v", f.Groups)
+	}
+	// the group labels are not copied into its targets
+	if f.Groups[0].Targets[0].Labels != nil {
+		t.Errorf("Expected the target of the group without labels, got %v", f.Groups[0].Targets[0].Labels)
+	}
+	if len(f.Targets) != 2 || f.Targets[0].Address != "127.0.0.1:1" || f.Targets[1].Address != "127.0.0.1:4" {
+		t.Errorf("Expected the top level targets with the added one, got %+v", f.Targets)
+	}
+}
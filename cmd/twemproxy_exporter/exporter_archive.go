@@ -0,0 +1,160 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveRecord of one stats payload, every record is its own gzip member so zcat read the whole file
+type archiveRecord struct {
+	Time   time.Time       `json:"time"`
+	Target string          `json:"target"`
+	Stats  json.RawMessage `json:"stats"`
+}
+
+// archiver append the raw stats payloads of every target to rotating gzipped files for offline analysis.
+// A file is rotated once larger than fileSize, files older than retention or beyond maxSize in total are removed
+type archiver struct {
+	dir       string
+	fileSize  int64
+	maxSize   int64
+	retention time.Duration
+
+	file    *os.File
+	written int64
+	mu      sync.Mutex
+}
+
+func newArchiver(dir string, fileSize int64, maxSize int64, retention time.Duration) (*archiver, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create archive dir %s. Error: %s", dir, err.Error())
+	}
+	return &archiver{dir: dir, fileSize: fileSize, maxSize: maxSize, retention: retention}, nil
+}
+
+// add a payload of the target, a nil archiver archive nothing
+func (a *archiver) add(target string, t time.Time, payload []byte) {
+	if a == nil {
+		return
+	}
+	record, err := json.Marshal(archiveRecord{Time: t, Target: target, Stats: payload})
+	if err != nil {
+		log.Printf("Cannot archive stats of %s. Error: %s", target, err.Error())
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err = a.write(append(record, '\n'), t)
+	if err != nil {
+		log.Printf("Cannot archive stats of %s. Error: %s", target, err.Error())
+	}
+}
+
+func (a *archiver) write(record []byte, t time.Time) error {
+	if a.file == nil || a.written >= a.fileSize {
+		err := a.rotate(t)
+		if err != nil {
+			return err
+		}
+	}
+	gz := gzip.NewWriter(a.file)
+	_, err := gz.Write(record)
+	if err != nil {
+		return err
+	}
+	err = gz.Close()
+	if err != nil {
+		return err
+	}
+	info, err := a.file.Stat()
+	if err != nil {
+		return err
+	}
+	a.written = info.Size()
+	return nil
+}
+
+// rotate to a new file named by time and apply the retention
+func (a *archiver) rotate(t time.Time) error {
+	if a.file != nil {
+		a.file.Close()
+	}
+	name := filepath.Join(a.dir, "stats-"+t.UTC().Format("20060102T150405.000000000")+".json.gz")
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	a.file, a.written = file, 0
+	a.prune(t)
+	return nil
+}
+
+// prune the files out of the retention, oldest first, never the current one
+func (a *archiver) prune(now time.Time) {
+	infos, err := ioutil.ReadDir(a.dir)
+	if err != nil {
+		log.Printf("Cannot prune archive %s. Error: %s", a.dir, err.Error())
+		return
+	}
+	var files []os.FileInfo
+	total := int64(0)
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), "stats-") && strings.HasSuffix(info.Name(), ".json.gz") {
+			files = append(files, info)
+			total += info.Size()
+		}
+	}
+	// names sort by time
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	current := filepath.Base(a.file.Name())
+	for _, info := range files {
+		if info.Name() == current {
+			break
+		}
+		expired := a.retention > 0 && now.Sub(info.ModTime()) > a.retention
+		if !expired && (a.maxSize <= 0 || total <= a.maxSize) {
+			break
+		}
+		err = os.Remove(filepath.Join(a.dir, info.Name()))
+		if err != nil {
+			log.Printf("Cannot prune archive %s. Error: %s", info.Name(), err.Error())
+			continue
+		}
+		total -= info.Size()
+		os.Remove(filepath.Join(a.dir, info.Name()+uploadedSuffix))
+	}
+}
+
+// current file name, empty before the first payload
+func (a *archiver) current() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return ""
+	}
+	return filepath.Base(a.file.Name())
+}
+
+// Close the current file
+func (a *archiver) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestArchiver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	payload, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a file per record, only the current file fit
+	a, err := newArchiver(dir, 1, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		a.add("127.0.0.1:22222", start.Add(time.Duration(i)*time.Second), payload)
+	}
+	a.Close()
+
+	files, err := filepath.Glob(filepath.Join(dir, "stats-*.json.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected the oldest files pruned, got %v", files)
+	}
+
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := archiveRecord{}
+	err = json.NewDecoder(bufio.NewReader(gz)).Decode(&record)
+	if err != nil {
+		t.Fatal("Invalid archive record: ", err.Error())
+	}
+	if record.Target != "127.0.0.1:22222" || !record.Time.Equal(start.Add(time.Second*3)) || len(record.Stats) == 0 {
+		t.Errorf("Unexpected archive record %s %s", record.Target, record.Time)
+	}
+}
+
+func TestArchiveUploader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"stats-1.json.gz", "stats-2.json.gz", "stats-3.json.gz"} {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "stats-1.json.gz"+uploadedSuffix), nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var uploaded []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") ||
+			!strings.Contains(r.Header.Get("Authorization"), "x-amz-content-sha256") {
+			t.Errorf("Unexpected upload request %s %s", r.Method, r.Header.Get("Authorization"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-Amz-Content-Sha256") != sha256Hex(string(body)) {
+			t.Errorf("Unexpected payload hash of %s", r.URL.Path)
+		}
+		mu.Lock()
+		uploaded = append(uploaded, r.URL.Path)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	u, err := newArchiveUploader(dir, "s3://archive/twemproxy/edge-1", server.URL, "eu-west-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stats-1 is already uploaded, stats-3 is still written
+	u.uploadPending(context.Background(), "stats-3.json.gz")
+	if len(uploaded) != 1 || uploaded[0] != "/archive/twemproxy/edge-1/stats-2.json.gz" {
+		t.Fatalf("Expected only stats-2 uploaded, got %v", uploaded)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "stats-2.json.gz"+uploadedSuffix)); err != nil {
+		t.Error("Expected stats-2 marked as uploaded")
+	}
+	u.uploadPending(context.Background(), "stats-3.json.gz")
+	if len(uploaded) != 1 {
+		t.Errorf("Expected no upload of marked files, got %v", uploaded)
+	}
+
+	_, err = newArchiveUploader(dir, "ftp://archive", "", "")
+	if err == nil {
+		t.Error("Expected an error on unknown scheme")
+	}
+}
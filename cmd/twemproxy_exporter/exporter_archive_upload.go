@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gcsURL of the Cloud Storage XML API
+var gcsURL = "https://storage.googleapis.com"
+
+// uploadedSuffix of the marker written next to an archive file once uploaded
+const uploadedSuffix = ".uploaded"
+
+// archiveUploader push the rotated archive files to a bucket, so capacity planning jobs read them
+// without touching the production hosts.
+// s3:// use the AWS credentials of the environment or the instance role, any S3 compatible store with an endpoint.
+// gs:// use the service account of the GCE instance
+type archiveUploader struct {
+	dir      string
+	scheme   string
+	bucket   string
+	prefix   string
+	endpoint string
+	region   string
+	client   *http.Client
+}
+
+func newArchiveUploader(dir string, target string, endpoint string, region string) (*archiveUploader, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid upload url %s. Error: %s", target, err.Error())
+	}
+	if (u.Scheme != "s3" && u.Scheme != "gs") || u.Host == "" {
+		return nil, fmt.Errorf("Invalid upload url %s, expected s3://bucket/prefix or gs://bucket/prefix", target)
+	}
+	uploader := &archiveUploader{
+		dir:      dir,
+		scheme:   u.Scheme,
+		bucket:   u.Host,
+		prefix:   strings.Trim(u.Path, "/"),
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		region:   region,
+		client:   &http.Client{Timeout: time.Minute * 5},
+	}
+	if uploader.prefix != "" {
+		uploader.prefix += "/"
+	}
+	return uploader, nil
+}
+
+// objectURL of the file in the bucket
+func (u *archiveUploader) objectURL(name string) string {
+	key := u.prefix + name
+	switch {
+	case u.scheme == "gs":
+		return gcsURL + "/" + u.bucket + "/" + key
+	case u.endpoint != "":
+		// path style for S3 compatible stores
+		return u.endpoint + "/" + u.bucket + "/" + key
+	default:
+		return "https://" + u.bucket + ".s3." + u.region + ".amazonaws.com/" + key
+	}
+}
+
+// gceAccessToken of the instance service account
+func gceAccessToken() (string, error) {
+	content, err := gceMetadataGet("instance/service-accounts/default/token")
+	if err != nil {
+		return "", err
+	}
+	token := struct {
+		AccessToken string `json:"access_token"`
+	}{}
+	err = json.Unmarshal([]byte(content), &token)
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// upload a file of the archive dir
+func (u *archiveUploader) upload(ctx context.Context, name string) error {
+	content, err := ioutil.ReadFile(filepath.Join(u.dir, name))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", u.objectURL(name), bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+
+	if u.scheme == "gs" {
+		token, err := gceAccessToken()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		creds, err := getAWSCredentials()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Amz-Content-Sha256", sha256Hex(string(content)))
+		signAWSRequest(req, creds, u.region, "s3", time.Now())
+	}
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("Upload of %s returned %s: %s", name, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return ioutil.WriteFile(filepath.Join(u.dir, name+uploadedSuffix), nil, 0644)
+}
+
+// uploadPending files, every rotated file without its uploaded marker, oldest first
+func (u *archiveUploader) uploadPending(ctx context.Context, current string) {
+	files, err := filepath.Glob(filepath.Join(u.dir, "stats-*.json.gz"))
+	if err != nil {
+		log.Printf("Cannot list archive %s. Error: %s", u.dir, err.Error())
+		return
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		if name == current {
+			continue
+		}
+		if _, err := os.Stat(file + uploadedSuffix); err == nil {
+			continue
+		}
+		err = u.upload(ctx, name)
+		if err != nil {
+			log.Printf("Cannot upload archive %s, retrying later. Error: %s", name, err.Error())
+			return
+		}
+		log.Printf("Uploaded archive %s to %s", name, u.objectURL(name))
+	}
+}
+
+// uploadEvery interval until ctx is canceled
+func (u *archiveUploader) uploadEvery(ctx context.Context, a *archiver, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			u.uploadPending(ctx, a.current())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
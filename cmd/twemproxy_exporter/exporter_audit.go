@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// auditLog is the dedicated stream for changes made through the admin endpoints
+var auditLog = log.New(os.Stderr, "", 0)
+
+// auditEntry is written as one JSON document per line
+type auditEntry struct {
+	Time      string `json:"time"`
+	Action    string `json:"action"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	SourceIP  string `json:"source_ip"`
+	Principal string `json:"principal"`
+	Status    int    `json:"status"`
+	Outcome   string `json:"outcome"`
+}
+
+// openAuditLog redirect the audit stream to a file, stderr is used when path is empty
+func openAuditLog(path string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	auditLog.SetOutput(f)
+	return nil
+}
+
+// statusRecorder keep the status code written by the handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// requestPrincipal is the authenticated user, from basic auth or the client certificate
+func requestPrincipal(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok {
+		return user
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return "anonymous"
+}
+
+// auditActions map admin paths to their audit action, so requests rejected
+// before reaching the handler (e.g. failed authentication) are audited too
+var auditActions = make(map[string]string)
+
+// handleAudited register an admin endpoint whose every hit is audited, a path ending with / audit its subtree
+func handleAudited(mux *http.ServeMux, path string, action string, handler http.Handler) {
+	auditActions[path] = action
+	mux.Handle(path, audited(action, handler))
+}
+
+// auditAction of the admin path, the longest registered subtree wins like in http.ServeMux
+func auditAction(path string) (string, bool) {
+	if action, ok := auditActions[path]; ok {
+		return action, true
+	}
+	action, longest := "", 0
+	for pattern, a := range auditActions {
+		if strings.HasSuffix(pattern, "/") && strings.HasPrefix(path, pattern) && len(pattern) > longest {
+			action, longest = a, len(pattern)
+		}
+	}
+	return action, longest > 0
+}
+
+// audited log every hit of an admin endpoint with who did it and how it ended,
+// except the reads that succeeded since they change nothing
+func audited(action string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) && rec.status < 400 {
+			return
+		}
+		writeAudit(action, r, rec.status)
+	})
+}
+
+func writeAudit(action string, r *http.Request, status int) {
+	sourceIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sourceIP = r.RemoteAddr
+	}
+	outcome := "success"
+	if status >= 400 {
+		outcome = "failure"
+	}
+	entry, err := json.Marshal(auditEntry{
+		Time:      time.Now().UTC().Format(time.RFC3339),
+		Action:    action,
+		Method:    r.Method,
+		Path:      r.URL.RequestURI(),
+		SourceIP:  sourceIP,
+		Principal: requestPrincipal(r),
+		Status:    status,
+		Outcome:   outcome,
+	})
+	if err != nil {
+		log.Println("Cannot marshal audit entry: ", err.Error())
+		return
+	}
+	auditLog.Println(string(entry))
+}
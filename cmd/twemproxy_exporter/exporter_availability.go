@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+)
+
+// availabilityBucketSize is the resolution of the availability window, the state file keep one bucket per hour
+const availabilityBucketSize = time.Hour
+
+// availabilityBucket sum the availability samples of one hour
+type availabilityBucket struct {
+	Start int64   `json:"start"` // unix seconds
+	Up    float64 `json:"up"`
+	Total float64 `json:"total"`
+}
+
+// availabilityKey of a series, the server is empty for pools
+type availabilityKey struct {
+	Instance string `json:"instance"`
+	Pool     string `json:"pool"`
+	Server   string `json:"server,omitempty"`
+}
+
+// availabilitySeries as saved in the state file
+type availabilitySeries struct {
+	availabilityKey
+	Labels  map[string]string    `json:"labels,omitempty"` // of the target
+	Buckets []availabilityBucket `json:"buckets"`
+}
+
+// availabilityTracker keep per pool and per server availability over a rolling window,
+// persisted to a state file so restarts don't reset the window.
+// A pool sample is the ratio of its available servers, a server sample is 1 when twemproxy has a connection to it.
+// A failed scrape count as unavailable for every pool and server of the target.
+// Series without sample in the window, e.g. of removed servers or targets, are dropped
+type availabilityTracker struct {
+	window    time.Duration
+	stateFile string
+
+	series map[availabilityKey][]availabilityBucket
+	labels map[string]map[string]string // of the targets, by instance
+	mu     sync.Mutex
+	saveMu sync.Mutex // one Save at a time, they share the temporary file
+
+	poolName   string
+	serverName string
+}
+
+// windowName as used in the metric names, e.g. 30d
+func windowName(window time.Duration) string {
+	if window%(time.Hour*24) == 0 {
+		return strconv.Itoa(int(window/(time.Hour*24))) + "d"
+	}
+	return strconv.Itoa(int(window/time.Hour)) + "h"
+}
+
+func newAvailabilityTracker(window time.Duration, stateFile string) (*availabilityTracker, error) {
+	if window < availabilityBucketSize {
+		return nil, fmt.Errorf("Availability window %s is shorter than %s", window, availabilityBucketSize)
+	}
+	a := &availabilityTracker{
+		window:     window,
+		stateFile:  stateFile,
+		series:     make(map[availabilityKey][]availabilityBucket),
+		labels:     make(map[string]map[string]string),
+		poolName:   prometheus.BuildFQName(collector.Namespace, "pool", "availability_"+windowName(window)+"_ratio"),
+		serverName: prometheus.BuildFQName(collector.Namespace, "server", "availability_"+windowName(window)+"_ratio"),
+	}
+	if stateFile == "" {
+		return a, nil
+	}
+	content, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open: %s. Error: %s", stateFile, err.Error())
+	}
+	var saved []availabilitySeries
+	err = json.Unmarshal(content, &saved)
+	if err != nil {
+		// a corrupted state only lose the history, not worth refusing to start
+		log.Printf("Ignoring invalid availability state %s. Error: %s", stateFile, err.Error())
+		return a, nil
+	}
+	for _, series := range saved {
+		a.series[series.availabilityKey] = series.Buckets
+		if series.Labels != nil {
+			a.labels[series.Instance] = series.Labels
+		}
+	}
+	return a, nil
+}
+
+// add a sample to the series, dropping the buckets out of the window
+func (a *availabilityTracker) add(key availabilityKey, up float64, now time.Time) {
+	start := now.Truncate(availabilityBucketSize).Unix()
+	buckets := a.series[key]
+	if len(buckets) == 0 || buckets[len(buckets)-1].Start != start {
+		buckets = append(buckets, availabilityBucket{Start: start})
+	}
+	buckets[len(buckets)-1].Up += up
+	buckets[len(buckets)-1].Total++
+
+	oldest := now.Add(-a.window).Unix()
+	for len(buckets) > 0 && buckets[0].Start+int64(availabilityBucketSize/time.Second) <= oldest {
+		buckets = buckets[1:]
+	}
+	a.series[key] = buckets
+}
+
+// expire the series without sample in the window before now and the labels of the targets left without series,
+// the lock must be held
+func (a *availabilityTracker) expire(now time.Time) {
+	oldest := now.Add(-a.window).Unix()
+	instances := make(map[string]bool)
+	for key, buckets := range a.series {
+		if len(buckets) == 0 || buckets[len(buckets)-1].Start+int64(availabilityBucketSize/time.Second) <= oldest {
+			delete(a.series, key)
+			continue
+		}
+		instances[key.Instance] = true
+	}
+	for instance := range a.labels {
+		if !instances[instance] {
+			delete(a.labels, instance)
+		}
+	}
+}
+
+// Observe a scrape result
+func (a *availabilityTracker) Observe(result ScrapeResult) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	instance := result.Target.instanceLabel()
+	a.labels[instance] = result.Target.metricLabels()
+	if result.Err != nil {
+		for key := range a.series {
+			if key.Instance == instance {
+				a.add(key, 0, result.Time)
+			}
+		}
+		return
+	}
+	for poolName, pool := range result.Stats.Services {
+		if pool.ExpectedAvailable > 0 {
+			up := float64(pool.ExpectedAvailable-pool.NotAvailable) / float64(pool.ExpectedAvailable)
+			a.add(availabilityKey{Instance: instance, Pool: poolName}, up, result.Time)
+		}
+		for _, server := range pool.Servers {
+			up := 0.0
+			if server.Up() {
+				up = 1
+			}
+			a.add(availabilityKey{Instance: instance, Pool: poolName, Server: server.HostAlias}, up, result.Time)
+		}
+	}
+}
+
+// Describe nothing, unchecked like the scheduler
+func (a *availabilityTracker) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect the availability ratio of every pool and server, labeled with the labels of its target
+func (a *availabilityTracker) Collect(ch chan<- prometheus.Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire(time.Now())
+	for key, buckets := range a.series {
+		up, total := 0.0, 0.0
+		for _, b := range buckets {
+			up += b.Up
+			total += b.Total
+		}
+		if total == 0 {
+			continue
+		}
+		labels := a.labels[key.Instance]
+		if key.Server == "" {
+			desc := prometheus.NewDesc(a.poolName, "Average ratio of available servers of the pool over the last "+windowName(a.window),
+				[]string{"instance", "group"}, labels)
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, up/total, key.Instance, key.Pool)
+			continue
+		}
+		desc := prometheus.NewDesc(a.serverName, "Ratio of scrapes where backend server was available over the last "+windowName(a.window),
+			serverLabels.Names(), labels)
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, up/total, key.Instance, key.Pool, key.Server)
+	}
+}
+
+// Save the state file, written to a temporary file first so a crash never leave a truncated state
+func (a *availabilityTracker) Save() error {
+	if a.stateFile == "" {
+		return nil
+	}
+	a.saveMu.Lock()
+	defer a.saveMu.Unlock()
+	a.mu.Lock()
+	a.expire(time.Now())
+	saved := make([]availabilitySeries, 0, len(a.series))
+	for key, buckets := range a.series {
+		saved = append(saved, availabilitySeries{availabilityKey: key, Labels: a.labels[key.Instance], Buckets: buckets})
+	}
+	content, err := json.Marshal(saved)
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := a.stateFile + ".tmp"
+	err = ioutil.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.stateFile)
+}
+
+// saveEvery interval until the process exit
+func (a *availabilityTracker) saveEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		err := a.Save()
+		if err != nil {
+			log.Printf("Cannot save availability state %s. Error: %s", a.stateFile, err.Error())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+)
+
+func TestAvailabilityWindowPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "availability")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state := filepath.Join(dir, "state.json")
+
+	a, err := newAvailabilityTracker(time.Hour*24*30, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	a.Observe(ScrapeResult{Target: Target{Address: "proxy:22222"}, Stats: testutil.ExampleStats(t), Time: now})
+	a.Observe(ScrapeResult{Target: Target{Address: "proxy:22222"}, Stats: testutil.ExampleStats(t, "alpha"), Time: now})
+	a.Observe(ScrapeResult{Target: Target{Address: "proxy:22222"}, Err: errors.New("refused"), Time: now})
+	err = a.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := newAvailabilityTracker(time.Hour*24*30, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := testutil.GatherValue(t, restarted, "twemproxy_pool_availability_30d_ratio"); value != 0.5 {
+		t.Errorf("Expected (1 + 0.5 + 0) / 3 pool availability, got %f", value)
+	}
+}
+
+func TestAvailabilityWindowExpire(t *testing.T) {
+	a, err := newAvailabilityTracker(time.Hour*24, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	a.Observe(ScrapeResult{Target: Target{Address: "proxy:22222"}, Stats: testutil.ExampleStats(t, "alpha", "beta"), Time: now.Add(-time.Hour * 48)})
+	a.Observe(ScrapeResult{Target: Target{Address: "proxy:22222"}, Stats: testutil.ExampleStats(t), Time: now})
+	if value := testutil.GatherValue(t, a, "twemproxy_pool_availability_1d_ratio"); value != 1 {
+		t.Errorf("Expected the samples out of the window to be dropped, got %f", value)
+	}
+}
+
+func TestAvailabilityExpireRemovedTarget(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "state.json")
+	a, err := newAvailabilityTracker(time.Hour*24, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := ScrapeResult{Target: Target{Address: "removed:22222"}, Stats: testutil.ExampleStats(t), Time: time.Now().Add(-time.Hour * 48)}
+	a.Observe(removed)
+	current := ScrapeResult{Target: Target{Address: "proxy:22222", Labels: map[string]string{"dc": "dc1"}}, Stats: testutil.ExampleStats(t), Time: time.Now()}
+	a.Observe(current)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(a)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, pair := range metric.GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			if labels["instance"] != "proxy:22222" || labels["dc"] != "dc1" {
+				t.Errorf("Expected only the current target with its labels, got %v", labels)
+			}
+		}
+	}
+
+	err = a.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted, err := newAvailabilityTracker(time.Hour*24, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.series) != 3 || restarted.labels["proxy:22222"]["dc"] != "dc1" {
+		t.Errorf("Expected the pool and servers of the current target saved with its labels, got %+v", restarted.series)
+	}
+}
+
+func TestAvailabilityConcurrentSave(t *testing.T) {
+	a, err := newAvailabilityTracker(time.Hour*24, filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Observe(ScrapeResult{Target: Target{Address: "proxy:22222"}, Stats: testutil.ExampleStats(t), Time: time.Now()})
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- a.Save() }()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Expected concurrent saves to succeed, got %s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+)
+
+// backendProbeFailures by pool, the keys of the server are dropped until it answers again
+var backendProbeFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: collector.Namespace,
+	Subsystem: "exporter",
+	Name:      "backend_probe_failures_total",
+	Help:      "Failed queries of the keys of the backend servers with -probe.backend-keys by pool",
+}, []string{"group"})
+
+func init() {
+	prometheus.MustRegister(backendProbeFailures)
+}
+
+// backendProbeFailed count the failed query of a server of the pool, the monitor hook of -probe.backend-keys
+func backendProbeFailed(pool string, err error) {
+	backendProbeFailures.WithLabelValues(pool).Inc()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+// LoadBaselines from the state file, given to the monitors of the targets created afterwards
+func (s *Scheduler) LoadBaselines(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot open: %s. Error: %s", path, err.Error())
+	}
+	baselines := make(map[string]monitor.Baseline)
+	err = json.Unmarshal(content, &baselines)
+	if err != nil {
+		// like the availability state, a corrupted state only lose the baselines
+		log.Printf("Ignoring invalid baselines state %s. Error: %s", path, err.Error())
+		return nil
+	}
+	s.mu.Lock()
+	s.baselines = baselines
+	s.mu.Unlock()
+	return nil
+}
+
+// SaveBaselines of every target by address, written to a temporary file first
+func (s *Scheduler) SaveBaselines(path string) error {
+	baselines := make(map[string]monitor.Baseline)
+	for _, m := range s.Monitors() {
+		if b, ok := m.Baseline(); ok {
+			baselines[m.Address()] = b
+		}
+	}
+	content, err := json.Marshal(baselines)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// saveBaselinesEvery interval until the process exit
+func (s *Scheduler) saveBaselinesEvery(path string, interval time.Duration) {
+	for range time.Tick(interval) {
+		err := s.SaveBaselines(path)
+		if err != nil {
+			log.Printf("Cannot save baselines state %s. Error: %s", path, err.Error())
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestBaselinesPersisted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "baselines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state := filepath.Join(dir, "baselines.json")
+
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	mock := startMockServer(t)
+	defer mock.Close()
+
+	scheduler := NewScheduler(conf, time.Hour, nil)
+	scheduler.SetTargets([]Target{{Address: mock.Addr()}})
+	err = scheduler.Monitors()[0].Run(context.Background())
+	if err != nil {
+		t.Fatal("Failed to run monitor: ", err.Error())
+	}
+	err = scheduler.SaveBaselines(state)
+	scheduler.Stop()
+	if err != nil {
+		t.Fatal("Failed to save baselines: ", err.Error())
+	}
+
+	// restarted exporter
+	restarted := NewScheduler(conf, time.Hour, nil)
+	err = restarted.LoadBaselines(state)
+	if err != nil {
+		t.Fatal("Failed to load baselines: ", err.Error())
+	}
+	restarted.SetTargets([]Target{{Address: mock.Addr()}})
+	defer restarted.Stop()
+	m := restarted.Monitors()[0]
+	err = m.Run(context.Background())
+	if err != nil {
+		t.Fatal("Failed to run monitor: ", err.Error())
+	}
+	if m.Rates()["wallet-oauth-token"].Servers["alpha"].Requests <= 0 {
+		t.Errorf("Expected rates on the first scrape after a restart, got %+v", m.Rates())
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+// captureTimeFormat used for naming captured files, sortable by name
+const captureTimeFormat = "20060102-150405.000"
+
+// runCapture save raw stats payloads (and the config used) for later replay and bug reports
+func runCapture(args []string) error {
+	fs := flag.NewFlagSet("capture", flag.ExitOnError)
+	target := fs.String("target", "localhost:22222", "twemproxy stats host")
+	out := fs.String("out", "files/", "directory to write the captured payloads")
+	confPath := fs.String("config", "", "nutcracker config to save alongside the payloads")
+	count := fs.Int("count", 1, "number of payloads to capture")
+	interval := fs.Duration("interval", time.Second*3, "interval between captures")
+	fs.Parse(args)
+
+	err := os.MkdirAll(*out, 0755)
+	if err != nil {
+		return err
+	}
+	// host:port is not a friendly file name
+	prefix := strings.Replace(*target, ":", "_", -1)
+
+	if *confPath != "" {
+		content, err := ioutil.ReadFile(*confPath)
+		if err != nil {
+			return fmt.Errorf("Cannot open: %s. Error: %s", *confPath, err.Error())
+		}
+		name := filepath.Join(*out, fmt.Sprintf("%s-%s.yml", prefix, time.Now().Format(captureTimeFormat)))
+		err = ioutil.WriteFile(name, content, 0644)
+		if err != nil {
+			return err
+		}
+		log.Printf("Saved config %s to %s", *confPath, name)
+	}
+
+	for i := 0; i < *count; i++ {
+		if i > 0 {
+			time.Sleep(*interval)
+		}
+
+		payload, err := stats.Fetch(context.Background(), *target, nil, 0)
+		if err != nil {
+			return fmt.Errorf("Cannot fetch stats from %s. Error: %s", *target, err.Error())
+		}
+		// still save the payload, a broken one is exactly what we want attached to a bug report
+		if !json.Valid(payload) {
+			log.Printf("Payload from %s is not valid JSON (%d bytes)", *target, len(payload))
+		}
+
+		name := filepath.Join(*out, fmt.Sprintf("%s-%s.json", prefix, time.Now().Format(captureTimeFormat)))
+		err = ioutil.WriteFile(name, payload, 0644)
+		if err != nil {
+			return err
+		}
+		log.Printf("Captured %d bytes from %s to %s", len(payload), *target, name)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/rate"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+// Nagios plugin exit codes
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+var checkStatusNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// checkThresholds of the check subcommand, a value reaching a threshold above 0 raise the status
+type checkThresholds struct {
+	UnavailableWarning  float64
+	UnavailableCritical float64
+	ErrorsWarning       float64
+	ErrorsCritical      float64
+	QueueWarning        float64
+	QueueCritical       float64
+}
+
+// runCheck scrape twemproxy once like a Nagios or Icinga plugin, print the status line with perfdata
+// and return the exit code
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	target := fs.String("target", "localhost:22222", "twemproxy stats host")
+	confPath := fs.String("config", "", "nutcracker config of the pools and servers to check")
+	timeout := fs.Duration("timeout", stats.Timeout, "timeout of every fetch of the stats")
+	interval := fs.Duration("interval", time.Second, "time between the two fetches measuring the error rate, only with error rate thresholds")
+	th := checkThresholds{}
+	fs.Float64Var(&th.UnavailableWarning, "unavailable.warning", 1, "unavailable servers of all the pools for WARNING, 0 to disable")
+	fs.Float64Var(&th.UnavailableCritical, "unavailable.critical", 0, "unavailable servers of all the pools for CRITICAL, 0 to disable")
+	fs.Float64Var(&th.ErrorsWarning, "errors.warning", 0, "errors and timeouts per second of any server for WARNING, 0 to disable")
+	fs.Float64Var(&th.ErrorsCritical, "errors.critical", 0, "errors and timeouts per second of any server for CRITICAL, 0 to disable")
+	fs.Float64Var(&th.QueueWarning, "queue.warning", 0, "in and out queue requests of any server for WARNING, 0 to disable")
+	fs.Float64Var(&th.QueueCritical, "queue.critical", 0, "in and out queue requests of any server for CRITICAL, 0 to disable")
+	fs.Parse(args)
+
+	if *confPath == "" {
+		fmt.Println("TWEMPROXY UNKNOWN - -config is required")
+		return checkUnknown
+	}
+	conf, err := config.LoadConfig(*confPath)
+	if err != nil {
+		fmt.Printf("TWEMPROXY UNKNOWN - Cannot load config %s. Error: %s\n", *confPath, err.Error())
+		return checkUnknown
+	}
+
+	var st stats.TwemproxyStats
+	var rates map[string]rate.PoolRates
+	if th.ErrorsWarning > 0 || th.ErrorsCritical > 0 {
+		st, rates, err = checkRates(*target, conf, *timeout, *interval)
+	} else {
+		st, err = checkFetch(*target, conf, *timeout)
+	}
+	if err != nil {
+		fmt.Printf("TWEMPROXY CRITICAL - Cannot scrape %s. Error: %s\n", *target, err.Error())
+		return checkCritical
+	}
+
+	status, line := evaluateCheck(st, rates, th)
+	fmt.Println(line)
+	return status
+}
+
+func checkFetch(target string, conf map[string]config.Config, timeout time.Duration) (stats.TwemproxyStats, error) {
+	payload, err := stats.Fetch(context.Background(), target, nil, timeout)
+	if err != nil {
+		return stats.TwemproxyStats{}, err
+	}
+	return stats.Parse(payload, conf)
+}
+
+// checkRates fetch the stats twice, interval apart, and return the last stats with the rates in between.
+// The rates are over the time measured between the fetches, which is longer than the interval by the fetch time
+func checkRates(target string, conf map[string]config.Config, timeout time.Duration, interval time.Duration) (stats.TwemproxyStats, map[string]rate.PoolRates, error) {
+	prev, err := checkFetch(target, conf, timeout)
+	if err != nil {
+		return stats.TwemproxyStats{}, nil, err
+	}
+	prevTime := time.Now()
+	time.Sleep(interval)
+	cur, err := checkFetch(target, conf, timeout)
+	if err != nil {
+		return stats.TwemproxyStats{}, nil, err
+	}
+	return cur, rate.Compute(prev, cur, time.Since(prevTime)), nil
+}
+
+// evaluateCheck return the status and the status line with perfdata, errors are only checked when rates is not nil
+func evaluateCheck(st stats.TwemproxyStats, rates map[string]rate.PoolRates, th checkThresholds) (int, string) {
+	var unavailable []string
+	maxQueue, queueServer := 0.0, ""
+	for poolName, pool := range st.Services {
+		if pool.Missing > 0 {
+			unavailable = append(unavailable, fmt.Sprintf("%s: %d missing", poolName, pool.Missing))
+		}
+		for name, server := range pool.Servers {
+			if server.ServerConnections < 1 {
+				unavailable = append(unavailable, poolName+": "+name)
+			}
+			if queue := server.InQueue + server.OutQueue; queue > maxQueue || queueServer == "" {
+				maxQueue, queueServer = queue, poolName+"/"+name
+			}
+		}
+	}
+	sort.Strings(unavailable)
+	maxErrors, errorsServer := 0.0, ""
+	for poolName, r := range rates {
+		for name, server := range r.Servers {
+			if server.Errors > maxErrors || errorsServer == "" {
+				maxErrors, errorsServer = server.Errors, poolName+"/"+name
+			}
+		}
+	}
+
+	status := checkOK
+	raise := func(value, warning, critical float64) {
+		if critical > 0 && value >= critical {
+			status = checkCritical
+		} else if warning > 0 && value >= warning && status < checkWarning {
+			status = checkWarning
+		}
+	}
+	raise(float64(st.NotAvailable), th.UnavailableWarning, th.UnavailableCritical)
+	raise(maxQueue, th.QueueWarning, th.QueueCritical)
+
+	parts := []string{fmt.Sprintf("%d/%d servers unavailable", st.NotAvailable, st.ExpectedAvailable)}
+	if len(unavailable) > 0 {
+		parts[0] += " (" + strings.Join(unavailable, ", ") + ")"
+	}
+	perfdata := []string{
+		perfValue("unavailable", float64(st.NotAvailable), th.UnavailableWarning, th.UnavailableCritical) + ";0;" + strconv.Itoa(st.ExpectedAvailable),
+		perfValue("queue", maxQueue, th.QueueWarning, th.QueueCritical) + ";0",
+	}
+	if rates != nil {
+		raise(maxErrors, th.ErrorsWarning, th.ErrorsCritical)
+		part := fmt.Sprintf("max errors %.2f/s", maxErrors)
+		if maxErrors > 0 {
+			part += " on " + errorsServer
+		}
+		parts = append(parts, part)
+		perfdata = append(perfdata, perfValue("errors_per_second", maxErrors, th.ErrorsWarning, th.ErrorsCritical)+";0")
+	}
+	part := fmt.Sprintf("max queue %.0f", maxQueue)
+	if maxQueue > 0 {
+		part += " on " + queueServer
+	}
+	parts = append(parts, part)
+
+	return status, fmt.Sprintf("TWEMPROXY %s - %s | %s", checkStatusNames[status], strings.Join(parts, ", "), strings.Join(perfdata, " "))
+}
+
+// perfValue is label=value;warn;crit with 3 decimals at most, disabled thresholds are left empty
+func perfValue(label string, value float64, warning float64, critical float64) string {
+	value = math.Round(value*1000) / 1000
+	threshold := func(v float64) string {
+		if v <= 0 {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprintf("%s=%s;%s;%s", label, strconv.FormatFloat(value, 'f', -1, 64), threshold(warning), threshold(critical))
+}
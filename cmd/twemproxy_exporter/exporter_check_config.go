@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// runCheckConfig validate a nutcracker config like the exporter load it, print the errors and the warnings and
+// return the exit code: 1 when the config is invalid, or has warnings with -strict
+func runCheckConfig(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ExitOnError)
+	confPath := fs.String("config", "", "nutcracker config to check")
+	strict := fs.Bool("strict", false, "fail on warnings too, e.g. duplicate servers")
+	fs.Parse(args)
+
+	if *confPath == "" {
+		fmt.Println("-config is required")
+		return 2
+	}
+	conf, err := config.LoadConfig(*confPath)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 1
+	}
+	warnings := config.Warnings(conf)
+	if len(warnings) > 0 {
+		fmt.Println("Config warnings:")
+		for _, w := range warnings {
+			fmt.Printf("  %s\n", w.String())
+		}
+	}
+	servers := 0
+	for _, pool := range conf {
+		servers += len(pool.Servers)
+	}
+	fmt.Printf("%s: %d pools, %d servers, %d warnings\n", *confPath, len(conf), servers, len(warnings))
+	if *strict && len(warnings) > 0 {
+		return 1
+	}
+	return 0
+}
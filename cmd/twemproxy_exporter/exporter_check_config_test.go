@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestCheckConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nutcracker.yml")
+	ioutil.WriteFile(path, []byte("alpha:\n  redis: true\n  servers:\n    - 10.0.0.1:6379:1 redis-1\n    - 10.0.0.1:6379:1 redis-2\n"), 0644)
+	if code := runCheckConfig([]string{"-config", path}); code != 0 {
+		t.Errorf("Expected warnings to pass, got %d", code)
+	}
+	if code := runCheckConfig([]string{"-config", path, "-strict"}); code != 1 {
+		t.Errorf("Expected warnings to fail with -strict, got %d", code)
+	}
+	if code := runCheckConfig([]string{"-config", "../../files/nutcracker.yml", "-strict"}); code != 0 {
+		t.Errorf("Expected the example config to pass, got %d", code)
+	}
+	invalid := filepath.Join(t.TempDir(), "invalid.yml")
+	ioutil.WriteFile(invalid, []byte("alpha:\n  hash: sha1\n  servers:\n    - 10.0.0.1:6379:1\n"), 0644)
+	if code := runCheckConfig([]string{"-config", invalid}); code != 1 {
+		t.Errorf("Expected an invalid config to fail, got %d", code)
+	}
+
+	conf, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnConfig(conf)
+	if value := testutil.GatherValue(t, configWarnings.WithLabelValues(config.WarningDuplicateAddress), "twemproxy_exporter_config_warnings"); value != 1 {
+		t.Errorf("Expected 1 duplicate address, got %f", value)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/rate"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+func TestEvaluateCheck(t *testing.T) {
+	th := checkThresholds{UnavailableWarning: 1, UnavailableCritical: 2, QueueWarning: 100, QueueCritical: 1000, ErrorsWarning: 1, ErrorsCritical: 10}
+	queued := testutil.ExampleStats(t)
+	alpha := queued.Services[testutil.ExamplePool].Servers["alpha"]
+	alpha.InQueue = 2000
+	queued.Services[testutil.ExamplePool].Servers["alpha"] = alpha
+	tests := []struct {
+		name   string
+		stats  stats.TwemproxyStats
+		rates  map[string]rate.PoolRates
+		status int
+		line   string
+	}{
+		{"ok", testutil.ExampleStats(t), nil, checkOK,
+			"TWEMPROXY OK - 0/2 servers unavailable, max queue 1 on wallet-oauth-token/beta | unavailable=0;1;2;0;2 queue=1;100;1000;0"},
+		{"unavailable", testutil.ExampleStats(t, "beta"), nil, checkWarning,
+			"TWEMPROXY WARNING - 1/2 servers unavailable (wallet-oauth-token: beta), max queue 1 on wallet-oauth-token/beta | unavailable=1;1;2;0;2 queue=1;100;1000;0"},
+		{"queue", queued, nil, checkCritical,
+			"TWEMPROXY CRITICAL - 0/2 servers unavailable, max queue 2000 on wallet-oauth-token/alpha | unavailable=0;1;2;0;2 queue=2000;100;1000;0"},
+		{"errors", testutil.ExampleStats(t), map[string]rate.PoolRates{testutil.ExamplePool: {Servers: map[string]rate.ServerRates{"alpha": {Errors: 2.5}}}}, checkWarning,
+			"TWEMPROXY WARNING - 0/2 servers unavailable, max errors 2.50/s on wallet-oauth-token/alpha, max queue 1 on wallet-oauth-token/beta | unavailable=0;1;2;0;2 queue=1;100;1000;0 errors_per_second=2.5;1;10;0"},
+	}
+	for _, test := range tests {
+		status, line := evaluateCheck(test.stats, test.rates, th)
+		if status != test.status || line != test.line {
+			t.Errorf("%s: expected %d %q, got %d %q", test.name, test.status, test.line, status, line)
+		}
+	}
+
+	status, line := evaluateCheck(testutil.ExampleStats(t, "beta"), nil, checkThresholds{})
+	if status != checkOK || line != "TWEMPROXY OK - 1/2 servers unavailable (wallet-oauth-token: beta), max queue 1 on wallet-oauth-token/beta | unavailable=1;;;0;2 queue=1;;;0" {
+		t.Errorf("Expected OK without thresholds, got %d %q", status, line)
+	}
+}
+
+func TestCheckRatesOverElapsedTime(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	// every fetch grow the requests by 100 and take longer than the interval
+	mock := startMockServer(t, func(m *mockServer) { m.Delay = time.Millisecond * 200 })
+	defer mock.Close()
+
+	_, rates, err := checkRates(mock.Addr(), conf, time.Second*5, time.Millisecond*10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := 0
+	for _, pool := range rates {
+		for name, server := range pool.Servers {
+			servers++
+			// over the 10ms interval alone it would be 10000/s
+			if server.Requests <= 0 || server.Requests > 100/0.2 {
+				t.Errorf("Expected the requests of %s to be over at least 200ms, got %f/s", name, server.Requests)
+			}
+		}
+	}
+	if servers == 0 {
+		t.Fatal("Expected the rates of the servers")
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gceMetadataURL is the GCE metadata server
+var gceMetadataURL = "http://metadata.google.internal"
+
+func gceMetadataGet(path string) (string, error) {
+	client := &http.Client{Timeout: time.Second * 2}
+	req, err := http.NewRequest("GET", gceMetadataURL+"/computeMetadata/v1/"+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Metadata %s returned %s", path, resp.Status)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// ec2Labels of the instance, nil when not running on EC2
+func ec2Labels() prometheus.Labels {
+	labels := prometheus.Labels{}
+	for label, path := range map[string]string{
+		"region":      "/latest/meta-data/placement/region",
+		"zone":        "/latest/meta-data/placement/availability-zone",
+		"instance_id": "/latest/meta-data/instance-id",
+	} {
+		value, err := imdsGet(path)
+		if err != nil {
+			return nil
+		}
+		labels[label] = value
+	}
+	return labels
+}
+
+// gceLabels of the instance, nil when not running on GCE
+func gceLabels() prometheus.Labels {
+	// projects/123/zones/europe-west1-b
+	zone, err := gceMetadataGet("instance/zone")
+	if err != nil {
+		return nil
+	}
+	zone = zone[strings.LastIndex(zone, "/")+1:]
+	id, err := gceMetadataGet("instance/id")
+	if err != nil {
+		return nil
+	}
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		region = zone[:i]
+	}
+	return prometheus.Labels{"region": region, "zone": zone, "instance_id": id}
+}
+
+// cloudLabels detect the cloud the exporter runs on and return its region, zone and instance id.
+// Empty when the exporter doesn't run on EC2 nor GCE
+func cloudLabels() prometheus.Labels {
+	if labels := ec2Labels(); labels != nil {
+		return labels
+	}
+	if labels := gceLabels(); labels != nil {
+		return labels
+	}
+	return prometheus.Labels{}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGCELabels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing header", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/zone":
+			fmt.Fprint(w, "projects/123/zones/europe-west1-b")
+		case "/computeMetadata/v1/instance/id":
+			fmt.Fprint(w, "4242")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer func(url string) { gceMetadataURL = url }(gceMetadataURL)
+	gceMetadataURL = server.URL
+
+	labels := gceLabels()
+	if labels["region"] != "europe-west1" || labels["zone"] != "europe-west1-b" || labels["instance_id"] != "4242" {
+		t.Errorf("Unexpected labels %v", labels)
+	}
+}
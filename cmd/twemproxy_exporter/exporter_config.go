@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// configPoolErrors is the number of invalid pools skipped with -config.lenient
+var configPoolErrors = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: collector.Namespace,
+	Subsystem: "exporter",
+	Name:      "config_pool_errors",
+	Help:      "Invalid pools of the nutcracker config skipped with -config.lenient",
+})
+
+// configWarnings of the loaded config by kind, servers nutcracker accept but whose series are merged
+var configWarnings = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: collector.Namespace,
+	Subsystem: "exporter",
+	Name:      "config_warnings",
+	Help:      "Duplicate server addresses and aliases of the nutcracker config by kind",
+}, []string{"kind"})
+
+func init() {
+	prometheus.MustRegister(configPoolErrors)
+	prometheus.MustRegister(configWarnings)
+}
+
+// warnConfig log the warnings of the config and export their number by kind
+func warnConfig(conf map[string]config.Config) {
+	counts := map[string]float64{config.WarningDuplicateAddress: 0, config.WarningDuplicateAlias: 0, config.WarningAliasCollision: 0}
+	for _, w := range config.Warnings(conf) {
+		log.Printf("Config warning, %s", w.String())
+		counts[w.Kind]++
+	}
+	for kind, count := range counts {
+		configWarnings.WithLabelValues(kind).Set(count)
+	}
+}
+
+// lenientConfig keep the valid pools of an invalid config when -config.lenient is set, logging the invalid ones.
+// A config without any valid pool is still an error
+func lenientConfig(conf map[string]config.Config, err error) (map[string]config.Config, error) {
+	var errs config.ConfigErrors
+	if !errors.As(err, &errs) || !*configLenient || len(conf) == 0 {
+		if err == nil {
+			configPoolErrors.Set(0)
+		}
+		return conf, err
+	}
+	invalid := make(map[string]bool)
+	for _, e := range errs {
+		log.Printf("Skipping invalid %s", e.Error())
+		invalid[e.Pool] = true
+	}
+	configPoolErrors.Set(float64(len(invalid)))
+	return conf, nil
+}
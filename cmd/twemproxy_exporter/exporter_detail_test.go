@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+func TestPoolDetail(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	tests := []struct {
+		level    string
+		exported []string
+		dropped  []string
+	}{
+		{monitor.DetailFull, []string{"twemproxy_server_connection", "twemproxy_pool_hot_server", "twemproxy_server_requests_per_second", "twemproxy_pool_servers_configured"}, nil},
+		{monitor.DetailSummary, []string{"twemproxy_pool_servers_configured", "twemproxy_pool_request_bytes", "twemproxy_pool_info"}, []string{"twemproxy_server_up", "twemproxy_server_requests_per_second", "twemproxy_pool_hot_server"}},
+		{monitor.DetailAvailability, []string{"twemproxy_server_up", "twemproxy_up"}, []string{"twemproxy_server_connection", "twemproxy_pool_servers_configured", "twemproxy_pool_info", "twemproxy_pool_request_bytes_per_second"}},
+	}
+	for _, test := range tests {
+		mock := startMockServer(t)
+		scheduler := NewScheduler(conf, time.Hour, nil, monitor.WithRates(true), monitor.WithTopN(1),
+			monitor.WithPoolDetail(map[string]string{"wallet-oauth-token": test.level}))
+		scheduler.SetTargets([]Target{{Address: mock.Addr()}})
+		m := scheduler.Monitors()[0]
+		for i := 0; i < 2; i++ {
+			err := m.Run(context.Background())
+			if err != nil {
+				t.Fatal("Failed to run monitor: ", err.Error())
+			}
+		}
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(scheduler)
+		families, err := registry.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := map[string]bool{}
+		for _, family := range families {
+			names[family.GetName()] = true
+		}
+		for _, name := range test.exported {
+			if !names[name] {
+				t.Errorf("Expected %s to be exported at %s detail", name, test.level)
+			}
+		}
+		for _, name := range test.dropped {
+			if names[name] {
+				t.Errorf("Expected %s not to be exported at %s detail", name, test.level)
+			}
+		}
+		scheduler.Stop()
+		mock.Close()
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/twemproxy"
+)
+
+// statsDialer of the stats connections, from -twemphost.source-address and -twemphost.proxy, nil for the default
+var statsDialer twemproxy.ContextDialer
+
+// newStatsDialer bound to the source address, an IP or an interface name, and through the proxy url, whose user
+// authenticate with the password secret. The url can't hold the password, flags are visible to everyone with ps.
+// The host names are resolved with the cache, or by the proxy when there is one. nil when there is nothing to set
+func newStatsDialer(source, proxy string, password *config.Secret, cache *dnsCache) (twemproxy.ContextDialer, error) {
+	if source == "" && proxy == "" && cache == nil {
+		return nil, nil
+	}
+	var ip net.IP
+	if source != "" {
+		var err error
+		ip, err = sourceIP(source)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dialer, err := twemproxy.NewDialer(ip, proxy)
+	if err != nil {
+		return nil, err
+	}
+	if dialer.Proxy != nil {
+		if _, ok := dialer.Proxy.User.Password(); ok {
+			return nil, errors.New("The proxy url can't hold a password, use -twemphost.proxy.password-file")
+		}
+		if password != nil {
+			if dialer.Proxy.User == nil {
+				return nil, errors.New("The proxy password needs a user in the proxy url")
+			}
+			dialer.ProxyPassword = password.Get
+		}
+	}
+	if cache == nil || proxy != "" {
+		return dialer, nil
+	}
+	return &dnsDialer{cache: cache, dialer: dialer}, nil
+}
+
+// sourceIP is the IP, or the first address of the interface
+func sourceIP(source string) (net.IP, error) {
+	if ip := net.ParseIP(source); ip != nil {
+		return ip, nil
+	}
+	iface, err := net.InterfaceByName(source)
+	if err != nil {
+		return nil, fmt.Errorf("Source address %s is neither an IP nor an interface. Error: %s", source, err.Error())
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+			return ipNet.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("Interface %s has no address", source)
+}
+
+// newStatsSource of the address, dialed with statsDialer
+func newStatsSource(address string, tlsConfig *tls.Config) (twemproxy.StatsSource, error) {
+	source, err := twemproxy.NewSource(address, tlsConfig)
+	if err != nil || statsDialer == nil {
+		return source, err
+	}
+	switch s := source.(type) {
+	case *twemproxy.TCPSource:
+		s.Dialer = statsDialer
+	case *twemproxy.HTTPSource:
+		s.Client = &http.Client{Transport: &http.Transport{DialContext: statsDialer.DialContext, TLSClientConfig: tlsConfig}}
+	}
+	return source, nil
+}
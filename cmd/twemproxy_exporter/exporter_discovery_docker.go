@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dockerPortLabel on a container override the twemproxy stats port
+const dockerPortLabel = "twemproxy-exporter.io/port"
+
+// dockerContainer is the part of the docker container list we care about
+type dockerContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	Labels map[string]string `json:"Labels"`
+	Ports  []struct {
+		IP          string `json:"IP"`
+		PrivatePort int    `json:"PrivatePort"`
+		PublicPort  int    `json:"PublicPort"`
+		Type        string `json:"Type"`
+	} `json:"Ports"`
+	HostConfig struct {
+		NetworkMode string `json:"NetworkMode"`
+	} `json:"HostConfig"`
+	NetworkSettings struct {
+		Networks map[string]struct {
+			IPAddress string `json:"IPAddress"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// dockerDiscovery find the running containers matching an image pattern and/or a label
+type dockerDiscovery struct {
+	endpoint string
+	client   *http.Client
+	image    *regexp.Regexp
+	label    string
+	value    string
+	port     int
+}
+
+// newDockerDiscovery talking to the daemon at unix:///path or tcp://host:port
+func newDockerDiscovery(host string, image string, label string, port int) (*dockerDiscovery, error) {
+	d := &dockerDiscovery{port: port}
+	if image != "" {
+		re, err := regexp.Compile(image)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid docker image pattern %s. Error: %s", image, err.Error())
+		}
+		d.image = re
+	}
+	if label != "" {
+		p := strings.SplitN(label, "=", 2)
+		d.label = p[0]
+		if len(p) == 2 {
+			d.value = p[1]
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(host, "unix://"):
+		socket := strings.TrimPrefix(host, "unix://")
+		d.endpoint = "http://docker"
+		d.client = &http.Client{
+			Timeout: time.Second * 10,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		}
+	case strings.HasPrefix(host, "tcp://"):
+		d.endpoint = "http://" + strings.TrimPrefix(host, "tcp://")
+		d.client = &http.Client{Timeout: time.Second * 10}
+	default:
+		return nil, fmt.Errorf("Invalid docker host %s, expected unix:// or tcp://", host)
+	}
+	return d, nil
+}
+
+// matches is true for the containers to monitor
+func (d *dockerDiscovery) matches(c dockerContainer) bool {
+	if d.image != nil && !d.image.MatchString(c.Image) {
+		return false
+	}
+	if d.label != "" {
+		value, ok := c.Labels[d.label]
+		if !ok || (d.value != "" && value != d.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// address of the container stats endpoint, empty when it cannot be reached.
+// Host networking use localhost, published ports are preferred over the container IP
+func (d *dockerDiscovery) address(c dockerContainer) string {
+	port := d.port
+	if p, err := strconv.Atoi(c.Labels[dockerPortLabel]); err == nil {
+		port = p
+	}
+
+	if c.HostConfig.NetworkMode == "host" {
+		return net.JoinHostPort("localhost", strconv.Itoa(port))
+	}
+	for _, p := range c.Ports {
+		if p.PrivatePort != port || p.PublicPort == 0 || p.Type != "tcp" {
+			continue
+		}
+		ip := p.IP
+		if ip == "" || ip == "0.0.0.0" || ip == "::" {
+			ip = "localhost"
+		}
+		return net.JoinHostPort(ip, strconv.Itoa(p.PublicPort))
+	}
+	for _, n := range c.NetworkSettings.Networks {
+		if n.IPAddress != "" {
+			return net.JoinHostPort(n.IPAddress, strconv.Itoa(port))
+		}
+	}
+	return ""
+}
+
+// targets of the running matching containers
+func (d *dockerDiscovery) targets() ([]Target, error) {
+	resp, err := d.client.Get(d.endpoint + "/containers/json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Docker container list returned %s", resp.Status)
+	}
+	var containers []dockerContainer
+	err = json.NewDecoder(resp.Body).Decode(&containers)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []Target
+	for _, c := range containers {
+		if !d.matches(c) {
+			continue
+		}
+		address := d.address(c)
+		if address == "" {
+			continue
+		}
+		targets = append(targets, Target{Address: address})
+	}
+	return targets, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const dockerContainers = `[
+	{"Id": "1", "Image": "twemproxy:0.4.1", "HostConfig": {"NetworkMode": "host"}},
+	{"Id": "2", "Image": "twemproxy:0.4.1", "HostConfig": {"NetworkMode": "bridge"},
+		"Ports": [{"IP": "0.0.0.0", "PrivatePort": 22222, "PublicPort": 32768, "Type": "tcp"}]},
+	{"Id": "3", "Image": "twemproxy:0.4.1", "Labels": {"twemproxy-exporter.io/port": "22223"}, "HostConfig": {"NetworkMode": "bridge"},
+		"NetworkSettings": {"Networks": {"bridge": {"IPAddress": "172.17.0.3"}}}},
+	{"Id": "4", "Image": "redis:5", "HostConfig": {"NetworkMode": "host"}}
+]`
+
+func TestDockerDiscovery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/json" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, dockerContainers)
+	}))
+	defer server.Close()
+
+	d, err := newDockerDiscovery(strings.Replace(server.URL, "http://", "tcp://", 1), "^twemproxy:", "", 22222)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, err := d.targets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"localhost:22222", "localhost:32768", "172.17.0.3:22223"}
+	if len(targets) != len(expected) {
+		t.Fatalf("Expected %d targets, got %+v", len(expected), targets)
+	}
+	for i, address := range expected {
+		if targets[i].Address != address {
+			t.Errorf("Expected target %s, got %s", address, targets[i].Address)
+		}
+	}
+}
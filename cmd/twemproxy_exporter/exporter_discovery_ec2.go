@@ -0,0 +1,275 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoAWSCredentials returned when neither the env vars nor an instance IAM role give credentials
+var ErrNoAWSCredentials = errors.New("no AWS credentials in the environment nor from the instance IAM role")
+
+// imdsURL is the EC2 instance metadata service
+var imdsURL = "http://169.254.169.254"
+
+// imdsGet read a path of the instance metadata, using an IMDSv2 session token
+func imdsGet(path string) (string, error) {
+	client := &http.Client{Timeout: time.Second * 2}
+
+	req, err := http.NewRequest("PUT", imdsURL+"/latest/api/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	token, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Metadata token request returned %s", resp.Status)
+	}
+
+	req, err = http.NewRequest("GET", imdsURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+	resp, err = client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Metadata %s returned %s", path, resp.Status)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// awsCredentials from the environment or the instance IAM role, role credentials are cached until they expire
+type awsCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+var (
+	roleCredentials   awsCredentials
+	roleCredentialsMu sync.Mutex
+)
+
+func getAWSCredentials() (awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			Token:           os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	roleCredentialsMu.Lock()
+	defer roleCredentialsMu.Unlock()
+	// role credentials are rotated well before they expire
+	if time.Until(roleCredentials.Expiration) > time.Minute*5 {
+		return roleCredentials, nil
+	}
+	role, err := imdsGet("/latest/meta-data/iam/security-credentials/")
+	if err != nil || role == "" {
+		return awsCredentials{}, ErrNoAWSCredentials
+	}
+	content, err := imdsGet("/latest/meta-data/iam/security-credentials/" + strings.Split(role, "\n")[0])
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	creds := awsCredentials{}
+	err = json.Unmarshal([]byte(content), &creds)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	roleCredentials = creds
+	return creds, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	h := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(h[:])
+}
+
+// signAWSRequest sign a GET request without body with signature version 4
+func signAWSRequest(req *http.Request, creds awsCredentials, region string, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	// S3 need the payload hash signed, the other services sign the hash without the header
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	headers := "host:" + req.URL.Host + "\n"
+	signedHeaders := "host"
+	if payloadHash != "" {
+		headers += "x-amz-content-sha256:" + payloadHash + "\n"
+		signedHeaders += ";x-amz-content-sha256"
+	} else {
+		payloadHash = sha256Hex("")
+	}
+	headers += "x-amz-date:" + amzDate + "\n"
+	signedHeaders += ";x-amz-date"
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+		headers += "x-amz-security-token:" + creds.Token + "\n"
+		signedHeaders += ";x-amz-security-token"
+	}
+	// AWS want spaces as %20, Encode already sort by key
+	query := strings.Replace(req.URL.Query().Encode(), "+", "%20", -1)
+	req.URL.RawQuery = query
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	canonical := strings.Join([]string{req.Method, path, query, headers, signedHeaders, payloadHash}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex(canonical)
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// ec2Instances is the part of the DescribeInstances response we care about
+type ec2Instances struct {
+	Reservations []struct {
+		Instances []struct {
+			InstanceID       string `xml:"instanceId"`
+			PrivateIPAddress string `xml:"privateIpAddress"`
+		} `xml:"instancesSet>item"`
+	} `xml:"reservationSet>item"`
+	NextToken string `xml:"nextToken"`
+}
+
+// ec2Discovery find the running instances having a tag, the ones of an ASG share the same tags
+type ec2Discovery struct {
+	endpoint string
+	region   string
+	key      string
+	value    string
+	port     int
+}
+
+// newEC2Discovery from key=value, the region default to AWS_REGION then to the region of this instance
+func newEC2Discovery(tag string, region string, port int) (*ec2Discovery, error) {
+	p := strings.SplitN(tag, "=", 2)
+	if len(p) != 2 || p[0] == "" {
+		return nil, fmt.Errorf("Invalid EC2 tag %s, expected key=value", tag)
+	}
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		var err error
+		region, err = imdsGet("/latest/meta-data/placement/region")
+		if err != nil {
+			return nil, fmt.Errorf("Cannot find the AWS region, set -targets.ec2.region. Error: %s", err.Error())
+		}
+	}
+	return &ec2Discovery{
+		endpoint: "https://ec2." + region + ".amazonaws.com",
+		region:   region,
+		key:      p[0],
+		value:    p[1],
+		port:     port,
+	}, nil
+}
+
+// targets of the running tagged instances, at their private address
+func (d *ec2Discovery) targets() ([]Target, error) {
+	creds, err := getAWSCredentials()
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: time.Second * 30}
+
+	var targets []Target
+	nextToken := ""
+	for {
+		query := url.Values{
+			"Action":           {"DescribeInstances"},
+			"Version":          {"2016-11-15"},
+			"Filter.1.Name":    {"tag:" + d.key},
+			"Filter.1.Value.1": {d.value},
+			"Filter.2.Name":    {"instance-state-name"},
+			"Filter.2.Value.1": {"running"},
+		}
+		if nextToken != "" {
+			query.Set("NextToken", nextToken)
+		}
+		req, err := http.NewRequest("GET", d.endpoint+"/?"+query.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		signAWSRequest(req, creds, d.region, "ec2", time.Now())
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("DescribeInstances returned %s: %s", resp.Status, strings.TrimSpace(string(content)))
+		}
+
+		instances := ec2Instances{}
+		err = xml.Unmarshal(content, &instances)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range instances.Reservations {
+			for _, i := range r.Instances {
+				if i.PrivateIPAddress == "" {
+					continue
+				}
+				targets = append(targets, Target{Address: net.JoinHostPort(i.PrivateIPAddress, strconv.Itoa(d.port))})
+			}
+		}
+		if instances.NextToken == "" {
+			return targets, nil
+		}
+		nextToken = instances.NextToken
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+const describeInstancesPage = `<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+	<reservationSet>
+		<item>
+			<instancesSet>
+				<item><instanceId>i-%d</instanceId><privateIpAddress>10.0.0.%d</privateIpAddress></item>
+			</instancesSet>
+		</item>
+	</reservationSet>
+	<nextToken>%s</nextToken>
+</DescribeInstancesResponse>`
+
+func TestEC2Discovery(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+			t.Errorf("Request is not signed: %s", r.Header.Get("Authorization"))
+		}
+		if r.URL.Query().Get("Filter.1.Name") != "tag:role" {
+			t.Errorf("Unexpected filter %s", r.URL.Query().Get("Filter.1.Name"))
+		}
+		// two pages
+		if r.URL.Query().Get("NextToken") == "" {
+			fmt.Fprintf(w, describeInstancesPage, 1, 1, "page2")
+			return
+		}
+		fmt.Fprintf(w, describeInstancesPage, 2, 2, "")
+	}))
+	defer server.Close()
+
+	d, err := newEC2Discovery("role=twemproxy", "eu-west-1", 22222)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.endpoint = server.URL
+	targets, err := d.targets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 2 || targets[0].Address != "10.0.0.1:22222" || targets[1].Address != "10.0.0.2:22222" {
+		t.Errorf("Unexpected targets %+v", targets)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// targetAnnotation on a Service declare the twemproxy stats endpoints behind it, comma separated
+//
+//	twemproxy-exporter.io/target: twemproxy.cache.svc:22222
+const targetAnnotation = "twemproxy-exporter.io/target"
+
+// kubeTargetPaths of the objects declaring targets, the namespace is filled in when discovery is namespaced
+var kubeTargetPaths = map[string]string{
+	"services": "/api/v1/%sservices",
+	"crd":      "/apis/twemproxy-exporter.io/v1alpha1/%stwemproxytargets",
+}
+
+// kubeTargetObject is either a Service or a TwemproxyTarget custom resource
+//
+//	apiVersion: twemproxy-exporter.io/v1alpha1
+//	kind: TwemproxyTarget
+//	metadata: {name: cache, namespace: cache}
+//	spec: {address: "twemproxy.cache.svc:22222", instance: cache}
+type kubeTargetObject struct {
+	Metadata kubeObjectMeta `json:"metadata"`
+	Spec     Target         `json:"spec"`
+}
+
+type kubeTargetList struct {
+	Metadata kubeObjectMeta     `json:"metadata"`
+	Items    []kubeTargetObject `json:"items"`
+}
+
+// targets declared by the object, from the annotation for services and from the spec for the custom resource
+func (o kubeTargetObject) targets(kind string) []Target {
+	if kind == "crd" {
+		if o.Spec.Address == "" {
+			return nil
+		}
+		return []Target{o.Spec}
+	}
+	var targets []Target
+	for _, address := range strings.Split(o.Metadata.Annotations[targetAnnotation], ",") {
+		address = strings.TrimSpace(address)
+		if address != "" {
+			targets = append(targets, Target{Address: address})
+		}
+	}
+	return targets
+}
+
+func (o kubeTargetObject) key() string {
+	return o.Metadata.Namespace + "/" + o.Metadata.Name
+}
+
+// flattenTargets sorted by object, so the same objects always give the same list
+func flattenTargets(objects map[string][]Target) []Target {
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var targets []Target
+	for _, key := range keys {
+		targets = append(targets, objects[key]...)
+	}
+	return targets
+}
+
+// watchTargets call onChange with every declared target each time an object change.
+// It never return, failed watches are restarted after a short delay
+func (k *kubeClient) watchTargets(kind string, path string, onChange func([]Target)) {
+	for {
+		// list first, objects changed while we were not watching are not lost
+		list := kubeTargetList{}
+		err := k.get(path, &list)
+		if err != nil {
+			log.Printf("Cannot list kubernetes %s. Error: %s", kind, err.Error())
+			time.Sleep(kubeRetryInterval)
+			continue
+		}
+		objects := make(map[string][]Target)
+		for _, o := range list.Items {
+			if targets := o.targets(kind); len(targets) > 0 {
+				objects[o.key()] = targets
+			}
+		}
+		onChange(flattenTargets(objects))
+
+		err = k.watch(path, list.Metadata.ResourceVersion, func(event kubeEvent) {
+			o := kubeTargetObject{}
+			err := json.Unmarshal(event.Object, &o)
+			if err != nil {
+				log.Printf("Cannot decode kubernetes %s event. Error: %s", kind, err.Error())
+				return
+			}
+			switch event.Type {
+			case "ADDED", "MODIFIED":
+				targets := o.targets(kind)
+				if len(targets) == 0 {
+					// the annotation was removed
+					delete(objects, o.key())
+				} else {
+					objects[o.key()] = targets
+				}
+			case "DELETED":
+				delete(objects, o.key())
+			default:
+				return
+			}
+			onChange(flattenTargets(objects))
+		})
+		if err != nil {
+			log.Printf("Watch of kubernetes %s stopped. Error: %s", kind, err.Error())
+		}
+		time.Sleep(kubeRetryInterval)
+	}
+}
+
+// watchKubernetesTargets keep the scheduler targets in sync with the annotated services
+// and/or the TwemproxyTarget custom resources
+func watchKubernetesTargets(kinds string, namespace string, scheduler *Scheduler) error {
+	var paths []string
+	var names []string
+	for _, kind := range strings.Split(kinds, ",") {
+		kind = strings.TrimSpace(kind)
+		path, ok := kubeTargetPaths[kind]
+		if !ok {
+			return fmt.Errorf("Unknown kubernetes target source %s, expected services or crd", kind)
+		}
+		prefix := ""
+		if namespace != "" {
+			prefix = "namespaces/" + namespace + "/"
+		}
+		paths = append(paths, fmt.Sprintf(path, prefix))
+		names = append(names, kind)
+	}
+
+	client, err := newInClusterClient()
+	if err != nil {
+		return err
+	}
+	for i := range paths {
+		kind := names[i]
+		go client.watchTargets(kind, paths[i], func(targets []Target) {
+			scheduler.SetSource("kubernetes-"+kind, targets)
+		})
+	}
+	return nil
+}
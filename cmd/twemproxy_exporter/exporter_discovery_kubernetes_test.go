@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestKubeTargetObject(t *testing.T) {
+	service := kubeTargetObject{}
+	err := json.Unmarshal([]byte(`{"metadata": {"name": "cache", "namespace": "cache",
+		"annotations": {"twemproxy-exporter.io/target": "proxy-1:22222, proxy-2:22222"}}}`), &service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := service.targets("services")
+	if len(targets) != 2 || targets[1].Address != "proxy-2:22222" {
+		t.Errorf("Unexpected service targets %+v", targets)
+	}
+
+	crd := kubeTargetObject{}
+	err = json.Unmarshal([]byte(`{"metadata": {"name": "cache", "namespace": "cache"},
+		"spec": {"address": "proxy-1:22222", "instance": "cache"}}`), &crd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets = crd.targets("crd")
+	if len(targets) != 1 || targets[0].instanceLabel() != "cache" {
+		t.Errorf("Unexpected custom resource targets %+v", targets)
+	}
+}
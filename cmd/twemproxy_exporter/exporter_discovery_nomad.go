@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// nomadService is one registration of the Nomad native service discovery
+type nomadService struct {
+	ServiceName string   `json:"ServiceName"`
+	JobID       string   `json:"JobID"`
+	AllocID     string   `json:"AllocID"`
+	Tags        []string `json:"Tags"`
+	Address     string   `json:"Address"`
+	Port        int      `json:"Port"`
+}
+
+// nomadDiscovery find the allocations registering a service with all the given tags
+type nomadDiscovery struct {
+	address   string
+	namespace string
+	service   string
+	tags      []string
+	client    *http.Client
+}
+
+// newNomadDiscovery of the service, the address default to NOMAD_ADDR then to the local agent
+func newNomadDiscovery(address string, namespace string, service string, tags string) *nomadDiscovery {
+	if address == "" {
+		address = os.Getenv("NOMAD_ADDR")
+	}
+	if address == "" {
+		address = "http://127.0.0.1:4646"
+	}
+	d := &nomadDiscovery{
+		address:   strings.TrimRight(address, "/"),
+		namespace: namespace,
+		service:   service,
+		client:    &http.Client{Timeout: time.Second * 10},
+	}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			d.tags = append(d.tags, tag)
+		}
+	}
+	return d
+}
+
+func (d *nomadDiscovery) hasTags(s nomadService) bool {
+	for _, tag := range d.tags {
+		found := false
+		for _, t := range s.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// targets of the allocations registering the service, labeled with their job and allocation
+func (d *nomadDiscovery) targets() ([]Target, error) {
+	path := d.address + "/v1/service/" + url.PathEscape(d.service)
+	if d.namespace != "" {
+		path += "?namespace=" + url.QueryEscape(d.namespace)
+	}
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("NOMAD_TOKEN"); token != "" {
+		req.Header.Set("X-Nomad-Token", token)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Nomad service %s returned %s", d.service, resp.Status)
+	}
+	var services []nomadService
+	err = json.NewDecoder(resp.Body).Decode(&services)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []Target
+	for _, s := range services {
+		if !d.hasTags(s) {
+			continue
+		}
+		targets = append(targets, Target{
+			Address: net.JoinHostPort(s.Address, strconv.Itoa(s.Port)),
+			Labels:  map[string]string{"job": s.JobID, "alloc_id": s.AllocID},
+		})
+	}
+	return targets, nil
+}
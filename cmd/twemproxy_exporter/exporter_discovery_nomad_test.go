@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const nomadServices = `[
+	{"ServiceName": "twemproxy-stats", "JobID": "cache", "AllocID": "a1", "Tags": ["stats", "eu"], "Address": "10.0.0.1", "Port": 22222},
+	{"ServiceName": "twemproxy-stats", "JobID": "cache", "AllocID": "a2", "Tags": ["stats"], "Address": "10.0.0.2", "Port": 22222}
+]`
+
+func TestNomadDiscovery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/service/twemproxy-stats" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, nomadServices)
+	}))
+	defer server.Close()
+
+	d := newNomadDiscovery(server.URL, "", "twemproxy-stats", "stats,eu")
+	targets, err := d.targets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 1 {
+		t.Fatalf("Expected 1 target, got %+v", targets)
+	}
+	if targets[0].Address != "10.0.0.1:22222" || targets[0].Labels["job"] != "cache" || targets[0].Labels["alloc_id"] != "a1" {
+		t.Errorf("Unexpected target %+v", targets[0])
+	}
+}
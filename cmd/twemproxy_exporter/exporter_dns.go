@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+	"github.com/albert-widi/twemproxy_exporter/pkg/twemproxy"
+)
+
+// dnsLookupsTotal of the cache by result: hit, miss, or negative_hit when a failed lookup is still cached
+var dnsLookupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: collector.Namespace,
+	Subsystem: "exporter",
+	Name:      "dns_cache_lookups_total",
+	Help:      "Host name lookups of the DNS cache by result",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(dnsLookupsTotal)
+}
+
+// hostCache of -dns.ttl, used by the stats connections and the probes, nil when disabled
+var hostCache *dnsCache
+
+// dnsCache of the addresses of host names for ttl, failed lookups for negativeTTL.
+// Concurrent lookups of the same name wait for the first one
+type dnsCache struct {
+	ttl         time.Duration
+	negativeTTL time.Duration // failed lookups are not cached when zero
+	timeout     time.Duration // of the shared lookups, which don't use the context of any caller
+	lookup      func(ctx context.Context, host string) ([]string, error)
+
+	entries map[string]*dnsEntry
+	mu      sync.Mutex
+}
+
+type dnsEntry struct {
+	addrs   []string
+	err     error
+	expires time.Time
+	done    chan struct{} // closed once the lookup is over
+}
+
+func newDNSCache(ttl, negativeTTL time.Duration) *dnsCache {
+	return &dnsCache{ttl: ttl, negativeTTL: negativeTTL, timeout: time.Second * 10, lookup: net.DefaultResolver.LookupHost, entries: make(map[string]*dnsEntry)}
+}
+
+// LookupHost from the cache, resolved again once the entry expired
+func (c *dnsCache) LookupHost(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[host]
+	if ok {
+		select {
+		case <-entry.done:
+			if time.Now().Before(entry.expires) {
+				c.mu.Unlock()
+				if entry.err != nil {
+					dnsLookupsTotal.WithLabelValues("negative_hit").Inc()
+				} else {
+					dnsLookupsTotal.WithLabelValues("hit").Inc()
+				}
+				return entry.addrs, entry.err
+			}
+			ok = false
+		default:
+			// in flight
+		}
+	}
+	if !ok {
+		c.evict(time.Now())
+		entry = &dnsEntry{done: make(chan struct{})}
+		c.entries[host] = entry
+		dnsLookupsTotal.WithLabelValues("miss").Inc()
+		go c.resolve(host, entry)
+	}
+	c.mu.Unlock()
+	select {
+	case <-entry.done:
+		return entry.addrs, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// evict the expired entries, e.g. of the targets no longer scraped, on every miss. The lock must be held
+func (c *dnsCache) evict(now time.Time) {
+	for host, entry := range c.entries {
+		select {
+		case <-entry.done:
+			if !now.Before(entry.expires) {
+				delete(c.entries, host)
+			}
+		default:
+		}
+	}
+}
+
+// resolve the host for every waiter of the entry. The lookup is detached from the callers, so a scrape timing out
+// doesn't fail it for the others, and only the failures of the name itself are cached
+func (c *dnsCache) resolve(host string, entry *dnsEntry) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	addrs, err := c.lookup(ctx, host)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.addrs, entry.err = addrs, err
+	entry.expires = time.Now().Add(c.ttl)
+	if err != nil {
+		entry.expires = time.Now()
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && !dnsErr.IsTimeout && ctx.Err() == nil {
+			entry.expires = entry.expires.Add(c.negativeTTL)
+		}
+	}
+	close(entry.done)
+}
+
+// probeDialer of the probes, resolving with hostCache when enabled
+func probeDialer() twemproxy.ContextDialer {
+	if hostCache == nil {
+		return &net.Dialer{}
+	}
+	return &dnsDialer{cache: hostCache, dialer: &net.Dialer{}}
+}
+
+// dnsDialer resolve the host names with the cache and dial the addresses in turn with dialer, like net.Dialer
+type dnsDialer struct {
+	cache  *dnsCache
+	dialer twemproxy.ContextDialer
+}
+
+// DialContext the address, unix sockets and IPs are dialed as they are
+func (d *dnsDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if network == "unix" || err != nil || net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+	addrs, err := d.cache.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	for _, addr := range addrs {
+		conn, err = d.dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil || ctx.Err() != nil {
+			return conn, err
+		}
+	}
+	if err == nil {
+		err = &net.DNSError{Err: "no addresses", Name: host}
+	}
+	return nil, err
+}
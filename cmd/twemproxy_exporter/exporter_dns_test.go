@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDNSCache(t *testing.T) {
+	var lookups int32
+	cache := newDNSCache(time.Hour, time.Hour)
+	cache.lookup = func(ctx context.Context, host string) ([]string, error) {
+		atomic.AddInt32(&lookups, 1)
+		time.Sleep(time.Millisecond * 10)
+		if host == "missing.example" {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return []string{"127.0.0.1"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs, err := cache.LookupHost(context.Background(), "proxy-1.example")
+			if err != nil || len(addrs) != 1 {
+				t.Errorf("Unexpected lookup %v %v", addrs, err)
+			}
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 2; i++ {
+		if _, err := cache.LookupHost(context.Background(), "missing.example"); err == nil {
+			t.Error("Expected the failed lookup")
+		}
+	}
+	if n := atomic.LoadInt32(&lookups); n != 2 {
+		t.Errorf("Expected one lookup per host, got %d", n)
+	}
+
+	cache.entries["proxy-1.example"].expires = time.Now()
+	cache.LookupHost(context.Background(), "proxy-1.example")
+	if n := atomic.LoadInt32(&lookups); n != 3 {
+		t.Errorf("Expected the expired entry to be resolved again, got %d lookups", n)
+	}
+
+	cache.negativeTTL = 0
+	cache.entries["missing.example"].expires = time.Now()
+	cache.LookupHost(context.Background(), "missing.example")
+	cache.LookupHost(context.Background(), "missing.example")
+	if n := atomic.LoadInt32(&lookups); n != 5 {
+		t.Errorf("Expected failed lookups not to be cached without negative ttl, got %d lookups", n)
+	}
+}
+
+func TestDNSCacheEvict(t *testing.T) {
+	cache := newDNSCache(time.Hour, 0)
+	cache.lookup = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"127.0.0.1"}, nil
+	}
+	cache.LookupHost(context.Background(), "proxy-1.example")
+	cache.LookupHost(context.Background(), "proxy-2.example")
+	cache.entries["proxy-1.example"].expires = time.Now()
+
+	cache.LookupHost(context.Background(), "proxy-3.example")
+	if _, ok := cache.entries["proxy-1.example"]; ok || len(cache.entries) != 2 {
+		t.Errorf("Expected the expired entry to be evicted, got %d entries", len(cache.entries))
+	}
+}
+
+func TestDNSCacheCanceled(t *testing.T) {
+	var lookups int32
+	cache := newDNSCache(time.Hour, time.Hour)
+	cache.lookup = func(ctx context.Context, host string) ([]string, error) {
+		if atomic.AddInt32(&lookups, 1) == 1 {
+			return nil, errors.New("connection refused")
+		}
+		time.Sleep(time.Millisecond * 50)
+		return []string{"127.0.0.1"}, ctx.Err()
+	}
+
+	if _, err := cache.LookupHost(context.Background(), "proxy-1.example"); err == nil {
+		t.Fatal("Expected the failed lookup")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if _, err := cache.LookupHost(ctx, "proxy-1.example"); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the deadline of the caller, got %v", err)
+	}
+	addrs, err := cache.LookupHost(context.Background(), "proxy-1.example")
+	if err != nil || len(addrs) != 1 {
+		t.Errorf("Expected the lookup to go on after the caller timed out, got %v %v", addrs, err)
+	}
+	if n := atomic.LoadInt32(&lookups); n != 2 {
+		t.Errorf("Expected errors other than DNS ones not to be cached and one shared lookup, got %d lookups", n)
+	}
+}
+
+func TestDNSDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	cache := newDNSCache(time.Hour, 0)
+	cache.lookup = func(ctx context.Context, host string) ([]string, error) {
+		// the first address refuse the connection
+		return []string{"127.0.0.2", "127.0.0.1"}, nil
+	}
+	dialer := &dnsDialer{cache: cache, dialer: &net.Dialer{}}
+	conn, err := dialer.DialContext(context.Background(), "tcp", net.JoinHostPort("proxy-1.example", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+// downtimeMaxGap is the longest interval between two scrapes counted as downtime,
+// an exporter stopped for a day must not add a day to a server found down on restart
+const downtimeMaxGap = time.Minute * 5
+
+// downtimeEntry of a server as saved in the state file
+type downtimeEntry struct {
+	availabilityKey
+	Seconds  float64   `json:"seconds"`
+	LastSeen time.Time `json:"last_seen"`
+
+	Ejections      float64 `json:"ejections"`
+	EjectedSeconds float64 `json:"ejected_seconds"`
+	EjectedAt      float64 `json:"ejected_at"`  // last server_ejected_at counted, microseconds
+	Ejected        bool    `json:"ejected"`     // ejected and not reconnected yet
+	ProxyStart     int64   `json:"proxy_start"` // unix seconds, timestamp - uptime of the stats
+}
+
+// proxyStart of the twemproxy process, stable across scrapes until it restart
+func proxyStart(st stats.TwemproxyStats, t time.Time) int64 {
+	if st.Uptime == 0 {
+		return 0
+	}
+	now := st.Timestamp
+	if now == 0 {
+		now = float64(t.Unix())
+	}
+	return int64(now - st.Uptime)
+}
+
+// clampGap to the counted interval
+func clampGap(gap time.Duration) time.Duration {
+	if gap > downtimeMaxGap {
+		return downtimeMaxGap
+	}
+	if gap < 0 {
+		return 0
+	}
+	return gap
+}
+
+// observeEjection of the server.
+// An ejection is counted once when server_ejected_at move past the saved one, so neither an exporter restart
+// (the saved value is kept) nor a proxy restart (the value go back to 0) count it twice.
+// The ejection last until twemproxy has a connection to the server again, or until twemproxy restart
+// since a restarted twemproxy reconnect every server
+func (e *downtimeEntry) observeEjection(server stats.ServerStats, start int64, t time.Time) {
+	restarted := e.ProxyStart != 0 && start != 0 && (start-e.ProxyStart > 1 || e.ProxyStart-start > 1)
+	if start != 0 {
+		e.ProxyStart = start
+	}
+	if restarted {
+		e.Ejected = false
+	}
+
+	switch {
+	case e.LastSeen.IsZero():
+		// first scrape of the server, its past ejections are not ours to count
+	case server.ServerEjectedAt > e.EjectedAt:
+		e.Ejections++
+		if server.ServerConnections < 1 {
+			e.Ejected = true
+			from := time.Unix(0, int64(server.ServerEjectedAt)*int64(time.Microsecond))
+			if from.Before(e.LastSeen) {
+				from = e.LastSeen
+			}
+			e.EjectedSeconds += clampGap(t.Sub(from)).Seconds()
+		}
+	case e.Ejected && server.ServerConnections < 1:
+		e.EjectedSeconds += clampGap(t.Sub(e.LastSeen)).Seconds()
+	}
+	if server.ServerConnections >= 1 {
+		e.Ejected = false
+	}
+	if server.ServerEjectedAt > e.EjectedAt || restarted {
+		e.EjectedAt = server.ServerEjectedAt
+	}
+}
+
+// downtimeLedger accumulate how long every server has been unavailable or ejected, persisted across restarts
+// so monthly reliability reports per shard can be computed from a single counter.
+// The interval since the previous scrape is counted when the server is found without connection from twemproxy.
+// Servers not seen for retention, removed from the config or from a deregistered target, are forgotten
+type downtimeLedger struct {
+	stateFile string
+	retention time.Duration
+	entries   map[availabilityKey]*downtimeEntry
+	mu        sync.Mutex
+	saveMu    sync.Mutex // one Save at a time, they share the temporary file
+
+	desc          *prometheus.Desc
+	ejectionsDesc *prometheus.Desc
+	ejectedDesc   *prometheus.Desc
+}
+
+func newDowntimeLedger(stateFile string, retention time.Duration) (*downtimeLedger, error) {
+	l := &downtimeLedger{
+		stateFile: stateFile,
+		retention: retention,
+		entries:   make(map[availabilityKey]*downtimeEntry),
+		desc: prometheus.NewDesc(prometheus.BuildFQName(collector.Namespace, "server", "downtime_seconds_total"),
+			"Seconds the backend server was unavailable or ejected, persisted across restarts", serverLabels.Names(), nil),
+		ejectionsDesc: prometheus.NewDesc(prometheus.BuildFQName(collector.Namespace, "server", "ejections_total"),
+			"Ejections of the backend server, persisted across exporter and twemproxy restarts", serverLabels.Names(), nil),
+		ejectedDesc: prometheus.NewDesc(prometheus.BuildFQName(collector.Namespace, "server", "ejected_seconds_total"),
+			"Seconds the backend server was ejected, persisted across exporter and twemproxy restarts", serverLabels.Names(), nil),
+	}
+	content, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open: %s. Error: %s", stateFile, err.Error())
+	}
+	var saved []*downtimeEntry
+	err = json.Unmarshal(content, &saved)
+	if err != nil {
+		// unlike the availability window, losing the ledger reset counters used for reports
+		return nil, fmt.Errorf("Invalid downtime state %s. Error: %s", stateFile, err.Error())
+	}
+	for _, entry := range saved {
+		l.entries[entry.availabilityKey] = entry
+	}
+	return l, nil
+}
+
+// Observe a scrape result, failed scrapes are not counted since the servers state is unknown
+func (l *downtimeLedger) Observe(result ScrapeResult) {
+	if result.Err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	instance := result.Target.instanceLabel()
+	start := proxyStart(result.Stats, result.Time)
+	for poolName, pool := range result.Stats.Services {
+		for _, server := range pool.Servers {
+			key := availabilityKey{Instance: instance, Pool: poolName, Server: server.HostAlias}
+			entry, ok := l.entries[key]
+			if !ok {
+				entry = &downtimeEntry{availabilityKey: key}
+				l.entries[key] = entry
+			}
+			if !entry.LastSeen.IsZero() && server.ServerConnections < 1 {
+				entry.Seconds += clampGap(result.Time.Sub(entry.LastSeen)).Seconds()
+			}
+			entry.observeEjection(server, start, result.Time)
+			entry.LastSeen = result.Time
+		}
+	}
+	// by the time of the scrapes rather than the clock, the ledger of a stopped exporter is kept
+	l.expire(result.Time)
+}
+
+// expire the entries not seen for retention before now, the lock must be held
+func (l *downtimeLedger) expire(now time.Time) {
+	for key, entry := range l.entries {
+		if now.Sub(entry.LastSeen) > l.retention {
+			delete(l.entries, key)
+		}
+	}
+}
+
+// Describe nothing, unchecked like the scheduler
+func (l *downtimeLedger) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect the downtime and ejections of every server
+func (l *downtimeLedger) Collect(ch chan<- prometheus.Metric) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, entry := range l.entries {
+		ch <- prometheus.MustNewConstMetric(l.desc, prometheus.CounterValue, entry.Seconds, key.Instance, key.Pool, key.Server)
+		ch <- prometheus.MustNewConstMetric(l.ejectionsDesc, prometheus.CounterValue, entry.Ejections, key.Instance, key.Pool, key.Server)
+		ch <- prometheus.MustNewConstMetric(l.ejectedDesc, prometheus.CounterValue, entry.EjectedSeconds, key.Instance, key.Pool, key.Server)
+	}
+}
+
+// Save the state file, written to a temporary file first
+func (l *downtimeLedger) Save() error {
+	l.saveMu.Lock()
+	defer l.saveMu.Unlock()
+	l.mu.Lock()
+	saved := make([]*downtimeEntry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		saved = append(saved, entry)
+	}
+	content, err := json.Marshal(saved)
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := l.stateFile + ".tmp"
+	err = ioutil.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.stateFile)
+}
+
+// saveEvery interval until the process exit
+func (l *downtimeLedger) saveEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		err := l.Save()
+		if err != nil {
+			log.Printf("Cannot save downtime state %s. Error: %s", l.stateFile, err.Error())
+		}
+	}
+}
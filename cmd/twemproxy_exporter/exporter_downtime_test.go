@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+)
+
+func TestDowntimeLedger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "downtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state := filepath.Join(dir, "downtime.json")
+
+	l, err := newDowntimeLedger(state, time.Hour*24*35)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	down := ScrapeResult{Target: Target{Address: "proxy:22222"}, Stats: testutil.ExampleStats(t, "beta"), Time: start.Add(time.Minute)}
+	l.Observe(ScrapeResult{Target: Target{Address: "proxy:22222"}, Stats: testutil.ExampleStats(t), Time: start})
+	l.Observe(down)
+	// the exporter stopped for an hour
+	down.Time = start.Add(time.Hour)
+	l.Observe(down)
+	err = l.Save()
+	if err != nil {
+		t.Fatal("Failed to save: ", err.Error())
+	}
+
+	restarted, err := newDowntimeLedger(state, time.Hour*24*35)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seconds := testutil.GatherValue(t, restarted, "twemproxy_server_downtime_seconds_total")
+	expected := (time.Minute + downtimeMaxGap).Seconds()
+	if seconds != expected {
+		t.Errorf("Expected %f seconds of downtime, got %f", expected, seconds)
+	}
+}
+
+func TestDowntimeLedgerEjections(t *testing.T) {
+	dir, err := ioutil.TempDir("", "downtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state := filepath.Join(dir, "downtime.json")
+
+	start := time.Unix(1500000000, 0)
+	scrape := func(l *downtimeLedger, at time.Duration, uptime time.Duration, ejectedAt time.Duration, connections float64) {
+		result := ScrapeResult{Target: Target{Address: "proxy:22222"}, Stats: testutil.ExampleStats(t), Time: start.Add(at)}
+		result.Stats.Uptime = uptime.Seconds()
+		result.Stats.Timestamp = float64(result.Time.Unix())
+		servers := result.Stats.Services[testutil.ExamplePool].Servers
+		delete(servers, "beta")
+		server := servers["alpha"]
+		server.ServerConnections, server.ServerEjectedAt = connections, 0
+		if ejectedAt > 0 {
+			server.ServerEjectedAt = float64(start.Add(ejectedAt).UnixNano() / int64(time.Microsecond))
+		}
+		servers["alpha"] = server
+		l.Observe(result)
+	}
+
+	l, err := newDowntimeLedger(state, time.Hour*24*35)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scrape(l, 0, time.Hour, 0, 1)
+	// ejected 30s before the scrape
+	scrape(l, time.Minute, time.Hour+time.Minute, time.Second*30, 0)
+	err = l.Save()
+	if err != nil {
+		t.Fatal("Failed to save: ", err.Error())
+	}
+
+	// the exporter restarted, the same ejection is still going on
+	l, err = newDowntimeLedger(state, time.Hour*24*35)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scrape(l, time.Minute*2, time.Hour+time.Minute*2, time.Second*30, 0)
+	// twemproxy restarted and reconnected, server_ejected_at went back to 0
+	scrape(l, time.Minute*3, time.Second*10, 0, 1)
+	// ejected again after the restart
+	scrape(l, time.Minute*4, time.Second*70, time.Second*200, 0)
+
+	if ejections := testutil.GatherValue(t, l, "twemproxy_server_ejections_total"); ejections != 2 {
+		t.Errorf("Expected 2 ejections, got %f", ejections)
+	}
+	if seconds := testutil.GatherValue(t, l, "twemproxy_server_ejected_seconds_total"); seconds != 130 {
+		t.Errorf("Expected 130 seconds ejected, got %f", seconds)
+	}
+}
+
+func TestDowntimeLedgerRetention(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "downtime.json")
+	l, err := newDowntimeLedger(state, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	l.Observe(ScrapeResult{Target: Target{Address: "proxy:22222"}, Stats: testutil.ExampleStats(t), Time: start})
+	// alpha was removed from the config
+	later := ScrapeResult{Target: Target{Address: "proxy:22222"}, Stats: testutil.ExampleStats(t), Time: start.Add(time.Hour * 2)}
+	delete(later.Stats.Services[testutil.ExamplePool].Servers, "alpha")
+	l.Observe(later)
+	err = l.Save()
+	if err != nil {
+		t.Fatal("Failed to save: ", err.Error())
+	}
+
+	restarted, err := newDowntimeLedger(state, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.entries) != 1 {
+		t.Errorf("Expected the removed server to be forgotten, got %+v", restarted.entries)
+	}
+}
+
+func TestDowntimeLedgerConcurrentSave(t *testing.T) {
+	l, err := newDowntimeLedger(filepath.Join(t.TempDir(), "downtime.json"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- l.Save() }()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Expected concurrent saves to succeed, got %s", err.Error())
+		}
+	}
+}
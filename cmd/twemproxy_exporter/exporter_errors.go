@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+	"github.com/albert-widi/twemproxy_exporter/pkg/twemproxy"
+)
+
+// scrapeErrors by reason, to tell a dead proxy from a wrong port or a flaky network without reading the logs
+var scrapeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: collector.Namespace,
+	Subsystem: "exporter",
+	Name:      "scrape_errors_total",
+	Help:      "Failed scrapes of every target by reason: unreachable, timeout, truncated, schema or other",
+}, []string{"reason"})
+
+// parseFailures of the stats payloads by reason, the last failing payload is kept for /debug/last-parse-failure
+var parseFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: collector.Namespace,
+	Subsystem: "exporter",
+	Name:      "json_parse_failures_total",
+	Help:      "Stats payloads of every target that could not be parsed by reason: truncated, invalid or schema",
+}, []string{"reason"})
+
+func init() {
+	prometheus.MustRegister(scrapeErrors, parseFailures)
+}
+
+// scrapeErrorReason of a failed scrape
+func scrapeErrorReason(err error) string {
+	switch {
+	case errors.Is(err, twemproxy.ErrTargetUnreachable):
+		return "unreachable"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, twemproxy.ErrTruncatedStats):
+		return "truncated"
+	case errors.Is(err, twemproxy.ErrSchemaMismatch):
+		return "schema"
+	}
+	return "other"
+}
+
+// scrapeFailed count the failed scrape by reason, the monitor hook of every target
+func scrapeFailed(target string, err error) {
+	scrapeErrors.WithLabelValues(scrapeErrorReason(err)).Inc()
+}
+
+// parseFailureReason of a payload that could not be parsed
+func parseFailureReason(err error) string {
+	switch {
+	case errors.Is(err, twemproxy.ErrTruncatedStats):
+		return "truncated"
+	case errors.Is(err, twemproxy.ErrInvalidJSON):
+		return "invalid"
+	}
+	return "schema"
+}
+
+// maxFailedPayload kept of the last payload that could not be parsed
+const maxFailedPayload = 64 << 10
+
+// parseFailure of a stats payload
+type parseFailure struct {
+	Target    string    `json:"target"`
+	Time      time.Time `json:"time"`
+	Reason    string    `json:"reason"`
+	Error     string    `json:"error"`
+	Size      int       `json:"size"`      // of the whole payload
+	Truncated bool      `json:"truncated"` // when only the first bytes of the payload are kept
+	Payload   string    `json:"payload"`
+}
+
+var (
+	lastParseFailure   *parseFailure
+	lastParseFailureMu sync.Mutex
+)
+
+// parseFailed count the failure and keep the payload as the last failing one
+func parseFailed(target string, payload []byte, err error) {
+	reason := parseFailureReason(err)
+	parseFailures.WithLabelValues(reason).Inc()
+	failure := &parseFailure{Target: target, Time: time.Now(), Reason: reason, Error: err.Error(), Size: len(payload)}
+	if len(payload) > maxFailedPayload {
+		payload, failure.Truncated = payload[:maxFailedPayload], true
+	}
+	failure.Payload = string(payload)
+
+	lastParseFailureMu.Lock()
+	defer lastParseFailureMu.Unlock()
+	lastParseFailure = failure
+}
+
+// lastParseFailureHandler serve the last payload that could not be parsed, 404 when every payload was parsed
+func lastParseFailureHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastParseFailureMu.Lock()
+		failure := lastParseFailure
+		lastParseFailureMu.Unlock()
+		if failure == nil {
+			http.Error(w, "No parse failure", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(failure)
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+func TestScrapeErrorReason(t *testing.T) {
+	// not the stats of twemproxy, e.g. another exporter
+	mock, err := newMockServer([]byte(`{"db0": {"keys": 1}}`), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mock.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to start mock server: ", err.Error())
+	}
+	defer mock.Close()
+	m, _ := monitor.New(monitor.WithHost(mock.Addr()), monitor.WithHooks(monitor.Hooks{ScrapeFailed: scrapeFailed}))
+	err = m.Run(context.Background())
+	if reason := scrapeErrorReason(err); reason != "schema" {
+		t.Errorf("Expected a schema error, got %s: %v", reason, err)
+	}
+
+	mock.Close()
+	err = m.Run(context.Background())
+	if reason := scrapeErrorReason(err); reason != "unreachable" {
+		t.Errorf("Expected an unreachable error, got %s: %v", reason, err)
+	}
+	if value := testutil.GatherValue(t, scrapeErrors.WithLabelValues("unreachable"), "twemproxy_exporter_scrape_errors_total"); value < 1 {
+		t.Errorf("Expected the unreachable error counted, got %f", value)
+	}
+}
+
+func TestParseFailures(t *testing.T) {
+	// e.g. -twemphost pointing to redis
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("-ERR unknown command\r\n"))
+			conn.Close()
+		}
+	}()
+	m, _ := monitor.New(monitor.WithHost(listener.Addr().String()), monitor.WithHooks(monitor.Hooks{ParseFailed: parseFailed}))
+	m.Run(context.Background())
+	if value := testutil.GatherValue(t, parseFailures.WithLabelValues("invalid"), "twemproxy_exporter_json_parse_failures_total"); value < 1 {
+		t.Errorf("Expected the invalid payload counted, got %f", value)
+	}
+
+	rec := httptest.NewRecorder()
+	lastParseFailureHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/last-parse-failure", nil))
+	var failure parseFailure
+	if err := json.NewDecoder(rec.Body).Decode(&failure); err != nil {
+		t.Fatal("Failed to decode the last parse failure: ", err.Error())
+	}
+	if failure.Target != listener.Addr().String() || failure.Reason != "invalid" || failure.Payload != "-ERR unknown command\r\n" {
+		t.Errorf("Expected the payload of the mock, got %+v", failure)
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+)
+
+// federationTimeout of the scrape of a downstream exporter
+const federationTimeout = time.Second * 10
+
+// federationSite is a downstream exporter, e.g. at an edge site Prometheus cannot reach
+type federationSite struct {
+	Name string
+	URL  string
+}
+
+// parseFederationSites from comma separated site=url
+func parseFederationSites(s string) ([]federationSite, error) {
+	var sites []federationSite
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		p := strings.SplitN(entry, "=", 2)
+		if len(p) != 2 || p[0] == "" || p[1] == "" {
+			return nil, fmt.Errorf("Invalid federation site %s, expected site=url", entry)
+		}
+		sites = append(sites, federationSite{Name: p[0], URL: p[1]})
+	}
+	return sites, nil
+}
+
+// federationCollector scrape the /metrics of the downstream exporters on every collect and re-expose
+// their twemproxy_* metrics labeled with their site, for hub and spoke networks where Prometheus only reach the hub
+type federationCollector struct {
+	sites  []federationSite
+	client *http.Client
+	upDesc *prometheus.Desc
+}
+
+func newFederationCollector(sites []federationSite) *federationCollector {
+	return &federationCollector{
+		sites:  sites,
+		client: &http.Client{Timeout: federationTimeout},
+		upDesc: prometheus.NewDesc(prometheus.BuildFQName(collector.Namespace, "federation", "up"),
+			"1 when the last scrape of the downstream exporter succeeded", []string{"site"}, nil),
+	}
+}
+
+// fetch the metric families of the site
+func (f *federationCollector) fetch(site federationSite) (map[string]*dto.MetricFamily, error) {
+	req, err := http.NewRequest("GET", site.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", string(expfmt.FmtText))
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", site.URL, resp.Status)
+	}
+	parser := expfmt.TextParser{}
+	return parser.TextToMetricFamilies(resp.Body)
+}
+
+// Describe nothing, unchecked like the scheduler
+func (f *federationCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect the metrics of every site, scraped concurrently
+func (f *federationCollector) Collect(ch chan<- prometheus.Metric) {
+	families := make([]map[string]*dto.MetricFamily, len(f.sites))
+	var wg sync.WaitGroup
+	for i, site := range f.sites {
+		wg.Add(1)
+		go func(i int, site federationSite) {
+			defer wg.Done()
+			fetched, err := f.fetch(site)
+			if err != nil {
+				log.Printf("Cannot federate site %s. Error: %s", site.Name, err.Error())
+				return
+			}
+			families[i] = fetched
+		}(i, site)
+	}
+	wg.Wait()
+
+	// the registry refuse a family with two help strings, sites running other versions may differ
+	help := make(map[string]string)
+	for i, site := range f.sites {
+		if families[i] == nil {
+			ch <- prometheus.MustNewConstMetric(f.upDesc, prometheus.GaugeValue, 0, site.Name)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(f.upDesc, prometheus.GaugeValue, 1, site.Name)
+		for name, family := range families[i] {
+			if !strings.HasPrefix(name, collector.Namespace+"_") {
+				continue
+			}
+			if _, ok := help[name]; !ok {
+				help[name] = family.GetHelp()
+			}
+			for _, metric := range family.GetMetric() {
+				m, err := federatedMetric(family, metric, help[name], site.Name)
+				if err != nil {
+					log.Printf("Cannot federate %s of site %s. Error: %s", name, site.Name, err.Error())
+					continue
+				}
+				ch <- m
+			}
+		}
+	}
+}
+
+// federatedMetric of the downstream metric with the site label, replacing any site label it had
+func federatedMetric(family *dto.MetricFamily, metric *dto.Metric, help string, site string) (prometheus.Metric, error) {
+	labels := metric.GetLabel()
+	sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+	var names, values []string
+	for _, label := range labels {
+		if label.GetName() == "site" {
+			continue
+		}
+		names = append(names, label.GetName())
+		values = append(values, label.GetValue())
+	}
+	names = append(names, "site")
+	values = append(values, site)
+	desc := prometheus.NewDesc(family.GetName(), help, names, nil)
+
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, metric.GetCounter().GetValue(), values...)
+	case dto.MetricType_GAUGE:
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, metric.GetGauge().GetValue(), values...)
+	case dto.MetricType_SUMMARY:
+		quantiles := make(map[float64]float64)
+		for _, q := range metric.GetSummary().GetQuantile() {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+		s := metric.GetSummary()
+		return prometheus.NewConstSummary(desc, s.GetSampleCount(), s.GetSampleSum(), quantiles, values...)
+	case dto.MetricType_HISTOGRAM:
+		buckets := make(map[float64]uint64)
+		for _, b := range metric.GetHistogram().GetBucket() {
+			buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+		}
+		h := metric.GetHistogram()
+		return prometheus.NewConstHistogram(desc, h.GetSampleCount(), h.GetSampleSum(), buckets, values...)
+	}
+	return prometheus.NewConstMetric(desc, prometheus.UntypedValue, metric.GetUntyped().GetValue(), values...)
+}
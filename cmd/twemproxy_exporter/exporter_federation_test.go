@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const federatedPayload = `# HELP twemproxy_server_in_queue In queue requests of the backend server
+# TYPE twemproxy_server_in_queue gauge
+twemproxy_server_in_queue{group="sessions",instance="proxy-1",redis_server="alpha"} 3
+# HELP twemproxy_exporter_scrape_duration_seconds Duration of the scrapes
+# TYPE twemproxy_exporter_scrape_duration_seconds histogram
+twemproxy_exporter_scrape_duration_seconds_bucket{le="0.1"} 4
+twemproxy_exporter_scrape_duration_seconds_bucket{le="+Inf"} 5
+twemproxy_exporter_scrape_duration_seconds_sum 0.7
+twemproxy_exporter_scrape_duration_seconds_count 5
+# HELP go_goroutines Number of goroutines that currently exist.
+# TYPE go_goroutines gauge
+go_goroutines 12
+`
+
+func TestFederationCollector(t *testing.T) {
+	edge := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, federatedPayload)
+	}))
+	defer edge.Close()
+
+	sites, err := parseFederationSites("edge-1=" + edge.URL + ",edge-2=http://127.0.0.1:1/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newFederationCollector(sites))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal("Failed to gather: ", err.Error())
+	}
+
+	found := make(map[string]bool)
+	for _, family := range families {
+		found[family.GetName()] = true
+		switch family.GetName() {
+		case "twemproxy_server_in_queue":
+			metric := family.GetMetric()[0]
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["site"] != "edge-1" || labels["instance"] != "proxy-1" || metric.GetGauge().GetValue() != 3 {
+				t.Errorf("Unexpected federated metric %v", metric)
+			}
+		case "twemproxy_exporter_scrape_duration_seconds":
+			if family.GetMetric()[0].GetHistogram().GetSampleCount() != 5 {
+				t.Error("Expected the histogram federated")
+			}
+		case "twemproxy_federation_up":
+			for _, metric := range family.GetMetric() {
+				site := metric.GetLabel()[0].GetValue()
+				if (site == "edge-1") != (metric.GetGauge().GetValue() == 1) {
+					t.Errorf("Unexpected twemproxy_federation_up of %s: %f", site, metric.GetGauge().GetValue())
+				}
+			}
+		}
+	}
+	if found["go_goroutines"] {
+		t.Error("Expected only the twemproxy metrics federated")
+	}
+	if !found["twemproxy_server_in_queue"] || !found["twemproxy_federation_up"] {
+		t.Errorf("Missing federated metrics, got %v", found)
+	}
+
+	_, err = parseFederationSites("edge-1")
+	if err == nil {
+		t.Error("Expected an error without url")
+	}
+}
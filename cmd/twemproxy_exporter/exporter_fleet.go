@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+)
+
+// fleetCollector sum the pools of every target, for dashboards looking at a pool as a whole
+// without joining the series of hundreds of proxies
+type fleetCollector struct {
+	scheduler *Scheduler
+
+	requestsDesc    *prometheus.Desc
+	targetsDesc     *prometheus.Desc
+	targetsUpDesc   *prometheus.Desc
+	serversDesc     *prometheus.Desc
+	unavailableDesc *prometheus.Desc
+	clientsDesc     *prometheus.Desc
+}
+
+func newFleetCollector(scheduler *Scheduler) *fleetCollector {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(collector.Namespace, "fleet", name), help, []string{"group"}, nil)
+	}
+	return &fleetCollector{
+		scheduler:       scheduler,
+		requestsDesc:    desc("pool_requests_total", "Requests to the backend servers of the pool summed over every target"),
+		targetsDesc:     desc("pool_targets", "Targets serving the pool"),
+		targetsUpDesc:   desc("pool_targets_up", "Targets serving the pool whose last scrape succeeded"),
+		serversDesc:     desc("pool_servers", "Backend servers of the pool summed over the targets up"),
+		unavailableDesc: desc("pool_servers_unavailable", "Unavailable backend servers of the pool summed over the targets up"),
+		clientsDesc:     desc("pool_client_connections", "Client connections to the pool summed over the targets up"),
+	}
+}
+
+// fleetPool sums
+type fleetPool struct {
+	requests    float64
+	targets     float64
+	targetsUp   float64
+	servers     float64
+	unavailable float64
+	clients     float64
+}
+
+// Describe nothing, unchecked like the scheduler
+func (f *fleetCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect the sums of every pool.
+// The requests of a failing target are its last good ones, so the sum doesn't look like a counter reset
+// every time a target miss a scrape
+func (f *fleetCollector) Collect(ch chan<- prometheus.Metric) {
+	pools := make(map[string]*fleetPool)
+	for _, m := range f.scheduler.Monitors() {
+		st, up := m.LastStats()
+		for name, service := range st.Services {
+			p, ok := pools[name]
+			if !ok {
+				p = &fleetPool{}
+				pools[name] = p
+			}
+			p.targets++
+			for _, server := range service.Servers {
+				p.requests += server.Requests
+			}
+			if !up {
+				continue
+			}
+			p.targetsUp++
+			p.servers += float64(service.ExpectedAvailable)
+			p.unavailable += float64(service.NotAvailable)
+			p.clients += service.ClientConnections
+		}
+	}
+	for name, p := range pools {
+		ch <- prometheus.MustNewConstMetric(f.requestsDesc, prometheus.CounterValue, p.requests, name)
+		ch <- prometheus.MustNewConstMetric(f.targetsDesc, prometheus.GaugeValue, p.targets, name)
+		ch <- prometheus.MustNewConstMetric(f.targetsUpDesc, prometheus.GaugeValue, p.targetsUp, name)
+		ch <- prometheus.MustNewConstMetric(f.serversDesc, prometheus.GaugeValue, p.servers, name)
+		ch <- prometheus.MustNewConstMetric(f.unavailableDesc, prometheus.GaugeValue, p.unavailable, name)
+		ch <- prometheus.MustNewConstMetric(f.clientsDesc, prometheus.GaugeValue, p.clients, name)
+	}
+}
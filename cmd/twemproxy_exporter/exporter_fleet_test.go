@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestFleetCollector(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	first := startMockServer(t)
+	defer first.Close()
+	second := startMockServer(t)
+
+	scheduler := NewScheduler(conf, time.Hour, nil)
+	scheduler.SetTargets([]Target{{Address: first.Addr()}, {Address: second.Addr()}})
+	defer scheduler.Stop()
+	for _, m := range scheduler.Monitors() {
+		err := m.Run(context.Background())
+		if err != nil {
+			t.Fatal("Failed to run monitor: ", err.Error())
+		}
+	}
+
+	requests := 0.0
+	for _, m := range scheduler.Monitors() {
+		st, _ := m.LastStats()
+		for _, server := range st.Services["wallet-oauth-token"].Servers {
+			requests += server.Requests
+		}
+	}
+	fleet := newFleetCollector(scheduler)
+	if value := testutil.GatherValue(t, fleet, "twemproxy_fleet_pool_requests_total"); value != requests || requests == 0 {
+		t.Errorf("Expected %f fleet requests, got %f", requests, value)
+	}
+	if value := testutil.GatherValue(t, fleet, "twemproxy_fleet_pool_targets_up"); value != 2 {
+		t.Errorf("Expected 2 targets up, got %f", value)
+	}
+
+	// the requests of a failing target are kept, its servers are not counted anymore
+	second.Close()
+	for _, m := range scheduler.Monitors() {
+		m.Scrape(context.Background())
+	}
+	if value := testutil.GatherValue(t, fleet, "twemproxy_fleet_pool_targets_up"); value != 1 {
+		t.Errorf("Expected 1 target up, got %f", value)
+	}
+	if value := testutil.GatherValue(t, fleet, "twemproxy_fleet_pool_targets"); value != 2 {
+		t.Errorf("Expected 2 targets, got %f", value)
+	}
+}
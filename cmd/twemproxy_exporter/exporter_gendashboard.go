@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+)
+
+// grafana dashboard model, only the fields we set
+type dashboard struct {
+	Title         string            `json:"title"`
+	UID           string            `json:"uid"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Time          map[string]string `json:"time"`
+	Refresh       string            `json:"refresh"`
+	Templating    struct {
+		List []dashboardVariable `json:"list"`
+	} `json:"templating"`
+	Panels []dashboardPanel `json:"panels"`
+}
+
+type dashboardVariable struct {
+	Name       string      `json:"name"`
+	Label      string      `json:"label"`
+	Type       string      `json:"type"`
+	Datasource interface{} `json:"datasource,omitempty"`
+	Query      string      `json:"query"`
+	Refresh    int         `json:"refresh,omitempty"`
+	Multi      bool        `json:"multi"`
+	IncludeAll bool        `json:"includeAll"`
+}
+
+type dashboardPanel struct {
+	ID          int               `json:"id"`
+	Title       string            `json:"title"`
+	Type        string            `json:"type"`
+	Datasource  string            `json:"datasource"`
+	GridPos     map[string]int    `json:"gridPos"`
+	Targets     []dashboardTarget `json:"targets"`
+	FieldConfig struct {
+		Defaults struct {
+			Unit string `json:"unit,omitempty"`
+		} `json:"defaults"`
+	} `json:"fieldConfig"`
+}
+
+type dashboardTarget struct {
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat"`
+	RefID        string `json:"refId"`
+}
+
+// dashboard selectors, filled by the variables
+const instanceSelector = `instance=~"$instance"`
+
+// serverSelector with the label of the backend servers
+func serverSelector() string {
+	return `instance=~"$instance", group=~"$pool", ` + serverLabels.ServerLabel() + `=~"$server"`
+}
+
+// generateDashboard matching the exporter metric names and labels
+func generateDashboard(title string, datasource string) dashboard {
+	d := dashboard{
+		Title:         title,
+		UID:           "twemproxy-exporter",
+		SchemaVersion: 27,
+		Time:          map[string]string{"from": "now-6h", "to": "now"},
+		Refresh:       "30s",
+	}
+	d.Templating.List = []dashboardVariable{
+		{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+		{Name: "instance", Label: "Instance", Type: "query", Datasource: datasource, Refresh: 2, Multi: true, IncludeAll: true,
+			Query: "label_values(twemproxy_service_current_connections, instance)"},
+		{Name: "pool", Label: "Pool", Type: "query", Datasource: datasource, Refresh: 2, Multi: true, IncludeAll: true,
+			Query: `label_values(twemproxy_server_connection{instance=~"$instance"}, group)`},
+		{Name: "server", Label: "Server", Type: "query", Datasource: datasource, Refresh: 2, Multi: true, IncludeAll: true,
+			Query: `label_values(twemproxy_server_connection{instance=~"$instance", group=~"$pool"}, ` + serverLabels.ServerLabel() + ")"},
+	}
+
+	panels := []struct {
+		title  string
+		unit   string
+		expr   string
+		legend string
+	}{
+		{"Available servers", "short", "sum by (instance, group) (twemproxy_server_connection{" + serverSelector() + "} >= bool 1)", "{{instance}} {{group}}"},
+		{"Unavailable servers", "short", "twemproxy_server_connection{" + serverSelector() + "} < 1", "{{instance}} {{group}} {{" + serverLabels.ServerLabel() + "}}"},
+		{"Client connections", "short", "twemproxy_service_current_connections{" + instanceSelector + "}", "{{instance}}"},
+		{"New client connections", "ops", "rate(twemproxy_service_total_connections{" + instanceSelector + "}[5m])", "{{instance}}"},
+		{"Server timeouts", "ops", "rate(twemproxy_server_timed_out{" + serverSelector() + "}[5m])", "{{group}} {{" + serverLabels.ServerLabel() + "}}"},
+		{"Server ejections", "short", "changes(twemproxy_server_ejected_at{" + serverSelector() + "}[5m])", "{{group}} {{" + serverLabels.ServerLabel() + "}}"},
+		{"In queue requests", "short", "twemproxy_server_in_queue{" + serverSelector() + "}", "{{group}} {{" + serverLabels.ServerLabel() + "}}"},
+		{"In queue bytes", "bytes", "twemproxy_server_in_queue_bytes{" + serverSelector() + "}", "{{group}} {{" + serverLabels.ServerLabel() + "}}"},
+	}
+	for i, p := range panels {
+		panel := dashboardPanel{
+			ID:         i + 1,
+			Title:      p.title,
+			Type:       "timeseries",
+			Datasource: datasource,
+			// two panels per row
+			GridPos: map[string]int{"h": 8, "w": 12, "x": (i % 2) * 12, "y": (i / 2) * 8},
+			Targets: []dashboardTarget{{Expr: p.expr, LegendFormat: p.legend, RefID: "A"}},
+		}
+		panel.FieldConfig.Defaults.Unit = p.unit
+		d.Panels = append(d.Panels, panel)
+	}
+	return d
+}
+
+func writeDashboard(w io.Writer, d dashboard) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
+
+// runGenDashboard print a grafana dashboard for the exporter metrics
+func runGenDashboard(args []string) error {
+	fs := flag.NewFlagSet("gen-dashboard", flag.ExitOnError)
+	title := fs.String("title", "Twemproxy", "dashboard title")
+	datasource := fs.String("datasource", "$datasource", "prometheus data source of the panels, the data source variable by default")
+	label := fs.String("server-label", collector.DefaultServerLabel, "label of the backend servers, same as -metrics.server-label of the exporter")
+	fs.Parse(args)
+	serverLabels.Server = *label
+
+	return writeDashboard(os.Stdout, generateDashboard(*title, *datasource))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestGenerateDashboard(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := writeDashboard(buf, generateDashboard("Twemproxy", "$datasource"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		t.Fatal("Generated dashboard is not valid JSON")
+	}
+
+	d := generateDashboard("Twemproxy", "$datasource")
+	for _, panel := range d.Panels {
+		for _, target := range panel.Targets {
+			if !strings.Contains(target.Expr, "twemproxy_") || !strings.Contains(target.Expr, `instance=~"$instance"`) {
+				t.Errorf("Panel %s doesn't query the exporter metrics: %s", panel.Title, target.Expr)
+			}
+		}
+	}
+}
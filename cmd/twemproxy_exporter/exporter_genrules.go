@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// prometheus rules file format
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// ruleThresholds of the generated alerts
+type ruleThresholds struct {
+	For          time.Duration
+	QueueSize    int
+	TimeoutsRate float64
+}
+
+// promDuration format a duration the way prometheus parse it, time.Duration.String give 1m0s
+func promDuration(d time.Duration) string {
+	if d%time.Minute == 0 {
+		return strconv.Itoa(int(d/time.Minute)) + "m"
+	}
+	return strconv.Itoa(int(d/time.Second)) + "s"
+}
+
+// generateRules for every pool of the config, one group per pool
+func generateRules(conf map[string]config.Config, t ruleThresholds) ruleFile {
+	pools := make([]string, 0, len(conf))
+	for pool := range conf {
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+
+	file := ruleFile{}
+	for _, pool := range pools {
+		servers := len(conf[pool].Servers)
+		selector := fmt.Sprintf(`{group="%s"}`, pool)
+		labels := map[string]string{"pool": pool}
+		// the servers in a maintenance window of the exporter are left out, joined on the server labels
+		maintenance := fmt.Sprintf("twemproxy_maintenance%s", selector)
+		serverNames := strings.Join(serverLabels.Names(), ", ")
+		on := "on (" + serverNames + ")"
+		group := ruleGroup{
+			Name: "twemproxy-" + pool,
+			Rules: []rule{
+				{
+					Alert:  "TwemproxyPoolDown",
+					Expr:   fmt.Sprintf("sum by (instance) (twemproxy_server_connection%s >= bool 1 unless %s %s) == 0", selector, on, maintenance),
+					For:    promDuration(t.For),
+					Labels: map[string]string{"pool": pool, "severity": "critical"},
+					Annotations: map[string]string{
+						"summary": fmt.Sprintf("No server of pool %s is available on {{ $labels.instance }}", pool),
+					},
+				},
+				{
+					Alert: "TwemproxyPoolDegraded",
+					// a server in maintenance count as available, once even when in more than one window
+					Expr: fmt.Sprintf("sum by (instance) (max by (%s) (%s) or %s (twemproxy_server_connection%s >= bool 1)) < %d",
+						serverNames, maintenance, on, selector, servers),
+					For:    promDuration(t.For),
+					Labels: map[string]string{"pool": pool, "severity": "warning"},
+					Annotations: map[string]string{
+						"summary": fmt.Sprintf("{{ $value }} of the %d servers of pool %s are available or in maintenance on {{ $labels.instance }}", servers, pool),
+					},
+				},
+				{
+					Alert:  "TwemproxyServerEjected",
+					Expr:   fmt.Sprintf("changes(twemproxy_server_ejected_at%s[5m]) > 0 unless %s %s", selector, on, maintenance),
+					Labels: labels,
+					Annotations: map[string]string{
+						"summary": "{{ $labels." + serverLabels.ServerLabel() + " }} was ejected from pool " + pool + " on {{ $labels.instance }}",
+					},
+				},
+				{
+					Alert:  "TwemproxyServerTimeouts",
+					Expr:   fmt.Sprintf("rate(twemproxy_server_timed_out%s[5m]) > %g unless %s %s", selector, t.TimeoutsRate, on, maintenance),
+					For:    promDuration(t.For),
+					Labels: labels,
+					Annotations: map[string]string{
+						"summary": "{{ $labels." + serverLabels.ServerLabel() + " }} of pool " + pool + " times out {{ $value }} requests/s on {{ $labels.instance }}",
+					},
+				},
+				{
+					Alert:  "TwemproxyServerQueueing",
+					Expr:   fmt.Sprintf("twemproxy_server_in_queue%s > %d unless %s %s", selector, t.QueueSize, on, maintenance),
+					For:    promDuration(t.For),
+					Labels: labels,
+					Annotations: map[string]string{
+						"summary": "{{ $value }} requests queued to {{ $labels." + serverLabels.ServerLabel() + " }} of pool " + pool + " on {{ $labels.instance }}",
+					},
+				},
+			},
+		}
+		file.Groups = append(file.Groups, group)
+	}
+	return file
+}
+
+func writeRules(w io.Writer, file ruleFile) error {
+	content, err := yaml.Marshal(file)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+	return err
+}
+
+// runGenRules print a prometheus rules file matching the pools of the nutcracker config
+func runGenRules(args []string) error {
+	fs := flag.NewFlagSet("gen-rules", flag.ExitOnError)
+	confPath := fs.String("config", "", "nutcracker config to generate the rules for")
+	forDuration := fs.Duration("for", time.Minute*5, "how long a condition must hold before alerting")
+	queueSize := fs.Int("queue-size", 100, "requests queued to a server before alerting")
+	timeoutsRate := fs.Float64("timeouts-rate", 1, "timed out requests per second to a server before alerting")
+	label := fs.String("server-label", collector.DefaultServerLabel, "label of the backend servers, same as -metrics.server-label of the exporter")
+	fs.Parse(args)
+	serverLabels.Server = *label
+
+	conf, err := config.LoadConfig(*confPath)
+	if err != nil {
+		return err
+	}
+	return writeRules(os.Stdout, generateRules(conf, ruleThresholds{
+		For:          *forDuration,
+		QueueSize:    *queueSize,
+		TimeoutsRate: *timeoutsRate,
+	}))
+}
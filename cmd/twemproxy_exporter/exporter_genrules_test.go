@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestGenerateRules(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	buf := &bytes.Buffer{}
+	err = writeRules(buf, generateRules(conf, ruleThresholds{For: time.Minute * 5, QueueSize: 100, TimeoutsRate: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := ruleFile{}
+	err = yaml.Unmarshal(buf.Bytes(), &file)
+	if err != nil {
+		t.Fatal("Generated rules are not valid yaml: ", err.Error())
+	}
+	if len(file.Groups) != len(conf) {
+		t.Fatalf("Expected a group per pool, got %d", len(file.Groups))
+	}
+	for _, group := range file.Groups {
+		pool := strings.TrimPrefix(group.Name, "twemproxy-")
+		for _, r := range group.Rules {
+			if !strings.Contains(r.Expr, `group="`+pool+`"`) {
+				t.Errorf("Rule %s of pool %s doesn't select the pool: %s", r.Alert, pool, r.Expr)
+			}
+			if !strings.Contains(r.Expr, `twemproxy_maintenance{group="`+pool+`"}`) || !strings.Contains(r.Expr, "on (instance, group, redis_server)") {
+				t.Errorf("Rule %s of pool %s doesn't leave out the servers in maintenance: %s", r.Alert, pool, r.Expr)
+			}
+			if r.For != "" && r.For != "5m" {
+				t.Errorf("Unexpected for %s", r.For)
+			}
+		}
+	}
+}
+
+func TestGenerateRulesServerLabel(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	serverLabels.Server = "server"
+	defer func() { serverLabels.Server = "" }()
+
+	buf := &bytes.Buffer{}
+	err = writeRules(buf, generateRules(conf, ruleThresholds{For: time.Minute, QueueSize: 100, TimeoutsRate: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "redis_server") || !strings.Contains(buf.String(), "$labels.server") {
+		t.Errorf("Expected the rules to use the server label, got %s", buf.String())
+	}
+}
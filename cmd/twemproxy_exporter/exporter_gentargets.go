@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// fileSDGroup is a target group of the Prometheus file_sd format
+type fileSDGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels"`
+}
+
+// generateTargets of the backend servers of the pools of the protocol, every protocol when empty.
+// One group per server, labeled with its pool, name and protocol. Unix sockets can't be scraped and are left out
+func generateTargets(conf map[string]config.Config, protocol, prefix string) []fileSDGroup {
+	names := make([]string, 0, len(conf))
+	for name := range conf {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	groups := []fileSDGroup{}
+	for _, name := range names {
+		pool := conf[name]
+		if protocol != "" && pool.Protocol != protocol {
+			continue
+		}
+		for _, server := range pool.Servers {
+			if server.Port == "" {
+				continue
+			}
+			groups = append(groups, fileSDGroup{
+				Targets: []string{prefix + net.JoinHostPort(server.Host, server.Port)},
+				Labels:  map[string]string{"pool": name, "server": server.Name(), "protocol": pool.Protocol},
+			})
+		}
+	}
+	return groups
+}
+
+// writeFileSD to path through a temporary file, so Prometheus never read half a file
+func writeFileSD(path string, content []byte) error {
+	tmp := path + ".tmp"
+	err := ioutil.WriteFile(tmp, content, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runGenTargets print the backend servers of the nutcracker config as Prometheus file_sd targets, for the scrape
+// configs of redis_exporter and memcached_exporter. With -output and -interval the file is kept in sync with the config
+func runGenTargets(args []string) error {
+	fs := flag.NewFlagSet("gen-targets", flag.ExitOnError)
+	confPath := fs.String("config", "", "nutcracker config of the backend servers")
+	protocol := fs.String("protocol", "", "only the servers of the redis or memcache pools, every pool when empty")
+	prefix := fs.String("prefix", "", "prefix of the targets, e.g. redis:// for the multi target mode of redis_exporter")
+	output := fs.String("output", "", "file the targets are written to, stdout when empty")
+	interval := fs.Duration("interval", 0, "reload the config and rewrite -output when it changed every interval, once when 0")
+	fs.Parse(args)
+
+	var last []byte
+	for {
+		conf, err := config.LoadConfig(*confPath)
+		if err != nil {
+			if *interval == 0 || last == nil {
+				return err
+			}
+			log.Printf("Cannot load config, keeping the targets. Error: %s", err.Error())
+		} else {
+			content, err := json.MarshalIndent(generateTargets(conf, *protocol, *prefix), "", "  ")
+			if err != nil {
+				return err
+			}
+			content = append(content, '\n')
+			switch {
+			case *output == "":
+				os.Stdout.Write(content)
+			case !bytes.Equal(content, last):
+				err = writeFileSD(*output, content)
+				if err != nil {
+					return err
+				}
+				if last != nil {
+					log.Printf("Targets of %s rewritten", *confPath)
+				}
+			}
+			last = content
+		}
+		if *interval == 0 || *output == "" {
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestGenerateTargets(t *testing.T) {
+	conf, err := config.ParseConfig([]byte(`
+sessions:
+  redis: true
+  servers:
+    - 10.0.0.1:6379:1 redis-1
+    - /var/run/redis.sock:1 local
+pages:
+  servers:
+    - 10.0.1.1:11211:1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := generateTargets(conf, "", "")
+	expected := []fileSDGroup{
+		{Targets: []string{"10.0.1.1:11211"}, Labels: map[string]string{"pool": "pages", "server": "10.0.1.1", "protocol": "memcache"}},
+		{Targets: []string{"10.0.0.1:6379"}, Labels: map[string]string{"pool": "sessions", "server": "redis-1", "protocol": "redis"}},
+	}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, groups)
+	}
+	groups = generateTargets(conf, config.ProtocolRedis, "redis://")
+	if len(groups) != 1 || groups[0].Targets[0] != "redis://10.0.0.1:6379" {
+		t.Errorf("Expected the redis server with the prefix, got %+v", groups)
+	}
+
+	output := filepath.Join(t.TempDir(), "backends.json")
+	err = runGenTargets([]string{"-config", "../../files/nutcracker.yml", "-output", output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := []fileSDGroup{}
+	if err := json.Unmarshal(content, &written); err != nil || len(written) == 0 {
+		t.Errorf("Expected file_sd targets, got %s %v", content, err)
+	}
+}
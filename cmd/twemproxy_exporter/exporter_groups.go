@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+// collectGroups of metrics, each can be turned off with -collect.<group>=false like the collectors of mysqld_exporter.
+// twemproxy_up, the service metrics and the exporter own metrics are always exported
+var collectGroups = []struct {
+	name string
+	help string
+}{
+	{monitor.GroupPool, "pool metrics: servers configured and reported, mismatch, imbalance and pool info"},
+	{monitor.GroupServer, "server metrics: up, connections, ejections, timeouts, request and error rates and hot backends"},
+	{monitor.GroupBytes, "request and response bytes of the pools and the servers, totals and rates"},
+	{monitor.GroupQueues, "in queue requests and bytes of the servers"},
+	{monitor.GroupProbes, "listen address probes of the pools and keys of the backend servers, the probes are not run when off"},
+	{"process", "process and Go runtime metrics of the exporter"},
+}
+
+// collectFlags by group, registered in init
+var collectFlags = map[string]*bool{}
+
+func init() {
+	for _, group := range collectGroups {
+		collectFlags[group.name] = flag.Bool("collect."+group.name, true, "export the "+group.help)
+	}
+}
+
+// disabledGroups from the -collect.* flags
+func disabledGroups() map[string]bool {
+	disabled := map[string]bool{}
+	for name, enabled := range collectFlags {
+		if !*enabled {
+			disabled[name] = true
+		}
+	}
+	return disabled
+}
+
+// unregisterProcessMetrics of the default registry, when the process group is disabled
+func unregisterProcessMetrics() {
+	prometheus.Unregister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	prometheus.Unregister(prometheus.NewGoCollector())
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+func TestCollectGroups(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	mock := startMockServer(t)
+	defer mock.Close()
+
+	scheduler := NewScheduler(conf, time.Hour, nil, monitor.WithRates(true),
+		monitor.WithDisabledGroups(map[string]bool{"bytes": true, "queues": true}))
+	scheduler.SetTargets([]Target{{Address: mock.Addr()}})
+	defer scheduler.Stop()
+	m := scheduler.Monitors()[0]
+	for i := 0; i < 2; i++ {
+		err := m.Run(context.Background())
+		if err != nil {
+			t.Fatal("Failed to run monitor: ", err.Error())
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(scheduler)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, family := range families {
+		names[family.GetName()] = true
+		if strings.Contains(family.GetName(), "bytes") || strings.Contains(family.GetName(), "in_queue") {
+			t.Errorf("Expected %s of a disabled group not to be exported", family.GetName())
+		}
+	}
+	for _, name := range []string{"twemproxy_up", "twemproxy_server_connection", "twemproxy_server_requests_per_second", "twemproxy_pool_servers_configured"} {
+		if !names[name] {
+			t.Errorf("Expected %s to be exported", name)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// heartbeat GET a dead man's switch url, e.g. https://hc-ping.com/<uuid>, after successful scrapes.
+// It is sent at most once per interval, so the monitored service alarm once the exporter or every target goes silent
+type heartbeat struct {
+	url      string
+	interval time.Duration
+	client   *http.Client
+
+	last    time.Time // of the last successful ping
+	pinging bool
+	mu      sync.Mutex
+}
+
+func newHeartbeat(url string, interval time.Duration) *heartbeat {
+	return &heartbeat{url: url, interval: interval, client: &http.Client{Timeout: time.Second * 10}}
+}
+
+// Observe a scrape result, ping in the background when it succeeded and the last ping is older than the interval
+func (h *heartbeat) Observe(result ScrapeResult) {
+	if result.Err != nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.pinging || result.Time.Sub(h.last) < h.interval {
+		return
+	}
+	h.pinging = true
+	go h.ping(result.Time)
+}
+
+// ping the url, a failed ping is retried after the next successful scrape
+func (h *heartbeat) ping(at time.Time) {
+	err := h.get()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pinging = false
+	if err != nil {
+		log.Printf("Cannot send heartbeat to %s. Error: %s", h.url, err.Error())
+		return
+	}
+	h.last = at
+}
+
+func (h *heartbeat) get() error {
+	resp, err := h.client.Get(h.url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("heartbeat returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat(t *testing.T) {
+	var pings int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&pings, 1)
+	}))
+	defer server.Close()
+
+	h := newHeartbeat(server.URL, time.Minute)
+	wait := func() {
+		deadline := time.Now().Add(time.Second * 5)
+		for time.Now().Before(deadline) {
+			h.mu.Lock()
+			pinging := h.pinging
+			h.mu.Unlock()
+			if !pinging {
+				return
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		t.Fatal("Heartbeat did not finish")
+	}
+	target := Target{Address: "proxy:22222"}
+	start := time.Now()
+
+	h.Observe(ScrapeResult{Target: target, Err: errors.New("refused"), Time: start})
+	wait()
+	if got := atomic.LoadInt32(&pings); got != 0 {
+		t.Fatalf("Expected no heartbeat after a failed scrape, got %d", got)
+	}
+	h.Observe(ScrapeResult{Target: target, Time: start})
+	wait()
+	h.Observe(ScrapeResult{Target: target, Time: start.Add(time.Second * 30)})
+	wait()
+	if got := atomic.LoadInt32(&pings); got != 1 {
+		t.Fatalf("Expected 1 heartbeat within the interval, got %d", got)
+	}
+	h.Observe(ScrapeResult{Target: target, Time: start.Add(time.Minute)})
+	wait()
+	if got := atomic.LoadInt32(&pings); got != 2 {
+		t.Fatalf("Expected 2 heartbeats after the interval, got %d", got)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+// historyHandler serve the last scrapes of every target, or of ?target=host:port only
+func historyHandler(scheduler *Scheduler) http.Handler {
+	type targetHistory struct {
+		Target  string                 `json:"target"`
+		History []monitor.ScrapeRecord `json:"history"`
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		only := r.URL.Query().Get("target")
+		targets := []targetHistory{}
+		for _, m := range scheduler.Monitors() {
+			if only != "" && m.Address() != only {
+				continue
+			}
+			targets = append(targets, targetHistory{Target: m.Address(), History: m.History()})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"targets": targets})
+	})
+}
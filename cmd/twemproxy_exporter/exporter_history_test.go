@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+func TestHistoryHandler(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	mock := startMockServer(t)
+	defer mock.Close()
+
+	scheduler := NewScheduler(conf, time.Hour, nil, monitor.WithHistory(10))
+	scheduler.SetTargets([]Target{{Address: mock.Addr()}, {Address: "127.0.0.1:1"}})
+	defer scheduler.Stop()
+	for _, m := range scheduler.Monitors() {
+		m.Run(context.Background())
+	}
+
+	rec := httptest.NewRecorder()
+	historyHandler(scheduler).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/history?target=127.0.0.1:1", nil))
+	body := struct {
+		Targets []struct {
+			Target  string                 `json:"target"`
+			History []monitor.ScrapeRecord `json:"history"`
+		} `json:"targets"`
+	}{}
+	err = json.NewDecoder(rec.Body).Decode(&body)
+	if err != nil {
+		t.Fatal("Invalid JSON: ", err.Error())
+	}
+	if len(body.Targets) != 1 || len(body.Targets[0].History) != 1 || body.Targets[0].History[0].Status != "error" {
+		t.Errorf("Expected the failed scrape of the unreachable target, got %+v", body.Targets)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+// hotAPIHandler serve the top n servers of every pool, e.g. /api/v1/hot?by=errors&n=3.
+// by is requests (default), errors or in_queue, n default to 5
+func hotAPIHandler(scheduler *Scheduler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		by := r.URL.Query().Get("by")
+		if by == "" {
+			by = monitor.HotByRequests
+		}
+		if by != monitor.HotByRequests && by != monitor.HotByErrors && by != monitor.HotByInQueue {
+			http.Error(w, "by must be one of requests, errors or in_queue", http.StatusBadRequest)
+			return
+		}
+		n := 5
+		if value := r.URL.Query().Get("n"); value != "" {
+			var err error
+			n, err = strconv.Atoi(value)
+			if err != nil || n < 1 {
+				http.Error(w, "n must be a positive number", http.StatusBadRequest)
+				return
+			}
+		}
+
+		pools := []monitor.HotPool{}
+		for _, m := range scheduler.Monitors() {
+			pools = append(pools, m.Hot(by, n)...)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"by": by, "pools": pools})
+	})
+}
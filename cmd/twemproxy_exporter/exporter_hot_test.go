@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+func TestHotAPI(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	mock := startMockServer(t)
+	defer mock.Close()
+
+	scheduler := NewScheduler(conf, time.Hour, nil)
+	scheduler.SetTargets([]Target{{Address: mock.Addr()}})
+	defer scheduler.Stop()
+	m := scheduler.Monitors()[0]
+	for i := 0; i < 2; i++ {
+		err := m.Run(context.Background())
+		if err != nil {
+			t.Fatal("Failed to run monitor: ", err.Error())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	hotAPIHandler(scheduler).ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/hot?n=1", nil))
+	body := struct {
+		By    string            `json:"by"`
+		Pools []monitor.HotPool `json:"pools"`
+	}{}
+	err = json.NewDecoder(rec.Body).Decode(&body)
+	if err != nil {
+		t.Fatal("Invalid JSON: ", err.Error())
+	}
+	if body.By != monitor.HotByRequests || len(body.Pools) == 0 {
+		t.Fatalf("Unexpected response %+v", body)
+	}
+	for _, pool := range body.Pools {
+		if len(pool.Servers) != 1 {
+			t.Errorf("Expected the top server of %s, got %+v", pool.Pool, pool.Servers)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	hotAPIHandler(scheduler).ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/hot?by=latency", nil))
+	if rec.Code != 400 {
+		t.Errorf("Expected 400 for an unknown signal, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,647 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+)
+
+// kafka event types, published on every state change
+const (
+	kafkaTargetDown      = "target_down"
+	kafkaTargetUp        = "target_up"
+	kafkaServerEjected   = "server_ejected"
+	kafkaServerRecovered = "server_recovered"
+	kafkaPoolDegraded    = "pool_degraded"
+	kafkaPoolRestored    = "pool_restored"
+)
+
+// kafkaEventsTotal by result: sent, failed when the brokers refused them, dropped when too many were pending
+var kafkaEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: collector.Namespace,
+	Subsystem: "exporter",
+	Name:      "kafka_events_total",
+	Help:      "Events published to Kafka by result",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(kafkaEventsTotal)
+}
+
+// kafkaEvent is the JSON value of a message, its key is the target so the events of a target stay ordered
+type kafkaEvent struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Target    string    `json:"target"`
+	Pool      string    `json:"pool,omitempty"`
+	Server    string    `json:"server,omitempty"`
+	Reason    string    `json:"reason,omitempty"` // why the target or server went down
+	Value     float64   `json:"value,omitempty"`  // unavailable ratio of the pool
+	Threshold float64   `json:"threshold,omitempty"`
+}
+
+// kafkaPublisher turn the scrape results into state change events published to a Kafka topic.
+// The first scrape of a target only publish what is down, not what is up
+type kafkaPublisher struct {
+	producer  *kafkaProducer
+	threshold float64 // pool unavailable ratio above which the pool is degraded
+	events    chan kafkaEvent
+
+	targetUp map[string]bool
+	serverUp map[string]bool // by target and server
+	degraded map[string]bool // by target and pool
+	mu       sync.Mutex
+}
+
+func newKafkaPublisher(producer *kafkaProducer, threshold float64) *kafkaPublisher {
+	return &kafkaPublisher{
+		producer:  producer,
+		threshold: threshold,
+		events:    make(chan kafkaEvent, 1000),
+		targetUp:  make(map[string]bool),
+		serverUp:  make(map[string]bool),
+		degraded:  make(map[string]bool),
+	}
+}
+
+// Observe a scrape result, queue an event for every target, server and pool changing state
+func (k *kafkaPublisher) Observe(result ScrapeResult) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	target := result.Target.instanceLabel()
+	base := kafkaEvent{Time: result.Time, Target: target}
+	up, seen := k.targetUp[target]
+	k.targetUp[target] = result.Err == nil
+	if result.Err != nil {
+		if up || !seen {
+			e := base
+			e.Type, e.Reason = kafkaTargetDown, result.Err.Error()
+			k.send(e)
+		}
+		// nothing to say about the pools of an unreachable target
+		return
+	}
+	if seen && !up {
+		e := base
+		e.Type = kafkaTargetUp
+		k.send(e)
+	}
+
+	for poolName, pool := range result.Stats.Services {
+		for name, server := range pool.Servers {
+			key := target + "\xff" + name
+			was, seen := k.serverUp[key]
+			now := server.Up()
+			k.serverUp[key] = now
+			if seen && was == now || !seen && now {
+				continue
+			}
+			e := base
+			e.Pool, e.Server = poolName, name
+			if now {
+				e.Type = kafkaServerRecovered
+			} else {
+				e.Type, e.Reason = kafkaServerEjected, "no_connection"
+				if server.Ejected {
+					e.Reason = "ejected"
+				}
+			}
+			k.send(e)
+		}
+
+		if pool.ExpectedAvailable == 0 {
+			continue
+		}
+		key := target + "\xff" + poolName
+		ratio := float64(pool.NotAvailable) / float64(pool.ExpectedAvailable)
+		was, seen := k.degraded[key]
+		now := ratio > k.threshold
+		k.degraded[key] = now
+		if seen && was == now || !seen && !now {
+			continue
+		}
+		e := base
+		e.Pool, e.Value, e.Threshold = poolName, ratio, k.threshold
+		e.Type = kafkaPoolRestored
+		if now {
+			e.Type = kafkaPoolDegraded
+		}
+		k.send(e)
+	}
+}
+
+func (k *kafkaPublisher) send(event kafkaEvent) {
+	select {
+	case k.events <- event:
+	default:
+		kafkaEventsTotal.WithLabelValues("dropped").Inc()
+		log.Printf("Too many pending Kafka events, dropping %s on %s", event.Type, event.Target)
+	}
+}
+
+// run publish the queued events until ctx is done, the events queued in the meantime are sent together
+func (k *kafkaPublisher) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-k.events:
+			batch := []kafkaEvent{event}
+		pending:
+			for len(batch) < cap(k.events) {
+				select {
+				case event := <-k.events:
+					batch = append(batch, event)
+				default:
+					break pending
+				}
+			}
+			k.publish(ctx, batch)
+		}
+	}
+}
+
+func (k *kafkaPublisher) publish(ctx context.Context, batch []kafkaEvent) {
+	messages := make([]kafkaMessage, 0, len(batch))
+	for _, event := range batch {
+		value, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		messages = append(messages, kafkaMessage{Key: []byte(event.Target), Value: value, Time: event.Time})
+	}
+	err := k.producer.Produce(ctx, messages)
+	if err != nil {
+		kafkaEventsTotal.WithLabelValues("failed").Add(float64(len(messages)))
+		log.Printf("Cannot publish %d events to Kafka topic %s. Error: %s", len(messages), k.producer.topic, err.Error())
+		return
+	}
+	kafkaEventsTotal.WithLabelValues("sent").Add(float64(len(messages)))
+}
+
+// kafka protocol, only what is needed to produce: Metadata v4 to find the partition leaders
+// and Produce v3 with record batches, supported by Kafka 0.11 and later including 4.x.
+// Plaintext or TLS without SASL, a connection is opened for every batch since state changes are rare
+const (
+	kafkaProduceKey    = 0
+	kafkaProduceVer    = 3
+	kafkaMetadataKey   = 3
+	kafkaMetadataVer   = 4
+	kafkaAcksLeader    = 1
+	kafkaMaxResponse   = 16 << 20
+	kafkaDefaultPort   = "9092"
+	kafkaDefaultClient = "twemproxy_exporter"
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaMessage to produce, the partition is chosen from the key
+type kafkaMessage struct {
+	Key   []byte
+	Value []byte
+	Time  time.Time
+}
+
+// kafkaProducer write messages to a topic, acknowledged by the partition leader
+type kafkaProducer struct {
+	brokers   []string // bootstrap host:port
+	topic     string
+	clientID  string
+	tlsConfig *tls.Config // nil in plaintext
+	timeout   time.Duration
+}
+
+func newKafkaProducer(brokers string, topic string, clientID string, tlsConfig *tls.Config, timeout time.Duration) (*kafkaProducer, error) {
+	p := &kafkaProducer{topic: topic, clientID: clientID, tlsConfig: tlsConfig, timeout: timeout}
+	for _, broker := range strings.Split(brokers, ",") {
+		broker = strings.TrimSpace(broker)
+		if broker == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(broker); err != nil {
+			broker = net.JoinHostPort(broker, kafkaDefaultPort)
+		}
+		p.brokers = append(p.brokers, broker)
+	}
+	if len(p.brokers) == 0 {
+		return nil, fmt.Errorf("No Kafka broker in %q", brokers)
+	}
+	if topic == "" {
+		return nil, fmt.Errorf("Kafka topic is empty")
+	}
+	if p.clientID == "" {
+		p.clientID = kafkaDefaultClient
+	}
+	if p.timeout == 0 {
+		p.timeout = time.Second * 10
+	}
+	return p, nil
+}
+
+// kafkaPartition of the topic and the address of its leader, empty when it has none
+type kafkaPartition struct {
+	id     int32
+	leader string
+}
+
+// murmur2 of Kafka's Utils.murmur2, the hash the Java producer partition the keyed messages with,
+// so the events of a target land in the same partition as the ones other producers key the same
+func murmur2(data []byte) int32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// Produce the messages, one request per partition leader
+func (p *kafkaProducer) Produce(ctx context.Context, messages []kafkaMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	partitions, err := p.partitions(ctx)
+	if err != nil {
+		return err
+	}
+	byPartition := make(map[int32][]kafkaMessage)
+	for _, m := range messages {
+		// like the Java DefaultPartitioner, over every partition even those without a leader
+		partition := partitions[int(murmur2(m.Key)&0x7fffffff)%len(partitions)]
+		if partition.leader == "" {
+			return fmt.Errorf("Kafka partition %s/%d has no leader", p.topic, partition.id)
+		}
+		byPartition[partition.id] = append(byPartition[partition.id], m)
+	}
+	byLeader := make(map[string][]int32)
+	for _, partition := range partitions {
+		if _, ok := byPartition[partition.id]; ok {
+			byLeader[partition.leader] = append(byLeader[partition.leader], partition.id)
+		}
+	}
+	for leader, ids := range byLeader {
+		err := p.produce(ctx, leader, ids, byPartition)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// partitions of the topic from the first bootstrap broker answering
+func (p *kafkaProducer) partitions(ctx context.Context) ([]kafkaPartition, error) {
+	var lastErr error
+	for _, broker := range p.brokers {
+		partitions, err := p.metadata(ctx, broker)
+		if err == nil {
+			return partitions, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+func (p *kafkaProducer) metadata(ctx context.Context, broker string) ([]kafkaPartition, error) {
+	req := &kafkaWriter{}
+	req.int32(1) // topics
+	req.string(p.topic)
+	req.int8(0) // allow_auto_topic_creation
+	resp, err := p.roundTrip(ctx, broker, kafkaMetadataKey, kafkaMetadataVer, req.buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	r := &kafkaReader{buf: resp}
+	r.int32() // throttle_time_ms
+	brokers := make(map[int32]string)
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		id := r.int32()
+		host := r.string()
+		port := r.int32()
+		r.string() // rack
+		brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	r.string() // cluster_id
+	r.int32()  // controller_id
+	var partitions []kafkaPartition
+	led := 0
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		code := r.int16()
+		name := r.string()
+		r.int8() // is_internal
+		for j := r.int32(); j > 0 && r.err == nil; j-- {
+			partitionCode := r.int16()
+			id := r.int32()
+			leader := r.int32()
+			r.int32Array() // replica_nodes
+			r.int32Array() // isr_nodes
+			if name != p.topic {
+				continue
+			}
+			partition := kafkaPartition{id: id}
+			if partitionCode == 0 {
+				partition.leader = brokers[leader]
+			}
+			if partition.leader != "" {
+				led++
+			}
+			partitions = append(partitions, partition)
+		}
+		if name == p.topic && code != 0 {
+			return nil, fmt.Errorf("Kafka topic %s has error code %d", p.topic, code)
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("Cannot decode metadata from %s. Error: %w", broker, r.err)
+	}
+	if led == 0 {
+		return nil, fmt.Errorf("Kafka topic %s has no partition with a leader", p.topic)
+	}
+	// the partitioners index the partitions by id
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].id < partitions[j].id })
+	return partitions, nil
+}
+
+func (p *kafkaProducer) produce(ctx context.Context, leader string, ids []int32, byPartition map[int32][]kafkaMessage) error {
+	req := &kafkaWriter{}
+	req.int16(-1) // transactional_id
+	req.int16(kafkaAcksLeader)
+	req.int32(int32(p.timeout / time.Millisecond))
+	req.int32(1) // topics
+	req.string(p.topic)
+	req.int32(int32(len(ids)))
+	for _, id := range ids {
+		req.int32(id)
+		req.bytes(recordBatch(byPartition[id]))
+	}
+	resp, err := p.roundTrip(ctx, leader, kafkaProduceKey, kafkaProduceVer, req.buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	r := &kafkaReader{buf: resp}
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		r.string() // name
+		for j := r.int32(); j > 0 && r.err == nil; j-- {
+			id := r.int32()
+			code := r.int16()
+			r.int64() // base_offset
+			r.int64() // log_append_time_ms
+			if code != 0 && r.err == nil {
+				return fmt.Errorf("Kafka partition %s/%d returned error code %d", p.topic, id, code)
+			}
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("Cannot decode produce response from %s. Error: %w", leader, r.err)
+	}
+	return nil
+}
+
+// roundTrip send one request to the broker and return the response body, without the correlation id
+func (p *kafkaProducer) roundTrip(ctx context.Context, broker string, key int16, version int16, body []byte) ([]byte, error) {
+	var dialer interface {
+		DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	} = &net.Dialer{Timeout: p.timeout}
+	if p.tlsConfig != nil {
+		// the server name is taken from the broker address when not configured
+		dialer = &tls.Dialer{NetDialer: &net.Dialer{Timeout: p.timeout}, Config: p.tlsConfig}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", broker)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(p.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	const correlationID = 1
+	header := &kafkaWriter{}
+	header.int16(key)
+	header.int16(version)
+	header.int32(correlationID)
+	header.string(p.clientID)
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(header.buf.Len()+len(body)))
+	_, err = conn.Write(append(append(size, header.buf.Bytes()...), body...))
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	_, err = io.ReadFull(reader, size)
+	if err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(size)
+	if length < 4 || length > kafkaMaxResponse {
+		return nil, fmt.Errorf("Invalid response size %d from %s", length, broker)
+	}
+	resp := make([]byte, length)
+	_, err = io.ReadFull(reader, resp)
+	if err != nil {
+		return nil, err
+	}
+	if int32(binary.BigEndian.Uint32(resp)) != correlationID {
+		return nil, fmt.Errorf("Unexpected correlation id from %s", broker)
+	}
+	return resp[4:], nil
+}
+
+// recordBatch of the messages in the v2 format (magic 2), uncompressed
+func recordBatch(messages []kafkaMessage) []byte {
+	first := messages[0].Time
+	last := first
+	records := &kafkaWriter{}
+	for i, m := range messages {
+		if m.Time.After(last) {
+			last = m.Time
+		}
+		record := &kafkaWriter{}
+		record.int8(0) // attributes
+		record.varint(m.Time.Sub(first).Milliseconds())
+		record.varint(int64(i)) // offset delta
+		record.varint(int64(len(m.Key)))
+		record.buf.Write(m.Key)
+		record.varint(int64(len(m.Value)))
+		record.buf.Write(m.Value)
+		record.varint(0) // headers
+		records.varint(int64(record.buf.Len()))
+		records.buf.Write(record.buf.Bytes())
+	}
+
+	// from attributes to the end, covered by the crc
+	tail := &kafkaWriter{}
+	tail.int16(0) // attributes
+	tail.int32(int32(len(messages) - 1))
+	tail.int64(first.UnixNano() / int64(time.Millisecond))
+	tail.int64(last.UnixNano() / int64(time.Millisecond))
+	tail.int64(-1) // producer_id
+	tail.int16(-1) // producer_epoch
+	tail.int32(-1) // base_sequence
+	tail.int32(int32(len(messages)))
+	tail.buf.Write(records.buf.Bytes())
+
+	batch := &kafkaWriter{}
+	batch.int64(0) // base_offset
+	batch.int32(int32(4 + 1 + 4 + tail.buf.Len()))
+	batch.int32(-1) // partition_leader_epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(tail.buf.Bytes(), crc32c)))
+	batch.buf.Write(tail.buf.Bytes())
+	return batch.buf.Bytes()
+}
+
+// kafkaWriter encode the big endian primitives of the protocol
+type kafkaWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *kafkaWriter) int8(v int8) {
+	w.buf.WriteByte(byte(v))
+}
+
+func (w *kafkaWriter) int16(v int16) {
+	w.buf.Write([]byte{byte(v >> 8), byte(v)})
+}
+
+func (w *kafkaWriter) int32(v int32) {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, uint32(v))
+	w.buf.Write(b)
+}
+
+func (w *kafkaWriter) int64(v int64) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v))
+	w.buf.Write(b)
+}
+
+func (w *kafkaWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *kafkaWriter) bytes(b []byte) {
+	w.int32(int32(len(b)))
+	w.buf.Write(b)
+}
+
+// varint zigzag encoded, as in the records
+func (w *kafkaWriter) varint(v int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	w.buf.Write(b[:binary.PutVarint(b, v)])
+}
+
+// kafkaReader decode the big endian primitives of the protocol, err is set on the first short read
+// and every following read return zero
+type kafkaReader struct {
+	buf []byte
+	err error
+}
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.buf) < n {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *kafkaReader) int8() int8 {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	return int8(b[0])
+}
+
+func (r *kafkaReader) int16() int16 {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return int16(binary.BigEndian.Uint16(b))
+}
+
+func (r *kafkaReader) int32() int32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (r *kafkaReader) int64() int64 {
+	b := r.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+// string or nullable string, null is empty
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+func (r *kafkaReader) int32Array() {
+	for i := r.int32(); i > 0 && r.err == nil; i-- {
+		r.int32()
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+)
+
+// startFakeBroker answer the metadata of one topic with one partition led by itself,
+// and send the values of the produced records on the channel, over TLS when tlsConfig is set
+func startFakeBroker(t *testing.T, topic string, tlsConfig *tls.Config) (string, chan []byte) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	t.Cleanup(func() { l.Close() })
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	values := make(chan []byte, 100)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			size := make([]byte, 4)
+			if _, err := io.ReadFull(conn, size); err != nil {
+				conn.Close()
+				continue
+			}
+			req := make([]byte, binary.BigEndian.Uint32(size))
+			io.ReadFull(conn, req)
+			r := &kafkaReader{buf: req}
+			key, _, correlation := r.int16(), r.int16(), r.int32()
+			r.string() // client_id
+
+			resp := &kafkaWriter{}
+			resp.int32(correlation)
+			switch key {
+			case kafkaMetadataKey:
+				resp.int32(0) // throttle
+				resp.int32(1) // brokers
+				resp.int32(7)
+				resp.string(host)
+				resp.int32(int32(portNum))
+				resp.int16(-1) // rack
+				resp.int16(-1) // cluster_id
+				resp.int32(7)  // controller
+				resp.int32(1)  // topics
+				resp.int16(0)
+				resp.string(topic)
+				resp.int8(0)
+				resp.int32(1) // partitions
+				resp.int16(0)
+				resp.int32(0)
+				resp.int32(7)
+				resp.int32(1)
+				resp.int32(7)
+				resp.int32(1)
+				resp.int32(7)
+			case kafkaProduceKey:
+				r.string() // transactional_id
+				r.int16()  // acks
+				r.int32()  // timeout
+				r.int32()  // topics
+				r.string()
+				r.int32() // partitions
+				r.int32()
+				batch := r.next(int(r.int32()))
+				b := &kafkaReader{buf: batch}
+				b.int64()
+				b.int32()
+				b.int32()
+				b.int8()
+				crc := uint32(b.int32())
+				if crc != crc32.Checksum(b.buf, crc32c) {
+					t.Errorf("Invalid record batch crc")
+				}
+				b.next(2 + 4 + 8 + 8 + 8 + 2 + 4)
+				for i := b.int32(); i > 0; i-- {
+					length, n := binary.Varint(b.buf)
+					record := &kafkaReader{buf: b.next(n + int(length))[n:]}
+					record.int8()
+					for j := 0; j < 2; j++ {
+						_, n := binary.Varint(record.buf)
+						record.next(n)
+					}
+					keyLen, n := binary.Varint(record.buf)
+					record.next(n + int(keyLen))
+					valueLen, n := binary.Varint(record.buf)
+					values <- record.next(n + int(valueLen))[n:]
+				}
+				resp.int32(1)
+				resp.string(topic)
+				resp.int32(1)
+				resp.int32(0)
+				resp.int16(0)
+				resp.int64(0)
+				resp.int64(-1)
+				resp.int32(0) // throttle
+			}
+			binary.BigEndian.PutUint32(size, uint32(resp.buf.Len()))
+			conn.Write(append(size, resp.buf.Bytes()...))
+			conn.Close()
+		}
+	}()
+	return l.Addr().String(), values
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	broker, values := startFakeBroker(t, "events", nil)
+	producer, err := newKafkaProducer(broker, "events", "", nil, time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := newKafkaPublisher(producer, 0.25)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go k.run(ctx)
+
+	target := Target{Address: "proxy:22222"}
+	now := time.Now()
+	ejected := testutil.ExampleStats(t, "alpha")
+	alpha := ejected.Services[testutil.ExamplePool].Servers["alpha"]
+	alpha.Ejected = true
+	ejected.Services[testutil.ExamplePool].Servers["alpha"] = alpha
+	k.Observe(ScrapeResult{Target: target, Stats: testutil.ExampleStats(t), Time: now})
+	k.Observe(ScrapeResult{Target: target, Err: errors.New("refused"), Time: now.Add(time.Second)})
+	k.Observe(ScrapeResult{Target: target, Stats: testutil.ExampleStats(t), Time: now.Add(time.Second * 2)})
+	k.Observe(ScrapeResult{Target: target, Stats: ejected, Time: now.Add(time.Second * 3)})
+	k.Observe(ScrapeResult{Target: target, Stats: testutil.ExampleStats(t), Time: now.Add(time.Second * 4)})
+
+	pool := testutil.ExamplePool
+	expected := []kafkaEvent{
+		{Type: kafkaTargetDown, Reason: "refused"},
+		{Type: kafkaTargetUp},
+		{Type: kafkaServerEjected, Pool: pool, Server: "alpha", Reason: "ejected"},
+		{Type: kafkaPoolDegraded, Pool: pool, Value: 0.5, Threshold: 0.25},
+		{Type: kafkaServerRecovered, Pool: pool, Server: "alpha"},
+		{Type: kafkaPoolRestored, Pool: pool, Threshold: 0.25},
+	}
+	for i, want := range expected {
+		select {
+		case value := <-values:
+			var got kafkaEvent
+			err := json.Unmarshal(value, &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Type != want.Type || got.Pool != want.Pool || got.Server != want.Server || got.Reason != want.Reason ||
+				got.Value != want.Value || got.Threshold != want.Threshold || got.Target != target.instanceLabel() {
+				t.Fatalf("Expected event %d to be %+v, got %+v", i, want, got)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Expected event %d %s", i, want.Type)
+		}
+	}
+}
+
+func TestMurmur2(t *testing.T) {
+	// from Kafka's UtilsTest.testMurmur2
+	for key, expected := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		if got := murmur2([]byte(key)); got != expected {
+			t.Errorf("Expected murmur2 of %q to be %d, got %d", key, expected, got)
+		}
+	}
+}
+
+func TestKafkaProducerTLS(t *testing.T) {
+	certPath, keyPath := writeCertificate(t, t.TempDir())
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	broker, values := startFakeBroker(t, "events", &tls.Config{Certificates: []tls.Certificate{cert}})
+	tlsConfig, err := TLSConfig{Enabled: true, CAFile: certPath}.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer, err := newKafkaProducer(broker, "events", "", tlsConfig, time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = producer.Produce(context.Background(), []kafkaMessage{{Key: []byte("proxy:22222"), Value: []byte("event"), Time: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case value := <-values:
+		if string(value) != "event" {
+			t.Fatalf("Expected the produced value, got %q", value)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Expected the message to be produced over TLS")
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// Error list
+var (
+	ErrNotInCluster = errors.New("not running inside a kubernetes cluster")
+	ErrKubeNotFound = errors.New("kubernetes object not found")
+)
+
+// service account files mounted in every pod
+const (
+	serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubeRetryInterval = time.Second * 5
+)
+
+// defaults when running as a sidecar next to twemproxy in the same pod
+const (
+	sidecarConfigPath = "/etc/nutcracker/nutcracker.yml"
+	sidecarPodInfoDir = "/etc/podinfo"
+)
+
+// downwardAPILabels map the label name to the Downward API env var and file name
+var downwardAPILabels = []struct {
+	label string
+	env   string
+	file  string
+}{
+	{"pod", "POD_NAME", "pod_name"},
+	{"namespace", "POD_NAMESPACE", "pod_namespace"},
+	{"node", "NODE_NAME", "node_name"},
+}
+
+// inKubernetesPod is true when the Downward API exposed the pod name through env or a volume
+func inKubernetesPod(podInfoDir string) bool {
+	if os.Getenv("KUBERNETES_SERVICE_HOST") == "" {
+		return false
+	}
+	if os.Getenv("POD_NAME") != "" {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(podInfoDir, "pod_name"))
+	return err == nil
+}
+
+// kubernetesPodLabels read pod, namespace and node from the Downward API env vars,
+// falling back to the files of a Downward API volume
+func kubernetesPodLabels(podInfoDir string) prometheus.Labels {
+	labels := prometheus.Labels{}
+	for _, l := range downwardAPILabels {
+		value := os.Getenv(l.env)
+		if value == "" {
+			content, err := ioutil.ReadFile(filepath.Join(podInfoDir, l.file))
+			if err != nil {
+				continue
+			}
+			value = strings.TrimSpace(string(content))
+		}
+		if value != "" {
+			labels[l.label] = value
+		}
+	}
+	return labels
+}
+
+// kubeClient talk to the API server using the pod service account
+type kubeClient struct {
+	host   string
+	client *http.Client
+}
+
+// kubeEvent is one line of a watch stream
+type kubeEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// kubeObjectMeta we care about
+type kubeObjectMeta struct {
+	Name            string            `json:"name,omitempty"`
+	Namespace       string            `json:"namespace,omitempty"`
+	ResourceVersion string            `json:"resourceVersion,omitempty"`
+	Annotations     map[string]string `json:"annotations,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+}
+
+type kubeConfigMap struct {
+	Metadata kubeObjectMeta    `json:"metadata"`
+	Data     map[string]string `json:"data"`
+}
+
+func newInClusterClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, ErrNotInCluster
+	}
+
+	ca, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+
+	return &kubeClient{
+		host: "https://" + net.JoinHostPort(host, port),
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// do the request, the token is read on every request because bound tokens are rotated
+func (k *kubeClient) do(method string, path string, body []byte, timeout time.Duration) (*http.Response, error) {
+	token, err := config.ReadSecretFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, k.host+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := *k.client
+	client.Timeout = timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return resp, ErrKubeNotFound
+	}
+	if resp.StatusCode >= 300 {
+		content, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, strings.TrimSpace(string(content)))
+	}
+	return resp, nil
+}
+
+// get the object at path into out
+func (k *kubeClient) get(path string, out interface{}) error {
+	resp, err := k.do("GET", path, nil, time.Second*10)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// watch the path and call fn for every event until the stream is closed by the API server
+func (k *kubeClient) watch(path string, resourceVersion string, fn func(kubeEvent)) error {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	path += sep + "watch=true&resourceVersion=" + url.QueryEscape(resourceVersion)
+
+	// no timeout, the API server end the watch by itself after a while
+	resp, err := k.do("GET", path, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		event := kubeEvent{}
+		err := decoder.Decode(&event)
+		if err != nil {
+			return err
+		}
+		if event.Type == "ERROR" {
+			return fmt.Errorf("watch %s failed: %s", path, string(event.Object))
+		}
+		fn(event)
+	}
+}
+
+// parseConfigMapRef from namespace/name/key
+func parseConfigMapRef(ref string) (string, string, string, error) {
+	p := strings.Split(ref, "/")
+	if len(p) != 3 || p[0] == "" || p[1] == "" || p[2] == "" {
+		return "", "", "", fmt.Errorf("Invalid ConfigMap reference %s, expected namespace/name/key", ref)
+	}
+	return p[0], p[1], p[2], nil
+}
+
+// getConfigMapKey return the content of the key and the ConfigMap resource version
+func (k *kubeClient) getConfigMapKey(namespace, name, key string) ([]byte, string, error) {
+	cm := kubeConfigMap{}
+	err := k.get(fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s", namespace, name), &cm)
+	if err != nil {
+		return nil, "", err
+	}
+	content, ok := cm.Data[key]
+	if !ok {
+		return nil, "", fmt.Errorf("ConfigMap %s/%s has no key %s", namespace, name, key)
+	}
+	return []byte(content), cm.Metadata.ResourceVersion, nil
+}
+
+// watchConfigMap call onChange with the content of the key every time the ConfigMap change.
+// It never return, failed watches are restarted after a short delay
+func (k *kubeClient) watchConfigMap(namespace, name, key string, onChange func([]byte)) {
+	path := fmt.Sprintf("/api/v1/namespaces/%s/configmaps?fieldSelector=%s", namespace, url.QueryEscape("metadata.name="+name))
+	for {
+		// get first, changes made while we were not watching are not lost
+		content, resourceVersion, err := k.getConfigMapKey(namespace, name, key)
+		if err != nil {
+			log.Printf("Cannot get ConfigMap %s/%s. Error: %s", namespace, name, err.Error())
+			time.Sleep(kubeRetryInterval)
+			continue
+		}
+		onChange(content)
+
+		err = k.watch(path, resourceVersion, func(event kubeEvent) {
+			if event.Type != "ADDED" && event.Type != "MODIFIED" {
+				return
+			}
+			cm := kubeConfigMap{}
+			err := json.Unmarshal(event.Object, &cm)
+			if err != nil {
+				log.Println("Cannot decode ConfigMap event: ", err.Error())
+				return
+			}
+			if content, ok := cm.Data[key]; ok {
+				onChange([]byte(content))
+			}
+		})
+		if err != nil {
+			log.Printf("Watch of ConfigMap %s/%s stopped. Error: %s", namespace, name, err.Error())
+		}
+		time.Sleep(kubeRetryInterval)
+	}
+}
+
+// watchConfigMapConfig hot reload the monitors config every time the ConfigMap change
+func watchConfigMapConfig(ref string, scheduler *Scheduler) error {
+	namespace, name, key, err := parseConfigMapRef(ref)
+	if err != nil {
+		return err
+	}
+	client, err := newInClusterClient()
+	if err != nil {
+		return err
+	}
+
+	var last []byte
+	go client.watchConfigMap(namespace, name, key, func(content []byte) {
+		if bytes.Equal(content, last) {
+			return
+		}
+		conf, err := lenientConfig(config.ParseConfig(content))
+		configReloaded(conf, err)
+		if err != nil {
+			log.Printf("Invalid config in ConfigMap %s, keeping the current one. Error: %s", ref, err.Error())
+			return
+		}
+		last = content
+		scheduler.SetConfig(conf)
+		log.Printf("Config reloaded from ConfigMap %s", ref)
+	})
+	return nil
+}
@@ -0,0 +1,45 @@
+//go:build unix && !solaris && !aix
+// +build unix,!solaris,!aix
+
+package main
+
+import (
+	"log"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// fileLockElector elect the replica holding an exclusive lock on a shared file.
+// The lock is released by the kernel when the leader dies, so another replica take over
+type fileLockElector struct {
+	path   string
+	leader int32
+}
+
+func newFileLockElector(path string, retry time.Duration) (*fileLockElector, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	e := &fileLockElector{path: path}
+	go func() {
+		for {
+			err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+			if err == nil {
+				// we keep the file open, the lock is ours until we exit
+				log.Printf("Acquired lock %s", path)
+				atomic.StoreInt32(&e.leader, 1)
+				return
+			}
+			time.Sleep(retry)
+		}
+	}()
+	return e, nil
+}
+
+// IsLeader once the lock is acquired
+func (e *fileLockElector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1
+}
@@ -0,0 +1,22 @@
+//go:build !unix || solaris || aix
+// +build !unix solaris aix
+
+package main
+
+import (
+	"errors"
+	"runtime"
+	"time"
+)
+
+// fileLockElector without flock(2), never the leader
+type fileLockElector struct{}
+
+func newFileLockElector(path string, retry time.Duration) (*fileLockElector, error) {
+	return nil, errors.New("file lock leader election is not supported on " + runtime.GOOS)
+}
+
+// IsLeader is never true without flock(2)
+func (e *fileLockElector) IsLeader() bool {
+	return false
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+	"github.com/albert-widi/twemproxy_exporter/pkg/rate"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+// MaintenanceWindow of a target, a pool or a server, planned downtime excluded from the availability and the events
+type MaintenanceWindow struct {
+	ID     string    `yaml:"id" json:"id"`
+	Target string    `yaml:"target,omitempty" json:"target,omitempty"` // address, instance or name, every target when empty
+	Pool   string    `yaml:"pool,omitempty" json:"pool,omitempty"`     // the whole target when empty
+	Server string    `yaml:"server,omitempty" json:"server,omitempty"` // the whole pool when empty, name or address of the server
+	Start  time.Time `yaml:"start" json:"start"`
+	End    time.Time `yaml:"end" json:"end"`
+	Reason string    `yaml:"reason,omitempty" json:"reason,omitempty"`
+}
+
+// maintenanceFile is the content of -maintenance.file
+//
+//	windows:
+//	  - pool: alpha
+//	    server: redis-3
+//	    start: 2024-05-02T22:00:00Z
+//	    end: 2024-05-03T02:00:00Z
+//	    reason: shard migration
+type maintenanceFile struct {
+	Windows []MaintenanceWindow `yaml:"windows"`
+}
+
+func (w MaintenanceWindow) active(at time.Time) bool {
+	return !at.Before(w.Start) && at.Before(w.End)
+}
+
+func (w MaintenanceWindow) matchTarget(t Target) bool {
+	return w.Target == "" || w.Target == t.Address || w.Target == t.instanceLabel() || (t.Name != "" && w.Target == t.Name)
+}
+
+func (w MaintenanceWindow) matchServer(name string, server stats.ServerStats) bool {
+	return w.Server == name || w.Server == server.HostAlias
+}
+
+// maintenanceSeenTimeout after which a target without scrape, e.g. a removed one, has no twemproxy_maintenance series
+const maintenanceSeenTimeout = time.Minute * 15
+
+// maintenanceTarget is the last scrape of a target, the servers in maintenance are exported with its labels
+type maintenanceTarget struct {
+	target  Target
+	servers map[string]map[string]stats.ServerStats // by pool, of the last successful scrape
+	seen    time.Time
+}
+
+// maintenanceSchedule hold the windows of the file and the admin API, the file is rewritten on every change.
+// Expired windows are dropped on the next change
+type maintenanceSchedule struct {
+	path    string
+	windows []MaintenanceWindow
+	targets map[string]maintenanceTarget // by address
+	mu      sync.Mutex
+
+	desc *prometheus.Desc
+}
+
+func newMaintenanceSchedule(path string) (*maintenanceSchedule, error) {
+	s := &maintenanceSchedule{
+		path:    path,
+		targets: make(map[string]maintenanceTarget),
+		// labelled like the server metrics rather than adding maintenance="true" to them, which would start new
+		// series for the window, so the alerting rules join on instance, group and the server
+		desc: prometheus.NewDesc(prometheus.BuildFQName(collector.Namespace, "", "maintenance"),
+			"1 while the server is in a maintenance window, its unavailability is excluded from the availability and the events",
+			append(serverLabels.Names(), "id"), nil),
+	}
+	if path == "" {
+		return s, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot open: %s. Error: %s", path, err.Error())
+	}
+	file := maintenanceFile{}
+	err = yaml.Unmarshal(content, &file)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse: %s. Error: %s", path, err.Error())
+	}
+	for i, w := range file.Windows {
+		if w.End.IsZero() {
+			return nil, fmt.Errorf("Maintenance window without end in %s", path)
+		}
+		if w.Server != "" && w.Pool == "" {
+			return nil, fmt.Errorf("Maintenance window of server %s without pool in %s", w.Server, path)
+		}
+		if w.ID == "" {
+			file.Windows[i].ID = strconv.Itoa(i + 1)
+		}
+	}
+	s.windows = file.Windows
+	return s, nil
+}
+
+// Add a window starting now when it has no start, with the next free id when it has none
+func (s *maintenanceSchedule) Add(w MaintenanceWindow) (MaintenanceWindow, error) {
+	now := time.Now()
+	if w.Start.IsZero() {
+		w.Start = now
+	}
+	if !w.End.After(w.Start) {
+		return w, fmt.Errorf("Maintenance window must end after its start")
+	}
+	if w.Server != "" && w.Pool == "" {
+		return w, fmt.Errorf("Maintenance window of server %s without pool", w.Server)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	windows := make([]MaintenanceWindow, 0, len(s.windows)+1)
+	next := 1
+	for _, existing := range s.windows {
+		if n, err := strconv.Atoi(existing.ID); err == nil && n >= next {
+			next = n + 1
+		}
+		if existing.End.After(now) && existing.ID != w.ID {
+			windows = append(windows, existing)
+		}
+	}
+	if w.ID == "" {
+		w.ID = strconv.Itoa(next)
+	}
+	return w, s.set(append(windows, w))
+}
+
+// Remove the window by id, false when there is none
+func (s *maintenanceSchedule) Remove(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	windows := make([]MaintenanceWindow, 0, len(s.windows))
+	for _, w := range s.windows {
+		if w.ID != id {
+			windows = append(windows, w)
+		}
+	}
+	if len(windows) == len(s.windows) {
+		return false, nil
+	}
+	return true, s.set(windows)
+}
+
+// Windows not expired yet, sorted by start
+func (s *maintenanceSchedule) Windows() []MaintenanceWindow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	windows := make([]MaintenanceWindow, 0, len(s.windows))
+	for _, w := range s.windows {
+		if w.End.After(now) {
+			windows = append(windows, w)
+		}
+	}
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].Start.Before(windows[j].Start)
+	})
+	return windows
+}
+
+// set the windows, saved before they are applied so a failed save change nothing
+func (s *maintenanceSchedule) set(windows []MaintenanceWindow) error {
+	if s.path != "" {
+		content, err := yaml.Marshal(maintenanceFile{Windows: windows})
+		if err != nil {
+			return err
+		}
+		tmp := s.path + ".tmp"
+		err = ioutil.WriteFile(tmp, content, 0644)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(tmp, s.path)
+		if err != nil {
+			return err
+		}
+	}
+	s.windows = windows
+	return nil
+}
+
+// exclude what is in maintenance from the scrape result, false when the whole target is.
+// The stats are copied, the other observers still get the original result
+func (s *maintenanceSchedule) exclude(result ScrapeResult) (ScrapeResult, bool) {
+	s.mu.Lock()
+	var windows []MaintenanceWindow
+	for _, w := range s.windows {
+		if w.active(result.Time) && w.matchTarget(result.Target) {
+			if w.Pool == "" {
+				s.mu.Unlock()
+				return result, false
+			}
+			windows = append(windows, w)
+		}
+	}
+	s.mu.Unlock()
+	if len(windows) == 0 || result.Err != nil {
+		return result, true
+	}
+
+	services := make(map[string]stats.ServiceStats, len(result.Stats.Services))
+	rates := make(map[string]rate.PoolRates, len(result.Rates))
+	for poolName, pool := range result.Stats.Services {
+		services[poolName] = pool
+	}
+	for poolName, r := range result.Rates {
+		rates[poolName] = r
+	}
+	for _, w := range windows {
+		pool, ok := services[w.Pool]
+		if !ok {
+			continue
+		}
+		if w.Server == "" {
+			delete(services, w.Pool)
+			delete(rates, w.Pool)
+			result.Stats.ExpectedAvailable -= pool.ExpectedAvailable
+			result.Stats.NotAvailable -= pool.NotAvailable
+			continue
+		}
+		servers := make(map[string]stats.ServerStats, len(pool.Servers))
+		for name, server := range pool.Servers {
+			if !w.matchServer(name, server) {
+				servers[name] = server
+				continue
+			}
+			pool.ExpectedAvailable--
+			result.Stats.ExpectedAvailable--
+			if server.ServerConnections < 1 {
+				pool.NotAvailable--
+				result.Stats.NotAvailable--
+			}
+			if r, ok := rates[w.Pool]; ok && hasServerRates(r, name) {
+				serverRates := make(map[string]rate.ServerRates, len(r.Servers))
+				for n, sr := range r.Servers {
+					if n != name {
+						serverRates[n] = sr
+					}
+				}
+				r.Servers = serverRates
+				rates[w.Pool] = r
+			}
+		}
+		pool.Servers = servers
+		services[w.Pool] = pool
+	}
+	result.Stats.Services = services
+	if result.Rates != nil {
+		result.Rates = rates
+	}
+	return result, true
+}
+
+func hasServerRates(r rate.PoolRates, name string) bool {
+	_, ok := r.Servers[name]
+	return ok
+}
+
+// observer wrapping fn, which only see what is not in maintenance
+func (s *maintenanceSchedule) observer(fn func(ScrapeResult)) func(ScrapeResult) {
+	if s == nil {
+		return fn
+	}
+	return func(result ScrapeResult) {
+		result, ok := s.exclude(result)
+		if ok {
+			fn(result)
+		}
+	}
+}
+
+// Observe the servers of every target, the ones of a failed scrape are kept from the last successful one
+func (s *maintenanceSchedule) Observe(result ScrapeResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.targets[result.Target.Address]
+	t.target, t.seen = result.Target, result.Time
+	if result.Err == nil {
+		t.servers = make(map[string]map[string]stats.ServerStats, len(result.Stats.Services))
+		for poolName, pool := range result.Stats.Services {
+			t.servers[poolName] = pool.Servers
+		}
+	}
+	s.targets[result.Target.Address] = t
+}
+
+// Describe nothing, unchecked like the scheduler
+func (s *maintenanceSchedule) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect every server of the targets in an active window
+func (s *maintenanceSchedule) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for address, t := range s.targets {
+		if now.Sub(t.seen) > maintenanceSeenTimeout {
+			delete(s.targets, address)
+		}
+	}
+	for _, w := range s.windows {
+		if !w.active(now) {
+			continue
+		}
+		for _, t := range s.targets {
+			if !w.matchTarget(t.target) {
+				continue
+			}
+			for poolName, servers := range t.servers {
+				if w.Pool != "" && w.Pool != poolName {
+					continue
+				}
+				for name, server := range servers {
+					if w.Server == "" || w.matchServer(name, server) {
+						ch <- prometheus.MustNewConstMetric(s.desc, prometheus.GaugeValue, 1,
+							t.target.instanceLabel(), poolName, server.HostAlias, w.ID)
+					}
+				}
+			}
+		}
+	}
+}
+
+// maintenanceHandler list the windows with GET /api/v1/maintenance, and when admin is true add them with POST
+// and remove them with DELETE /api/v1/maintenance/{id}. The changes are only allowed when the web config
+// authenticate the requests
+func maintenanceHandler(schedule *maintenanceSchedule, admin bool, authenticated bool) http.Handler {
+	change := maintenanceChangeHandler(schedule)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/api/v1/maintenance" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"windows": schedule.Windows()})
+			return
+		}
+		if !admin {
+			http.Error(w, "Only GET is allowed, the admin API is disabled", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authenticated {
+			http.Error(w, "Changing maintenance windows needs basic_auth_users or required client certificates in the web config", http.StatusForbidden)
+			return
+		}
+		change.ServeHTTP(w, r)
+	})
+}
+
+func maintenanceChangeHandler(schedule *maintenanceSchedule) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1/maintenance"), "/")
+		switch {
+		case r.Method == http.MethodPost && id == "":
+			window := MaintenanceWindow{}
+			err := json.NewDecoder(r.Body).Decode(&window)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid maintenance window: %s", err.Error()), http.StatusBadRequest)
+				return
+			}
+			window, err = schedule.Add(window)
+			if err != nil {
+				log.Printf("Cannot add maintenance window. Error: %s", err.Error())
+				http.Error(w, fmt.Sprintf("Cannot add maintenance window: %s", err.Error()), http.StatusBadRequest)
+				return
+			}
+			log.Printf("Maintenance window %s of %s/%s/%s added through the API", window.ID, window.Target, window.Pool, window.Server)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(window)
+		case r.Method == http.MethodDelete && id != "":
+			removed, err := schedule.Remove(id)
+			if err != nil {
+				log.Printf("Cannot remove maintenance window %s. Error: %s", id, err.Error())
+				http.Error(w, fmt.Sprintf("Cannot remove maintenance window: %s", err.Error()), http.StatusInternalServerError)
+				return
+			}
+			if !removed {
+				http.Error(w, fmt.Sprintf("No maintenance window %s", id), http.StatusNotFound)
+				return
+			}
+			log.Printf("Maintenance window %s removed through the API", id)
+			fmt.Fprintf(w, "Maintenance window %s removed\n", id)
+		default:
+			http.Error(w, "Only GET, POST and DELETE are allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+	"github.com/albert-widi/twemproxy_exporter/pkg/rate"
+)
+
+func TestMaintenanceExclude(t *testing.T) {
+	now := time.Now()
+	target := Target{Address: "proxy:22222", Name: "edge-1"}
+	pool := testutil.ExamplePool
+	window := func(target, pool, server string) MaintenanceWindow {
+		return MaintenanceWindow{Target: target, Pool: pool, Server: server, Start: now.Add(-time.Minute), End: now.Add(time.Hour)}
+	}
+	s, err := newMaintenanceSchedule("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.windows = []MaintenanceWindow{window("edge-1", pool, "redis2:6379:1")}
+	original := testutil.ExampleStats(t, "beta")
+	rates := map[string]rate.PoolRates{pool: {Servers: map[string]rate.ServerRates{"alpha": {}, "beta": {Errors: 5}}}}
+	result, ok := s.exclude(ScrapeResult{Target: target, Stats: original, Rates: rates, Time: now})
+	if !ok {
+		t.Fatal("Expected the target to be observed")
+	}
+	excluded := result.Stats.Services[pool]
+	if _, in := excluded.Servers["beta"]; in || excluded.ExpectedAvailable != 1 || excluded.NotAvailable != 0 {
+		t.Errorf("Expected beta to be excluded from the pool, got %+v", excluded)
+	}
+	if result.Stats.ExpectedAvailable != 1 || result.Stats.NotAvailable != 0 {
+		t.Errorf("Expected 0 of 1 server unavailable, got %d of %d", result.Stats.NotAvailable, result.Stats.ExpectedAvailable)
+	}
+	if _, in := result.Rates[pool].Servers["beta"]; in {
+		t.Error("Expected the rates of beta to be excluded")
+	}
+	if _, in := original.Services[pool].Servers["beta"]; !in || len(rates[pool].Servers) != 2 {
+		t.Error("Expected the original result to be left untouched")
+	}
+
+	s.windows = []MaintenanceWindow{window("", pool, "")}
+	result, _ = s.exclude(ScrapeResult{Target: target, Stats: testutil.ExampleStats(t, "beta"), Time: now})
+	if _, in := result.Stats.Services[pool]; in || result.Stats.ExpectedAvailable != 0 || result.Stats.NotAvailable != 0 {
+		t.Errorf("Expected the pool to be excluded, got %+v", result.Stats)
+	}
+
+	s.windows = []MaintenanceWindow{window("proxy:22222", "", "")}
+	if _, ok := s.exclude(ScrapeResult{Target: target, Err: errors.New("refused"), Time: now}); ok {
+		t.Error("Expected the target in maintenance not to be observed")
+	}
+	if _, ok := s.exclude(ScrapeResult{Target: Target{Address: "other:22222"}, Err: errors.New("refused"), Time: now}); !ok {
+		t.Error("Expected another target to be observed")
+	}
+	if _, ok := s.exclude(ScrapeResult{Target: target, Err: errors.New("refused"), Time: now.Add(time.Hour * 2)}); !ok {
+		t.Error("Expected the target to be observed after the window")
+	}
+}
+
+func TestMaintenanceSeries(t *testing.T) {
+	now := time.Now()
+	pool := testutil.ExamplePool
+	s, err := newMaintenanceSchedule("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.windows = []MaintenanceWindow{
+		{ID: "1", Target: "edge-1", Pool: pool, Server: "beta", Start: now.Add(-time.Minute), End: now.Add(time.Hour)},
+		{ID: "2", Pool: pool, Start: now.Add(-time.Minute), End: now.Add(time.Hour)},
+		{ID: "3", Target: "edge-2", Start: now.Add(-time.Minute), End: now.Add(time.Hour)},
+		{ID: "4", Pool: pool, Start: now.Add(time.Hour), End: now.Add(time.Hour * 2)},
+	}
+	s.Observe(ScrapeResult{Target: Target{Address: "proxy-1:22222", Name: "edge-1"}, Stats: testutil.ExampleStats(t), Time: now})
+	s.Observe(ScrapeResult{Target: Target{Address: "proxy-2:22222", Name: "edge-2", Instance: "edge-2"}, Stats: testutil.ExampleStats(t), Time: now})
+	// the servers of the last successful scrape are kept
+	s.Observe(ScrapeResult{Target: Target{Address: "proxy-2:22222", Name: "edge-2", Instance: "edge-2"}, Err: errors.New("refused"), Time: now})
+	s.Observe(ScrapeResult{Target: Target{Address: "gone:22222"}, Stats: testutil.ExampleStats(t), Time: now.Add(-maintenanceSeenTimeout * 2)})
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(s)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var series []string
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			series = append(series, strings.Join([]string{labels["instance"], labels["group"], labels["redis_server"], labels["id"]}, " "))
+		}
+	}
+	expected := []string{
+		"proxy-1:22222 " + pool + " redis2:6379:1 1",
+		"proxy-1:22222 " + pool + " redis:6379:1 2",
+		"proxy-1:22222 " + pool + " redis2:6379:1 2",
+		"edge-2 " + pool + " redis:6379:1 2",
+		"edge-2 " + pool + " redis2:6379:1 2",
+		"edge-2 " + pool + " redis:6379:1 3",
+		"edge-2 " + pool + " redis2:6379:1 3",
+	}
+	sort.Strings(series)
+	sort.Strings(expected)
+	if strings.Join(series, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected the servers in maintenance labelled like the server metrics\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(series, "\n"))
+	}
+}
+
+func TestMaintenanceAPI(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maintenance.yml")
+	s, err := newMaintenanceSchedule(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := maintenanceHandler(s, true, true)
+
+	end := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/maintenance", strings.NewReader(`{"pool":"alpha","server":"redis-2","end":"`+end+`"}`)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d %s", rec.Code, rec.Body.String())
+	}
+	added := MaintenanceWindow{}
+	json.NewDecoder(rec.Body).Decode(&added)
+	if added.ID != "1" || added.Start.IsZero() {
+		t.Errorf("Expected id 1 starting now, got %+v", added)
+	}
+
+	reloaded, err := newMaintenanceSchedule(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if windows := reloaded.Windows(); len(windows) != 1 || windows[0].Server != "redis-2" {
+		t.Errorf("Expected the window to be saved, got %+v", windows)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/maintenance", strings.NewReader(`{"server":"redis-2","end":"`+end+`"}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected a window of a server without pool to be refused, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("DELETE", "/api/v1/maintenance/1", nil))
+	if rec.Code != http.StatusOK || len(s.Windows()) != 0 {
+		t.Errorf("Expected the window to be removed, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	maintenanceHandler(s, false, true).ServeHTTP(rec, httptest.NewRequest("DELETE", "/api/v1/maintenance/1", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected changes to be refused without the admin API, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	maintenanceHandler(s, true, false).ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/maintenance", strings.NewReader(`{"pool":"alpha","end":"`+end+`"}`)))
+	if rec.Code != http.StatusForbidden || len(s.Windows()) != 0 {
+		t.Errorf("Expected changes to be refused without authentication, got %d", rec.Code)
+	}
+}
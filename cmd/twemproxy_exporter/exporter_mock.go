@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// counters that grow on every connection served by the mock server
+var mockCounters = []string{"requests", "request_bytes", "responses", "response_bytes"}
+
+// mockServer serve a stats payload the same way twemproxy stats port does:
+// write the whole JSON document on accept and close the connection.
+// Faults can be injected to test the resilience of the exporter
+type mockServer struct {
+	Delay        time.Duration // wait before writing the payload
+	PartialWrite bool          // write the payload in small chunks with pauses in between
+	ResetRate    float64       // probability to reset the connection instead of answering
+	DriftRate    float64       // probability to rename and drop fields from the payload
+	CounterReset int           // reset the counters every n connections, like a twemproxy restart
+
+	payload  map[string]interface{}
+	listener net.Listener
+	random   *rand.Rand
+	served   int
+	mu       sync.Mutex
+}
+
+func newMockServer(payload []byte, seed int64) (*mockServer, error) {
+	m := &mockServer{
+		payload: make(map[string]interface{}),
+		random:  rand.New(rand.NewSource(seed)),
+	}
+	err := json.Unmarshal(payload, &m.payload)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Listen and serve in the background
+func (m *mockServer) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	m.listener = l
+	go m.serve()
+	return nil
+}
+
+// Addr of the listener
+func (m *mockServer) Addr() string {
+	return m.listener.Addr().String()
+}
+
+// Close the listener
+func (m *mockServer) Close() error {
+	return m.listener.Close()
+}
+
+func (m *mockServer) serve() {
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			return
+		}
+		go m.handle(conn)
+	}
+}
+
+func (m *mockServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	m.mu.Lock()
+	m.served++
+	generation := m.served
+	if m.CounterReset > 0 {
+		generation = m.served % m.CounterReset
+	}
+	reset := m.random.Float64() < m.ResetRate
+	drift := m.random.Float64() < m.DriftRate
+	m.mu.Unlock()
+
+	if m.Delay > 0 {
+		time.Sleep(m.Delay)
+	}
+	if reset {
+		// linger 0 make the kernel send RST instead of FIN
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetLinger(0)
+		}
+		return
+	}
+
+	content, err := json.Marshal(m.render(generation, drift))
+	if err != nil {
+		log.Println("Mock failed to marshal payload: ", err.Error())
+		return
+	}
+	if !m.PartialWrite {
+		conn.Write(content)
+		return
+	}
+	for len(content) > 0 {
+		n := 64
+		if n > len(content) {
+			n = len(content)
+		}
+		_, err := conn.Write(content[:n])
+		if err != nil {
+			return
+		}
+		content = content[n:]
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+// render a copy of the payload with counters grown by generation
+func (m *mockServer) render(generation int, drift bool) map[string]interface{} {
+	out := make(map[string]interface{})
+	for key, val := range m.payload {
+		pool, ok := val.(map[string]interface{})
+		if !ok {
+			out[key] = val
+			continue
+		}
+
+		p := make(map[string]interface{})
+		for poolKey, poolVal := range pool {
+			server, ok := poolVal.(map[string]interface{})
+			if !ok {
+				p[poolKey] = poolVal
+				continue
+			}
+
+			s := make(map[string]interface{})
+			for serverKey, serverVal := range server {
+				s[serverKey] = serverVal
+			}
+			for _, counter := range mockCounters {
+				if val, ok := s[counter].(float64); ok {
+					s[counter] = val + float64(generation*100)
+				}
+			}
+			if drift {
+				// the kind of renames we have seen from twemproxy forks
+				s["server_timeout"] = s["server_timedout"]
+				delete(s, "server_timedout")
+				delete(s, "out_queue_bytes")
+			}
+			p[poolKey] = s
+		}
+		if drift {
+			delete(p, "fragments")
+		}
+		out[key] = p
+	}
+	if uptime, ok := out["uptime"].(float64); ok {
+		out["uptime"] = uptime + float64(generation)
+	}
+	return out
+}
+
+// runMock serve a stats payload with optional fault injection
+func runMock(args []string) error {
+	fs := flag.NewFlagSet("mock", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:22222", "address to listen on")
+	file := fs.String("file", "files/example.json", "stats payload to serve")
+	seed := fs.Int64("seed", 1, "random seed for the fault injection")
+	delay := fs.Duration("delay", 0, "delay before writing the payload")
+	partial := fs.Bool("partial", false, "write the payload in small chunks")
+	resetRate := fs.Float64("reset-rate", 0, "probability of resetting the connection")
+	driftRate := fs.Float64("drift-rate", 0, "probability of renaming and dropping payload fields")
+	counterReset := fs.Int("counter-reset", 0, "reset counters every n connections")
+	fs.Parse(args)
+
+	payload, err := ioutil.ReadFile(*file)
+	if err != nil {
+		return fmt.Errorf("Cannot open: %s. Error: %s", *file, err.Error())
+	}
+	m, err := newMockServer(payload, *seed)
+	if err != nil {
+		return err
+	}
+	m.Delay = *delay
+	m.PartialWrite = *partial
+	m.ResetRate = *resetRate
+	m.DriftRate = *driftRate
+	m.CounterReset = *counterReset
+
+	err = m.Listen(*listen)
+	if err != nil {
+		return err
+	}
+	log.Printf("Mock twemproxy stats listening on %s", m.Addr())
+
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, shutdownSignals...)
+	<-term
+	return m.Close()
+}
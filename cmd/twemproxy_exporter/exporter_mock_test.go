@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+// startMockServer with the options set before it start accepting connections
+func startMockServer(t *testing.T, options ...func(*mockServer)) *mockServer {
+	payload, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Fatal("Failed to read json example: ", err.Error())
+	}
+	m, err := newMockServer(payload, 1)
+	if err != nil {
+		t.Fatal("Failed to create mock server: ", err.Error())
+	}
+	for _, option := range options {
+		option(m)
+	}
+	err = m.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to start mock server: ", err.Error())
+	}
+	return m
+}
+
+func requestsOf(t *testing.T, payload []byte) float64 {
+	stats := make(map[string]interface{})
+	err := json.Unmarshal(payload, &stats)
+	if err != nil {
+		t.Fatal("Invalid payload: ", err.Error())
+	}
+	pool := stats["wallet-oauth-token"].(map[string]interface{})
+	return pool["alpha"].(map[string]interface{})["requests"].(float64)
+}
+
+func TestFetchStatsPartialWrite(t *testing.T) {
+	m := startMockServer(t, func(m *mockServer) {
+		m.PartialWrite = true
+		m.Delay = time.Millisecond * 50
+	})
+	defer m.Close()
+
+	payload, err := stats.Fetch(context.Background(), m.Addr(), nil, 0)
+	if err != nil {
+		t.Fatal("Failed to fetch stats: ", err.Error())
+	}
+	if !json.Valid(payload) {
+		t.Errorf("Payload is truncated: %s", string(payload))
+	}
+}
+
+func TestFetchStatsConnectionReset(t *testing.T) {
+	m := startMockServer(t, func(m *mockServer) {
+		m.ResetRate = 1
+	})
+	defer m.Close()
+
+	payload, err := stats.Fetch(context.Background(), m.Addr(), nil, 0)
+	if err == nil && len(payload) > 0 {
+		t.Errorf("Expected reset connection, got %d bytes", len(payload))
+	}
+}
+
+func TestMockCounterReset(t *testing.T) {
+	m := startMockServer(t, func(m *mockServer) {
+		m.CounterReset = 3
+	})
+	defer m.Close()
+
+	var requests []float64
+	for i := 0; i < 3; i++ {
+		payload, err := stats.Fetch(context.Background(), m.Addr(), nil, 0)
+		if err != nil {
+			t.Fatal("Failed to fetch stats: ", err.Error())
+		}
+		requests = append(requests, requestsOf(t, payload))
+	}
+	if requests[1] <= requests[0] {
+		t.Errorf("Expected counters to grow, got %v", requests)
+	}
+	if requests[2] >= requests[1] {
+		t.Errorf("Expected counters to reset, got %v", requests)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/rate"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+// natsPoolStats is the JSON message published for every pool of every successful scrape
+type natsPoolStats struct {
+	Target    string             `json:"target"`
+	Instance  string             `json:"instance"`
+	Time      time.Time          `json:"time"`
+	Service   string             `json:"service"`
+	Source    string             `json:"source"`
+	Uptime    float64            `json:"uptime"`
+	Timestamp float64            `json:"timestamp"`
+	Pool      string             `json:"pool"`
+	Stats     stats.ServiceStats `json:"stats"`
+	Rates     *rate.PoolRates    `json:"rates,omitempty"`
+}
+
+// natsPublisher publish the parsed stats of every pool on <prefix>.<pool>, over one connection
+// re-opened after any error. Plaintext NATS protocol, authenticated with the user of the url and the password of
+// the secret, or with the secret as token when the url has no user
+type natsPublisher struct {
+	url     *url.URL
+	secret  *config.Secret // nil without authentication
+	prefix  string
+	timeout time.Duration
+	results chan ScrapeResult
+
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newNATSPublisher to the url, which can't hold the password or token, flags are visible to everyone with ps
+func newNATSPublisher(rawURL string, prefix string, secret *config.Secret) (*natsPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid NATS url %s. Error: %s", rawURL, err.Error())
+	}
+	if u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("Invalid NATS url %s, expected nats://host:port", rawURL)
+	}
+	if _, ok := u.User.Password(); ok || (u.User != nil && secret == nil) {
+		return nil, fmt.Errorf("The NATS url %s can't hold the password or token, use -nats.password-file", u.Redacted())
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	return &natsPublisher{
+		url:     u,
+		secret:  secret,
+		prefix:  strings.TrimSuffix(prefix, "."),
+		timeout: time.Second * 10,
+		results: make(chan ScrapeResult, 100),
+	}, nil
+}
+
+// natsToken of a pool in a subject, the separators and wildcards of the subjects are replaced
+func natsToken(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// Observe a scrape result, failed scrapes have no stats to publish
+func (n *natsPublisher) Observe(result ScrapeResult) {
+	if result.Err != nil {
+		return
+	}
+	select {
+	case n.results <- result:
+	default:
+		log.Printf("Too many pending NATS publications, dropping the stats of %s", result.Target.instanceLabel())
+	}
+}
+
+// run publish the observed stats until ctx is done
+func (n *natsPublisher) run(ctx context.Context) {
+	defer n.close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case result := <-n.results:
+			err := n.publish(result)
+			if err != nil && n.conn != nil {
+				// the server may have closed an idle connection, retry once on a new one
+				n.close()
+				err = n.publish(result)
+			}
+			if err != nil {
+				log.Printf("Cannot publish the stats of %s to NATS. Error: %s", result.Target.instanceLabel(), err.Error())
+				n.close()
+			}
+		}
+	}
+}
+
+// publish one message per pool, confirmed by a PING answered once the server processed them
+func (n *natsPublisher) publish(result ScrapeResult) error {
+	if n.conn == nil {
+		err := n.connect()
+		if err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	for pool, service := range result.Stats.Services {
+		message := natsPoolStats{
+			Target:    result.Target.Address,
+			Instance:  result.Target.instanceLabel(),
+			Time:      result.Time,
+			Service:   result.Stats.Service,
+			Source:    result.Stats.Source,
+			Uptime:    result.Stats.Uptime,
+			Timestamp: result.Stats.Timestamp,
+			Pool:      pool,
+			Stats:     service,
+		}
+		if r, ok := result.Rates[pool]; ok {
+			message.Rates = &r
+		}
+		payload, err := json.Marshal(message)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "PUB %s.%s %d\r\n", n.prefix, natsToken(pool), len(payload))
+		buf.Write(payload)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("PING\r\n")
+	n.conn.SetDeadline(time.Now().Add(n.timeout))
+	_, err := n.conn.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return n.waitPong()
+}
+
+// connect, read the INFO of the server and send CONNECT
+func (n *natsPublisher) connect() error {
+	conn, err := net.DialTimeout("tcp", n.url.Host, n.timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(n.timeout))
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("Unexpected greeting from %s: %q", n.url.Host, strings.TrimSpace(line))
+	}
+	info := struct {
+		TLSRequired bool `json:"tls_required"`
+	}{}
+	json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info)
+	if info.TLSRequired {
+		conn.Close()
+		return fmt.Errorf("NATS server %s requires TLS", n.url.Host)
+	}
+
+	options := map[string]interface{}{"verbose": false, "pedantic": false, "lang": "go", "name": "twemproxy_exporter", "protocol": 0}
+	if n.secret != nil {
+		// re-read on every connection so rotated credentials are picked up
+		password, err := n.secret.Get()
+		if err != nil {
+			conn.Close()
+			return fmt.Errorf("Cannot read the NATS password. Error: %s", err.Error())
+		}
+		if n.url.User != nil {
+			options["user"], options["pass"] = n.url.User.Username(), password
+		} else {
+			options["auth_token"] = password
+		}
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	_, err = conn.Write([]byte("CONNECT " + string(connect) + "\r\nPING\r\n"))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	n.conn, n.reader = conn, reader
+	return n.waitPong()
+}
+
+// waitPong answer the PINGs of the server until the PONG of ours, a -ERR is an error
+func (n *natsPublisher) waitPong() error {
+	for {
+		line, err := n.reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			_, err = n.conn.Write([]byte("PONG\r\n"))
+			if err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS server %s returned %s", n.url.Host, line)
+		}
+	}
+}
+
+func (n *natsPublisher) close() {
+	if n.conn != nil {
+		n.conn.Close()
+		n.conn, n.reader = nil, nil
+	}
+}
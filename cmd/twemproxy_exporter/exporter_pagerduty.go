@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// pagerDutyEventsURL is the PagerDuty Events API v2
+var pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PagerDutyConfig to page on critical conditions
+//
+//	pagerduty:
+//	  routing_key: {vault: "secret/data/twemproxy_exporter#pagerduty"}
+//	  rules: [twemproxy_down]
+type PagerDutyConfig struct {
+	RoutingKey *config.Secret `yaml:"routing_key"`
+	// Rules paging, when empty twemproxy being unreachable and whole pools being unavailable page
+	Rules []string `yaml:"rules"`
+}
+
+// wholePoolThreshold from which a pool_unavailable_ratio rule is about a whole pool being unavailable, like the
+// default pool_down rule
+const wholePoolThreshold = 0.99
+
+// pagerDutyNotifier trigger an incident per target and pool, resolved when the alert resolve
+type pagerDutyNotifier struct {
+	conf   PagerDutyConfig
+	rules  map[string]bool
+	client *http.Client
+}
+
+func newPagerDutyNotifier(conf PagerDutyConfig) *pagerDutyNotifier {
+	p := &pagerDutyNotifier{
+		conf:   conf,
+		rules:  make(map[string]bool),
+		client: &http.Client{Timeout: time.Second * 10},
+	}
+	for _, rule := range conf.Rules {
+		p.rules[rule] = true
+	}
+	return p
+}
+
+// pages is true for the events of the rules worth waking somebody up, decided by the rule and not the value as an
+// alert is only notified once when it start firing
+func (p *pagerDutyNotifier) pages(event alertEvent) bool {
+	if len(p.rules) > 0 {
+		return p.rules[event.Rule]
+	}
+	switch event.Condition {
+	case conditionTargetDown:
+		return true
+	case conditionPoolUnavailable:
+		return event.Threshold >= wholePoolThreshold
+	}
+	return false
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string           `json:"routing_key"`
+	EventAction string           `json:"event_action"`
+	DedupKey    string           `json:"dedup_key"`
+	Payload     *pagerDutyDetail `json:"payload,omitempty"`
+}
+
+type pagerDutyDetail struct {
+	Summary       string     `json:"summary"`
+	Source        string     `json:"source"`
+	Severity      string     `json:"severity"`
+	Component     string     `json:"component,omitempty"`
+	Group         string     `json:"group,omitempty"`
+	Timestamp     time.Time  `json:"timestamp"`
+	CustomDetails alertEvent `json:"custom_details"`
+}
+
+// Notify PagerDuty, the dedup key is the target and pool so the resolve close the incident
+func (p *pagerDutyNotifier) Notify(ctx context.Context, event alertEvent) error {
+	if !p.pages(event) {
+		return nil
+	}
+	key, err := p.conf.RoutingKey.Get()
+	if err != nil {
+		return err
+	}
+
+	e := pagerDutyEvent{
+		RoutingKey:  key,
+		EventAction: "trigger",
+		DedupKey:    "twemproxy/" + event.Target + "/" + event.Pool,
+	}
+	if event.Status == alertResolved {
+		e.EventAction = "resolve"
+	} else {
+		summary := fmt.Sprintf("twemproxy %s is unreachable", event.Target)
+		if event.Pool != "" {
+			summary = fmt.Sprintf("pool %s on twemproxy %s has %.0f%% of its servers unavailable", event.Pool, event.Target, event.Value*100)
+		}
+		e.Payload = &pagerDutyDetail{
+			Summary:       summary,
+			Source:        event.Target,
+			Severity:      "critical",
+			Component:     "twemproxy",
+			Group:         event.Pool,
+			Timestamp:     event.StartsAt,
+			CustomDetails: event,
+		}
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	resp, err := postJSON(ctx, p.client, pagerDutyEventsURL, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("PagerDuty returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// pagerDutyServer record the events sent to the Events API
+type pagerDutyServer struct {
+	*httptest.Server
+	events []pagerDutyEvent
+	mu     sync.Mutex
+}
+
+func startPagerDutyServer(t *testing.T) *pagerDutyServer {
+	s := &pagerDutyServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := pagerDutyEvent{}
+		json.NewDecoder(r.Body).Decode(&e)
+		s.mu.Lock()
+		s.events = append(s.events, e)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	url := pagerDutyEventsURL
+	pagerDutyEventsURL = s.URL
+	t.Cleanup(func() {
+		pagerDutyEventsURL = url
+		s.Close()
+	})
+	return s
+}
+
+// wait for count events, then a bit longer to catch unexpected ones
+func (s *pagerDutyServer) wait(t *testing.T, count int) []pagerDutyEvent {
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		n := len(s.events)
+		s.mu.Unlock()
+		if n >= count {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	time.Sleep(time.Millisecond * 50)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]pagerDutyEvent(nil), s.events...)
+}
+
+func TestPagerDutyTriggerAndResolve(t *testing.T) {
+	server := startPagerDutyServer(t)
+	p := newPagerDutyNotifier(PagerDutyConfig{RoutingKey: config.NewSecret("key")})
+	a := newAlerter(context.Background(), []AlertRule{
+		{Name: "pool_degraded", Condition: conditionPoolUnavailable, Threshold: 0.25},
+		{Name: "pool_down", Condition: conditionPoolUnavailable, Threshold: 0.99},
+		{Name: "backend_down", Condition: conditionServerUnavailable},
+	}, []notifier{p}, time.Hour)
+	target := Target{Address: "proxy:22222"}
+	start := time.Now()
+
+	// degraded first, then the whole pool down, then recovered
+	for i, down := range [][]string{{"alpha"}, {"alpha", "beta"}, nil} {
+		a.Observe(ScrapeResult{Target: target, Stats: testutil.ExampleStats(t, down...), Time: start.Add(time.Duration(i) * time.Second)})
+	}
+	events := server.wait(t, 2)
+	if len(events) != 2 {
+		t.Fatalf("Expected a trigger and a resolve of pool_down, got %+v", events)
+	}
+	if events[0].EventAction != "trigger" || events[1].EventAction != "resolve" || events[0].DedupKey != events[1].DedupKey {
+		t.Errorf("Unexpected events %+v", events)
+	}
+	if events[0].RoutingKey != "key" || events[0].Payload.Severity != "critical" || events[0].Payload.CustomDetails.Rule != "pool_down" {
+		t.Errorf("Unexpected trigger %+v", events[0])
+	}
+}
+
+func TestPagerDutyConfiguredRule(t *testing.T) {
+	server := startPagerDutyServer(t)
+	p := newPagerDutyNotifier(PagerDutyConfig{RoutingKey: config.NewSecret("key"), Rules: []string{"pool_degraded"}})
+	a := newAlerter(context.Background(), []AlertRule{
+		{Name: "pool_degraded", Condition: conditionPoolUnavailable, Threshold: 0.25},
+	}, []notifier{p}, time.Hour)
+	target := Target{Address: "proxy:22222"}
+
+	// the alert fire once below a whole pool down and is not notified again when the pool goes fully down
+	a.Observe(ScrapeResult{Target: target, Stats: testutil.ExampleStats(t, "alpha"), Time: time.Now()})
+	a.Observe(ScrapeResult{Target: target, Stats: testutil.ExampleStats(t, "alpha", "beta"), Time: time.Now()})
+	events := server.wait(t, 1)
+	if len(events) != 1 || events[0].EventAction != "trigger" {
+		t.Errorf("Expected the configured rule paging when it start firing, got %+v", events)
+	}
+}
@@ -0,0 +1,65 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// dropPrivileges chroot and switch to an unprivileged user, called after the listening socket is bound.
+// Files read later (config reload, secrets, certificates) are then resolved inside the chroot
+func dropPrivileges(username, chroot string) error {
+	if username == "" && chroot == "" {
+		return nil
+	}
+
+	// look up the user before chroot, /etc/passwd is likely not inside it
+	uid, gid := -1, -1
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			return err
+		}
+		uid, err = strconv.Atoi(u.Uid)
+		if err != nil {
+			return err
+		}
+		gid, err = strconv.Atoi(u.Gid)
+		if err != nil {
+			return err
+		}
+	}
+
+	if chroot != "" {
+		err := syscall.Chroot(chroot)
+		if err != nil {
+			return fmt.Errorf("Cannot chroot to %s. Error: %s", chroot, err.Error())
+		}
+		err = os.Chdir("/")
+		if err != nil {
+			return err
+		}
+	}
+
+	if username != "" {
+		// groups first, we can't change them anymore once setuid is done
+		err := syscall.Setgroups([]int{gid})
+		if err != nil {
+			return fmt.Errorf("Cannot set groups. Error: %s", err.Error())
+		}
+		err = syscall.Setgid(gid)
+		if err != nil {
+			return fmt.Errorf("Cannot set gid %d. Error: %s", gid, err.Error())
+		}
+		err = syscall.Setuid(uid)
+		if err != nil {
+			return fmt.Errorf("Cannot set uid %d. Error: %s", uid, err.Error())
+		}
+	}
+	return nil
+}
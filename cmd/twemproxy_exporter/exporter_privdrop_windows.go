@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "errors"
+
+// dropPrivileges is not supported on windows, configure the service account instead
+func dropPrivileges(username, chroot string) error {
+	if username == "" && chroot == "" {
+		return nil
+	}
+	return errors.New("-user and -chroot are not supported on windows")
+}
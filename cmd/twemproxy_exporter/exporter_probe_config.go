@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// probeConf of the pools, loaded from -probe.config
+var probeConf = ProbeConfig{}
+
+// ProbeConfig of the listen and backend probes by pool, for pools whose servers need a password or TLS
+type ProbeConfig struct {
+	Pools map[string]PoolProbeConfig `yaml:"pools"`
+
+	listenTLS  map[string]*tls.Config
+	backendTLS map[string]*tls.Config
+}
+
+// PoolProbeConfig of a pool, the redis_auth of the nutcracker config is used when RedisAuth is nil
+type PoolProbeConfig struct {
+	RedisAuth *config.Secret `yaml:"redis_auth"`
+	TLS       *TLSConfig     `yaml:"tls"`        // of the backend servers
+	ListenTLS *TLSConfig     `yaml:"listen_tls"` // of the listen address, when twemproxy is behind stunnel or envoy
+}
+
+// LoadProbeConfig from yaml, empty path means the probes only use the nutcracker config
+func LoadProbeConfig(path string) (ProbeConfig, error) {
+	conf := ProbeConfig{}
+	if path == "" {
+		return conf, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return conf, fmt.Errorf("Cannot open: %s. Error: %s", path, err.Error())
+	}
+	err = yaml.UnmarshalStrict(content, &conf)
+	if err != nil {
+		return conf, err
+	}
+	// certificates are loaded once, not on every probe
+	conf.listenTLS = make(map[string]*tls.Config)
+	conf.backendTLS = make(map[string]*tls.Config)
+	for name, pool := range conf.Pools {
+		if pool.TLS != nil {
+			conf.backendTLS[name], err = pool.TLS.Build()
+			if err != nil {
+				return conf, fmt.Errorf("Cannot build tls of pool %s. Error: %s", name, err.Error())
+			}
+		}
+		if pool.ListenTLS != nil {
+			conf.listenTLS[name], err = pool.ListenTLS.Build()
+			if err != nil {
+				return conf, fmt.Errorf("Cannot build listen_tls of pool %s. Error: %s", name, err.Error())
+			}
+		}
+	}
+	return conf, nil
+}
+
+// Password of the pool, the probe config one first then the redis_auth of the nutcracker config
+func (c ProbeConfig) Password(name string, pool config.Config) (string, error) {
+	if auth := c.Pools[name].RedisAuth; auth != nil {
+		return auth.Get()
+	}
+	if pool.RedisAuth != nil {
+		return pool.RedisAuth.Get()
+	}
+	return "", nil
+}
+
+// DialListen of the pool through probeDialer, wrapped in the listen_tls of the pool
+func (c ProbeConfig) DialListen(ctx context.Context, pool string, network string, address string) (net.Conn, error) {
+	return dialProbe(ctx, c.listenTLS[pool], network, address)
+}
+
+// DialBackend of the pool through probeDialer, wrapped in the tls of the pool
+func (c ProbeConfig) DialBackend(ctx context.Context, pool string, network string, address string) (net.Conn, error) {
+	return dialProbe(ctx, c.backendTLS[pool], network, address)
+}
+
+// dialProbe with probeDialer and wrap the connection in TLS when the pool has one
+func dialProbe(ctx context.Context, tlsConfig *tls.Config, network string, address string) (net.Conn, error) {
+	conn, err := probeDialer().DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		// unix socket path
+		host = address
+	}
+	return wrapTLS(conn, tlsConfig, host), nil
+}
+
+// wrap the connection in TLS when the pool has one, verifying the certificate against the dialed host by default
+func wrapTLS(conn net.Conn, tlsConfig *tls.Config, host string) net.Conn {
+	if tlsConfig == nil {
+		return conn
+	}
+	if tlsConfig.ServerName == "" && !tlsConfig.InsecureSkipVerify {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+	return tls.Client(conn, tlsConfig)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestLoadProbeConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "probe.yml")
+	err := ioutil.WriteFile(path, []byte("pools:\n  sessions:\n    redis_auth: override\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := LoadProbeConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, _ := conf.Password("sessions", config.Config{RedisAuth: config.NewSecret("nutcracker")})
+	if password != "override" {
+		t.Errorf("Expected the probe config password, got %q", password)
+	}
+	password, _ = conf.Password("other", config.Config{RedisAuth: config.NewSecret("nutcracker")})
+	if password != "nutcracker" {
+		t.Errorf("Expected the redis_auth of the pool, got %q", password)
+	}
+
+	err = ioutil.WriteFile(path, []byte("pools:\n  sessions:\n    tls:\n      enabled: true\n      ca_file: "+filepath.Join(dir, "missing.pem")+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadProbeConfig(path)
+	if err == nil {
+		t.Errorf("Expected an error for a missing CA file")
+	}
+	_, err = LoadProbeConfig(filepath.Join(dir, "missing.yml"))
+	if err == nil {
+		t.Errorf("Expected an error for a missing probe config")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+// leaderElector tell whether this replica is the one allowed to push
+type leaderElector interface {
+	IsLeader() bool
+}
+
+// alwaysLeader is used when there is a single replica
+type alwaysLeader struct{}
+
+func (alwaysLeader) IsLeader() bool { return true }
+
+// newLeaderElector from the -push.leader-election flags
+func newLeaderElector(kind string, lease string, lockFile string, duration time.Duration) (leaderElector, error) {
+	switch kind {
+	case "":
+		return alwaysLeader{}, nil
+	case "kubernetes":
+		p := strings.Split(lease, "/")
+		if len(p) != 2 {
+			return nil, fmt.Errorf("Invalid lease %s, expected namespace/name", lease)
+		}
+		return newLeaseElector(p[0], p[1], hostname, duration)
+	case "file":
+		if lockFile == "" {
+			return nil, errors.New("file leader election needs -push.lock-file")
+		}
+		return newFileLockElector(lockFile, duration/3)
+	}
+	return nil, fmt.Errorf("Unknown leader election %s, expected kubernetes or file", kind)
+}
+
+// pushMetrics to the Pushgateway every interval, only while this replica is the leader.
+// All replicas push to the same grouping key, so a new leader take over the same series
+func pushMetrics(url string, job string, interval time.Duration, elector leaderElector) {
+	pusher := push.New(url, job).Gatherer(prometheus.DefaultGatherer)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	wasLeader := false
+	for range ticker.C {
+		isLeader := elector.IsLeader()
+		if isLeader != wasLeader {
+			log.Printf("Push leadership changed, leader: %t", isLeader)
+			wasLeader = isLeader
+		}
+		if !isLeader {
+			continue
+		}
+		err := pusher.Push()
+		if err != nil {
+			log.Printf("Cannot push metrics to %s. Error: %s", url, err.Error())
+		}
+	}
+}
+
+// leaseElector elect the leader using a coordination.k8s.io Lease, same protocol as client-go
+type leaseElector struct {
+	client    *kubeClient
+	namespace string
+	name      string
+	identity  string
+	duration  time.Duration
+	leader    int32
+}
+
+type kubeLease struct {
+	APIVersion string         `json:"apiVersion"`
+	Kind       string         `json:"kind"`
+	Metadata   kubeObjectMeta `json:"metadata"`
+	Spec       kubeLeaseSpec  `json:"spec"`
+}
+
+type kubeLeaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// kubeMicroTime is the format of Lease timestamps
+const kubeMicroTime = "2006-01-02T15:04:05.000000Z07:00"
+
+func newLeaseElector(namespace, name, identity string, duration time.Duration) (*leaseElector, error) {
+	client, err := newInClusterClient()
+	if err != nil {
+		return nil, err
+	}
+	e := &leaseElector{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+		identity:  identity,
+		duration:  duration,
+	}
+	go e.run()
+	return e, nil
+}
+
+// IsLeader while we hold the lease
+func (e *leaseElector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1
+}
+
+// run try to acquire or renew the lease every third of its duration
+func (e *leaseElector) run() {
+	lastRenew := time.Time{}
+	for {
+		err := e.tryAcquireOrRenew()
+		if err == nil {
+			lastRenew = time.Now()
+		} else {
+			log.Printf("Cannot acquire lease %s/%s. Error: %s", e.namespace, e.name, err.Error())
+		}
+		// stop pushing once the lease could have been taken by another replica
+		if err != nil && time.Since(lastRenew) > e.duration {
+			atomic.StoreInt32(&e.leader, 0)
+		}
+		time.Sleep(e.duration / 3)
+	}
+}
+
+func (e *leaseElector) tryAcquireOrRenew() error {
+	path := fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases/%s", e.namespace, e.name)
+	now := time.Now().UTC()
+
+	lease := kubeLease{}
+	err := e.client.get(path, &lease)
+	if err != nil && err != ErrKubeNotFound {
+		return err
+	}
+	if err == ErrKubeNotFound {
+		// the first replica create the lease
+		lease = kubeLease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   kubeObjectMeta{Name: e.name, Namespace: e.namespace},
+			Spec: kubeLeaseSpec{
+				HolderIdentity:       e.identity,
+				LeaseDurationSeconds: int(e.duration.Seconds()),
+				AcquireTime:          now.Format(kubeMicroTime),
+				RenewTime:            now.Format(kubeMicroTime),
+			},
+		}
+		return e.write("POST", fmt.Sprintf("/apis/coordination.k8s.io/v1/namespaces/%s/leases", e.namespace), lease)
+	}
+
+	if lease.Spec.HolderIdentity != e.identity {
+		renewTime, _ := time.Parse(kubeMicroTime, lease.Spec.RenewTime)
+		expiry := renewTime.Add(time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second)
+		if lease.Spec.HolderIdentity != "" && now.Before(expiry) {
+			atomic.StoreInt32(&e.leader, 0)
+			return nil
+		}
+		// the previous leader is gone, take over
+		lease.Spec.HolderIdentity = e.identity
+		lease.Spec.AcquireTime = now.Format(kubeMicroTime)
+		lease.Spec.LeaseTransitions++
+	}
+	lease.Spec.LeaseDurationSeconds = int(e.duration.Seconds())
+	lease.Spec.RenewTime = now.Format(kubeMicroTime)
+	// resourceVersion in the metadata make the update fail if someone else won the race
+	return e.write("PUT", path, lease)
+}
+
+func (e *leaseElector) write(method string, path string, lease kubeLease) error {
+	body, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.do(method, path, body, time.Second*10)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusConflict {
+			atomic.StoreInt32(&e.leader, 0)
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	atomic.StoreInt32(&e.leader, 1)
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// status of the last config reload, named like the ones of prometheus itself
+var (
+	configReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: collector.Namespace,
+		Subsystem: "exporter",
+		Name:      "config_last_reload_successful",
+		Help:      "1 when the last reload of the nutcracker config succeeded",
+	})
+	configReloadTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: collector.Namespace,
+		Subsystem: "exporter",
+		Name:      "config_last_reload_timestamp_seconds",
+		Help:      "Timestamp of the last successful reload of the nutcracker config",
+	})
+	configHash = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: collector.Namespace,
+		Subsystem: "exporter",
+		Name:      "config_hash",
+		Help:      "Hash of the pools of the loaded nutcracker config, always 1",
+	}, []string{"hash"})
+)
+
+func init() {
+	prometheus.MustRegister(configReloadSuccessful, configReloadTimestamp, configHash)
+}
+
+// configReloaded record the result of a load of the config, the initial one included
+func configReloaded(conf map[string]config.Config, err error) {
+	if err != nil {
+		configReloadSuccessful.Set(0)
+		return
+	}
+	configReloadSuccessful.Set(1)
+	configReloadTimestamp.Set(float64(time.Now().UnixNano()) / 1e9)
+	configHash.Reset()
+	configHash.WithLabelValues(hashConfig(conf)).Set(1)
+	warnConfig(conf)
+}
+
+// hashConfig of the parsed pools, the same whatever the formatting, comments, anchors or split of the files.
+// The inline redis_auth passwords are not part of it, only the references to files and vault
+func hashConfig(conf map[string]config.Config) string {
+	// maps are encoded with sorted keys
+	content, err := json.Marshal(conf)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+// remoteConfigTimeout of fetching the config of one target
+const remoteConfigTimeout = time.Second * 30
+
+// remoteConfig fetch the nutcracker config each target is actually running from its host,
+// {host} in the url is replaced by the host of the target
+//
+//	http://{host}:8080/nutcracker.yml
+//	ssh://deploy@{host}/etc/nutcracker/nutcracker.yml
+type remoteConfig struct {
+	url    string
+	client *http.Client
+}
+
+func newRemoteConfig(template string) (*remoteConfig, error) {
+	u, err := url.Parse(strings.Replace(template, "{host}", "localhost", -1))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid remote config url %s. Error: %s", template, err.Error())
+	}
+	switch u.Scheme {
+	case "http", "https", "ssh":
+	default:
+		return nil, fmt.Errorf("Invalid remote config url %s, expected http, https or ssh", template)
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("Invalid remote config url %s, missing the config path", template)
+	}
+	return &remoteConfig{url: template, client: &http.Client{Timeout: remoteConfigTimeout}}, nil
+}
+
+// urlFor the target, IPv6 hosts are bracketed
+func (r *remoteConfig) urlFor(target string) string {
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return strings.Replace(r.url, "{host}", host, -1)
+}
+
+// fetch the config content of the target
+func (r *remoteConfig) fetch(ctx context.Context, target string) ([]byte, error) {
+	u, err := url.Parse(r.urlFor(target))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "ssh" {
+		return fetchSSH(ctx, u)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching %s returned %s", u.String(), resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// fetchSSH with the ssh client of the system, so ~/.ssh/config, agents and known hosts just work
+func fetchSSH(ctx context.Context, u *url.URL) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteConfigTimeout)
+	defer cancel()
+
+	args := []string{"-o", "BatchMode=yes"}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	args = append(args, host, "cat", u.Path)
+	content, err := exec.CommandContext(ctx, "ssh", args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("ssh %s cat %s failed: %s", host, u.Path, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+// load the config of the target, parsed like the local one
+func (r *remoteConfig) load(ctx context.Context, target string) (map[string]config.Config, error) {
+	content, err := r.fetch(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	return lenientConfig(config.ParseConfig(content))
+}
+
+// loadRemoteConfig of the monitor target, keeping its current config on failure.
+// The fetch is canceled when the monitor stops
+func (s *Scheduler) loadRemoteConfig(m *monitor.Monitor) {
+	conf, err := s.remoteConfig.load(m.Context(), m.Address())
+	if err != nil {
+		log.Printf("Cannot fetch the config of %s, keeping the current one. Error: %s", m.Address(), err.Error())
+		return
+	}
+	m.SetConfig(conf)
+	log.Printf("Config of %s fetched from %s", m.Address(), s.remoteConfig.urlFor(m.Address()))
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRemoteConfig(t *testing.T) {
+	content, err := ioutil.ReadFile("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/nutcracker.yml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	remote, err := newRemoteConfig("http://{host}:" + port + "/nutcracker.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf, err := remote.load(context.Background(), "127.0.0.1:22222")
+	if err != nil {
+		t.Fatal("Failed to fetch config: ", err.Error())
+	}
+	if _, ok := conf["wallet-oauth-token"]; !ok {
+		t.Errorf("Unexpected remote config %+v", conf)
+	}
+
+	if u := remote.urlFor("[2001:db8::1]:22222"); u != "http://[2001:db8::1]:"+port+"/nutcracker.yml" {
+		t.Errorf("Unexpected url for an IPv6 target %s", u)
+	}
+	for _, template := range []string{"ftp://{host}/nutcracker.yml", "ssh://{host}"} {
+		if _, err := newRemoteConfig(template); err == nil {
+			t.Errorf("Expected an error for %s", template)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+	"github.com/albert-widi/twemproxy_exporter/pkg/rate"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+// Scheduler run one monitor.Monitor per target, each on its own ticker
+type Scheduler struct {
+	interval  time.Duration
+	tlsConfig *tls.Config
+	conf      map[string]config.Config
+	// options of every monitor, the ones of the target are added by setTargets
+	options  []monitor.Option
+	monitors map[string]*monitor.Monitor
+	targets  map[string]Target // of the monitors, by address
+	shard    shard
+	// remoteConfig fetch the config of every target from its host, nil to use conf everywhere
+	remoteConfig *remoteConfig
+	archive      *archiver
+	// baselines loaded from the state file, by address, used once by the new monitors
+	baselines map[string]monitor.Baseline
+	sources   map[string][]Target
+	observers []func(ScrapeResult)
+	mu        sync.Mutex
+}
+
+// ScrapeResult of one target, passed to the scheduler observers after every scrape
+type ScrapeResult struct {
+	Target Target
+	Stats  stats.TwemproxyStats
+	Rates  map[string]rate.PoolRates // nil until the target has been scraped successfully twice
+	Err    error
+	Time   time.Time
+}
+
+var (
+	targetsConfiguredDesc = prometheus.NewDesc(prometheus.BuildFQName(collector.Namespace, "exporter", "targets_configured"),
+		"Targets monitored by this exporter", nil, nil)
+	targetsUpDesc = prometheus.NewDesc(prometheus.BuildFQName(collector.Namespace, "exporter", "targets_up"),
+		"Targets whose last scrape succeeded", nil, nil)
+	targetInfoDesc = prometheus.NewDesc(prometheus.BuildFQName(collector.Namespace, "exporter", "target_info"),
+		"Monitored target and the source it comes from", []string{"target", "source"}, nil)
+)
+
+// NewScheduler without any target, use SetTargets to start monitoring. opts are applied to every monitor
+func NewScheduler(conf map[string]config.Config, interval time.Duration, tlsConfig *tls.Config, opts ...monitor.Option) *Scheduler {
+	return &Scheduler{
+		interval:  interval,
+		tlsConfig: tlsConfig,
+		conf:      conf,
+		options:   opts,
+		monitors:  make(map[string]*monitor.Monitor),
+		targets:   make(map[string]Target),
+		sources:   make(map[string][]Target),
+	}
+}
+
+// SetTargets start monitoring the new targets and stop the ones not in the list anymore
+func (s *Scheduler) SetTargets(targets []Target) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setTargets(targets)
+}
+
+// SetSource replace the targets found by a source, e.g. the static list or a discovery.
+// The targets of every source are merged and the ones not owned by the shard are dropped
+func (s *Scheduler) SetSource(name string, targets []Target) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sources[name] = targets
+
+	names := make([]string, 0, len(s.sources))
+	for name := range s.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	// the same address found by two sources is monitored once, the first source by name win
+	seen := make(map[string]bool)
+	var merged []Target
+	for _, name := range names {
+		for _, t := range s.sources[name] {
+			if seen[t.Address] {
+				continue
+			}
+			seen[t.Address] = true
+			if t.source == "" {
+				t.source = name
+			}
+			merged = append(merged, t)
+		}
+	}
+	s.setTargets(s.shard.filter(merged))
+}
+
+// Observe every scrape result, must be called before the targets are set
+func (s *Scheduler) Observe(fn func(ScrapeResult)) {
+	s.observers = append(s.observers, fn)
+}
+
+func (s *Scheduler) notify(result ScrapeResult) {
+	for _, fn := range s.observers {
+		fn(result)
+	}
+}
+
+// PollSource refresh the targets of the source every interval in the background.
+// When discovery fails the targets found last time are kept
+func (s *Scheduler) PollSource(name string, interval time.Duration, discover func() ([]Target, error)) {
+	go func() {
+		for {
+			targets, err := discover()
+			if err != nil {
+				log.Printf("Cannot discover %s targets. Error: %s", name, err.Error())
+			} else {
+				s.SetSource(name, targets)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+func (s *Scheduler) setTargets(targets []Target) {
+	wanted := make(map[string]bool)
+	for _, t := range targets {
+		wanted[t.Address] = true
+		if existing, ok := s.monitors[t.Address]; ok {
+			if reflect.DeepEqual(s.targets[t.Address], t) {
+				continue
+			}
+			// labels changed, the series must be recreated
+			existing.Stop()
+		}
+		tlsConfig := s.tlsConfig
+		var err error
+		if t.TLS != nil {
+			tlsConfig, err = t.TLS.Build()
+			if err != nil {
+				log.Printf("Cannot create monitor for %s. Error: %s", t.Address, err.Error())
+				continue
+			}
+		}
+		source, err := newStatsSource(t.Address, tlsConfig)
+		if err != nil {
+			log.Printf("Cannot create monitor for %s. Error: %s", t.Address, err.Error())
+			continue
+		}
+		interval := s.interval
+		if t.Interval > 0 {
+			interval = t.Interval
+		}
+		target := t
+		opts := []monitor.Option{
+			monitor.WithConfig(s.conf),
+			monitor.WithHost(t.Address),
+			monitor.WithInstance(t.instanceLabel()),
+			monitor.WithTLS(tlsConfig),
+			monitor.WithSource(source),
+			monitor.WithTimeout(t.Timeout),
+			monitor.WithLabels(t.metricLabels()),
+			monitor.WithPools(t.Pools),
+			monitor.WithServerLabels(serverLabels),
+			monitor.WithProber(probeConf),
+			monitor.WithHooks(s.hooks()),
+			monitor.WithObserver(func(result monitor.Result) {
+				s.notify(ScrapeResult{Target: target, Stats: result.Stats, Rates: result.Rates, Err: result.Err, Time: result.Time})
+			}),
+		}
+		opts = append(opts, s.options...)
+		if b, ok := s.baselines[t.Address]; ok {
+			opts = append(opts, monitor.WithBaseline(b))
+			delete(s.baselines, t.Address)
+		}
+		m, err := monitor.New(opts...)
+		if err != nil {
+			log.Printf("Cannot create monitor for %s. Error: %s", t.Address, err.Error())
+			continue
+		}
+		m.Start(interval)
+		if s.remoteConfig != nil {
+			// scraped with conf until fetched
+			go s.loadRemoteConfig(m)
+		}
+		s.monitors[t.Address] = m
+		s.targets[t.Address] = t
+		log.Printf("Started monitoring %s", t.Address)
+	}
+
+	for address, m := range s.monitors {
+		if wanted[address] {
+			continue
+		}
+		m.Stop()
+		delete(s.monitors, address)
+		delete(s.targets, address)
+		log.Printf("Stopped monitoring %s", address)
+	}
+}
+
+// SetConfig replace the config of every monitor
+func (s *Scheduler) SetConfig(conf map[string]config.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conf = conf
+	for _, m := range s.monitors {
+		if s.remoteConfig != nil {
+			go s.loadRemoteConfig(m)
+			continue
+		}
+		m.SetConfig(conf)
+	}
+}
+
+// Monitors sorted by target address
+func (s *Scheduler) Monitors() []*monitor.Monitor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	monitors := make([]*monitor.Monitor, 0, len(s.monitors))
+	for _, m := range s.monitors {
+		monitors = append(monitors, m)
+	}
+	sort.Slice(monitors, func(i, j int) bool {
+		return monitors[i].Address() < monitors[j].Address()
+	})
+	return monitors
+}
+
+// Healthy is true when every monitor loop has ticked recently, a wedged loop make it false
+func (s *Scheduler) Healthy() bool {
+	for _, m := range s.Monitors() {
+		if !m.Healthy() {
+			return false
+		}
+	}
+	return true
+}
+
+// target of the monitor of address, the zero target when it is not monitored
+func (s *Scheduler) target(address string) Target {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.targets[address]
+}
+
+// hooks of the monitors into the tracing, the archive and the error counters of the exporter
+func (s *Scheduler) hooks() monitor.Hooks {
+	return monitor.Hooks{
+		Trace:              tracing.trace,
+		Scraped:            s.archive.add,
+		ParseFailed:        parseFailed,
+		ScrapeFailed:       scrapeFailed,
+		BackendProbeFailed: backendProbeFailed,
+	}
+}
+
+// Unscraped return the targets which were never scraped successfully,
+// targets not monitored by the scheduler are unscraped as well
+func (s *Scheduler) Unscraped(targets []Target) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var unscraped []string
+	for _, t := range targets {
+		m, ok := s.monitors[t.Address]
+		if !ok || m.LastSuccess().IsZero() {
+			unscraped = append(unscraped, t.Address)
+		}
+	}
+	return unscraped
+}
+
+// Describe nothing, the label names of each target can differ so the scheduler is an unchecked collector
+func (s *Scheduler) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect the metrics of every target and the targets summary
+func (s *Scheduler) Collect(ch chan<- prometheus.Metric) {
+	monitors := s.Monitors()
+	up := 0
+	for _, m := range monitors {
+		m.Collect(ch)
+		if _, ok := m.LastStats(); ok {
+			up++
+		}
+		source := s.target(m.Address()).source
+		if source == "" {
+			source = "static"
+		}
+		ch <- prometheus.MustNewConstMetric(targetInfoDesc, prometheus.GaugeValue, 1, m.Address(), source)
+	}
+	ch <- prometheus.MustNewConstMetric(targetsConfiguredDesc, prometheus.GaugeValue, float64(len(monitors)))
+	ch <- prometheus.MustNewConstMetric(targetsUpDesc, prometheus.GaugeValue, float64(up))
+}
+
+// Stop every monitor
+func (s *Scheduler) Stop() {
+	s.SetTargets(nil)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestSchedulerTargetLabels(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	plain := startMockServer(t)
+	defer plain.Close()
+	labeled := startMockServer(t)
+	defer labeled.Close()
+
+	scheduler := NewScheduler(conf, time.Hour, nil)
+	scheduler.SetTargets([]Target{
+		{Address: plain.Addr()},
+		{Address: labeled.Addr(), Labels: map[string]string{"job": "cache"}},
+	})
+	defer scheduler.Stop()
+	for _, m := range scheduler.Monitors() {
+		err := m.Run(context.Background())
+		if err != nil {
+			t.Fatal("Failed to run monitor: ", err.Error())
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(scheduler)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal("Failed to gather: ", err.Error())
+	}
+	for _, family := range families {
+		if family.GetName() != "twemproxy_service_total_connections" {
+			continue
+		}
+		if len(family.GetMetric()) != 2 {
+			t.Fatalf("Expected one series per target, got %d", len(family.GetMetric()))
+		}
+		jobs := 0
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "job" && label.GetValue() == "cache" {
+					jobs++
+				}
+			}
+		}
+		if jobs != 1 {
+			t.Errorf("Expected the job label on the labeled target only, got %d", jobs)
+		}
+		return
+	}
+	t.Error("twemproxy_service_total_connections not gathered")
+}
+
+func TestSchedulerTargetsSummary(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	mock := startMockServer(t)
+	defer mock.Close()
+
+	scheduler := NewScheduler(conf, time.Hour, nil)
+	scheduler.SetSource("static", []Target{{Address: mock.Addr()}})
+	scheduler.SetSource("docker", []Target{{Address: "127.0.0.1:1"}})
+	defer scheduler.Stop()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(scheduler)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal("Failed to gather: ", err.Error())
+	}
+	sources := make(map[string]string)
+	for _, family := range families {
+		switch family.GetName() {
+		case "twemproxy_exporter_targets_configured":
+			if family.GetMetric()[0].GetGauge().GetValue() != 2 {
+				t.Errorf("Expected 2 targets configured, got %f", family.GetMetric()[0].GetGauge().GetValue())
+			}
+		case "twemproxy_exporter_target_info":
+			for _, metric := range family.GetMetric() {
+				labels := make(map[string]string)
+				for _, label := range metric.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				sources[labels["target"]] = labels["source"]
+			}
+		}
+	}
+	if sources[mock.Addr()] != "static" || sources["127.0.0.1:1"] != "docker" {
+		t.Errorf("Unexpected target sources %v", sources)
+	}
+}
+
+func TestSchedulerFileTarget(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	scheduler := NewScheduler(conf, time.Hour, nil)
+	scheduler.SetTargets([]Target{{Address: "file://../../files/example.json"}, {Address: "udp://localhost:22222"}})
+	defer scheduler.Stop()
+	monitors := scheduler.Monitors()
+	if len(monitors) != 1 {
+		t.Fatalf("Expected only the file target, the unknown scheme is refused, got %d monitors", len(monitors))
+	}
+	err = monitors[0].Run(context.Background())
+	if err != nil {
+		t.Fatal("Failed to run monitor: ", err.Error())
+	}
+	if value := testutil.GatherValue(t, scheduler, "twemproxy_service_total_connections"); value != 64592 {
+		t.Errorf("Expected the total connections of the payload, got %f", value)
+	}
+}
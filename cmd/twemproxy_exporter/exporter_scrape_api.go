@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+// scrapeSummary is the response of a forced scrape
+type scrapeSummary struct {
+	Target            string                 `json:"target"`
+	Instance          string                 `json:"instance"`
+	Success           bool                   `json:"success"`
+	Error             string                 `json:"error,omitempty"`
+	DurationSeconds   float64                `json:"duration_seconds"`
+	ExpectedAvailable int                    `json:"expected_available"`
+	NotAvailable      int                    `json:"not_available"`
+	Pools             map[string]poolSummary `json:"pools,omitempty"`
+}
+
+type poolSummary struct {
+	Servers      int `json:"servers"`
+	NotAvailable int `json:"not_available"`
+	Missing      int `json:"missing"`
+}
+
+// scrapeLimiter allow one forced scrape per target every interval
+type scrapeLimiter struct {
+	interval time.Duration
+	last     map[string]time.Time
+	mu       sync.Mutex
+}
+
+// allow return the time to wait before the next forced scrape of the target, 0 when it is allowed now
+func (l *scrapeLimiter) allow(target string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if wait := l.last[target].Add(l.interval).Sub(now); wait > 0 {
+		return wait
+	}
+	l.last[target] = now
+	return 0
+}
+
+// scrapeHandler force a scrape of a target with POST /-/scrape?target=<address, instance or name>, the target can be
+// omitted when there is only one. The scrape is observed like the scheduled ones, which keep their interval.
+// Only served when the web config authenticate the requests
+func scrapeHandler(scheduler *Scheduler, authenticated bool, interval time.Duration) http.Handler {
+	limiter := &scrapeLimiter{interval: interval, last: make(map[string]time.Time)}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authenticated {
+			http.Error(w, "Forced scrapes need basic_auth_users or required client certificates in the web config", http.StatusForbidden)
+			return
+		}
+		name := r.URL.Query().Get("target")
+		monitors := scheduler.Monitors()
+		var m *monitor.Monitor
+		for _, candidate := range monitors {
+			t := scheduler.target(candidate.Address())
+			if name == t.Address || name == t.instanceLabel() || (t.Name != "" && name == t.Name) || (name == "" && len(monitors) == 1) {
+				m = candidate
+				break
+			}
+		}
+		if m == nil {
+			if name == "" {
+				http.Error(w, "target is required when monitoring more than one target", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, fmt.Sprintf("No target %s", name), http.StatusNotFound)
+			return
+		}
+		if wait := limiter.allow(m.Address(), time.Now()); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, fmt.Sprintf("%s was scraped less than %s ago", m.Address(), interval), http.StatusTooManyRequests)
+			return
+		}
+
+		start := time.Now()
+		result := m.Scrape(r.Context())
+		summary := scrapeSummary{
+			Target:          m.Address(),
+			Instance:        m.Instance(),
+			Success:         result.Err == nil,
+			DurationSeconds: time.Since(start).Seconds(),
+		}
+		if result.Err != nil {
+			summary.Error = result.Err.Error()
+		} else {
+			summary.ExpectedAvailable, summary.NotAvailable = result.Stats.ExpectedAvailable, result.Stats.NotAvailable
+			summary.Pools = make(map[string]poolSummary, len(result.Stats.Services))
+			for poolName, pool := range result.Stats.Services {
+				summary.Pools[poolName] = poolSummary{Servers: pool.ExpectedAvailable, NotAvailable: pool.NotAvailable, Missing: pool.Missing}
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if result.Err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		json.NewEncoder(w).Encode(summary)
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestScrapeHandler(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	mock := startMockServer(t)
+	defer mock.Close()
+
+	scheduler := NewScheduler(conf, time.Hour, nil)
+	scheduler.SetTargets([]Target{{Address: mock.Addr()}})
+	defer scheduler.Stop()
+	observed := make(chan ScrapeResult, 10)
+	scheduler.Observe(func(result ScrapeResult) {
+		observed <- result
+	})
+	handler := scrapeHandler(scheduler, true, time.Minute)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/-/scrape?target="+mock.Addr(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", rec.Code, rec.Body.String())
+	}
+	summary := scrapeSummary{}
+	json.NewDecoder(rec.Body).Decode(&summary)
+	if !summary.Success || summary.Target != mock.Addr() || len(summary.Pools) == 0 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	select {
+	case <-observed:
+	case <-time.After(time.Second * 5):
+		t.Error("Expected the forced scrape to be observed")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/-/scrape", nil))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/-/scrape?target=unknown:22222", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/-/scrape", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	scrapeHandler(scheduler, false, time.Minute).ServeHTTP(rec, httptest.NewRequest("POST", "/-/scrape", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without authentication, got %d", rec.Code)
+	}
+}
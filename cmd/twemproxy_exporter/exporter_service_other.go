@@ -0,0 +1,22 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// shutdownSignals that stop the exporter
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// startService is a noop outside of windows, the returned channel is never closed
+func startService() (<-chan struct{}, func(), error) {
+	return nil, func() {}, nil
+}
+
+func runService(args []string) error {
+	return errors.New("service command is only available on windows, use systemd instead")
+}
@@ -0,0 +1,138 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName registered in the Windows service manager
+const serviceName = "twemproxy_exporter"
+
+// shutdownSignals that stop the exporter, windows only deliver interrupt
+var shutdownSignals = []os.Signal{os.Interrupt}
+
+// serviceHandler bridge the Windows service manager with the exporter main loop
+type serviceHandler struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Execute is called by the service manager, it return when the exporter has exited
+func (h *serviceHandler) Execute(args []string, r <-chan svc.ChangeRequest, s chan<- svc.Status) (bool, uint32) {
+	s <- svc.Status{State: svc.StartPending}
+	s <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				s <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				s <- svc.Status{State: svc.StopPending}
+				close(h.stop)
+				<-h.done
+				return false, 0
+			}
+		case <-h.done:
+			// the exporter stopped by itself, e.g. failed to serve
+			return false, 1
+		}
+	}
+}
+
+// startService hand the control to the service manager when running as a Windows service.
+// The returned channel is closed when the service manager ask us to stop,
+// the returned func must be called once the exporter has exited
+func startService() (<-chan struct{}, func(), error) {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return nil, func() {}, err
+	}
+	if !isService {
+		return nil, func() {}, nil
+	}
+
+	h := &serviceHandler{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	exited := make(chan struct{})
+	go func() {
+		err := svc.Run(serviceName, h)
+		if err != nil {
+			log.Println("Windows service failed. Error: ", err.Error())
+		}
+		close(exited)
+	}()
+
+	finish := func() {
+		close(h.done)
+		<-exited
+	}
+	return h.stop, finish, nil
+}
+
+// runService register and control the exporter in the Windows service manager
+//
+//	twemproxy_exporter service install -config=C:\twemproxy\nutcracker.yml
+//	twemproxy_exporter service start|stop|uninstall
+func runService(args []string) error {
+	if len(args) < 1 {
+		return errors.New("usage: service install|uninstall|start|stop")
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	if args[0] == "install" {
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		// the rest of the args are passed to the exporter when the service start
+		s, err := m.CreateService(serviceName, exe, mgr.Config{
+			DisplayName: "Twemproxy Exporter",
+			Description: "Prometheus exporter for twemproxy stats",
+			StartType:   mgr.StartAutomatic,
+		}, args[1:]...)
+		if err != nil {
+			return err
+		}
+		s.Close()
+		log.Printf("Service %s installed", serviceName)
+		return nil
+	}
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("Cannot open service %s. Error: %s", serviceName, err.Error())
+	}
+	defer s.Close()
+
+	switch args[0] {
+	case "uninstall":
+		err = s.Delete()
+	case "start":
+		err = s.Start()
+	case "stop":
+		_, err = s.Control(svc.Stop)
+	default:
+		return fmt.Errorf("Unknown service command %s", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Service %s %s done", serviceName, args[0])
+	return nil
+}
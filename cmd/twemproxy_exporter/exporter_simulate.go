@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/hashkit"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// simulation of the keys of a pool, before and after adding servers
+type simulation struct {
+	Pool   config.Config
+	Added  []config.Server
+	Keys   int
+	Before []int // keys by server of the pool
+	After  []int // keys by server of the pool followed by the added ones, nil without added servers
+	Moved  int   // keys on another server after adding the servers
+}
+
+// simulate the distribution of the keys, each passed to the function given to keys
+func simulate(pool config.Config, added []config.Server, keys func(fn func(key []byte)) error) (simulation, error) {
+	s := simulation{Pool: pool, Added: added, Before: make([]int, len(pool.Servers))}
+	before, err := hashkit.NewPool(pool)
+	if err != nil {
+		return s, err
+	}
+	var after *hashkit.Pool
+	if len(added) > 0 {
+		grown := pool
+		grown.Servers = append(append([]config.Server{}, pool.Servers...), added...)
+		after, err = hashkit.NewPool(grown)
+		if err != nil {
+			return s, err
+		}
+		s.After = make([]int, len(grown.Servers))
+	}
+	err = keys(func(key []byte) {
+		s.Keys++
+		b := before.Server(key)
+		s.Before[b]++
+		if after != nil {
+			a := after.Server(key)
+			s.After[a]++
+			if a != b {
+				s.Moved++
+			}
+		}
+	})
+	return s, err
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "0.00%"
+	}
+	return fmt.Sprintf("%.2f%%", float64(n)*100/float64(total))
+}
+
+// writeSimulation as a table of the servers with their share of the keys and of the weights
+func writeSimulation(w io.Writer, s simulation) error {
+	hashTag := ""
+	if s.Pool.HashTag != "" {
+		hashTag = fmt.Sprintf(", hash_tag %q", s.Pool.HashTag)
+	}
+	fmt.Fprintf(w, "Pool %s: %s distribution, %s hash%s, %d keys\n\n", s.Pool.ConfigName, s.Pool.Distribution, s.Pool.Hash, hashTag, s.Keys)
+	servers := append(append([]config.Server{}, s.Pool.Servers...), s.Added...)
+	weights := 0
+	for _, server := range servers[:len(s.Pool.Servers)] {
+		weights += server.Weight
+	}
+	grownWeights := weights
+	for _, server := range s.Added {
+		grownWeights += server.Weight
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if s.After == nil {
+		fmt.Fprintln(tw, "SERVER\tWEIGHT\tKEYS\tSHARE\tWEIGHT SHARE")
+	} else {
+		fmt.Fprintln(tw, "SERVER\tWEIGHT\tKEYS\tSHARE\tKEYS AFTER\tSHARE AFTER\tWEIGHT SHARE AFTER")
+	}
+	for i, server := range servers {
+		if s.After == nil {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", server.Name(), server.Weight, s.Before[i], percent(s.Before[i], s.Keys), percent(server.Weight, weights))
+			continue
+		}
+		keys, share := "-", "-"
+		if i < len(s.Before) {
+			keys, share = fmt.Sprint(s.Before[i]), percent(s.Before[i], s.Keys)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%d\t%s\t%s\n", server.Name(), server.Weight, keys, share, s.After[i], percent(s.After[i], s.Keys), percent(server.Weight, grownWeights))
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	if s.After != nil {
+		fmt.Fprintf(w, "\nKeys moved: %d (%s)\n", s.Moved, percent(s.Moved, s.Keys))
+	}
+	return nil
+}
+
+// runSimulate print the expected share of keys of every server of a pool, from a file of keys or generated ones.
+// With -add the servers are added to the pool and the keys moving to another server are counted
+func runSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	confPath := fs.String("config", "", "nutcracker config of the pool")
+	poolName := fs.String("pool", "", "pool to simulate, required when the config has more than one")
+	keysPath := fs.String("keys", "", "file of sample keys, one per line, - for stdin, keys are generated when empty")
+	generate := fs.Int("generate", 100000, "keys to generate without -keys")
+	keyFormat := fs.String("key-format", "key:%d", "format of the generated keys, with the key number")
+	hash := fs.String("hash", "", "hash instead of the one of the pool")
+	distribution := fs.String("distribution", "", "distribution instead of the one of the pool")
+	hashTag := fs.String("hash-tag", "", "hash_tag instead of the one of the pool")
+	add := fs.String("add", "", "comma separated servers to add, host:port:weight [alias] like in the config")
+	fs.Parse(args)
+
+	conf, err := config.LoadConfig(*confPath)
+	if err != nil {
+		return err
+	}
+	if *poolName == "" && len(conf) == 1 {
+		for name := range conf {
+			*poolName = name
+		}
+	}
+	pool, ok := conf[*poolName]
+	if !ok {
+		return fmt.Errorf("No pool %q in %s, set -pool", *poolName, *confPath)
+	}
+	if *hash != "" {
+		pool.Hash = *hash
+	}
+	if *distribution != "" {
+		pool.Distribution = *distribution
+	}
+	if *hashTag != "" {
+		pool.HashTag = *hashTag
+	}
+	var added []config.Server
+	for _, entry := range strings.Split(*add, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		server, err := config.ParseServer(entry)
+		if err != nil {
+			return err
+		}
+		added = append(added, server)
+	}
+
+	keys := func(fn func(key []byte)) error {
+		for i := 0; i < *generate; i++ {
+			fn([]byte(fmt.Sprintf(*keyFormat, i)))
+		}
+		return nil
+	}
+	if *keysPath != "" {
+		keys = func(fn func(key []byte)) error {
+			r := os.Stdin
+			if *keysPath != "-" {
+				f, err := os.Open(*keysPath)
+				if err != nil {
+					return fmt.Errorf("Cannot open: %s. Error: %s", *keysPath, err.Error())
+				}
+				defer f.Close()
+				r = f
+			}
+			scanner := bufio.NewScanner(r)
+			scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+			for scanner.Scan() {
+				if len(scanner.Bytes()) > 0 {
+					fn(scanner.Bytes())
+				}
+			}
+			return scanner.Err()
+		}
+	}
+	s, err := simulate(pool, added, keys)
+	if err != nil {
+		return err
+	}
+	return writeSimulation(os.Stdout, s)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestSimulate(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	pool := conf["wallet-oauth-token"]
+	added, _ := config.ParseServer("10.0.0.99:6379:1 new-1")
+	keys := func(fn func(key []byte)) error {
+		for i := 0; i < 10000; i++ {
+			fn([]byte(fmt.Sprintf("token:%d", i)))
+		}
+		return nil
+	}
+	s, err := simulate(pool, []config.Server{added}, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Keys != 10000 || len(s.Before) != len(pool.Servers) || len(s.After) != len(pool.Servers)+1 {
+		t.Fatalf("Unexpected simulation %+v", s)
+	}
+	if s.Moved != s.After[len(s.After)-1] {
+		t.Errorf("Expected the moved keys to all go to the added server, moved %d, added server %d", s.Moved, s.After[len(s.After)-1])
+	}
+
+	buf := &bytes.Buffer{}
+	err = writeSimulation(buf, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "new-1") || !strings.Contains(buf.String(), "Keys moved: ") {
+		t.Errorf("Unexpected output %s", buf.String())
+	}
+}
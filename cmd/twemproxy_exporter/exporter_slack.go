@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// SlackConfig to post backend events to a Slack incoming webhook
+//
+//	slack:
+//	  webhook_url: {file: /etc/twemproxy_exporter/slack-webhook}
+//	  channel: "#cache-alerts"
+//	  max_messages: 10
+//	  per: 10m
+type SlackConfig struct {
+	WebhookURL  *config.Secret `yaml:"webhook_url"`
+	Channel     string         `yaml:"channel"`
+	MaxMessages int            `yaml:"max_messages"`
+	Per         time.Duration  `yaml:"per"`
+}
+
+// slackNotifier post a message per alert event, at most MaxMessages every Per.
+// Messages over the limit are dropped and counted in the next posted message
+type slackNotifier struct {
+	conf   SlackConfig
+	client *http.Client
+
+	sent       []time.Time
+	suppressed int
+	mu         sync.Mutex
+}
+
+func newSlackNotifier(conf SlackConfig) *slackNotifier {
+	if conf.MaxMessages == 0 {
+		conf.MaxMessages = 10
+	}
+	if conf.Per == 0 {
+		conf.Per = time.Minute * 10
+	}
+	return &slackNotifier{conf: conf, client: &http.Client{Timeout: time.Second * 10}}
+}
+
+// slackText describe the event, the backend and for how long it was down
+func slackText(event alertEvent) string {
+	backend := event.Pool + "/" + event.Server + " on " + event.Target
+	if event.Server == "" {
+		backend = event.Pool + " on " + event.Target
+	}
+	if event.Pool == "" {
+		backend = event.Target
+	}
+
+	if event.Status == alertResolved {
+		return fmt.Sprintf(":large_green_circle: %s recovered from %s after %s", backend, event.Rule, event.EndsAt.Sub(event.StartsAt).Round(time.Second))
+	}
+	switch event.Condition {
+	case conditionServerEjected:
+		return fmt.Sprintf(":warning: %s was ejected at %s", backend, time.Unix(0, int64(event.Value)*int64(time.Microsecond)).UTC().Format(time.RFC3339))
+	case conditionServerUnavailable:
+		return fmt.Sprintf(":red_circle: %s is unavailable since %s", backend, event.StartsAt.UTC().Format(time.RFC3339))
+	case conditionPoolUnavailable:
+		return fmt.Sprintf(":red_circle: %s has %.0f%% of its servers unavailable since %s", backend, event.Value*100, event.StartsAt.UTC().Format(time.RFC3339))
+	}
+	return fmt.Sprintf(":red_circle: %s is firing %s since %s", backend, event.Rule, event.StartsAt.UTC().Format(time.RFC3339))
+}
+
+// allow is false when the rate limit is reached
+func (s *slackNotifier) allow(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recent := s.sent[:0]
+	for _, t := range s.sent {
+		if now.Sub(t) < s.conf.Per {
+			recent = append(recent, t)
+		}
+	}
+	s.sent = recent
+	if len(s.sent) >= s.conf.MaxMessages {
+		s.suppressed++
+		return false
+	}
+	s.sent = append(s.sent, now)
+	return true
+}
+
+// Notify Slack, rate limited events are silently dropped
+func (s *slackNotifier) Notify(ctx context.Context, event alertEvent) error {
+	// an ejection is a one shot event, its resolution only mean the next scrape happened
+	if event.Condition == conditionServerEjected && event.Status == alertResolved {
+		return nil
+	}
+	if !s.allow(time.Now()) {
+		return nil
+	}
+	text := slackText(event)
+	s.mu.Lock()
+	if s.suppressed > 0 {
+		text += fmt.Sprintf("\n_%d notifications were suppressed by the rate limit_", s.suppressed)
+		s.suppressed = 0
+	}
+	s.mu.Unlock()
+
+	url, err := s.conf.WebhookURL.Get()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(map[string]string{"text": text, "channel": s.conf.Channel})
+	if err != nil {
+		return err
+	}
+	resp, err := postJSON(ctx, s.client, url, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Slack returned %s", resp.Status)
+	}
+	return nil
+}
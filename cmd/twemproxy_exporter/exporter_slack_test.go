@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestSlackNotifierRateLimit(t *testing.T) {
+	var texts []string
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		mu.Lock()
+		texts = append(texts, payload["text"])
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	s := newSlackNotifier(SlackConfig{WebhookURL: config.NewSecret(server.URL), MaxMessages: 1, Per: time.Hour})
+	start := time.Now().Add(-time.Minute * 2)
+	event := alertEvent{Status: alertFiring, Rule: "backend_down", Condition: conditionServerUnavailable,
+		Target: "proxy:22222", Pool: "sessions", Server: "redis-1", StartsAt: start}
+	for i := 0; i < 3; i++ {
+		err := s.Notify(context.Background(), event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(texts) != 1 || !strings.Contains(texts[0], "sessions/redis-1 on proxy:22222 is unavailable") {
+		t.Fatalf("Expected a single message, got %v", texts)
+	}
+
+	// the next message after the limit tell how many were dropped
+	s.sent = nil
+	event.Status = alertResolved
+	event.EndsAt = start.Add(time.Minute * 2)
+	err := s.Notify(context.Background(), event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(texts) != 2 || !strings.Contains(texts[1], "after 2m0s") || !strings.Contains(texts[1], "2 notifications were suppressed") {
+		t.Errorf("Unexpected resolved message %v", texts)
+	}
+}
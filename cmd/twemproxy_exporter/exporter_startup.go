@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+	"github.com/albert-widi/twemproxy_exporter/pkg/twemproxy"
+)
+
+// startupScrape every target once, dial and parse like a monitor, the failures of all the targets are returned together
+func startupScrape(ctx context.Context, targets []Target, conf map[string]config.Config, tlsConfig *tls.Config) error {
+	var failed []string
+	for _, t := range targets {
+		err := scrapeOnce(ctx, t, conf, tlsConfig)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", t.Address, err.Error()))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func scrapeOnce(ctx context.Context, t Target, conf map[string]config.Config, tlsConfig *tls.Config) error {
+	var err error
+	if t.TLS != nil {
+		tlsConfig, err = t.TLS.Build()
+		if err != nil {
+			return err
+		}
+	}
+	source, err := newStatsSource(t.Address, tlsConfig)
+	if err != nil {
+		return err
+	}
+	client := &twemproxy.Client{Source: source, Timeout: t.Timeout}
+	payload, err := client.FetchRaw(ctx)
+	if err != nil {
+		return err
+	}
+	st, err := stats.Parse(payload, conf)
+	if err != nil {
+		return err
+	}
+	// a config of another twemproxy parse fine but export nothing
+	if len(conf) > 0 && len(st.Services) == 0 {
+		return errors.New("no pool of the config in the stats")
+	}
+	return nil
+}
+
+// requireScrape of every target at startup, retried until the grace period is over
+func requireScrape(ctx context.Context, targets []Target, conf map[string]config.Config, tlsConfig *tls.Config, grace time.Duration) error {
+	deadline := time.Now().Add(grace)
+	for {
+		err := startupScrape(ctx, targets, conf, tlsConfig)
+		if err == nil || !time.Now().Before(deadline) {
+			return err
+		}
+		log.Printf("Startup scrape failed, retrying until %s. Error: %s", deadline.Format(time.RFC3339), err.Error())
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// exitUnscraped when a target was never scraped successfully once the grace period is over
+func exitUnscraped(scheduler *Scheduler, targets []Target, grace time.Duration) {
+	time.Sleep(grace)
+	unscraped := scheduler.Unscraped(targets)
+	if len(unscraped) > 0 {
+		log.Fatalf("Targets never scraped within -startup.grace %s: %s", grace, strings.Join(unscraped, ", "))
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestStartupScrape(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	mock := startMockServer(t)
+	defer mock.Close()
+	// a listener closed right away, nothing answer on its address
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := l.Addr().String()
+	l.Close()
+
+	ctx := context.Background()
+	err = startupScrape(ctx, []Target{{Address: mock.Addr()}}, conf, nil)
+	if err != nil {
+		t.Fatalf("Expected the startup scrape to succeed, got %s", err.Error())
+	}
+	err = startupScrape(ctx, []Target{{Address: mock.Addr()}}, map[string]config.Config{"other": {}}, nil)
+	if err == nil || !strings.Contains(err.Error(), "no pool of the config") {
+		t.Fatalf("Expected the config of another twemproxy to fail, got %v", err)
+	}
+
+	start := time.Now()
+	err = requireScrape(ctx, []Target{{Address: mock.Addr()}, {Address: down}}, conf, nil, time.Second*2)
+	if err == nil || !strings.Contains(err.Error(), down) || strings.Contains(err.Error(), mock.Addr()) {
+		t.Fatalf("Expected only %s to fail, got %v", down, err)
+	}
+	if time.Since(start) < time.Second*2 {
+		t.Errorf("Expected the startup scrape to be retried for the grace period")
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdListenFdsStart is the first file descriptor passed by systemd socket activation
+const sdListenFdsStart = 3
+
+// sdListeners return the sockets passed by systemd socket activation, nil when not socket activated
+func sdListeners() ([]net.Listener, error) {
+	return sdListenersFrom(sdListenFdsStart)
+}
+
+// sdListenersFrom the file descriptor start, systemd always start at sdListenFdsStart
+func sdListenersFrom(start int) ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds <= 0 {
+		return nil, nil
+	}
+	// the sockets are ours, don't let child processes think they were passed to them
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make([]net.Listener, 0, fds)
+	for fd := start; fd < start+fds; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		// FileListener dup the descriptor
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// sdNotify send the state to systemd when running as a Type=notify unit, noop otherwise
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// abstract sockets start with @, net package already take care of it
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval return how often the watchdog should be pinged, 0 when WatchdogSec is not set.
+// Ping at half of the timeout like sd_watchdog_enabled recommends
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	// the watchdog could be meant for another process
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
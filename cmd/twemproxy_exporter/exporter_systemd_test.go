@@ -0,0 +1,119 @@
+//go:build unix
+// +build unix
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("Expected noop without NOTIFY_SOCKET, got %s", err.Error())
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatal("Failed to notify: ", err.Error())
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state := string(buf[:n]); state != "READY=1" {
+		t.Errorf("Expected READY=1, got %s", state)
+	}
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		usec     string
+		pid      string
+		interval time.Duration
+	}{
+		{"", "", 0},
+		{"invalid", "", 0},
+		{"10000000", "", 5 * time.Second},
+		{"10000000", strconv.Itoa(os.Getpid()), 5 * time.Second},
+		{"10000000", strconv.Itoa(os.Getpid() + 1), 0},
+	}
+	for _, test := range tests {
+		t.Setenv("WATCHDOG_USEC", test.usec)
+		t.Setenv("WATCHDOG_PID", test.pid)
+		if interval := sdWatchdogInterval(); interval != test.interval {
+			t.Errorf("Expected %s for WATCHDOG_USEC=%s WATCHDOG_PID=%s, got %s", test.interval, test.usec, test.pid, interval)
+		}
+	}
+}
+
+func TestSdListeners(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	if listeners, err := sdListeners(); listeners != nil || err != nil {
+		t.Errorf("Expected no listener for another process, got %v %v", listeners, err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// a dup of the socket stand for the descriptor passed by systemd, sdListeners own and close it
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "metrics")
+
+	listeners, err := sdListenersFrom(fd)
+	if err != nil {
+		t.Fatal("Failed to accept the passed socket: ", err.Error())
+	}
+	if len(listeners) != 1 {
+		t.Fatalf("Expected 1 listener, got %d", len(listeners))
+	}
+	defer listeners[0].Close()
+	if listeners[0].Addr().String() != l.Addr().String() {
+		t.Errorf("Expected the listener on %s, got %s", l.Addr(), listeners[0].Addr())
+	}
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if value, ok := os.LookupEnv(name); ok {
+			t.Errorf("Expected %s to be unset, got %s", name, value)
+		}
+	}
+
+	go func() {
+		conn, err := listeners[0].Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+// Target is a twemproxy stats endpoint to monitor
+type Target struct {
+	Address  string `yaml:"address" json:"address"`
+	Instance string `yaml:"instance,omitempty" json:"instance"` // value of the instance label, the address when empty
+	// Name of the proxy, exported as the proxy label so dashboards survive moving the proxy to another host
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	// Labels attached to every metric of the target
+	Labels map[string]string `yaml:"labels,omitempty" json:"labels"`
+
+	// overrides of the targets file, e.g. longer timeouts and fewer pools for cross DC targets
+	Interval time.Duration `yaml:"interval,omitempty" json:"interval,omitempty"` // -interval when zero
+	Timeout  time.Duration `yaml:"timeout,omitempty" json:"timeout,omitempty"`   // 10s when zero
+	TLS      *TLSConfig    `yaml:"tls,omitempty" json:"-"`                       // -twemphost.tls.* when nil
+	Pools    []string      `yaml:"pools,omitempty" json:"pools,omitempty"`       // every pool of the config when empty
+
+	source string // static, file or the discovery which found the target
+}
+
+// proxyLabel is the label of the target name, see -metrics.proxy-label
+var proxyLabel = "proxy"
+
+// metricLabels of the target, its labels and its name
+func (t Target) metricLabels() map[string]string {
+	if t.Name == "" {
+		return t.Labels
+	}
+	labels := map[string]string{proxyLabel: t.Name}
+	for name, value := range t.Labels {
+		labels[name] = value
+	}
+	return labels
+}
+
+// instanceLabel of the target metrics
+func (t Target) instanceLabel() string {
+	if t.Instance != "" {
+		return t.Instance
+	}
+	return t.Address
+}
+
+// targetsFile is the content of -targets.file
+//
+//	targets:
+//	  - address: proxy-1:22222
+//	  - address: proxy-2:22222
+//	groups:
+//	  - name: cache-a
+//	    labels: {cluster: cache-a}
+//	    targets:
+//	      - address: proxy-3:22222
+type targetsFile struct {
+	Targets []Target      `yaml:"targets"`
+	Groups  []targetGroup `yaml:"groups,omitempty"`
+}
+
+// targetGroup share its labels with all of its targets, the labels of a target win over the group ones
+type targetGroup struct {
+	Name    string            `yaml:"name"`
+	Labels  map[string]string `yaml:"labels"`
+	Targets []Target          `yaml:"targets"`
+}
+
+// targets of the group with the group labels
+func (g targetGroup) targets() []Target {
+	targets := make([]Target, 0, len(g.Targets))
+	for _, t := range g.Targets {
+		labels := make(map[string]string, len(g.Labels)+len(t.Labels))
+		for name, value := range g.Labels {
+			labels[name] = value
+		}
+		for name, value := range t.Labels {
+			labels[name] = value
+		}
+		if len(labels) > 0 {
+			t.Labels = labels
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// readTargetsFile as written, with its groups
+func readTargetsFile(path string) (targetsFile, error) {
+	f := targetsFile{}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return f, fmt.Errorf("Cannot open: %s. Error: %s", path, err.Error())
+	}
+	err = yaml.Unmarshal(content, &f)
+	return f, err
+}
+
+func loadTargetsFile(path string) ([]Target, error) {
+	f, err := readTargetsFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range f.Groups {
+		if g.Name == "" {
+			return nil, fmt.Errorf("Target group without name in %s", path)
+		}
+		f.Targets = append(f.Targets, g.targets()...)
+	}
+	for i, t := range f.Targets {
+		f.Targets[i].source = "file"
+		if t.Address == "" {
+			return nil, fmt.Errorf("Target without address in %s", path)
+		}
+		if t.Interval < 0 || t.Timeout < 0 {
+			return nil, fmt.Errorf("Negative interval or timeout of target %s in %s", t.Address, path)
+		}
+		if t.TLS != nil {
+			_, err = t.TLS.Build()
+			if err != nil {
+				return nil, fmt.Errorf("Invalid tls of target %s in %s. Error: %s", t.Address, path, err.Error())
+			}
+		}
+	}
+	return f.Targets, nil
+}
+
+// staticTargets from the comma separated -twemphost and the -targets.file.
+// A single -twemphost keep instance as instance label, the exporter hostname like before multi target support
+func staticTargets(hosts string, path string, instance string) ([]Target, error) {
+	var targets []Target
+	for _, host := range strings.Split(hosts, ",") {
+		host = strings.TrimSpace(host)
+		if host != "" {
+			targets = append(targets, Target{Address: host})
+		}
+	}
+	if len(targets) <= 1 && path == "" {
+		if len(targets) == 0 {
+			targets = append(targets, Target{Address: monitor.DefaultHost})
+		}
+		targets[0].Instance = instance
+		return targets, nil
+	}
+
+	if path != "" {
+		fileTargets, err := loadTargetsFile(path)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, fileTargets...)
+	}
+	return targets, nil
+}
+
+// shard is the position of this exporter among the replicas sharing a target list
+type shard struct {
+	index  int // 1 based, like -shard=2/5
+	total  int // 0 when sharding is disabled
+	method string
+}
+
+// parseShard from index/total, empty means no sharding
+func parseShard(s string, method string) (shard, error) {
+	if s == "" {
+		return shard{}, nil
+	}
+	if method != "modulo" && method != "rendezvous" {
+		return shard{}, fmt.Errorf("Unknown shard method %s, expected modulo or rendezvous", method)
+	}
+	p := strings.Split(s, "/")
+	if len(p) != 2 {
+		return shard{}, fmt.Errorf("Invalid shard %s, expected index/total", s)
+	}
+	index, err := strconv.Atoi(p[0])
+	if err != nil {
+		return shard{}, fmt.Errorf("Invalid shard index %s", p[0])
+	}
+	total, err := strconv.Atoi(p[1])
+	if err != nil {
+		return shard{}, fmt.Errorf("Invalid shard total %s", p[1])
+	}
+	if total < 1 || index < 1 || index > total {
+		return shard{}, fmt.Errorf("Invalid shard %s, index must be between 1 and total", s)
+	}
+	return shard{index: index, total: total, method: method}, nil
+}
+
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// owns is true when the target belong to this shard.
+// Rendezvous hashing only move the targets of the added or removed shard when total change
+func (s shard) owns(address string) bool {
+	if s.total == 0 {
+		return true
+	}
+	if s.method == "rendezvous" {
+		winner, best := 0, uint64(0)
+		for i := 0; i < s.total; i++ {
+			score := hashString(address + "/" + strconv.Itoa(i))
+			if i == 0 || score > best {
+				winner, best = i, score
+			}
+		}
+		return winner == s.index-1
+	}
+	return hashString(address)%uint64(s.total) == uint64(s.index-1)
+}
+
+// filter the targets owned by this shard
+func (s shard) filter(targets []Target) []Target {
+	if s.total == 0 {
+		return targets
+	}
+	var owned []Target
+	for _, t := range targets {
+		if s.owns(t.Address) {
+			owned = append(owned, t)
+		}
+	}
+	return owned
+}
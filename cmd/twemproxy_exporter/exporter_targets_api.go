@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// targetStore hold the targets managed through the admin API, the "api" source of the scheduler.
+// When path is set the targets file is rewritten on every change, so the targets survive a restart
+type targetStore struct {
+	scheduler *Scheduler
+	path      string
+	file      targetsFile // last written to path, its groups are kept
+	targets   []Target
+	mu        sync.Mutex
+}
+
+func newTargetStore(scheduler *Scheduler, path string, targets []Target) (*targetStore, error) {
+	s := &targetStore{scheduler: scheduler, path: path, targets: targets}
+	// a missing file is created on the first change
+	if _, err := os.Stat(path); path != "" && err == nil {
+		s.file, err = readTargetsFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	scheduler.SetSource("api", targets)
+	return s, nil
+}
+
+// Add a target, replacing the one with the same address
+func (s *targetStore) Add(t Target) error {
+	if t.Address == "" {
+		return fmt.Errorf("Target without address")
+	}
+	if t.TLS != nil {
+		_, err := t.TLS.Build()
+		if err != nil {
+			return fmt.Errorf("Invalid tls of target %s. Error: %s", t.Address, err.Error())
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	targets := make([]Target, 0, len(s.targets)+1)
+	for _, existing := range s.targets {
+		if existing.Address != t.Address {
+			targets = append(targets, existing)
+		}
+	}
+	return s.set(append(targets, t))
+}
+
+// Remove the target by address, instance or name, false when there is none
+func (s *targetStore) Remove(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	targets := make([]Target, 0, len(s.targets))
+	for _, t := range s.targets {
+		if t.Address != name && t.Instance != name && t.Name != name {
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == len(s.targets) {
+		return false, nil
+	}
+	return true, s.set(targets)
+}
+
+// set the targets, saved before they are applied so a failed save change nothing
+func (s *targetStore) set(targets []Target) error {
+	if s.path != "" {
+		f := s.file.update(targets)
+		content, err := yaml.Marshal(f)
+		if err != nil {
+			return err
+		}
+		tmp := s.path + ".tmp"
+		err = ioutil.WriteFile(tmp, content, 0644)
+		if err != nil {
+			return err
+		}
+		err = os.Rename(tmp, s.path)
+		if err != nil {
+			return err
+		}
+		s.file = f
+	}
+	s.targets = targets
+	s.scheduler.SetSource("api", targets)
+	return nil
+}
+
+// update the file to the targets: the unchanged targets of a group stay in the group, the removed ones are dropped
+// and the changed or added ones are written at the top level with all of their labels
+func (f targetsFile) update(targets []Target) targetsFile {
+	current := make(map[string]Target, len(targets))
+	for _, t := range targets {
+		current[t.Address] = t
+	}
+	written := make(map[string]bool, len(targets))
+	updated := targetsFile{Targets: []Target{}}
+	for _, g := range f.Groups {
+		kept := targetGroup{Name: g.Name, Labels: g.Labels, Targets: []Target{}}
+		for i, t := range g.targets() {
+			if c, ok := current[t.Address]; ok && !written[t.Address] && sameTarget(c, t) {
+				kept.Targets = append(kept.Targets, g.Targets[i])
+				written[t.Address] = true
+			}
+		}
+		updated.Groups = append(updated.Groups, kept)
+	}
+	for _, t := range append(f.Targets, targets...) {
+		if c, ok := current[t.Address]; ok && !written[t.Address] {
+			updated.Targets = append(updated.Targets, c)
+			written[t.Address] = true
+		}
+	}
+	return updated
+}
+
+// sameTarget whatever the source which found it
+func sameTarget(a Target, b Target) bool {
+	a.source, b.source = "", ""
+	return reflect.DeepEqual(a, b)
+}
+
+// targetsAdminHandler add targets with POST /api/v1/targets and remove them with DELETE /api/v1/targets/{name},
+// GET is served by next. The changes are only allowed when the web config authenticate the requests
+func targetsAdminHandler(store *targetStore, authenticated bool, next http.Handler) http.Handler {
+	change := targetsChangeHandler(store)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/api/v1/targets" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !authenticated {
+			http.Error(w, "Changing targets needs basic_auth_users or required client certificates in the web config", http.StatusForbidden)
+			return
+		}
+		change.ServeHTTP(w, r)
+	})
+}
+
+func targetsChangeHandler(store *targetStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/api/v1/targets")
+		name = strings.TrimPrefix(name, "/")
+		switch {
+		case r.Method == http.MethodPost && name == "":
+			t := Target{}
+			err := json.NewDecoder(r.Body).Decode(&t)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Invalid target: %s", err.Error()), http.StatusBadRequest)
+				return
+			}
+			err = store.Add(t)
+			if err != nil {
+				log.Printf("Cannot add target %s. Error: %s", t.Address, err.Error())
+				http.Error(w, fmt.Sprintf("Cannot add target: %s", err.Error()), http.StatusBadRequest)
+				return
+			}
+			log.Printf("Target %s added through the API", t.Address)
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, "Target %s added\n", t.Address)
+		case r.Method == http.MethodDelete && name != "":
+			removed, err := store.Remove(name)
+			if err != nil {
+				log.Printf("Cannot remove target %s. Error: %s", name, err.Error())
+				http.Error(w, fmt.Sprintf("Cannot remove target: %s", err.Error()), http.StatusInternalServerError)
+				return
+			}
+			if !removed {
+				http.Error(w, fmt.Sprintf("No target %s added through the API", name), http.StatusNotFound)
+				return
+			}
+			log.Printf("Target %s removed through the API", name)
+			fmt.Fprintf(w, "Target %s removed\n", name)
+		default:
+			http.Error(w, "Only GET, POST and DELETE are allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestShardOwnsEveryTargetOnce(t *testing.T) {
+	var targets []Target
+	for i := 0; i < 100; i++ {
+		targets = append(targets, Target{Address: fmt.Sprintf("proxy-%d:22222", i)})
+	}
+
+	for _, method := range []string{"modulo", "rendezvous"} {
+		owners := make(map[string]int)
+		for i := 1; i <= 5; i++ {
+			s, err := parseShard(fmt.Sprintf("%d/5", i), method)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, target := range s.filter(targets) {
+				owners[target.Address]++
+			}
+		}
+		for _, target := range targets {
+			if owners[target.Address] != 1 {
+				t.Errorf("%s: target %s owned by %d shards", method, target.Address, owners[target.Address])
+			}
+		}
+	}
+}
+
+func TestParseShardInvalid(t *testing.T) {
+	for _, s := range []string{"0/5", "6/5", "1", "a/5", "1/0"} {
+		_, err := parseShard(s, "modulo")
+		if err == nil {
+			t.Errorf("Expected error for shard %s", s)
+		}
+	}
+	_, err := parseShard("1/5", "ring")
+	if err == nil {
+		t.Error("Expected error for unknown shard method")
+	}
+}
+
+func TestTargetsFileOverrides(t *testing.T) {
+	targets, err := loadTargetsFile("../../files/targets.yml")
+	if err != nil {
+		t.Fatal("Failed to load targets: ", err.Error())
+	}
+	if len(targets) != 4 {
+		t.Fatalf("Expected 4 targets, got %d", len(targets))
+	}
+	remote := targets[1]
+	if remote.Interval != time.Minute || remote.Timeout != time.Second*30 || remote.Labels["dc"] != "dc2" {
+		t.Errorf("Unexpected overrides %+v", remote)
+	}
+
+	if len(targets[0].Pools) != 0 {
+		t.Errorf("Expected every pool without pools override, got %v", targets[0].Pools)
+	}
+	if len(remote.Pools) != 1 || remote.Pools[0] != "wallet-oauth-token" {
+		t.Errorf("Expected only wallet-oauth-token, got %v", remote.Pools)
+	}
+}
+
+func TestTargetsFileGroups(t *testing.T) {
+	targets, err := loadTargetsFile("../../files/targets.yml")
+	if err != nil {
+		t.Fatal("Failed to load targets: ", err.Error())
+	}
+	labels := make(map[string]map[string]string)
+	for _, target := range targets {
+		labels[target.Address] = target.Labels
+	}
+	if labels["proxy-a1:22222"]["cluster"] != "cache-a" || labels["proxy-a1:22222"]["env"] != "prod" {
+		t.Errorf("Expected the group labels, got %v", labels["proxy-a1:22222"])
+	}
+	if labels["proxy-a2:22222"]["cluster"] != "cache-a" || labels["proxy-a2:22222"]["env"] != "canary" {
+		t.Errorf("Expected the target labels over the group ones, got %v", labels["proxy-a2:22222"])
+	}
+	if labels["proxy-1:22222"] != nil {
+		t.Errorf("Expected no labels outside of the groups, got %v", labels["proxy-1:22222"])
+	}
+}
+
+func TestStaticTargetsInstance(t *testing.T) {
+	targets, err := staticTargets("proxy-1:22222", "", "exporter-host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if targets[0].instanceLabel() != "exporter-host" {
+		t.Errorf("Expected the hostname as instance, got %s", targets[0].instanceLabel())
+	}
+	// without host labels both replicas of an HA pair use the address
+	targets, err = staticTargets("proxy-1:22222", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if targets[0].instanceLabel() != "proxy-1:22222" {
+		t.Errorf("Expected the address as instance, got %s", targets[0].instanceLabel())
+	}
+}
+
+func TestTargetMetricLabels(t *testing.T) {
+	target := Target{Address: "10.0.0.1:22222", Name: "sessions-us-east", Labels: map[string]string{"dc": "us-east"}}
+	labels := target.metricLabels()
+	if labels["proxy"] != "sessions-us-east" || labels["dc"] != "us-east" {
+		t.Errorf("Unexpected labels %v", labels)
+	}
+	if len(target.Labels) != 1 {
+		t.Error("Expected the target labels untouched")
+	}
+	if (Target{Address: "10.0.0.1:22222"}).metricLabels() != nil {
+		t.Error("Expected no labels without name")
+	}
+}
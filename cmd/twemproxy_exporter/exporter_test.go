@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+func TestLoadMetrics(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Error("Failed to read config: ", err.Error())
+	}
+
+	resp, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Error("Failed to read json example: ", err.Error())
+	}
+
+	st, err := stats.Parse(resp, conf)
+	if err != nil {
+		t.Error("Failed to parse stats: ", err.Error())
+	}
+	log.Printf("Stats: %+v", st)
+}
+
+func TestLenientConfig(t *testing.T) {
+	*configLenient = true
+	defer func() { *configLenient = false }()
+
+	conf, err := lenientConfig(config.LoadConfig("../../files/nutcracker-invalid.yml"))
+	if err != nil {
+		t.Fatal("Expected the invalid pool to be skipped, got ", err.Error())
+	}
+	if _, ok := conf["sessions"]; !ok || len(conf) != 1 {
+		t.Errorf("Expected only the valid pool, got %+v", conf)
+	}
+	if value := testutil.GatherValue(t, configPoolErrors, "twemproxy_exporter_config_pool_errors"); value != 1 {
+		t.Errorf("Expected 1 pool error, got %f", value)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+)
+
+// writeTextfile of the gathered metrics for the node_exporter textfile collector.
+// The file is written to a temporary file of the same directory then renamed, so node_exporter never read
+// half of it, and twemproxy_exporter_textfile_timestamp_seconds tell when it was written so an old file can be alerted on
+func writeTextfile(path string, gatherer prometheus.Gatherer, now time.Time) error {
+	timestamp := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: collector.Namespace,
+		Subsystem: "exporter",
+		Name:      "textfile_timestamp_seconds",
+		Help:      "Unix time the textfile was written",
+	})
+	timestamp.Set(float64(now.UnixNano()) / 1e9)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(timestamp)
+
+	families, err := prometheus.Gatherers{gatherer, registry}.Gather()
+	if err != nil {
+		// a partial gather is still worth writing, like the /metrics endpoint with ContinueOnError
+		log.Printf("Error gathering metrics for %s. Error: %s", path, err.Error())
+	}
+	var buf bytes.Buffer
+	for _, family := range families {
+		_, err = expfmt.MetricFamilyToText(&buf, family)
+		if err != nil {
+			return err
+		}
+	}
+
+	// node_exporter only read *.prom files, the temporary file is ignored
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	// TempFile create the file 0600, node_exporter usually run as another user
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeTextfileEvery interval until the process exit
+func writeTextfileEvery(path string, interval time.Duration) {
+	for range time.Tick(interval) {
+		err := writeTextfile(path, prometheus.DefaultGatherer, time.Now())
+		if err != nil {
+			log.Printf("Cannot write textfile %s. Error: %s", path, err.Error())
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWriteTextfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "textfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "twemproxy.prom")
+
+	registry := prometheus.NewRegistry()
+	up := prometheus.NewGauge(prometheus.GaugeOpts{Name: "twemproxy_up", Help: "up"})
+	up.Set(1)
+	registry.MustRegister(up)
+
+	err = writeTextfile(path, registry, time.Unix(1500000000, 0))
+	if err != nil {
+		t.Fatal("Failed to write: ", err.Error())
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "twemproxy_up 1\n") ||
+		!strings.Contains(string(content), "twemproxy_exporter_textfile_timestamp_seconds 1.5e+09\n") {
+		t.Errorf("Unexpected textfile content %s", content)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("Expected the temporary file renamed, got %d files", len(files))
+	}
+}
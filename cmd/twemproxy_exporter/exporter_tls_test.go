@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+// startTLSStats serve files/example.json over TLS with the certificate, like stunnel in front of the stats port
+func startTLSStats(t *testing.T, certPath, keyPath string) string {
+	payload, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Fatal("Failed to read json example: ", err.Error())
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write(payload)
+			conn.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestFetchStatsTLS(t *testing.T) {
+	certPath, keyPath := writeCertificate(t, t.TempDir())
+	address := startTLSStats(t, certPath, keyPath)
+
+	tlsConfig, err := TLSConfig{Enabled: true, CAFile: certPath}.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := stats.Fetch(context.Background(), address, tlsConfig, 0)
+	if err != nil {
+		t.Fatal("Failed to fetch the stats over TLS: ", err.Error())
+	}
+	if requests := requestsOf(t, payload); requests == 0 {
+		t.Error("Expected the stats of the example")
+	}
+
+	// another CA doesn't verify the certificate
+	otherCA, _ := writeCertificate(t, t.TempDir())
+	tlsConfig, err = TLSConfig{Enabled: true, CAFile: otherCA}.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stats.Fetch(context.Background(), address, tlsConfig, 0); err == nil {
+		t.Error("Expected the certificate not to be verified with another CA")
+	}
+	tlsConfig, err = TLSConfig{Enabled: true, CAFile: otherCA, InsecureSkipVerify: true}.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stats.Fetch(context.Background(), address, tlsConfig, 0); err != nil {
+		t.Errorf("Expected the certificate not to be verified with insecure_skip_verify, got %s", err.Error())
+	}
+}
+
+func TestTLSConfigBuild(t *testing.T) {
+	tlsConfig, err := TLSConfig{CAFile: "missing.pem"}.Build()
+	if tlsConfig != nil || err != nil {
+		t.Errorf("Expected no TLS when disabled, got %v %v", tlsConfig, err)
+	}
+	dir := t.TempDir()
+	certPath, _ := writeCertificate(t, dir)
+	if _, err := (TLSConfig{Enabled: true, CertFile: certPath}).Build(); err == nil {
+		t.Error("Expected a client certificate without key to fail")
+	}
+	if _, err := (TLSConfig{Enabled: true, CAFile: filepath.Join(dir, "missing.pem")}).Build(); err == nil {
+		t.Error("Expected a missing CA file to fail")
+	}
+	empty := filepath.Join(dir, "empty.pem")
+	ioutil.WriteFile(empty, nil, 0600)
+	if _, err := (TLSConfig{Enabled: true, CAFile: empty}).Build(); err == nil {
+		t.Error("Expected a CA file without certificate to fail")
+	}
+}
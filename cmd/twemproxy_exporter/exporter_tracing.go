@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tracing of the scrapes, nil when -tracing.otlp-endpoint is not set
+var tracing *tracer
+
+// tracerBatchSize of the spans sent at once, more are sent right away
+const tracerBatchSize = 512
+
+// tracer record spans of the scrape phases and send them to an OpenTelemetry collector with OTLP/HTTP in JSON.
+// Every method can be called on a nil tracer, doing nothing
+type tracer struct {
+	endpoint string // e.g. http://otel-collector:4318/v1/traces
+	service  string
+	client   *http.Client
+	spans    []otlpSpan
+	mu       sync.Mutex
+}
+
+func newTracer(endpoint string, service string) *tracer {
+	return &tracer{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: time.Second * 10},
+	}
+}
+
+// span of one phase, ended once
+type span struct {
+	tracer  *tracer
+	traceID string
+	spanID  string
+	parent  string
+	name    string
+	start   time.Time
+	attrs   map[string]string
+}
+
+type spanKey struct{}
+
+// start a span, child of the span of ctx when there is one. attrs are key, value pairs
+func (t *tracer) start(ctx context.Context, name string, attrs ...string) (context.Context, *span) {
+	if t == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: t, spanID: randomID(8), name: name, start: time.Now(), attrs: make(map[string]string)}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok && parent != nil {
+		s.traceID, s.parent = parent.traceID, parent.spanID
+	} else {
+		s.traceID = randomID(16)
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.attrs[attrs[i]] = attrs[i+1]
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// end the span, with an error status when err is not nil
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	span := otlpSpan{
+		TraceID:           s.traceID,
+		SpanID:            s.spanID,
+		ParentSpanID:      s.parent,
+		Name:              s.name,
+		Kind:              1, // internal
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+	}
+	for key, value := range s.attrs {
+		span.Attributes = append(span.Attributes, otlpAttribute{Key: key, Value: otlpValue{StringValue: value}})
+	}
+	if err != nil {
+		span.Status = &otlpStatus{Code: 2, Message: err.Error()}
+	}
+	s.tracer.add(span)
+}
+
+// trace a step of a scrape, the Trace hook of the monitors
+func (t *tracer) trace(ctx context.Context, name string, attrs ...string) (context.Context, func(err error)) {
+	ctx, s := t.start(ctx, name, attrs...)
+	return ctx, s.end
+}
+
+func (t *tracer) add(span otlpSpan) {
+	t.mu.Lock()
+	t.spans = append(t.spans, span)
+	full := len(t.spans) >= tracerBatchSize
+	t.mu.Unlock()
+	if full {
+		go t.flush(context.Background())
+	}
+}
+
+// exportEvery interval until ctx is done, the last spans are sent before returning
+func (t *tracer) exportEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.flush(ctx)
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			t.flush(flushCtx)
+			cancel()
+			return
+		}
+	}
+}
+
+// flush the recorded spans, dropped when the collector can't be reached
+func (t *tracer) flush(ctx context.Context) {
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+	err := t.export(ctx, spans)
+	if err != nil {
+		log.Printf("Cannot export %d spans to %s. Error: %s", len(spans), t.endpoint, err.Error())
+	}
+}
+
+func (t *tracer) export(ctx context.Context, spans []otlpSpan) error {
+	payload, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: t.service}}}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "twemproxy_exporter"},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		return err
+	}
+	resp, err := postJSON(ctx, t.client, t.endpoint, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+func randomID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// OTLP/HTTP JSON encoding of the traces, ids in hex and times as strings
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 2 is error
+	Message string `json:"message,omitempty"`
+}
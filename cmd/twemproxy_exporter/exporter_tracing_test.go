@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/monitor"
+)
+
+func TestTracingScrape(t *testing.T) {
+	var traces otlpTraces
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&traces); err != nil {
+			t.Errorf("Invalid OTLP payload: %s", err.Error())
+		}
+	}))
+	defer collector.Close()
+	mock := startMockServer(t)
+	defer mock.Close()
+
+	defer func() { tracing = nil }()
+	tracing = newTracer(collector.URL+"/v1/traces", "test")
+	m, _ := monitor.New(monitor.WithHost(mock.Addr()), monitor.WithHooks(monitor.Hooks{Trace: tracing.trace}))
+	if err := m.Run(context.Background()); err != nil {
+		t.Fatal("Failed to scrape: ", err.Error())
+	}
+	tracing.flush(context.Background())
+
+	if len(traces.ResourceSpans) != 1 || len(traces.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Expected the spans of one scope, got %+v", traces)
+	}
+	spans := make(map[string]otlpSpan)
+	for _, span := range traces.ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[span.Name] = span
+	}
+	scrape := spans["scrape"]
+	for _, name := range []string{"fetch", "parse", "publish"} {
+		if spans[name].ParentSpanID != scrape.SpanID || spans[name].TraceID != scrape.TraceID {
+			t.Errorf("Expected %s in the scrape, got %+v", name, spans[name])
+		}
+	}
+	for _, name := range []string{"dial", "read"} {
+		if spans[name].ParentSpanID != spans["fetch"].SpanID {
+			t.Errorf("Expected %s in the fetch, got %+v", name, spans[name])
+		}
+	}
+	if scrape.ParentSpanID != "" || scrape.Status != nil {
+		t.Errorf("Expected a successful root scrape span, got %+v", scrape)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tsdbSample of a series, the time in milliseconds
+type tsdbSample struct {
+	T int64
+	V float64
+}
+
+// tsdbSeries is a metric name with the instance, group and server labels, the server is empty for pool and twemproxy metrics
+type tsdbSeries struct {
+	Metric   string
+	Instance string
+	Pool     string
+	Server   string
+}
+
+// tsdb keep the samples of the last retention in memory, for edge sites without any Prometheus.
+// Only the main gauges and the rates are kept, each sample is 16 bytes
+type tsdb struct {
+	retention time.Duration
+	series    map[tsdbSeries][]tsdbSample
+	mu        sync.RWMutex
+}
+
+func newTSDB(retention time.Duration) *tsdb {
+	return &tsdb{retention: retention, series: make(map[tsdbSeries][]tsdbSample)}
+}
+
+// append a sample, dropping the samples out of the retention
+func (db *tsdb) append(series tsdbSeries, t time.Time, v float64) {
+	samples := append(db.series[series], tsdbSample{T: t.UnixNano() / int64(time.Millisecond), V: v})
+	oldest := t.Add(-db.retention).UnixNano() / int64(time.Millisecond)
+	i := 0
+	for i < len(samples) && samples[i].T < oldest {
+		i++
+	}
+	db.series[series] = samples[i:]
+}
+
+// Observe a scrape result, failed scrapes leave a gap like in Prometheus
+func (db *tsdb) Observe(result ScrapeResult) {
+	if result.Err != nil {
+		return
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	instance := result.Target.instanceLabel()
+	t := result.Time
+	db.append(tsdbSeries{Metric: "twemproxy_service_current_connections", Instance: instance}, t, result.Stats.CurrentConnections)
+	for poolName, pool := range result.Stats.Services {
+		for name, server := range pool.Servers {
+			series := tsdbSeries{Instance: instance, Pool: poolName, Server: server.HostAlias}
+			for metric, v := range map[string]float64{
+				"twemproxy_server_in_queue":       server.InQueue,
+				"twemproxy_server_in_queue_bytes": server.InQueueBytes,
+				"twemproxy_server_timed_out":      server.ServerTimedout,
+				"twemproxy_server_connection":     server.ServerConnections,
+			} {
+				series.Metric = metric
+				db.append(series, t, v)
+			}
+			if r, ok := result.Rates[poolName].Servers[name]; ok {
+				series.Metric = "twemproxy_server_requests_per_second"
+				db.append(series, t, r.Requests)
+				series.Metric = "twemproxy_server_errors_per_second"
+				db.append(series, t, r.Errors)
+			}
+		}
+		if r, ok := result.Rates[poolName]; ok {
+			db.append(tsdbSeries{Metric: "twemproxy_pool_request_imbalance", Instance: instance, Pool: poolName}, t, r.RequestImbalance)
+		}
+	}
+}
+
+// tsdbResult of a series, shaped like a Prometheus range query result
+type tsdbResult struct {
+	Metric map[string]string `json:"metric"`
+	Values [][]interface{}   `json:"values"`
+}
+
+// query the series of the metric between start and end, empty filters match everything
+func (db *tsdb) query(filter tsdbSeries, start time.Time, end time.Time) []tsdbResult {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	from := start.UnixNano() / int64(time.Millisecond)
+	to := end.UnixNano() / int64(time.Millisecond)
+
+	results := []tsdbResult{}
+	for series, samples := range db.series {
+		if series.Metric != filter.Metric ||
+			(filter.Instance != "" && series.Instance != filter.Instance) ||
+			(filter.Pool != "" && series.Pool != filter.Pool) ||
+			(filter.Server != "" && series.Server != filter.Server) {
+			continue
+		}
+		result := tsdbResult{Metric: map[string]string{"__name__": series.Metric, "instance": series.Instance}}
+		if series.Pool != "" {
+			result.Metric["group"] = series.Pool
+		}
+		if series.Server != "" {
+			result.Metric[serverLabels.ServerLabel()] = series.Server
+		}
+		for _, s := range samples {
+			if s.T >= from && s.T <= to {
+				result.Values = append(result.Values, []interface{}{float64(s.T) / 1000, strconv.FormatFloat(s.V, 'f', -1, 64)})
+			}
+		}
+		if len(result.Values) > 0 {
+			results = append(results, result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i].Metric, results[j].Metric
+		if a["instance"] != b["instance"] {
+			return a["instance"] < b["instance"]
+		}
+		if a["group"] != b["group"] {
+			return a["group"] < b["group"]
+		}
+		return a[serverLabels.ServerLabel()] < b[serverLabels.ServerLabel()]
+	})
+	return results
+}
+
+// parseQueryTime in unix seconds, the default when empty
+func parseQueryTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), nil
+}
+
+// queryRangeHandler serve /api/v1/query_range?metric=twemproxy_server_in_queue&pool=sessions&start=...&end=...
+// in the Prometheus response format, the last hour by default
+func queryRangeHandler(db *tsdb) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		filter := tsdbSeries{Metric: q.Get("metric"), Instance: q.Get("instance"), Pool: q.Get("pool"), Server: q.Get("server")}
+		if filter.Metric == "" {
+			http.Error(w, "metric is required", http.StatusBadRequest)
+			return
+		}
+		end, err := parseQueryTime(q.Get("end"), time.Now())
+		if err != nil {
+			http.Error(w, "end must be a unix timestamp", http.StatusBadRequest)
+			return
+		}
+		start, err := parseQueryTime(q.Get("start"), end.Add(-time.Hour))
+		if err != nil {
+			http.Error(w, "start must be a unix timestamp", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data": map[string]interface{}{
+				"resultType": "matrix",
+				"result":     db.query(filter, start, end),
+			},
+		})
+	})
+}
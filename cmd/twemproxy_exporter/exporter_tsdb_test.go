@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+)
+
+func TestTSDB(t *testing.T) {
+	db := newTSDB(time.Hour)
+	start := time.Unix(1500000000, 0)
+	for i := 0; i < 90; i++ {
+		result := ScrapeResult{Target: Target{Address: "proxy:22222"}, Stats: testutil.ExampleStats(t), Time: start.Add(time.Duration(i) * time.Minute)}
+		server := result.Stats.Services[testutil.ExamplePool].Servers["alpha"]
+		server.InQueue = float64(i)
+		result.Stats.Services[testutil.ExamplePool].Servers["alpha"] = server
+		db.Observe(result)
+	}
+	db.Observe(ScrapeResult{Target: Target{Address: "proxy:22222"}, Err: errors.New("down"), Time: start.Add(time.Hour * 2)})
+
+	samples := db.series[tsdbSeries{Metric: "twemproxy_server_in_queue", Instance: "proxy:22222", Pool: testutil.ExamplePool, Server: "redis:6379:1"}]
+	if len(samples) != 61 {
+		t.Fatalf("Expected the samples of the last hour only, got %d", len(samples))
+	}
+
+	end := start.Add(time.Minute * 89)
+	url := "/api/v1/query_range?metric=twemproxy_server_in_queue&pool=" + testutil.ExamplePool + "&server=redis:6379:1&end=" + strconv.FormatInt(end.Unix(), 10)
+	rec := httptest.NewRecorder()
+	queryRangeHandler(db).ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+	body := struct {
+		Status string `json:"status"`
+		Data   struct {
+			Result []tsdbResult `json:"result"`
+		} `json:"data"`
+	}{}
+	err := json.NewDecoder(rec.Body).Decode(&body)
+	if err != nil {
+		t.Fatal("Invalid JSON: ", err.Error())
+	}
+	if body.Status != "success" || len(body.Data.Result) != 1 {
+		t.Fatalf("Unexpected response %+v", body)
+	}
+	values := body.Data.Result[0].Values
+	if len(values) != 61 || values[len(values)-1][1] != "89" {
+		t.Errorf("Unexpected values %v", values)
+	}
+
+	rec = httptest.NewRecorder()
+	queryRangeHandler(db).ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/query_range", nil))
+	if rec.Code != 400 {
+		t.Errorf("Expected 400 without metric, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+)
+
+// startTime of the exporter, a crash looping exporter restart between two scrapes of prometheus and look up
+var startTime = time.Now()
+
+var (
+	startTimeMetric = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: collector.Namespace,
+		Subsystem: "exporter",
+		Name:      "start_time_seconds",
+		Help:      "Start time of the exporter since unix epoch in seconds",
+	})
+	uptimeMetric = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: collector.Namespace,
+		Subsystem: "exporter",
+		Name:      "uptime_seconds",
+		Help:      "Seconds since the exporter started",
+	}, func() float64 {
+		return time.Since(startTime).Seconds()
+	})
+)
+
+func init() {
+	startTimeMetric.Set(float64(startTime.UnixNano()) / 1e9)
+	prometheus.MustRegister(startTimeMetric, uptimeMetric)
+}
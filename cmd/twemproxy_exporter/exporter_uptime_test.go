@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+)
+
+func TestUptime(t *testing.T) {
+	start := testutil.GatherValue(t, startTimeMetric, "twemproxy_exporter_start_time_seconds")
+	if start != float64(startTime.UnixNano())/1e9 || start > float64(time.Now().Unix())+1 {
+		t.Errorf("Expected the start time of the exporter, got %f", start)
+	}
+	if uptime := testutil.GatherValue(t, uptimeMetric, "twemproxy_exporter_uptime_seconds"); uptime <= 0 {
+		t.Errorf("Expected the uptime to grow, got %f", uptime)
+	}
+}
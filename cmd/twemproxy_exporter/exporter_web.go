@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/yaml.v2"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// ErrWebTLSCertMissing returned when TLS is configured without a certificate
+var ErrWebTLSCertMissing = errors.New("tls_server_config needs both cert_file and key_file")
+
+// metrics of the exporter own HTTP server
+var (
+	httpInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: collector.Namespace,
+		Subsystem: "exporter",
+		Name:      "http_requests_in_flight",
+		Help:      "Current HTTP requests served by the exporter",
+	})
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: collector.Namespace,
+		Subsystem: "exporter",
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served by the exporter",
+	}, []string{"handler", "code", "method"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: collector.Namespace,
+		Subsystem: "exporter",
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of HTTP requests served by the exporter",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler", "code", "method"})
+)
+
+func init() {
+	prometheus.MustRegister(httpInFlight, httpRequests, httpDuration)
+}
+
+// client auth types, same naming as the prometheus web config file
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"NoClientCert":               tls.NoClientCert,
+	"RequestClientCert":          tls.RequestClientCert,
+	"RequireAnyClientCert":       tls.RequireAnyClientCert,
+	"VerifyClientCertIfGiven":    tls.VerifyClientCertIfGiven,
+	"RequireAndVerifyClientCert": tls.RequireAndVerifyClientCert,
+}
+
+// WebConfig for the exporter HTTP server
+type WebConfig struct {
+	TLSServerConfig *TLSServerConfig          `yaml:"tls_server_config"`
+	BasicAuthUsers  map[string]*config.Secret `yaml:"basic_auth_users"`
+}
+
+// TLSServerConfig to serve the endpoints over TLS, optionally requiring client certificates
+type TLSServerConfig struct {
+	CertFile       string `yaml:"cert_file"`
+	KeyFile        string `yaml:"key_file"`
+	ClientCAFile   string `yaml:"client_ca_file"`
+	ClientAuthType string `yaml:"client_auth_type"`
+}
+
+// LoadWebConfig from yaml, empty path means plain HTTP
+func LoadWebConfig(path string) (WebConfig, error) {
+	conf := WebConfig{}
+	if path == "" {
+		return conf, nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return conf, fmt.Errorf("Cannot open: %s. Error: %s", path, err.Error())
+	}
+	err = yaml.Unmarshal(content, &conf)
+	if err != nil {
+		return conf, err
+	}
+	// validate early, so we fail at startup and not on the first request
+	_, err = conf.tlsConfig()
+	return conf, err
+}
+
+// authenticated when every request needs a password or a verified client certificate, a client certificate which is
+// only requested or verified if given authenticate nobody
+func (c WebConfig) authenticated() bool {
+	if len(c.BasicAuthUsers) > 0 {
+		return true
+	}
+	s := c.TLSServerConfig
+	if s == nil || s.ClientCAFile == "" {
+		return false
+	}
+	// a client CA without client_auth_type require and verify the certificates
+	return s.ClientAuthType == "" || clientAuthTypes[s.ClientAuthType] == tls.RequireAndVerifyClientCert
+}
+
+func (c WebConfig) tlsConfig() (*tls.Config, error) {
+	s := c.TLSServerConfig
+	if s == nil {
+		return nil, nil
+	}
+	if s.CertFile == "" || s.KeyFile == "" {
+		return nil, ErrWebTLSCertMissing
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot load server certificate. Error: %s", err.Error())
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	authType := s.ClientAuthType
+	// having a client CA means we want to verify the clients
+	if authType == "" && s.ClientCAFile != "" {
+		authType = "RequireAndVerifyClientCert"
+	}
+	if authType != "" {
+		clientAuth, ok := clientAuthTypes[authType]
+		if !ok {
+			return nil, fmt.Errorf("Invalid client_auth_type: %s", authType)
+		}
+		tlsConfig.ClientAuth = clientAuth
+	}
+
+	if s.ClientCAFile != "" {
+		ca, err := ioutil.ReadFile(s.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot open: %s. Error: %s", s.ClientCAFile, err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("No certificate found in %s", s.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+	} else if tlsConfig.ClientAuth == tls.VerifyClientCertIfGiven || tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert {
+		return nil, fmt.Errorf("client_auth_type %s needs client_ca_file", authType)
+	}
+	return tlsConfig, nil
+}
+
+// webHandler wrap the handler with, from the outermost:
+// access log, source network allowlist and basic auth
+func webHandler(handler http.Handler, conf WebConfig, allowedNets []*net.IPNet, logAccess bool) http.Handler {
+	if len(conf.BasicAuthUsers) > 0 {
+		handler = basicAuth(handler, conf.BasicAuthUsers)
+	}
+	if len(allowedNets) > 0 {
+		handler = allowCIDR(handler, allowedNets)
+	}
+	if logAccess {
+		handler = accessLog(handler)
+	}
+	return handler
+}
+
+// serveHTTP the handler on the listener using the web config TLS settings
+func serveHTTP(listener net.Listener, handler http.Handler, conf WebConfig) error {
+	tlsConfig, err := conf.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:   handler,
+		TLSConfig: tlsConfig,
+	}
+	if tlsConfig == nil {
+		return server.Serve(listener)
+	}
+	// certificates are already in the TLSConfig
+	return server.ServeTLS(listener, "", "")
+}
+
+// accessLog log every request with its status and duration
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		log.Printf("%s %s %s %d %s %q", r.RemoteAddr, r.Method, r.URL.RequestURI(), rec.status, time.Since(start), r.UserAgent())
+	})
+}
+
+// instrumentHandler export in flight requests, durations and response codes of the handler
+func instrumentHandler(name string, handler http.Handler) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+	return promhttp.InstrumentHandlerInFlight(httpInFlight,
+		promhttp.InstrumentHandlerDuration(httpDuration.MustCurryWith(labels),
+			promhttp.InstrumentHandlerCounter(httpRequests.MustCurryWith(labels), handler),
+		),
+	)
+}
+
+// basicAuth reject requests without a valid user and password
+func basicAuth(next http.Handler, users map[string]*config.Secret) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if ok {
+			if secret, exists := users[user]; exists {
+				expected, err := secret.Get()
+				if err != nil {
+					log.Printf("Cannot read password of web user %s. Error: %s", user, err.Error())
+				}
+				if expected != "" && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1 {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		if action, ok := auditAction(r.URL.Path); ok {
+			writeAudit(action, r, http.StatusUnauthorized)
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="twemproxy_exporter"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	})
+}
+
+// parseCIDRs from a comma separated list, a plain IP is treated as a single host network
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid IP %s", s)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			s = fmt.Sprintf("%s/%d", s, bits)
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// allowCIDR reject requests coming from outside of the allowed networks
+func allowCIDR(next http.Handler, nets []*net.IPNet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		for _, n := range nets {
+			if ip != nil && n.Contains(ip) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if action, ok := auditAction(r.URL.Path); ok {
+			writeAudit(action, r, http.StatusForbidden)
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestAllowCIDR(t *testing.T) {
+	nets, err := parseCIDRs("10.0.0.0/8, 192.168.1.10,2001:db8::/32")
+	if err != nil {
+		t.Fatal("Failed to parse CIDRs: ", err.Error())
+	}
+	handler := allowCIDR(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), nets)
+
+	cases := map[string]int{
+		"10.1.2.3:1234":        http.StatusOK,
+		"192.168.1.10:1234":    http.StatusOK,
+		"192.168.1.11:1234":    http.StatusForbidden,
+		"[2001:db8::1]:1234":   http.StatusOK,
+		"[2001:db9::1]:1234":   http.StatusForbidden,
+		"not-an-address:12345": http.StatusForbidden,
+	}
+	for addr, expected := range cases {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		req.RemoteAddr = addr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != expected {
+			t.Errorf("%s: expected %d, got %d", addr, expected, rec.Code)
+		}
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	users := map[string]*config.Secret{"prometheus": config.NewSecret("s3cret")}
+	handler := basicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), users)
+
+	cases := []struct {
+		user     string
+		password string
+		expected int
+	}{
+		{"prometheus", "s3cret", http.StatusOK},
+		{"prometheus", "wrong", http.StatusUnauthorized},
+		{"grafana", "s3cret", http.StatusUnauthorized},
+		{"", "", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if c.user != "" {
+			req.SetBasicAuth(c.user, c.password)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != c.expected {
+			t.Errorf("%s:%s: expected %d, got %d", c.user, c.password, c.expected, rec.Code)
+		}
+	}
+}
+
+// writeCertificate self signed for 127.0.0.1 in dir, returning the paths of the certificate and its key
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "twemproxy_exporter"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath, keyPath := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certPath, keyPath
+}
+
+func TestAuthenticatedClientAuthType(t *testing.T) {
+	certPath, keyPath := writeCertificate(t, t.TempDir())
+	for authType, expected := range map[string]bool{
+		"":                           true,
+		"RequireAndVerifyClientCert": true,
+		"VerifyClientCertIfGiven":    false,
+		"RequestClientCert":          false,
+		"NoClientCert":               false,
+	} {
+		conf := WebConfig{TLSServerConfig: &TLSServerConfig{CertFile: certPath, KeyFile: keyPath, ClientCAFile: certPath, ClientAuthType: authType}}
+		if conf.authenticated() != expected {
+			t.Errorf("%q: expected authenticated %t", authType, expected)
+		}
+	}
+}
+
+func TestClientCertIfGivenForbidden(t *testing.T) {
+	certPath, keyPath := writeCertificate(t, t.TempDir())
+	conf := WebConfig{TLSServerConfig: &TLSServerConfig{CertFile: certPath, KeyFile: keyPath, ClientCAFile: certPath, ClientAuthType: "VerifyClientCertIfGiven"}}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	scheduler := NewScheduler(nil, time.Hour, nil)
+	go serveHTTP(listener, scrapeHandler(scheduler, conf.authenticated(), time.Second), conf)
+
+	// the handshake succeed without a certificate, the admin endpoint must still refuse the request
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Post("https://"+listener.Addr().String()+"/-/scrape", "", nil)
+	if err != nil {
+		t.Fatal("Failed to send the request: ", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected a request without certificate refused, got %d", resp.StatusCode)
+	}
+}
+
+func TestRequireClientCert(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath := writeCertificate(t, dir)
+	path := filepath.Join(dir, "web.yml")
+	ioutil.WriteFile(path, []byte("tls_server_config:\n  cert_file: "+certPath+"\n  key_file: "+keyPath+"\n  client_ca_file: "+certPath+"\n"), 0600)
+	conf, err := LoadWebConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serveHTTP(listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), conf)
+
+	ca, _ := ioutil.ReadFile(certPath)
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca)
+	url := "https://" + listener.Addr().String() + "/metrics"
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	if resp, err := client.Get(url); err == nil {
+		resp.Body.Close()
+		t.Errorf("Expected the handshake without client certificate to fail, got %d", resp.StatusCode)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{cert}}}}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal("Expected the client certificate to be accepted: ", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 with the client certificate, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/yaml.v2"
+)
+
+// ZabbixConfig of the items sent to a Zabbix server or proxy with the sender protocol
+//
+//	server: zabbix-proxy:10051
+//	host: edge-1-twemproxy
+//	items:
+//	  - metric: twemproxy_up
+//	    key: twemproxy.up[{instance}]
+//	  - metric: twemproxy_pool_client_connections
+//	    key: twemproxy.pool.connections[{instance},{group}]
+type ZabbixConfig struct {
+	Server  string        `yaml:"server"`
+	Host    string        `yaml:"host"`
+	Timeout time.Duration `yaml:"timeout"`
+	Items   []ZabbixItem  `yaml:"items"`
+}
+
+// ZabbixItem map every series of a metric to an item, {label} in the key and host is replaced by the value
+// of the label of the series
+type ZabbixItem struct {
+	Metric string `yaml:"metric"`
+	Key    string `yaml:"key"`
+	Host   string `yaml:"host"` // host of the config when empty
+}
+
+// LoadZabbixConfig from yaml
+func LoadZabbixConfig(path string) (ZabbixConfig, error) {
+	conf := ZabbixConfig{}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return conf, fmt.Errorf("Cannot open: %s. Error: %s", path, err.Error())
+	}
+	err = yaml.Unmarshal(content, &conf)
+	if err != nil {
+		return conf, err
+	}
+	if conf.Server == "" {
+		return conf, fmt.Errorf("Zabbix without server in %s", path)
+	}
+	if _, _, err := net.SplitHostPort(conf.Server); err != nil {
+		conf.Server = net.JoinHostPort(conf.Server, "10051")
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = time.Second * 10
+	}
+	if len(conf.Items) == 0 {
+		return conf, fmt.Errorf("Zabbix without items in %s", path)
+	}
+	for _, item := range conf.Items {
+		if item.Metric == "" || item.Key == "" {
+			return conf, fmt.Errorf("Zabbix item without metric or key in %s", path)
+		}
+		if item.Host == "" && conf.Host == "" {
+			return conf, fmt.Errorf("Zabbix item %s without host in %s", item.Key, path)
+		}
+	}
+	return conf, nil
+}
+
+// zabbixValue of the sender data request
+type zabbixValue struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+// zabbixLabel in the key and host templates
+var zabbixLabel = regexp.MustCompile(`\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+
+// zabbixValues of the gathered metrics mapped by the items, histograms and summaries are skipped
+func (c ZabbixConfig) zabbixValues(families []*dto.MetricFamily, now time.Time) []zabbixValue {
+	byName := make(map[string]*dto.MetricFamily, len(families))
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+	var values []zabbixValue
+	for _, item := range c.Items {
+		family, ok := byName[item.Metric]
+		if !ok {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			var value float64
+			switch {
+			case metric.Gauge != nil:
+				value = metric.GetGauge().GetValue()
+			case metric.Counter != nil:
+				value = metric.GetCounter().GetValue()
+			case metric.Untyped != nil:
+				value = metric.GetUntyped().GetValue()
+			default:
+				continue
+			}
+			labels := make(map[string]string, len(metric.GetLabel()))
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			host := item.Host
+			if host == "" {
+				host = c.Host
+			}
+			values = append(values, zabbixValue{
+				Host:  expandZabbix(host, labels, false),
+				Key:   expandZabbix(item.Key, labels, true),
+				Value: strconv.FormatFloat(value, 'f', -1, 64),
+				Clock: now.Unix(),
+			})
+		}
+	}
+	return values
+}
+
+// expandZabbix replace {label} by its value, quoted as an item key parameter when needed
+func expandZabbix(template string, labels map[string]string, key bool) string {
+	return zabbixLabel.ReplaceAllStringFunc(template, func(match string) string {
+		value := labels[match[1:len(match)-1]]
+		if key && strings.ContainsAny(value, `,[]" `) {
+			return `"` + strings.Replace(value, `"`, `\"`, -1) + `"`
+		}
+		return value
+	})
+}
+
+// zabbixResponse of the server, info is e.g. "processed: 3; failed: 0; total: 3; seconds spent: 0.000055"
+type zabbixResponse struct {
+	Response string `json:"response"`
+	Info     string `json:"info"`
+}
+
+// sendZabbix the values with one sender data request
+func sendZabbix(server string, timeout time.Duration, values []zabbixValue, now time.Time) (zabbixResponse, error) {
+	resp := zabbixResponse{}
+	payload, err := json.Marshal(map[string]interface{}{"request": "sender data", "data": values, "clock": now.Unix()})
+	if err != nil {
+		return resp, err
+	}
+	conn, err := net.DialTimeout("tcp", server, timeout)
+	if err != nil {
+		return resp, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	_, err = conn.Write(zabbixPacket(payload))
+	if err != nil {
+		return resp, err
+	}
+	header := make([]byte, 13)
+	_, err = io.ReadFull(conn, header)
+	if err != nil {
+		return resp, err
+	}
+	if !bytes.HasPrefix(header, []byte("ZBXD")) {
+		return resp, fmt.Errorf("Invalid response header from %s", server)
+	}
+	length := binary.LittleEndian.Uint64(header[5:])
+	if length > 1<<20 {
+		return resp, fmt.Errorf("Invalid response size %d from %s", length, server)
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(conn, body)
+	if err != nil {
+		return resp, err
+	}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return resp, fmt.Errorf("Cannot decode response from %s. Error: %w", server, err)
+	}
+	if resp.Response != "success" {
+		return resp, fmt.Errorf("Zabbix %s returned %s: %s", server, resp.Response, resp.Info)
+	}
+	return resp, nil
+}
+
+// zabbixPacket with the header of the protocol, ZBXD, flags and the little endian length
+func zabbixPacket(payload []byte) []byte {
+	packet := make([]byte, 13, 13+len(payload))
+	copy(packet, "ZBXD\x01")
+	binary.LittleEndian.PutUint64(packet[5:], uint64(len(payload)))
+	return append(packet, payload...)
+}
+
+// sendZabbixEvery interval the mapped metrics of the gatherer
+func sendZabbixEvery(conf ZabbixConfig, gatherer prometheus.Gatherer, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		families, err := gatherer.Gather()
+		if err != nil {
+			log.Printf("Cannot gather metrics for Zabbix. Error: %s", err.Error())
+		}
+		now := time.Now()
+		values := conf.zabbixValues(families, now)
+		if len(values) == 0 {
+			continue
+		}
+		resp, err := sendZabbix(conf.Server, conf.Timeout, values, now)
+		if err != nil {
+			log.Printf("Cannot send %d values to Zabbix %s. Error: %s", len(values), conf.Server, err.Error())
+			continue
+		}
+		// items unknown to Zabbix are only reported as failed
+		if !strings.Contains(resp.Info, "failed: 0;") {
+			log.Printf("Zabbix %s did not accept every value: %s", conf.Server, resp.Info)
+		}
+	}
+}
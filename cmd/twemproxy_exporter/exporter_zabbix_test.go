@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestZabbixValues(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	connections := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "twemproxy_pool_client_connections", Help: "help"}, []string{"instance", "group"})
+	connections.WithLabelValues("proxy-1", "alpha").Set(12)
+	connections.WithLabelValues("proxy-1", "beta, gamma").Set(3)
+	registry.MustRegister(connections)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf := ZabbixConfig{Host: "edge-1", Items: []ZabbixItem{
+		{Metric: "twemproxy_pool_client_connections", Key: "twemproxy.pool.connections[{group}]", Host: "{instance}-twemproxy"},
+		{Metric: "twemproxy_up", Key: "twemproxy.up"},
+	}}
+	now := time.Unix(1700000000, 0)
+	values := conf.zabbixValues(families, now)
+	expected := []zabbixValue{
+		{Host: "proxy-1-twemproxy", Key: "twemproxy.pool.connections[alpha]", Value: "12", Clock: now.Unix()},
+		{Host: "proxy-1-twemproxy", Key: `twemproxy.pool.connections["beta, gamma"]`, Value: "3", Clock: now.Unix()},
+	}
+	if len(values) != len(expected) {
+		t.Fatalf("Expected %d values, got %+v", len(expected), values)
+	}
+	for i := range expected {
+		if values[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], values[i])
+		}
+	}
+}
+
+func TestSendZabbix(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan map[string]interface{}, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		header := make([]byte, 13)
+		io.ReadFull(conn, header)
+		body := make([]byte, binary.LittleEndian.Uint64(header[5:]))
+		io.ReadFull(conn, body)
+		request := map[string]interface{}{}
+		json.Unmarshal(body, &request)
+		received <- request
+		conn.Write(zabbixPacket([]byte(`{"response":"success","info":"processed: 1; failed: 0; total: 1; seconds spent: 0.000055"}`)))
+	}()
+
+	now := time.Now()
+	resp, err := sendZabbix(l.Addr().String(), time.Second*5, []zabbixValue{{Host: "edge-1", Key: "twemproxy.up", Value: "1", Clock: now.Unix()}}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Info != "processed: 1; failed: 0; total: 1; seconds spent: 0.000055" {
+		t.Errorf("Unexpected info %s", resp.Info)
+	}
+	request := <-received
+	data, _ := request["data"].([]interface{})
+	if request["request"] != "sender data" || len(data) != 1 || data[0].(map[string]interface{})["key"] != "twemproxy.up" {
+		t.Errorf("Unexpected request %+v", request)
+	}
+}
+
+func TestLoadZabbixConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "zabbix.yml")
+	ioutil.WriteFile(path, []byte("server: zabbix\nhost: edge-1\nitems:\n  - metric: twemproxy_up\n    key: twemproxy.up\n"), 0644)
+	conf, err := LoadZabbixConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Server != "zabbix:10051" || conf.Timeout != time.Second*10 {
+		t.Errorf("Expected the default port and timeout, got %+v", conf)
+	}
+
+	ioutil.WriteFile(path, []byte("server: zabbix\nitems:\n  - metric: twemproxy_up\n    key: twemproxy.up\n"), 0644)
+	if _, err := LoadZabbixConfig(path); err == nil {
+		t.Error("Expected an error for an item without host")
+	}
+}
@@ -0,0 +1,18 @@
+module github.com/albert-widi/twemproxy_exporter
+
+go 1.21
+
+require (
+	github.com/prometheus/client_golang v0.9.4
+	github.com/prometheus/client_model v0.0.0-20190129233127-fd36f4220a90
+	github.com/prometheus/common v0.4.1
+	golang.org/x/sys v0.30.0
+	gopkg.in/yaml.v2 v2.2.8
+)
+
+require (
+	github.com/beorn7/perks v1.0.0 // indirect
+	github.com/golang/protobuf v1.3.1 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/procfs v0.0.2 // indirect
+)
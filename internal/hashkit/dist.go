@@ -0,0 +1,110 @@
+package hashkit
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// ketama settings of nutcracker
+const (
+	ketamaPointsPerServer = 160
+	ketamaPointsPerHash   = 4
+)
+
+type point struct {
+	value  uint32
+	server int
+}
+
+// Pool dispatch the keys to the servers like nutcracker, with every server live
+type Pool struct {
+	hash         HashFunc
+	distribution string
+	hashTag      []byte
+	continuum    []point
+	servers      int
+}
+
+// NewPool of the hash, distribution and hash_tag settings of a pool, and its servers in config order
+func NewPool(c config.Config) (*Pool, error) {
+	hash, err := Hash(c.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Servers) == 0 {
+		return nil, fmt.Errorf("Pool %s has no server", c.ConfigName)
+	}
+	p := &Pool{hash: hash, distribution: c.Distribution, hashTag: []byte(c.HashTag), servers: len(c.Servers)}
+	switch c.Distribution {
+	case "ketama":
+		p.continuum = ketamaContinuum(c.Servers)
+	case "modula":
+		// one point per weight
+		for i, s := range c.Servers {
+			for w := 0; w < s.Weight; w++ {
+				p.continuum = append(p.continuum, point{server: i})
+			}
+		}
+		if len(p.continuum) == 0 {
+			return nil, fmt.Errorf("Pool %s has no server with a weight", c.ConfigName)
+		}
+	case "random":
+	default:
+		return nil, fmt.Errorf("Unknown distribution %s", c.Distribution)
+	}
+	return p, nil
+}
+
+func ketamaContinuum(servers []config.Server) []point {
+	total := 0
+	for _, s := range servers {
+		total += s.Weight
+	}
+	var continuum []point
+	for i, s := range servers {
+		pct := float32(s.Weight) / float32(total)
+		points := int(math.Floor(float64(pct*ketamaPointsPerServer/ketamaPointsPerHash*float32(len(servers)))+0.0000000001)) * ketamaPointsPerHash
+		for index := 0; index < points/ketamaPointsPerHash; index++ {
+			sum := md5.Sum([]byte(fmt.Sprintf("%s-%d", s.Name(), index)))
+			for x := 0; x < ketamaPointsPerHash; x++ {
+				continuum = append(continuum, point{value: binary.LittleEndian.Uint32(sum[x*4:]), server: i})
+			}
+		}
+	}
+	sort.SliceStable(continuum, func(i, j int) bool {
+		return continuum[i].value < continuum[j].value
+	})
+	return continuum
+}
+
+// Server of the key, the index in the servers of the pool. Keys of the random distribution go to a random server
+func (p *Pool) Server(key []byte) int {
+	if len(p.hashTag) == 2 {
+		if start := bytes.IndexByte(key, p.hashTag[0]); start >= 0 {
+			if end := bytes.IndexByte(key[start+1:], p.hashTag[1]); end > 0 {
+				key = key[start+1 : start+1+end]
+			}
+		}
+	}
+	switch p.distribution {
+	case "random":
+		return rand.Intn(p.servers)
+	case "modula":
+		return p.continuum[p.hash(key)%uint32(len(p.continuum))].server
+	}
+	hash := p.hash(key)
+	i := sort.Search(len(p.continuum), func(i int) bool {
+		return p.continuum[i].value >= hash
+	})
+	if i == len(p.continuum) {
+		i = 0
+	}
+	return p.continuum[i].server
+}
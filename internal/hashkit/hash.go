@@ -0,0 +1,265 @@
+// Package hashkit is the key hashing and distribution of nutcracker, to tell which server of a pool a key goes to
+package hashkit
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+)
+
+// HashFunc of a key, the hash settings of a pool
+type HashFunc func(key []byte) uint32
+
+var hashes = map[string]HashFunc{
+	"one_at_a_time": oneAtATime,
+	"md5":           hashMD5,
+	"crc16":         hashCRC16,
+	"crc32":         hashCRC32,
+	"crc32a":        crc32.ChecksumIEEE,
+	"fnv1_64":       fnv164,
+	"fnv1a_64":      fnv1a64,
+	"fnv1_32":       fnv132,
+	"fnv1a_32":      fnv1a32,
+	"hsieh":         hsieh,
+	"murmur":        murmur,
+	"jenkins":       jenkins,
+}
+
+// Hash by nutcracker name
+func Hash(name string) (HashFunc, error) {
+	fn, ok := hashes[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown hash %s", name)
+	}
+	return fn, nil
+}
+
+// signed is a char of nutcracker, signed on the platforms it runs on, as some hashes widen it
+func signed(b byte) uint32 {
+	return uint32(int32(int8(b)))
+}
+
+func oneAtATime(key []byte) uint32 {
+	var value uint32
+	for _, b := range key {
+		value += signed(b)
+		value += value << 10
+		value ^= value >> 6
+	}
+	value += value << 3
+	value ^= value >> 11
+	value += value << 15
+	return value
+}
+
+func hashMD5(key []byte) uint32 {
+	sum := md5.Sum(key)
+	return binary.LittleEndian.Uint32(sum[:4])
+}
+
+// crc16Table of the XMODEM polynomial
+var crc16Table = func() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc & 0xffff
+	}
+	return table
+}()
+
+// hashCRC16 keep the bits shifted past 16 like nutcracker does
+func hashCRC16(key []byte) uint32 {
+	var crc uint32
+	for _, b := range key {
+		crc = crc<<8 ^ crc16Table[(crc>>8^uint32(b))&0xff]
+	}
+	return crc
+}
+
+// hashCRC32 is the 15 bits of libmemcached
+func hashCRC32(key []byte) uint32 {
+	return crc32.ChecksumIEEE(key) >> 16 & 0x7fff
+}
+
+const (
+	fnv64Init  = 0xcbf29ce484222325
+	fnv64Prime = 0x100000001b3
+	fnv32Init  = 2166136261
+	fnv32Prime = 16777619
+)
+
+func fnv164(key []byte) uint32 {
+	var hash uint64 = fnv64Init
+	for _, b := range key {
+		hash *= fnv64Prime
+		hash ^= uint64(int64(int8(b)))
+	}
+	return uint32(hash)
+}
+
+// fnv1a64 of nutcracker is 32 bits, with the 64 bits constants truncated
+func fnv1a64(key []byte) uint32 {
+	hash := uint32(fnv64Init & 0xffffffff)
+	for _, b := range key {
+		hash ^= signed(b)
+		hash *= uint32(fnv64Prime & 0xffffffff)
+	}
+	return hash
+}
+
+func fnv132(key []byte) uint32 {
+	var hash uint32 = fnv32Init
+	for _, b := range key {
+		hash *= fnv32Prime
+		hash ^= signed(b)
+	}
+	return hash
+}
+
+func fnv1a32(key []byte) uint32 {
+	var hash uint32 = fnv32Init
+	for _, b := range key {
+		hash ^= signed(b)
+		hash *= fnv32Prime
+	}
+	return hash
+}
+
+// hsieh is SuperFastHash of Paul Hsieh, reading 16 bits words in little endian
+func hsieh(key []byte) uint32 {
+	if len(key) == 0 {
+		return 0
+	}
+	var hash uint32
+	rem := len(key) & 3
+	for n := len(key) >> 2; n > 0; n-- {
+		hash += uint32(binary.LittleEndian.Uint16(key))
+		tmp := uint32(binary.LittleEndian.Uint16(key[2:]))<<11 ^ hash
+		hash = hash<<16 ^ tmp
+		key = key[4:]
+		hash += hash >> 11
+	}
+	switch rem {
+	case 3:
+		hash += uint32(binary.LittleEndian.Uint16(key))
+		hash ^= hash << 16
+		hash ^= signed(key[2]) << 18
+		hash += hash >> 11
+	case 2:
+		hash += uint32(binary.LittleEndian.Uint16(key))
+		hash ^= hash << 11
+		hash += hash >> 17
+	case 1:
+		hash += uint32(key[0])
+		hash ^= hash << 10
+		hash += hash >> 1
+	}
+	hash ^= hash << 3
+	hash += hash >> 5
+	hash ^= hash << 4
+	hash += hash >> 17
+	hash ^= hash << 25
+	hash += hash >> 6
+	return hash
+}
+
+// murmur is MurmurHash2 seeded with the length like libmemcached
+func murmur(key []byte) uint32 {
+	const m = 0x5bd1e995
+	length := uint32(len(key))
+	seed := 0xdeadbeef * length
+	h := seed ^ length
+	for len(key) >= 4 {
+		k := binary.LittleEndian.Uint32(key)
+		k *= m
+		k ^= k >> 24
+		k *= m
+		h *= m
+		h ^= k
+		key = key[4:]
+	}
+	switch len(key) {
+	case 3:
+		h ^= uint32(key[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(key[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(key[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// jenkinsInitval of libmemcached
+const jenkinsInitval = 13
+
+func jenkins(key []byte) uint32 {
+	return hashLittle(key, jenkinsInitval)
+}
+
+// hashLittle is lookup3 of Bob Jenkins, on a little endian host
+func hashLittle(key []byte, initval uint32) uint32 {
+	a := 0xdeadbeef + uint32(len(key)) + initval
+	b, c := a, a
+	for len(key) > 12 {
+		a += binary.LittleEndian.Uint32(key)
+		b += binary.LittleEndian.Uint32(key[4:])
+		c += binary.LittleEndian.Uint32(key[8:])
+		a -= c
+		a ^= bits.RotateLeft32(c, 4)
+		c += b
+		b -= a
+		b ^= bits.RotateLeft32(a, 6)
+		a += c
+		c -= b
+		c ^= bits.RotateLeft32(b, 8)
+		b += a
+		a -= c
+		a ^= bits.RotateLeft32(c, 16)
+		c += b
+		b -= a
+		b ^= bits.RotateLeft32(a, 19)
+		a += c
+		c -= b
+		c ^= bits.RotateLeft32(b, 4)
+		b += a
+		key = key[12:]
+	}
+	if len(key) == 0 {
+		return c
+	}
+	// the last 1 to 12 bytes, zero padded
+	var tail [12]byte
+	copy(tail[:], key)
+	a += binary.LittleEndian.Uint32(tail[:])
+	b += binary.LittleEndian.Uint32(tail[4:])
+	c += binary.LittleEndian.Uint32(tail[8:])
+	c ^= b
+	c -= bits.RotateLeft32(b, 14)
+	a ^= c
+	a -= bits.RotateLeft32(c, 11)
+	b ^= a
+	b -= bits.RotateLeft32(a, 25)
+	c ^= b
+	c -= bits.RotateLeft32(b, 16)
+	a ^= c
+	a -= bits.RotateLeft32(c, 4)
+	b ^= a
+	b -= bits.RotateLeft32(a, 14)
+	c ^= b
+	c -= bits.RotateLeft32(b, 24)
+	return c
+}
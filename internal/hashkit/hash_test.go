@@ -0,0 +1,105 @@
+package hashkit
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestHashes(t *testing.T) {
+	tests := []struct {
+		hash     string
+		key      string
+		expected uint32
+	}{
+		{"one_at_a_time", "a", 0xca2e9442},
+		{"crc32a", "123456789", 0xcbf43926},
+		{"crc32", "123456789", 0xcbf43926 >> 16 & 0x7fff},
+		{"fnv1_32", "a", 0x050c5d7e},
+		{"fnv1a_32", "a", 0xe40c292c},
+		{"fnv1a_32", "", 0x811c9dc5},
+		{"murmur", "", 0},
+	}
+	for _, test := range tests {
+		fn, err := Hash(test.hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fn([]byte(test.key)); got != test.expected {
+			t.Errorf("Expected %s(%q) %#x, got %#x", test.hash, test.key, test.expected, got)
+		}
+	}
+	if got := hashCRC16([]byte("123456789")) & 0xffff; got != 0x31c3 {
+		t.Errorf("Expected the XMODEM crc16 in the low bits, got %#x", got)
+	}
+	// lookup3 test vectors
+	if got := hashLittle([]byte("Four score and seven years ago"), 0); got != 0x17770551 {
+		t.Errorf("Expected lookup3 0x17770551, got %#x", got)
+	}
+	if got := hashLittle([]byte("Four score and seven years ago"), 1); got != 0xcd628161 {
+		t.Errorf("Expected lookup3 0xcd628161, got %#x", got)
+	}
+	if _, err := Hash("sha1"); err == nil {
+		t.Error("Expected an error for an unknown hash")
+	}
+}
+
+func testServers(n int) []config.Server {
+	servers := make([]config.Server, n)
+	for i := range servers {
+		servers[i] = config.Server{Host: fmt.Sprintf("10.0.0.%d", i+1), Port: "6379", Weight: 1}
+	}
+	return servers
+}
+
+func TestPoolDistribution(t *testing.T) {
+	for _, distribution := range []string{"ketama", "modula", "random"} {
+		for _, hash := range []string{"fnv1a_64", "md5", "murmur", "jenkins", "hsieh", "one_at_a_time", "crc16"} {
+			p, err := NewPool(config.Config{Hash: hash, Distribution: distribution, Servers: testServers(4)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts := make([]int, 4)
+			for i := 0; i < 40000; i++ {
+				counts[p.Server([]byte(fmt.Sprintf("user:%d", i)))]++
+			}
+			for i, n := range counts {
+				// ketama is within a few percent of an even share
+				if math.Abs(float64(n)/10000-1) > 0.3 {
+					t.Errorf("Expected about 10000 keys on server %d with %s %s, got %v", i, distribution, hash, counts)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestPoolKetamaMoves(t *testing.T) {
+	before, _ := NewPool(config.Config{Hash: "fnv1a_64", Distribution: "ketama", Servers: testServers(4)})
+	after, _ := NewPool(config.Config{Hash: "fnv1a_64", Distribution: "ketama", Servers: testServers(5)})
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		key := []byte(fmt.Sprintf("user:%d", i))
+		if b, a := before.Server(key), after.Server(key); b != a {
+			moved++
+			if a != 4 {
+				t.Fatalf("Expected keys to only move to the new server, %s moved from %d to %d", key, b, a)
+			}
+		}
+	}
+	if moved < 1000 || moved > 3000 {
+		t.Errorf("Expected about a fifth of the keys to move, got %d", moved)
+	}
+}
+
+func TestPoolHashTag(t *testing.T) {
+	p, _ := NewPool(config.Config{Hash: "fnv1a_64", Distribution: "ketama", HashTag: "{}", Servers: testServers(8)})
+	server := p.Server([]byte("{user:1}:profile"))
+	for _, key := range []string{"{user:1}:sessions", "cart:{user:1}", "user:1"} {
+		if got := p.Server([]byte(key)); got != server {
+			t.Errorf("Expected %s on server %d with its tag, got %d", key, server, got)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+// Package testutil hold the helpers shared by the tests of the exporter and its packages
+package testutil
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+// ExamplePool of files/example.json, its servers are alpha and beta
+const ExamplePool = "wallet-oauth-token"
+
+// File path of a file of the files directory at the root of the repository
+func File(name string) string {
+	_, self, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(self), "..", "..", "files", name)
+}
+
+// ExampleStats of files/example.json parsed with files/nutcracker.yml. The servers in down have no connection
+// and count as not available, like twemproxy report them once the connections are lost
+func ExampleStats(t testing.TB, down ...string) stats.TwemproxyStats {
+	t.Helper()
+	conf, err := config.LoadConfig(File("nutcracker.yml"))
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	payload, err := ioutil.ReadFile(File("example.json"))
+	if err != nil {
+		t.Fatal("Failed to read json example: ", err.Error())
+	}
+	st, err := stats.Parse(payload, conf)
+	if err != nil {
+		t.Fatal("Failed to parse json example: ", err.Error())
+	}
+	pool := st.Services[ExamplePool]
+	for _, name := range down {
+		server, ok := pool.Servers[name]
+		if !ok {
+			t.Fatalf("No server %s in the example", name)
+		}
+		server.ServerConnections = 0
+		pool.Servers[name] = server
+		pool.NotAvailable++
+		st.NotAvailable++
+	}
+	st.Services[ExamplePool] = pool
+	return st
+}
+
+// GatherValue of the first series of the metric name collected by c
+func GatherValue(t testing.TB, c prometheus.Collector, name string) float64 {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			metric := family.GetMetric()[0]
+			if metric.GetCounter() != nil {
+				return metric.GetCounter().GetValue()
+			}
+			return metric.GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("%s not gathered", name)
+	return 0
+}
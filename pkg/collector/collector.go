@@ -0,0 +1,228 @@
+package collector
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+	"github.com/albert-widi/twemproxy_exporter/pkg/twemproxy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector scrape one twemproxy on every collect, for programs embedding the exporter metrics in their own registry
+type Collector struct {
+	client           *twemproxy.Client
+	conf             map[string]config.Config
+	instance         string
+	logger           *log.Logger
+	constLabels      prometheus.Labels
+	serverLabels     ServerLabels
+	twemproxyMetrics Metrics
+	statusMetrics    Metrics
+	poolInfo         *prometheus.GaugeVec
+	serverMetrics    Metrics
+	exported         []prometheus.Collector // the metrics not vetoed
+	serverMappers    []ServerMapper
+	poolMappers      []PoolMapper
+	vetoes           []func(name string) bool
+	onStart          []func(ctx context.Context, instance string) context.Context
+	onSuccess        []func(ctx context.Context, instance string, duration time.Duration)
+	onError          []func(ctx context.Context, instance string, duration time.Duration, err error)
+	mu               sync.Mutex
+}
+
+// Option of the collector
+type Option func(*Collector)
+
+// WithLabels attached to every metric of the collector, like the labels of a target of the exporter
+func WithLabels(labels prometheus.Labels) Option {
+	return func(c *Collector) {
+		c.constLabels = labels
+	}
+}
+
+// WithServerLabel rename the label of the backend servers, DefaultServerLabel by default
+func WithServerLabel(name string) Option {
+	return func(c *Collector) {
+		c.serverLabels.Server = name
+	}
+}
+
+// WithConfiguredLabel add the configured label to the server metrics, "false" on the servers in the stats but not in
+// the config which are only exported with it
+func WithConfiguredLabel(enabled bool) Option {
+	return func(c *Collector) {
+		c.serverLabels.Configured = enabled
+	}
+}
+
+// WithInstance value of the instance label, the address of the client by default
+func WithInstance(instance string) Option {
+	return func(c *Collector) {
+		c.instance = instance
+	}
+}
+
+// WithTimeout of every fetch, the timeout of the client by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Collector) {
+		c.client.Timeout = timeout
+	}
+}
+
+// WithSource of the stats, the source of the client by default
+func WithSource(source twemproxy.StatsSource) Option {
+	return func(c *Collector) {
+		c.client.Source = source
+	}
+}
+
+// WithLogger of the failed scrapes, the standard logger by default
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Collector) {
+		c.logger = logger
+	}
+}
+
+// OnScrapeStart call fn before every scrape, the returned context is the one of the fetch and of the other callbacks
+// of the scrape, e.g. carrying a tracing span
+func OnScrapeStart(fn func(ctx context.Context, instance string) context.Context) Option {
+	return func(c *Collector) {
+		c.onStart = append(c.onStart, fn)
+	}
+}
+
+// OnScrapeSuccess call fn after every scrape fetched and parsed, with how long it took
+func OnScrapeSuccess(fn func(ctx context.Context, instance string, duration time.Duration)) Option {
+	return func(c *Collector) {
+		c.onSuccess = append(c.onSuccess, fn)
+	}
+}
+
+// OnScrapeError call fn after every failed scrape, err wrap the errors of twemproxy.Client
+func OnScrapeError(fn func(ctx context.Context, instance string, duration time.Duration, err error)) Option {
+	return func(c *Collector) {
+		c.onError = append(c.onError, fn)
+	}
+}
+
+// New collector of the twemproxy of client, the pools of conf are the ones of the nutcracker config.
+// The options change a copy of client
+func New(client *twemproxy.Client, conf map[string]config.Config, opts ...Option) prometheus.Collector {
+	copied := *client
+	c := &Collector{
+		client:   &copied,
+		conf:     conf,
+		instance: client.Address,
+		logger:   log.Default(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.twemproxyMetrics = NewTwemproxyMetrics(c.constLabels)
+	c.statusMetrics = Metrics{"up": NewStatusMetrics(c.constLabels)["up"]}
+	c.poolInfo = NewPoolInfoMetric(c.constLabels)
+	c.serverMetrics = NewServerMetrics(c.constLabels, c.serverLabels)
+	// by the names the metrics are built with
+	c.export(upMetricName, c.statusMetrics["up"])
+	for _, def := range twemproxyMetricDefs {
+		c.export(twemproxyMetricName(def.name), c.twemproxyMetrics[def.key])
+	}
+	c.export(poolInfoMetricName, c.poolInfo)
+	for _, def := range serverMetricDefs {
+		c.export(serverMetricName(def.name), c.serverMetrics[def.key])
+	}
+	return c
+}
+
+// Describe the default metrics, the samples of the mappers are unchecked
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, metric := range c.exported {
+		metric.Describe(ch)
+	}
+}
+
+// Collect scrape the stats and send the metrics
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.twemproxyMetrics.Reset()
+	c.poolInfo.Reset()
+	c.serverMetrics.Reset()
+	ctx := context.Background()
+	for _, fn := range c.onStart {
+		ctx = fn(ctx, c.instance)
+	}
+	start := time.Now()
+	up := 1.0
+	s, err := c.update(ctx)
+	duration := time.Since(start)
+	if err != nil {
+		c.logger.Printf("Cannot scrape %s. Error: %s", c.client.Address, err.Error())
+		up = 0
+		for _, fn := range c.onError {
+			fn(ctx, c.instance, duration, err)
+		}
+	} else {
+		for _, fn := range c.onSuccess {
+			fn(ctx, c.instance, duration)
+		}
+	}
+	c.statusMetrics["up"].WithLabelValues(c.instance).Set(up)
+	for _, metric := range c.exported {
+		metric.Collect(ch)
+	}
+	if err == nil {
+		c.collectMapped(ch, s)
+	}
+}
+
+func (c *Collector) update(ctx context.Context) (stats.TwemproxyStats, error) {
+	reply, err := c.client.FetchRaw(ctx)
+	if err != nil {
+		return stats.TwemproxyStats{}, err
+	}
+	s, err := stats.Parse(reply, c.conf)
+	if err != nil {
+		return stats.TwemproxyStats{}, err
+	}
+	Update(c.instance, s, c.twemproxyMetrics, c.serverMetrics, c.serverLabels)
+	for name, pool := range c.conf {
+		c.poolInfo.WithLabelValues(c.instance, name, pool.Protocol, pool.Listen, pool.Hash, pool.HashTag, pool.Distribution, strconv.Itoa(pool.Timeout)).Set(1)
+	}
+	return s, nil
+}
+
+// Update the twemproxy and server metrics from the stats of one scrape, the server metrics have the labels of labels
+func Update(instance string, s stats.TwemproxyStats, twemproxyMetrics, serverMetrics Metrics, labels ServerLabels) {
+	twemproxyMetrics["total_connections"].WithLabelValues(instance).Set(s.TotalConnections)
+	twemproxyMetrics["current_connections"].WithLabelValues(instance).Set(s.CurrentConnections)
+	for serviceName, service := range s.Services {
+		for _, server := range service.Servers {
+			updateServer(serverMetrics, labels.Values(instance, serviceName, server), server)
+		}
+		if !labels.Configured {
+			continue
+		}
+		for _, server := range service.Unknown {
+			updateServer(serverMetrics, labels.Values(instance, serviceName, server), server)
+		}
+	}
+}
+
+func updateServer(serverMetrics Metrics, labels []string, server stats.ServerStats) {
+	serverMetrics["in_queue"].WithLabelValues(labels...).Set(server.InQueue)
+	serverMetrics["in_queue_bytes"].WithLabelValues(labels...).Set(server.InQueueBytes)
+	serverMetrics["timed_out"].WithLabelValues(labels...).Set(server.ServerTimedout)
+	serverMetrics["server_connection"].WithLabelValues(labels...).Set(server.ServerConnections)
+	serverMetrics["server_ejected_at"].WithLabelValues(labels...).Set(server.ServerEjectedAt)
+	up := 0.0
+	if server.Up() {
+		up = 1
+	}
+	serverMetrics["up"].WithLabelValues(labels...).Set(up)
+}
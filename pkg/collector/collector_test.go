@@ -0,0 +1,265 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+	"github.com/albert-widi/twemproxy_exporter/pkg/twemproxy"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serveStats write payload to every connection, like the stats port of twemproxy
+func serveStats(t *testing.T, payload []byte) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write(payload)
+			conn.Close()
+		}
+	}()
+	return listener
+}
+
+func gauges(t *testing.T, c prometheus.Collector) map[string]float64 {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			name := family.GetName()
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == DefaultServerLabel || label.GetName() == "server" {
+					name += "/" + label.GetValue()
+				}
+			}
+			values[name] = metric.GetGauge().GetValue()
+		}
+	}
+	return values
+}
+
+func TestCollector(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to read config: ", err.Error())
+	}
+	payload, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Fatal("Failed to read json example: ", err.Error())
+	}
+	listener := serveStats(t, payload)
+	defer listener.Close()
+
+	c := New(twemproxy.NewClient(listener.Addr().String()), conf, WithLabels(prometheus.Labels{"site": "test"}), WithInstance("proxy-1"))
+	values := gauges(t, c)
+	if values["twemproxy_up"] != 1 {
+		t.Errorf("Expected twemproxy_up 1, got %v", values)
+	}
+	if values["twemproxy_service_total_connections"] != 64592 {
+		t.Errorf("Expected 64592 total connections, got %f", values["twemproxy_service_total_connections"])
+	}
+	if values["twemproxy_server_in_queue/redis2:6379:1"] != 1 {
+		t.Errorf("Expected 1 in queue on redis2, got %f", values["twemproxy_server_in_queue/redis2:6379:1"])
+	}
+
+	listener.Close()
+	values = gauges(t, New(twemproxy.NewClient(listener.Addr().String()), conf))
+	if values["twemproxy_up"] != 0 || len(values) != 1 {
+		t.Errorf("Expected only twemproxy_up 0 without twemproxy, got %v", values)
+	}
+}
+
+func TestCollectorMappers(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to read config: ", err.Error())
+	}
+	payload, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Fatal("Failed to read json example: ", err.Error())
+	}
+	listener := serveStats(t, payload)
+	defer listener.Close()
+
+	queued := func(pool string, server stats.ServerStats) []Sample {
+		return []Sample{{
+			Name:   "acme_server_queued",
+			Help:   "Requests in and out queue",
+			Labels: prometheus.Labels{"tier": "cache"},
+			Value:  server.InQueue + server.OutQueue,
+		}}
+	}
+	servers := func(pool string, service stats.ServiceStats) []Sample {
+		return []Sample{{Name: "acme_pool_servers", Help: "Servers of the pool", Value: float64(len(service.Servers))}}
+	}
+	c := New(twemproxy.NewClient(listener.Addr().String()), conf,
+		WithServerMapper(queued), WithPoolMapper(servers), WithoutMetrics("twemproxy_server_in_queue_bytes", "twemproxy_pool_info"))
+	values := gauges(t, c)
+	if values["acme_server_queued/redis2:6379:1"] != 1 {
+		t.Errorf("Expected the mapped sample of redis2, got %v", values)
+	}
+	if values["acme_pool_servers"] == 0 {
+		t.Errorf("Expected the mapped sample of the pool, got %v", values)
+	}
+	for name := range values {
+		if strings.HasPrefix(name, "twemproxy_server_in_queue_bytes") || name == "twemproxy_pool_info" {
+			t.Errorf("Expected %s to be vetoed", name)
+		}
+	}
+	if values["twemproxy_server_in_queue/redis2:6379:1"] != 1 {
+		t.Errorf("Expected the metrics not vetoed, got %v", values)
+	}
+}
+
+func TestCollectorVetoNames(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to read config: ", err.Error())
+	}
+	payload, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Fatal("Failed to read json example: ", err.Error())
+	}
+	listener := serveStats(t, payload)
+	defer listener.Close()
+
+	vetoed := make(map[string]bool)
+	New(twemproxy.NewClient(listener.Addr().String()), conf, WithVeto(func(name string) bool {
+		vetoed[name] = true
+		return true
+	}))
+	// every exported metric must be offered to the vetoes with its name
+	for name := range gauges(t, New(twemproxy.NewClient(listener.Addr().String()), conf)) {
+		if family := strings.SplitN(name, "/", 2)[0]; !vetoed[family] {
+			t.Errorf("Expected %s to be offered to the vetoes, got %v", family, vetoed)
+		}
+	}
+}
+
+func TestCollectorServerLabels(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to read config: ", err.Error())
+	}
+	payload, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Fatal("Failed to read json example: ", err.Error())
+	}
+	listener := serveStats(t, payload)
+	defer listener.Close()
+
+	// two collectors of one process with their own labels
+	redis := New(twemproxy.NewClient(listener.Addr().String()), conf)
+	memcache := New(twemproxy.NewClient(listener.Addr().String()), conf, WithServerLabel("server"), WithConfiguredLabel(true))
+	if values := gauges(t, redis); values["twemproxy_server_in_queue/redis2:6379:1"] != 1 {
+		t.Errorf("Expected the default server label, got %v", values)
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(memcache)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "twemproxy_server_in_queue" {
+			continue
+		}
+		names := []string{}
+		for _, label := range family.GetMetric()[0].GetLabel() {
+			names = append(names, label.GetName())
+		}
+		if strings.Join(names, ",") != "configured,group,instance,server" {
+			t.Errorf("Expected the server and configured labels, got %v", names)
+		}
+	}
+}
+
+func TestCollectorCallbacks(t *testing.T) {
+	payload, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Fatal("Failed to read json example: ", err.Error())
+	}
+	listener := serveStats(t, payload)
+	defer listener.Close()
+
+	type key struct{}
+	var events []string
+	c := New(twemproxy.NewClient(listener.Addr().String()), nil, WithInstance("proxy-1"),
+		OnScrapeStart(func(ctx context.Context, instance string) context.Context {
+			events = append(events, "start "+instance)
+			return context.WithValue(ctx, key{}, "span")
+		}),
+		OnScrapeSuccess(func(ctx context.Context, instance string, duration time.Duration) {
+			events = append(events, "success "+ctx.Value(key{}).(string))
+		}),
+		OnScrapeError(func(ctx context.Context, instance string, duration time.Duration, err error) {
+			if !errors.Is(err, twemproxy.ErrTargetUnreachable) {
+				t.Errorf("Expected ErrTargetUnreachable, got %v", err)
+			}
+			events = append(events, "error "+ctx.Value(key{}).(string))
+		}))
+	gauges(t, c)
+	listener.Close()
+	gauges(t, c)
+	expected := "start proxy-1,success span,start proxy-1,error span"
+	if got := strings.Join(events, ","); got != expected {
+		t.Errorf("Expected the callbacks %s, got %s", expected, got)
+	}
+}
+
+func TestCollectorOptions(t *testing.T) {
+	var logs bytes.Buffer
+	client := twemproxy.NewClient("127.0.0.1:1")
+	source := twemproxy.SourceFunc(func(ctx context.Context) ([]byte, error) {
+		return nil, fmt.Errorf("%w, no twemproxy in tests", twemproxy.ErrTargetUnreachable)
+	})
+	c := New(client, nil, WithSource(source), WithTimeout(time.Second), WithLogger(log.New(&logs, "", 0)))
+	if values := gauges(t, c); values["twemproxy_up"] != 0 {
+		t.Errorf("Expected twemproxy_up 0, got %v", values)
+	}
+	if !strings.Contains(logs.String(), "no twemproxy in tests") {
+		t.Errorf("Expected the failed scrape in the logger, got %q", logs.String())
+	}
+	if client.Source != nil || client.Timeout != 0 {
+		t.Errorf("Expected the client of the caller unchanged, got %+v", client)
+	}
+}
+
+func TestConfiguredLabel(t *testing.T) {
+	labels := ServerLabels{Configured: true}
+	serverMetrics := NewServerMetrics(nil, labels)
+	st := stats.TwemproxyStats{Services: map[string]stats.ServiceStats{"pool": {
+		Servers: map[string]stats.ServerStats{"alpha": {HostAlias: "10.0.0.1", InQueue: 1}},
+		Unknown: map[string]stats.ServerStats{"gamma": {HostAlias: "gamma", Unknown: true, InQueue: 2}},
+	}}}
+	Update("proxy-1", st, NewTwemproxyMetrics(nil), serverMetrics, labels)
+	values := gauges(t, serverMetrics["in_queue"])
+	if len(values) != 2 || values["twemproxy_server_in_queue/10.0.0.1"] != 1 || values["twemproxy_server_in_queue/gamma"] != 2 {
+		t.Errorf("Expected the configured and unknown servers, got %v", values)
+	}
+	labelValues := labels.Values("proxy-1", "pool", st.Services["pool"].Unknown["gamma"])
+	if labelValues[len(labelValues)-1] != "false" {
+		t.Errorf("Expected configured=false on the unknown server, got %v", labelValues)
+	}
+}
@@ -0,0 +1,109 @@
+package collector
+
+import (
+	"sort"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Sample of a custom metric returned by a mapper
+type Sample struct {
+	Name    string            // full name of the metric, e.g. acme_twemproxy_server_error_ratio
+	Help    string            // a name must always come with the same help and label names
+	Labels  prometheus.Labels // in addition to instance, group and the server label
+	Value   float64
+	Counter bool // a gauge when false
+}
+
+// ServerMapper map the stats of a backend server of a pool to custom samples, called on every scrape
+type ServerMapper func(pool string, server stats.ServerStats) []Sample
+
+// PoolMapper map the stats of a pool to custom samples, called on every scrape
+type PoolMapper func(pool string, service stats.ServiceStats) []Sample
+
+// WithServerMapper add the samples of mapper to the metrics of every backend server
+func WithServerMapper(mapper ServerMapper) Option {
+	return func(c *Collector) {
+		c.serverMappers = append(c.serverMappers, mapper)
+	}
+}
+
+// WithPoolMapper add the samples of mapper to the metrics of every pool
+func WithPoolMapper(mapper PoolMapper) Option {
+	return func(c *Collector) {
+		c.poolMappers = append(c.poolMappers, mapper)
+	}
+}
+
+// WithVeto drop the default metrics for which veto return true, veto get the full name e.g. twemproxy_server_in_queue_bytes
+func WithVeto(veto func(name string) bool) Option {
+	return func(c *Collector) {
+		c.vetoes = append(c.vetoes, veto)
+	}
+}
+
+// WithoutMetrics drop the default metrics by their full name
+func WithoutMetrics(names ...string) Option {
+	drop := make(map[string]bool, len(names))
+	for _, name := range names {
+		drop[name] = true
+	}
+	return WithVeto(func(name string) bool {
+		return drop[name]
+	})
+}
+
+// export the metric of the full name unless a veto of the collector drop it
+func (c *Collector) export(name string, metric prometheus.Collector) {
+	for _, veto := range c.vetoes {
+		if veto(name) {
+			return
+		}
+	}
+	c.exported = append(c.exported, metric)
+}
+
+// collectMapped send the samples of the mappers for the stats of one scrape
+func (c *Collector) collectMapped(ch chan<- prometheus.Metric, s stats.TwemproxyStats) {
+	for poolName, service := range s.Services {
+		for _, mapper := range c.poolMappers {
+			for _, sample := range mapper(poolName, service) {
+				ch <- c.sampleMetric(sample, poolLabelNames, c.instance, poolName)
+			}
+		}
+		for _, server := range service.Servers {
+			for _, mapper := range c.serverMappers {
+				for _, sample := range mapper(poolName, server) {
+					ch <- c.sampleMetric(sample, c.serverLabels.Names(), c.instance, poolName, server.HostAlias)
+				}
+			}
+		}
+	}
+}
+
+// sampleMetric with the default label names and values followed by the sample labels sorted by name,
+// an invalid sample is reported by the registry on gather
+func (c *Collector) sampleMetric(sample Sample, labelNames []string, labelValues ...string) prometheus.Metric {
+	extra := make([]string, 0, len(sample.Labels))
+	for name := range sample.Labels {
+		extra = append(extra, name)
+	}
+	sort.Strings(extra)
+	names := append(append([]string{}, labelNames...), extra...)
+	values := append([]string{}, labelValues...)
+	for _, name := range extra {
+		values = append(values, sample.Labels[name])
+	}
+
+	valueType := prometheus.GaugeValue
+	if sample.Counter {
+		valueType = prometheus.CounterValue
+	}
+	desc := prometheus.NewDesc(sample.Name, sample.Help, names, c.constLabels)
+	metric, err := prometheus.NewConstMetric(desc, valueType, sample.Value, values...)
+	if err != nil {
+		return prometheus.NewInvalidMetric(desc, err)
+	}
+	return metric
+}
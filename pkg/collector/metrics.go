@@ -0,0 +1,258 @@
+// Package collector turn twemproxy stats into prometheus metrics, the metric names and labels are the ones of twemproxy_exporter
+package collector
+
+import (
+	"strconv"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Namespace of every metric
+const Namespace = "twemproxy"
+
+// Metrics of one target by name
+type Metrics map[string]*prometheus.GaugeVec
+
+var (
+	twemproxyLabelNames = []string{"instance"}
+	poolLabelNames      = []string{"instance", "group"}
+)
+
+// DefaultServerLabel of the backend servers, kept for compatibility even though memcache pools have no redis
+const DefaultServerLabel = "redis_server"
+
+// ServerLabels of the server metrics, the zero value is the label names of the exporter defaults
+type ServerLabels struct {
+	// Server is the label of the backend servers, DefaultServerLabel when empty
+	Server string
+	// Configured add the configured label, "false" on the servers in the stats but not in the config which are only
+	// exported with it
+	Configured bool
+}
+
+// ServerLabel is the name of the label of the backend servers
+func (l ServerLabels) ServerLabel() string {
+	if l.Server == "" {
+		return DefaultServerLabel
+	}
+	return l.Server
+}
+
+// Names of the labels identifying a server, instance, group and the server label, a copy callers can append to
+func (l ServerLabels) Names() []string {
+	return []string{"instance", "group", l.ServerLabel()}
+}
+
+// MetricNames of the server metrics, the ones of Names followed by configured when set
+func (l ServerLabels) MetricNames() []string {
+	if l.Configured {
+		return append(l.Names(), "configured")
+	}
+	return l.Names()
+}
+
+// Values of a server for the server metrics, in the order of MetricNames
+func (l ServerLabels) Values(instance string, pool string, server stats.ServerStats) []string {
+	values := []string{instance, pool, server.HostAlias}
+	if l.Configured {
+		values = append(values, strconv.FormatBool(!server.Unknown))
+	}
+	return values
+}
+
+func newTwemproxyMetric(metricName string, doc string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   Namespace,
+			Subsystem:   "service",
+			Name:        metricName,
+			Help:        doc,
+			ConstLabels: constLabels,
+		},
+		twemproxyLabelNames,
+	)
+}
+
+func newServerMetric(metricName string, doc string, constLabels prometheus.Labels, labels ServerLabels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   Namespace,
+			Subsystem:   "server",
+			Name:        metricName,
+			Help:        doc,
+			ConstLabels: constLabels,
+		},
+		labels.MetricNames(),
+	)
+}
+
+// metricDef of a metric of a family, key is the one in Metrics and name the one after the prefix of the family
+type metricDef struct {
+	key  string
+	name string
+	help string
+}
+
+var twemproxyMetricDefs = []metricDef{
+	{"total_connections", "total_connections", "Total connectoins in twemproxy"},
+	{"current_connections", "current_connections", "Current connections in twemproxy"},
+}
+
+// twemproxyMetricName is the full name of a metric of NewTwemproxyMetrics
+func twemproxyMetricName(name string) string {
+	return prometheus.BuildFQName(Namespace, "service", name)
+}
+
+// NewTwemproxyMetrics of one target, constLabels are the target own labels
+func NewTwemproxyMetrics(constLabels prometheus.Labels) Metrics {
+	ms := make(Metrics, len(twemproxyMetricDefs))
+	for _, def := range twemproxyMetricDefs {
+		ms[def.key] = newTwemproxyMetric(def.name, def.help, constLabels)
+	}
+	return ms
+}
+
+func newPoolMetric(metricName string, doc string, constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   Namespace,
+			Name:        "pool_" + metricName,
+			Help:        doc,
+			ConstLabels: constLabels,
+		},
+		poolLabelNames,
+	)
+}
+
+// NewPoolMetrics of one target, constLabels are the target own labels
+func NewPoolMetrics(constLabels prometheus.Labels) Metrics {
+	return Metrics{
+		"request_imbalance":      newPoolMetric("request_imbalance", "Coefficient of variation of the request rates of the pool servers over the last scrape interval", constLabels),
+		"listen_reachable":       newPoolMetric("listen_reachable", "1 when the listen address of the pool accept connections, with -probe.listen", constLabels),
+		"listen_connect_seconds": newPoolMetric("listen_connect_seconds", "Seconds to connect to the listen address of the pool, including the ping and its reply with -probe.listen-ping", constLabels),
+		"servers_configured":     newPoolMetric("servers_configured", "Servers of the pool in the nutcracker config", constLabels),
+		"servers_reported":       newPoolMetric("servers_reported", "Servers of the pool in the stats of twemproxy", constLabels),
+		"unknown_servers":        newPoolMetric("unknown_servers", "Servers of the pool in the stats of twemproxy but not in the config", constLabels),
+		"request_bytes":          newPoolMetric("request_bytes", "Request bytes to every backend server of the pool, the sum of the servers", constLabels),
+		"response_bytes":         newPoolMetric("response_bytes", "Response bytes from every backend server of the pool, the sum of the servers", constLabels),
+		"servers_mismatch":       newPoolMetric("servers_mismatch", "1 when the servers of the pool in the stats differ from the config, e.g. twemproxy not reloaded", constLabels),
+	}
+}
+
+// NewStatusMetrics of one target, whether its last scrape worked and how old the exported values are
+func NewStatusMetrics(constLabels prometheus.Labels) Metrics {
+	return Metrics{
+		"up": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   Namespace,
+			Name:        "up",
+			Help:        "1 when the last scrape of twemproxy succeeded",
+			ConstLabels: constLabels,
+		}, twemproxyLabelNames),
+		"data_stale": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   Namespace,
+			Subsystem:   "exporter",
+			Name:        "data_stale",
+			Help:        "1 when the last scrape failed and the exported values are from an earlier scrape",
+			ConstLabels: constLabels,
+		}, twemproxyLabelNames),
+		"data_age_seconds": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   Namespace,
+			Subsystem:   "exporter",
+			Name:        "data_age_seconds",
+			Help:        "Seconds since the last successful scrape",
+			ConstLabels: constLabels,
+		}, twemproxyLabelNames),
+		"target_quarantined": prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   Namespace,
+			Subsystem:   "exporter",
+			Name:        "target_quarantined",
+			Help:        "1 while the target is scraped at the slower quarantine interval after repeated failures",
+			ConstLabels: constLabels,
+		}, twemproxyLabelNames),
+	}
+}
+
+// upMetricName is the full name of the up metric of NewStatusMetrics
+var upMetricName = prometheus.BuildFQName(Namespace, "", "up")
+
+// poolInfoMetricName is the full name of NewPoolInfoMetric
+var poolInfoMetricName = prometheus.BuildFQName(Namespace, "", "pool_info")
+
+// poolInfoLabelNames of the pool info metric, the effective pool settings of the config, timeout is -1 without one
+var poolInfoLabelNames = []string{"instance", "group", "protocol", "listen", "hash", "hash_tag", "distribution", "timeout"}
+
+// NewPoolInfoMetric of one target, the labels are instance, group, protocol, listen, hash, hash_tag, distribution and timeout
+func NewPoolInfoMetric(constLabels prometheus.Labels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   Namespace,
+			Name:        "pool_info",
+			Help:        "Settings of the pool in the nutcracker config, always 1",
+			ConstLabels: constLabels,
+		},
+		poolInfoLabelNames,
+	)
+}
+
+var serverMetricDefs = []metricDef{
+	{"in_queue", "in_queue", "In queue process in backend server"},
+	{"in_queue_bytes", "in_queue_bytes", "In queue size in backend server"},
+	{"timed_out", "timed_out", "Timed out in backend server"},
+	{"server_connection", "connection", "Count of server connection to backend server"},
+	{"server_ejected_at", "ejected_at", "Ejected at time to backend server"},
+	{"up", "up", "1 when twemproxy has a connection to the backend server and did not eject it"},
+}
+
+// serverMetricName is the full name of a metric of NewServerMetrics
+func serverMetricName(name string) string {
+	return prometheus.BuildFQName(Namespace, "server", name)
+}
+
+// NewServerMetrics of one target, constLabels are the target own labels
+func NewServerMetrics(constLabels prometheus.Labels, labels ServerLabels) Metrics {
+	ms := make(Metrics, len(serverMetricDefs))
+	for _, def := range serverMetricDefs {
+		ms[def.key] = newServerMetric(def.name, def.help, constLabels, labels)
+	}
+	return ms
+}
+
+// NewRateMetrics of one target, for consumers without PromQL
+func NewRateMetrics(constLabels prometheus.Labels, labels ServerLabels) Metrics {
+	return Metrics{
+		"requests":       newServerMetric("requests_per_second", "Requests per second to backend server over the last scrape interval", constLabels, labels),
+		"request_bytes":  newServerMetric("request_bytes_per_second", "Request bytes per second to backend server over the last scrape interval", constLabels, labels),
+		"response_bytes": newServerMetric("response_bytes_per_second", "Response bytes per second from backend server over the last scrape interval", constLabels, labels),
+		"errors":         newServerMetric("errors_per_second", "Errors and timeouts per second of backend server over the last scrape interval", constLabels, labels),
+	}
+}
+
+// NewPoolRateMetrics of one target, the rates of the pools for consumers without PromQL
+func NewPoolRateMetrics(constLabels prometheus.Labels) Metrics {
+	return Metrics{
+		"request_bytes":  newPoolMetric("request_bytes_per_second", "Request bytes per second to every backend server of the pool over the last scrape interval", constLabels),
+		"response_bytes": newPoolMetric("response_bytes_per_second", "Response bytes per second from every backend server of the pool over the last scrape interval", constLabels),
+	}
+}
+
+// Collect every metric
+func (ms Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, metric := range ms {
+		metric.Collect(ch)
+	}
+}
+
+// Reset every metric, dropping all their series
+func (ms Metrics) Reset() {
+	for _, metric := range ms {
+		metric.Reset()
+	}
+}
+
+// DeleteLabelValues of every metric
+func (ms Metrics) DeleteLabelValues(labels ...string) {
+	for _, metric := range ms {
+		metric.DeleteLabelValues(labels...)
+	}
+}
@@ -0,0 +1,448 @@
+// Package config load the nutcracker (twemproxy) yaml config the way nutcracker does,
+// with the pools, their servers and every setting the exporter cares about
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Error list
+var (
+	ErrPathEmpty         = errors.New("Config path is empty")
+	ErrNoServersDetected = errors.New("No servers detected in config")
+	// ErrInvalidConfig is matched by every ConfigError and ConfigErrors, errors.As give the pool and key
+	ErrInvalidConfig = errors.New("Invalid config")
+)
+
+// protocols of the pools
+const (
+	ProtocolRedis    = "redis"
+	ProtocolMemcache = "memcache"
+)
+
+// Config of twemproxy
+type Config struct {
+	ConfigName         string // configuration name
+	Listen             string // ip:port or unix socket path
+	ListenNetwork      string // tcp or unix, empty without listen
+	ClientConnections  int
+	TCPKeepalive       bool
+	Backlog            int
+	Preconnect         bool
+	Hash               string
+	HashTag            string
+	Distribution       string
+	AutoEjectHosts     bool
+	ServerRetryTimeout int
+	ServerFailureLimit int
+	ServerConnections  int
+	Timeout            int
+	Protocol           string // redis or memcache
+	Redis              bool
+	RedisAuth          *Secret // nil without auth, never printed
+	RedisDB            int
+	Servers            []Server // one service of twemproxy can have many different backend servers
+}
+
+// Server for backend server list
+type Server struct {
+	IP     string // host:port:weight as written, IPv6 hosts always bracketed
+	Alias  string
+	Host   string // without brackets
+	Port   string // empty for unix sockets
+	Weight int
+}
+
+// ParseServer entry of a pool, host:port:weight followed by an optional alias.
+// IPv6 hosts are written [2001:db8::1]:6379:1, unbracketed ones are accepted too since the port and weight are always last
+func ParseServer(entry string) (Server, error) {
+	fields := strings.Fields(entry)
+	if len(fields) == 0 || len(fields) > 2 {
+		return Server{}, fmt.Errorf("Invalid server %q, expected host:port:weight [alias]", entry)
+	}
+	server := Server{}
+	if len(fields) > 1 {
+		server.Alias = fields[1]
+	}
+
+	address := fields[0]
+	i := strings.LastIndex(address, ":")
+	if i < 0 {
+		return Server{}, fmt.Errorf("Invalid server %q, missing weight", entry)
+	}
+	weight, err := strconv.Atoi(address[i+1:])
+	if err != nil {
+		return Server{}, fmt.Errorf("Invalid weight of server %q", entry)
+	}
+	server.Weight = weight
+	address = address[:i]
+
+	if strings.HasPrefix(address, "/") {
+		// unix socket
+		server.Host = address
+		server.IP = fmt.Sprintf("%s:%d", address, weight)
+		return server, nil
+	}
+	i = strings.LastIndex(address, ":")
+	if i < 0 {
+		return Server{}, fmt.Errorf("Invalid server %q, expected host:port:weight [alias]", entry)
+	}
+	server.Host = strings.TrimSuffix(strings.TrimPrefix(address[:i], "["), "]")
+	server.Port = address[i+1:]
+	if _, err := strconv.Atoi(server.Port); err != nil || server.Host == "" {
+		return Server{}, fmt.Errorf("Invalid server %q, expected host:port:weight [alias]", entry)
+	}
+	server.IP = fmt.Sprintf("%s:%d", net.JoinHostPort(server.Host, server.Port), weight)
+	return server, nil
+}
+
+// Name of the server in the twemproxy stats, the alias or host:port.
+// Like libmemcached, twemproxy leave out the port when it is 11211
+func (s Server) Name() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	if s.Port == "" || s.Port == "11211" {
+		return s.Host
+	}
+	return net.JoinHostPort(s.Host, s.Port)
+}
+
+// LoadConfig for twemproxy yaml, with the pools of every document and included file
+func LoadConfig(path string) (map[string]Config, error) {
+	if path == "" {
+		return nil, ErrPathEmpty
+	}
+
+	pools := make(map[string]poolConfig)
+	err := loadPools(path, pools, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	return buildConfig(pools)
+}
+
+// loadPools of the file and the files it include, visited guard against include loops
+func loadPools(path string, pools map[string]poolConfig, visited map[string]bool) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if visited[abs] {
+		return fmt.Errorf("Config %s included twice", path)
+	}
+	visited[abs] = true
+
+	confContent, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Cannot open: %s. Error: %w", path, err)
+	}
+	includes, err := decodePools(confContent, pools)
+	if err != nil {
+		return fmt.Errorf("%w %s. Error: %w", ErrInvalidConfig, path, err)
+	}
+
+	for _, include := range includes {
+		// relative to the including file
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(path), include)
+		}
+		matches, err := filepath.Glob(include)
+		if err != nil {
+			return fmt.Errorf("Invalid include %s in %s. Error: %s", include, path, err.Error())
+		}
+		if len(matches) == 0 && !strings.ContainsAny(include, "*?[") {
+			return fmt.Errorf("Cannot open: %s included by %s", include, path)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			err = loadPools(match, pools, visited)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// poolConfig as written in twemproxy yaml, decoded by yaml itself so anchors, aliases and merge keys work.
+// Keys nutcracker doesn't know yet are kept in Unknown and ignored
+type poolConfig struct {
+	Listen             string                 `yaml:"listen"`
+	ClientConnections  int                    `yaml:"client_connections"`
+	TCPKeepalive       bool                   `yaml:"tcpkeepalive"`
+	Backlog            int                    `yaml:"backlog"`
+	Preconnect         bool                   `yaml:"preconnect"`
+	Hash               string                 `yaml:"hash"`
+	HashTag            string                 `yaml:"hash_tag"`
+	Distribution       string                 `yaml:"distribution"`
+	AutoEjectHosts     bool                   `yaml:"auto_eject_hosts"`
+	ServerRetryTimeout int                    `yaml:"server_retry_timeout"`
+	ServerFailureLimit int                    `yaml:"server_failure_limit"`
+	ServerConnections  int                    `yaml:"server_connections"`
+	Timeout            int                    `yaml:"timeout"`
+	Protocol           string                 `yaml:"protocol"`
+	Redis              bool                   `yaml:"redis"`
+	RedisAuth          *Secret                `yaml:"redis_auth"`
+	RedisDB            int                    `yaml:"redis_db"`
+	Servers            []string               `yaml:"servers"`
+	Unknown            map[string]interface{} `yaml:",inline"`
+
+	err error // decoding error, kept so the other pools are still decoded and validated
+}
+
+// NoTimeout is the timeout of pools without one, nutcracker wait for the servers indefinitely
+const NoTimeout = -1
+
+// defaultPoolConfig has the nutcracker defaults of the optional keys
+var defaultPoolConfig = poolConfig{
+	Backlog:            512,
+	Hash:               "fnv1a_64",
+	Distribution:       "ketama",
+	ServerRetryTimeout: 30000,
+	ServerFailureLimit: 2,
+	ServerConnections:  1,
+	Timeout:            NoTimeout,
+}
+
+// UnmarshalYAML keep the decoding error of the pool instead of stopping the whole config.
+// Keys left out keep the nutcracker defaults
+func (p *poolConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain poolConfig
+	*p = defaultPoolConfig
+	p.err = unmarshal((*plain)(p))
+	return nil
+}
+
+// ConfigError of a pool, Key is the field of the pool and empty when the error is not about a single key
+type ConfigError struct {
+	Pool    string
+	Key     string
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("pool %s: %s", e.Pool, e.Message)
+	}
+	return fmt.Sprintf("pool %s: %s: %s", e.Pool, e.Key, e.Message)
+}
+
+// Is ErrInvalidConfig
+func (e ConfigError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// ConfigErrors of every invalid pool, so they are all fixed at once
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	lines := make([]string, 0, len(e))
+	for _, err := range e {
+		lines = append(lines, err.Error())
+	}
+	return "Invalid config:\n  " + strings.Join(lines, "\n  ")
+}
+
+// Is ErrInvalidConfig
+func (e ConfigErrors) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// Unwrap every error, so errors.As find the first ConfigError
+func (e ConfigErrors) Unwrap() []error {
+	errs := make([]error, 0, len(e))
+	for _, err := range e {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// nutcracker hashes and distributions
+var (
+	configHashes        = []string{"one_at_a_time", "md5", "crc16", "crc32", "crc32a", "fnv1_64", "fnv1a_64", "fnv1_32", "fnv1a_32", "hsieh", "murmur", "jenkins"}
+	configDistributions = []string{"ketama", "modula", "random"}
+)
+
+func oneOf(value string, values []string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validate the pool the way nutcracker would, every error is returned
+func (p poolConfig) validate(name string) []ConfigError {
+	var errs []ConfigError
+	if p.err != nil {
+		typeErr, ok := p.err.(*yaml.TypeError)
+		if !ok {
+			return []ConfigError{{Pool: name, Message: p.err.Error()}}
+		}
+		// yaml already say the line and what was expected, e.g. line 7: cannot unmarshal !!str `abc` into int
+		for _, message := range typeErr.Errors {
+			errs = append(errs, ConfigError{Pool: name, Message: message})
+		}
+	}
+	if p.Hash != "" && !oneOf(p.Hash, configHashes) {
+		errs = append(errs, ConfigError{name, "hash", fmt.Sprintf("unknown hash %q, expected one of %s", p.Hash, strings.Join(configHashes, ", "))})
+	}
+	if p.Distribution != "" && !oneOf(p.Distribution, configDistributions) {
+		errs = append(errs, ConfigError{name, "distribution", fmt.Sprintf("unknown distribution %q, expected one of %s", p.Distribution, strings.Join(configDistributions, ", "))})
+	}
+	if p.HashTag != "" && len(p.HashTag) != 2 {
+		errs = append(errs, ConfigError{name, "hash_tag", fmt.Sprintf("%q must be two characters, e.g. \"{}\"", p.HashTag)})
+	}
+	if p.Listen != "" {
+		if _, _, err := ParseListen(p.Listen); err != nil {
+			errs = append(errs, ConfigError{name, "listen", err.Error()})
+		}
+	}
+	if p.Timeout < 0 && p.Timeout != NoTimeout {
+		errs = append(errs, ConfigError{name, "timeout", "must be positive, or left out to wait indefinitely"})
+	}
+	if p.RedisDB < 0 {
+		errs = append(errs, ConfigError{name, "redis_db", "must be positive"})
+	}
+	if !p.Redis && p.Protocol != ProtocolRedis && (p.RedisAuth != nil || p.RedisDB != 0) {
+		errs = append(errs, ConfigError{name, "redis_auth", "redis_auth and redis_db require redis: true"})
+	}
+	for _, s := range p.Servers {
+		if _, err := ParseServer(s); err != nil {
+			errs = append(errs, ConfigError{name, "servers", err.Error()})
+		}
+	}
+	return errs
+}
+
+// configDocument is one YAML document of the config, the pools with an optional include list.
+// include is our own convention on top of nutcracker, to split the pools by team
+//
+//	include:
+//	  - pools.d/*.yml
+type configDocument struct {
+	Include []string              `yaml:"include"`
+	Pools   map[string]poolConfig `yaml:",inline"`
+}
+
+// decodePools of every document of the content into pools, returning the includes
+func decodePools(confContent []byte, pools map[string]poolConfig) ([]string, error) {
+	var includes []string
+	decoder := yaml.NewDecoder(bytes.NewReader(confContent))
+	for {
+		var doc configDocument
+		err := decoder.Decode(&doc)
+		if err == io.EOF {
+			return includes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for key, pool := range doc.Pools {
+			if _, ok := pools[key]; ok {
+				return nil, fmt.Errorf("Pool %s is defined more than once", key)
+			}
+			pools[key] = pool
+		}
+		includes = append(includes, doc.Include...)
+	}
+}
+
+// ParseConfig from the content of twemproxy yaml, includes need a file and are refused
+func ParseConfig(confContent []byte) (map[string]Config, error) {
+	pools := make(map[string]poolConfig)
+	includes, err := decodePools(confContent, pools)
+	if err != nil {
+		return nil, err
+	}
+	if len(includes) > 0 {
+		return nil, errors.New("Config include is only supported with a config file")
+	}
+	return buildConfig(pools)
+}
+
+// buildConfig of the decoded pools, every pool is validated before failing
+func buildConfig(pools map[string]poolConfig) (map[string]Config, error) {
+	names := make([]string, 0, len(pools))
+	for key := range pools {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+
+	var errs ConfigErrors
+	confs := make(map[string]Config)
+	serversExists := false
+	for _, key := range names {
+		pool := pools[key]
+		if poolErrs := pool.validate(key); len(poolErrs) > 0 {
+			errs = append(errs, poolErrs...)
+			continue
+		}
+		if len(pool.Servers) == 0 {
+			// only holding anchors for the other pools, e.g. defaults: &defaults
+			continue
+		}
+		for unknown := range pool.Unknown {
+			log.Printf("Ignoring unknown key %s of pool %s", unknown, key)
+		}
+		c := Config{
+			ConfigName:         key,
+			Listen:             pool.Listen,
+			ClientConnections:  pool.ClientConnections,
+			TCPKeepalive:       pool.TCPKeepalive,
+			Backlog:            pool.Backlog,
+			Preconnect:         pool.Preconnect,
+			Hash:               pool.Hash,
+			HashTag:            pool.HashTag,
+			Distribution:       pool.Distribution,
+			AutoEjectHosts:     pool.AutoEjectHosts,
+			ServerRetryTimeout: pool.ServerRetryTimeout,
+			ServerFailureLimit: pool.ServerFailureLimit,
+			ServerConnections:  pool.ServerConnections,
+			Timeout:            pool.Timeout,
+			Protocol:           pool.Protocol,
+			Redis:              pool.Redis,
+			RedisAuth:          pool.RedisAuth,
+			RedisDB:            pool.RedisDB,
+		}
+		if pool.Listen != "" {
+			// already validated
+			c.ListenNetwork, c.Listen, _ = ParseListen(pool.Listen)
+		}
+		// nutcracker pools are memcache unless redis: true, protocol is kept from older configs of this exporter
+		c.Redis = pool.Redis || pool.Protocol == ProtocolRedis
+		c.Protocol = ProtocolMemcache
+		if c.Redis {
+			c.Protocol = ProtocolRedis
+		}
+		for _, s := range pool.Servers {
+			// already validated
+			server, _ := ParseServer(s)
+			c.Servers = append(c.Servers, server)
+			serversExists = true
+		}
+		confs[key] = c
+	}
+	if len(errs) > 0 {
+		// the valid pools, so callers can choose to keep going with them
+		return confs, errs
+	}
+	if !serversExists {
+		return nil, ErrNoServersDetected
+	}
+	return confs, nil
+}
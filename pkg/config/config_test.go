@@ -0,0 +1,158 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	_, err := LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Error("Failed to read config: ", err.Error())
+	}
+}
+
+func TestLoadConfigAnchors(t *testing.T) {
+	conf, err := LoadConfig("../../files/nutcracker-anchors.yml")
+	if err != nil {
+		t.Fatal("Failed to read config: ", err.Error())
+	}
+	replica, ok := conf["session-replica"]
+	if !ok {
+		t.Fatalf("Missing pool session-replica in %+v", conf)
+	}
+	// merged from defaults, overridden by the pool
+	if replica.Hash != "fnv1a_64" || !replica.AutoEjectHosts || replica.Timeout != 1000 || replica.HashTag != "{}" {
+		t.Errorf("Unexpected merged pool %+v", replica)
+	}
+	// aliased server list
+	if len(replica.Servers) != 2 || replica.Servers[1].Alias != "beta" {
+		t.Errorf("Unexpected aliased servers %+v", replica.Servers)
+	}
+	if _, ok := conf["defaults"]; ok {
+		t.Error("Expected the anchor only defaults not to be a pool")
+	}
+	if replica.Protocol != ProtocolRedis || conf["cache"].Protocol != ProtocolMemcache {
+		t.Errorf("Unexpected protocols %s and %s", replica.Protocol, conf["cache"].Protocol)
+	}
+	if conf["cache"].Redis || len(conf["cache"].Servers) != 1 {
+		t.Errorf("Unexpected pool cache %+v", conf["cache"])
+	}
+}
+
+func TestParseServer(t *testing.T) {
+	tests := []struct {
+		entry string
+		ip    string
+		name  string
+	}{
+		{"redis:6379:1 alpha", "redis:6379:1", "alpha"},
+		{"10.0.0.1:6379:1", "10.0.0.1:6379:1", "10.0.0.1:6379"},
+		{"[2001:db8::1]:6379:1", "[2001:db8::1]:6379:1", "[2001:db8::1]:6379"},
+		{"2001:db8::1:6379:2 beta", "[2001:db8::1]:6379:2", "beta"},
+		{"memcache:11211:1", "memcache:11211:1", "memcache"},
+		{"/var/run/redis.sock:1", "/var/run/redis.sock:1", "/var/run/redis.sock"},
+	}
+	for _, test := range tests {
+		server, err := ParseServer(test.entry)
+		if err != nil {
+			t.Errorf("Failed to parse %s: %s", test.entry, err.Error())
+			continue
+		}
+		if server.IP != test.ip || server.Name() != test.name {
+			t.Errorf("Expected %s named %s for %s, got %+v", test.ip, test.name, test.entry, server)
+		}
+	}
+
+	for _, entry := range []string{"redis", "redis:6379", "redis:port:1", "[::1]:6379:x", "a:1:1 b c"} {
+		if _, err := ParseServer(entry); err == nil {
+			t.Errorf("Expected an error for %s", entry)
+		}
+	}
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	conf, err := LoadConfig("../../files/nutcracker-include.yml")
+	if err != nil {
+		t.Fatal("Failed to read config: ", err.Error())
+	}
+	for _, pool := range []string{"wallet-oauth-token", "session", "cache"} {
+		if _, ok := conf[pool]; !ok {
+			t.Errorf("Missing pool %s in %+v", pool, conf)
+		}
+	}
+
+	_, err = ParseConfig([]byte("include: [pools.d/*.yml]\nsession:\n  servers: [redis:6379:1]\n"))
+	if err == nil {
+		t.Error("Expected an error for an include without a config file")
+	}
+	_, err = ParseConfig([]byte("session:\n  servers: [redis:6379:1]\n---\nsession:\n  servers: [redis:6380:1]\n"))
+	if err == nil {
+		t.Error("Expected an error for a pool defined twice")
+	}
+}
+
+func TestLoadConfigKnownKeys(t *testing.T) {
+	conf, err := LoadConfig("../../files/nutcracker-full.yml")
+	if err != nil {
+		t.Fatal("Failed to read config: ", err.Error())
+	}
+	pool := conf["sessions"]
+	if !pool.TCPKeepalive || pool.ServerConnections != 2 || pool.RedisDB != 2 || pool.Listen != "127.0.0.1:22122" {
+		t.Errorf("Unexpected pool %+v", pool)
+	}
+	if pool.RedisAuth == nil {
+		t.Fatal("Expected redis_auth")
+	}
+	password, err := pool.RedisAuth.Get()
+	if err != nil || password != "s3cret" {
+		t.Errorf("Unexpected redis_auth %s", password)
+	}
+	if strings.Contains(fmt.Sprintf("%+v", pool), "s3cret") {
+		t.Error("Expected redis_auth to never be printed")
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	_, err := LoadConfig("../../files/nutcracker-invalid.yml")
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("Expected the errors of every pool, got %v", err)
+	}
+	var first ConfigError
+	if !errors.Is(err, ErrInvalidConfig) || !errors.As(err, &first) || first.Pool != "broken" {
+		t.Errorf("Expected the errors to match ErrInvalidConfig and ConfigError, got %+v", first)
+	}
+	if _, err := LoadConfig("../../files/missing.yml"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing config to match os.ErrNotExist, got %v", err)
+	}
+	if len(errs) != 3 {
+		t.Fatalf("Expected 3 errors, got %s", errs.Error())
+	}
+	for _, e := range errs {
+		if e.Pool != "broken" {
+			t.Errorf("Expected only pool broken to be invalid, got %s", e.Error())
+		}
+	}
+	// timeout: soon
+	if !strings.Contains(errs.Error(), "line 12") {
+		t.Errorf("Expected the line of the invalid timeout, got %s", errs.Error())
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	conf, err := ParseConfig([]byte("minimal:\n  listen: 127.0.0.1:22121\n  servers:\n   - 127.0.0.1:11211:1\n"))
+	if err != nil {
+		t.Fatal("Failed to parse config: ", err.Error())
+	}
+	pool := conf["minimal"]
+	if pool.Hash != "fnv1a_64" || pool.Distribution != "ketama" || pool.HashTag != "" || pool.Timeout != NoTimeout {
+		t.Errorf("Expected the nutcracker defaults, got %+v", pool)
+	}
+	if pool.ServerConnections != 1 || pool.ServerFailureLimit != 2 || pool.ServerRetryTimeout != 30000 || pool.Backlog != 512 {
+		t.Errorf("Expected the nutcracker defaults, got %+v", pool)
+	}
+}
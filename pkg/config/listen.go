@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseListen address of a pool, ip:port or a unix socket path optionally followed by its permissions,
+// e.g. /var/run/nutcracker.sock 0666
+func ParseListen(listen string) (network string, address string, err error) {
+	fields := strings.Fields(listen)
+	if len(fields) == 0 {
+		return "", "", fmt.Errorf("Invalid listen %q, expected ip:port or a unix socket path", listen)
+	}
+	if strings.HasPrefix(fields[0], "/") {
+		return "unix", fields[0], nil
+	}
+	if len(fields) > 1 {
+		return "", "", fmt.Errorf("Invalid listen %q, permissions are only for unix sockets", listen)
+	}
+	host, port, err := net.SplitHostPort(fields[0])
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid listen %q, expected ip:port or a unix socket path", listen)
+	}
+	return "tcp", net.JoinHostPort(host, port), nil
+}
+
+// ListenAddress to dial the pool on the target, a pool listening on every interface is dialed on the target host.
+// Unix sockets can only be dialed when the exporter run next to twemproxy
+func (c Config) ListenAddress(target string) string {
+	if c.ListenNetwork != "tcp" {
+		return c.Listen
+	}
+	host, port, _ := net.SplitHostPort(c.Listen)
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		targetHost, _, err := net.SplitHostPort(target)
+		if err != nil {
+			targetHost = target
+		}
+		host = targetHost
+	}
+	return net.JoinHostPort(host, port)
+}
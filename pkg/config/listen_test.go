@@ -0,0 +1,33 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestParseListen(t *testing.T) {
+	tests := []struct {
+		listen  string
+		network string
+		address string
+	}{
+		{"0.0.0.0:22121", "tcp", "0.0.0.0:22121"},
+		{"[::1]:22121", "tcp", "[::1]:22121"},
+		{"/var/run/nutcracker.sock 0666", "unix", "/var/run/nutcracker.sock"},
+	}
+	for _, test := range tests {
+		network, address, err := ParseListen(test.listen)
+		if err != nil || network != test.network || address != test.address {
+			t.Errorf("Expected %s %s for %s, got %s %s %v", test.network, test.address, test.listen, network, address, err)
+		}
+	}
+	for _, listen := range []string{"22121", "localhost:22121 0666", ""} {
+		if _, _, err := ParseListen(listen); err == nil {
+			t.Errorf("Expected an error for %q", listen)
+		}
+	}
+
+	c := Config{Listen: "0.0.0.0:22121", ListenNetwork: "tcp"}
+	if address := c.ListenAddress("10.0.0.1:22222"); address != "10.0.0.1:22121" {
+		t.Errorf("Expected the target host, got %s", address)
+	}
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrVaultNotConfigured returned when a secret refers to Vault but VAULT_ADDR is not set
+var ErrVaultNotConfigured = errors.New("VAULT_ADDR is not set")
+
+// SecretRefreshInterval how often secrets from files and Vault are re-read
+var SecretRefreshInterval = time.Minute
+
+// Secret is a password or token, written inline, read from a file or from a Vault path.
+// Secrets from files and Vault are re-read periodically so rotated credentials are picked up
+// without restarting the exporter
+//
+//	password: plain-text
+//	password: {file: /etc/twemproxy_exporter/password}
+//	password: {vault: "secret/data/twemproxy_exporter#password"}
+type Secret struct {
+	File  string `yaml:"file"`
+	Vault string `yaml:"vault"`
+
+	value    string
+	loadedAt time.Time
+	mu       sync.Mutex
+}
+
+// NewSecret with an inline value
+func NewSecret(value string) *Secret {
+	return &Secret{value: value}
+}
+
+// NewSecretRef of a flag, vault:<path>#<field> is read from Vault and anything else is the path of a file.
+// It is loaded once, so a broken reference fail at startup
+func NewSecretRef(ref string) (*Secret, error) {
+	s := &Secret{File: ref}
+	if strings.HasPrefix(ref, "vault:") {
+		s = &Secret{Vault: strings.TrimPrefix(ref, "vault:")}
+	}
+	_, err := s.Get()
+	return s, err
+}
+
+// UnmarshalYAML accept both a plain string and a file/vault reference
+func (s *Secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var plain string
+	if err := unmarshal(&plain); err == nil {
+		s.value = plain
+		return nil
+	}
+
+	ref := struct {
+		File  string `yaml:"file"`
+		Vault string `yaml:"vault"`
+	}{}
+	if err := unmarshal(&ref); err != nil {
+		return err
+	}
+	if ref.File == "" && ref.Vault == "" {
+		return errors.New("secret needs either file or vault")
+	}
+	s.File = ref.File
+	s.Vault = ref.Vault
+	// load once, so a broken reference fail the config loading
+	_, err := s.Get()
+	return err
+}
+
+// String never print the secret value
+func (s *Secret) String() string {
+	return "<secret>"
+}
+
+// Get the secret value, re-reading it when the refresh interval has passed.
+// When re-reading fails the last known value is kept
+func (s *Secret) Get() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.File == "" && s.Vault == "" {
+		return s.value, nil
+	}
+	if !s.loadedAt.IsZero() && time.Since(s.loadedAt) < SecretRefreshInterval {
+		return s.value, nil
+	}
+
+	var value string
+	var err error
+	if s.File != "" {
+		value, err = ReadSecretFile(s.File)
+	} else {
+		value, err = readVaultSecret(s.Vault)
+	}
+	if err != nil {
+		if s.loadedAt.IsZero() {
+			return "", err
+		}
+		return s.value, err
+	}
+	s.value = value
+	s.loadedAt = time.Now()
+	return s.value, nil
+}
+
+// ReadSecretFile without its trailing new lines
+func ReadSecretFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Cannot open: %s. Error: %s", path, err.Error())
+	}
+	// editors love trailing new lines
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// vaultToken from VAULT_TOKEN or the token file written by `vault login`
+func vaultToken() (string, error) {
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	path := os.Getenv("VAULT_TOKEN_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, ".vault-token")
+	}
+	return ReadSecretFile(path)
+}
+
+// readVaultSecret read `path#field` from Vault, works with both KV v1 and v2 engines
+func readVaultSecret(ref string) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", ErrVaultNotConfigured
+	}
+	p := strings.SplitN(ref, "#", 2)
+	if len(p) != 2 {
+		return "", fmt.Errorf("Vault secret %s needs a #field", ref)
+	}
+	path, field := p[0], p[1]
+
+	token, err := vaultToken()
+	if err != nil {
+		return "", fmt.Errorf("Cannot read Vault token. Error: %s", err.Error())
+	}
+	req, err := http.NewRequest("GET", strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+
+	client := &http.Client{Timeout: time.Second * 10}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Vault returned %s for %s", resp.Status, path)
+	}
+
+	body := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", err
+	}
+	data := body.Data
+	// KV v2 nest the secret one level deeper
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		data = nested
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("Vault secret %s has no field %s", path, field)
+	}
+	return value, nil
+}
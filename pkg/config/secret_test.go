@@ -0,0 +1,101 @@
+package config
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestSecretFileReread(t *testing.T) {
+	defer func(interval time.Duration) { SecretRefreshInterval = interval }(SecretRefreshInterval)
+	SecretRefreshInterval = 0
+
+	path := filepath.Join(t.TempDir(), "password")
+	ioutil.WriteFile(path, []byte("first\n"), 0600)
+	conf := struct {
+		Password *Secret `yaml:"password"`
+	}{}
+	if err := yaml.Unmarshal([]byte("password: {file: "+path+"}"), &conf); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := conf.Password.Get(); err != nil || value != "first" {
+		t.Errorf("Expected first without the new line, got %q %v", value, err)
+	}
+
+	// rotated credentials are picked up
+	ioutil.WriteFile(path, []byte("second"), 0600)
+	if value, _ := conf.Password.Get(); value != "second" {
+		t.Errorf("Expected the rotated second, got %q", value)
+	}
+
+	// the last known value is kept when the file is gone
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := conf.Password.Get(); err == nil || value != "second" {
+		t.Errorf("Expected second with an error, got %q %v", value, err)
+	}
+
+	if err := yaml.Unmarshal([]byte("password: {file: "+path+"}"), &conf); err == nil {
+		t.Error("Expected a missing file to fail the config loading")
+	}
+}
+
+func TestSecretVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/twemproxy_exporter":
+			w.Write([]byte(`{"data": {"data": {"password": "kv2"}, "metadata": {}}}`))
+		case "/v1/kv/twemproxy_exporter":
+			w.Write([]byte(`{"data": {"password": "kv1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "token")
+
+	tests := []struct {
+		ref   string
+		value string
+	}{
+		{"vault:secret/data/twemproxy_exporter#password", "kv2"},
+		{"vault:kv/twemproxy_exporter#password", "kv1"},
+	}
+	for _, test := range tests {
+		s, err := NewSecretRef(test.ref)
+		if err != nil {
+			t.Errorf("Failed to read %s: %s", test.ref, err.Error())
+			continue
+		}
+		if value, _ := s.Get(); value != test.value {
+			t.Errorf("Expected %s for %s, got %s", test.value, test.ref, value)
+		}
+	}
+
+	for _, ref := range []string{"vault:kv/twemproxy_exporter#missing", "vault:kv/twemproxy_exporter", "vault:kv/unknown#password"} {
+		if _, err := NewSecretRef(ref); err == nil {
+			t.Errorf("Expected %s to fail", ref)
+		}
+	}
+
+	t.Setenv("VAULT_TOKEN", "wrong")
+	if _, err := NewSecretRef("vault:kv/twemproxy_exporter#password"); err == nil {
+		t.Error("Expected a wrong token to fail")
+	}
+	t.Setenv("VAULT_ADDR", "")
+	if _, err := NewSecretRef("vault:kv/twemproxy_exporter#password"); err != ErrVaultNotConfigured {
+		t.Errorf("Expected ErrVaultNotConfigured, got %v", err)
+	}
+}
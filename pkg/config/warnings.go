@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// kinds of ConfigWarning
+const (
+	WarningDuplicateAddress = "duplicate_address" // two servers of a pool with the same address
+	WarningDuplicateAlias   = "duplicate_alias"   // two servers of a pool with the same alias
+	WarningAliasCollision   = "alias_collision"   // the same alias in more than one pool
+)
+
+// ConfigWarning of a config nutcracker accept but whose servers can't be told apart, e.g. their series are merged
+type ConfigWarning struct {
+	Pool    string
+	Kind    string
+	Message string
+}
+
+func (w ConfigWarning) String() string {
+	return fmt.Sprintf("pool %s: %s", w.Pool, w.Message)
+}
+
+// Warnings of the config, in pool order: duplicate server addresses and aliases within a pool, and aliases used by
+// more than one pool
+func Warnings(conf map[string]Config) []ConfigWarning {
+	names := make([]string, 0, len(conf))
+	for name := range conf {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var warnings []ConfigWarning
+	aliasPools := make(map[string][]string)
+	for _, name := range names {
+		addresses := make(map[string]bool)
+		aliases := make(map[string]bool)
+		for _, server := range conf[name].Servers {
+			address := server.Host
+			if server.Port != "" {
+				address = net.JoinHostPort(server.Host, server.Port)
+			}
+			if addresses[address] {
+				warnings = append(warnings, ConfigWarning{name, WarningDuplicateAddress, fmt.Sprintf("server %s is listed more than once", address)})
+			}
+			addresses[address] = true
+			if server.Alias == "" {
+				continue
+			}
+			if aliases[server.Alias] {
+				warnings = append(warnings, ConfigWarning{name, WarningDuplicateAlias, fmt.Sprintf("alias %s is used by more than one server", server.Alias)})
+				continue
+			}
+			aliases[server.Alias] = true
+			if pools := aliasPools[server.Alias]; len(pools) > 0 {
+				warnings = append(warnings, ConfigWarning{name, WarningAliasCollision, fmt.Sprintf("alias %s is also used in pool %s", server.Alias, strings.Join(pools, ", "))})
+			}
+			aliasPools[server.Alias] = append(aliasPools[server.Alias], name)
+		}
+	}
+	return warnings
+}
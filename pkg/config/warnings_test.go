@@ -0,0 +1,48 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestWarnings(t *testing.T) {
+	conf, err := ParseConfig([]byte(`
+alpha:
+  listen: 127.0.0.1:22121
+  redis: true
+  servers:
+    - 10.0.0.1:6379:1 redis-1
+    - 10.0.0.2:6379:1 redis-1
+    - 10.0.0.1:6379:1 redis-3
+beta:
+  listen: 127.0.0.1:22122
+  redis: true
+  servers:
+    - 10.0.1.1:6379:1 redis-3
+    - 10.0.1.2:6379:1 redis-4
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings := Warnings(conf)
+	expected := []ConfigWarning{
+		{"alpha", WarningDuplicateAlias, "alias redis-1 is used by more than one server"},
+		{"alpha", WarningDuplicateAddress, "server 10.0.0.1:6379 is listed more than once"},
+		{"beta", WarningAliasCollision, "alias redis-3 is also used in pool alpha"},
+	}
+	if len(warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, got %+v", len(expected), warnings)
+	}
+	for i := range expected {
+		if warnings[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], warnings[i])
+		}
+	}
+
+	conf, err = LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warnings := Warnings(conf); len(warnings) != 0 {
+		t.Errorf("Expected no warning for the example config, got %+v", warnings)
+	}
+}
@@ -0,0 +1,177 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+// backendProbeTimeout of the query of one backend server
+const backendProbeTimeout = time.Second * 2
+
+// backendProbeConcurrency of the queries of one target
+const backendProbeConcurrency = 16
+
+func newBackendKeysMetric(constLabels prometheus.Labels, labels collector.ServerLabels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   collector.Namespace,
+			Name:        "server_keys",
+			Help:        "Keys of the backend server, redis DBSIZE of the db of the pool or memcache curr_items, with -probe.backend-keys",
+			ConstLabels: constLabels,
+		},
+		labels.MetricNames(),
+	)
+}
+
+// backendAddress of a server of the config, the network is unix for socket paths
+func backendAddress(s config.Server) (network, address string) {
+	if s.Port == "" {
+		return "unix", s.Host
+	}
+	return "tcp", net.JoinHostPort(s.Host, s.Port)
+}
+
+// probeBackendKeys query the keys of every backend server of the full detail pools, a few servers at a time.
+// DBSIZE and stats are cheap, but are still one connection per server on every scrape
+func (m *Monitor) probeBackendKeys(ctx context.Context, conf map[string]config.Config) {
+	ctx, end := m.hooks.trace(ctx, "probe_backends")
+	defer end(nil)
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, backendProbeConcurrency)
+	for name, c := range conf {
+		if m.detail(name) != DetailFull {
+			continue
+		}
+		for _, server := range c.Servers {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(name string, c config.Config, server config.Server) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				labels := m.serverLabels.Values(m.instance, name, stats.ServerStats{HostAlias: server.Name()})
+				keys, err := queryBackendKeys(ctx, m.prober, name, c, server)
+				if err != nil {
+					if m.hooks.BackendProbeFailed != nil {
+						m.hooks.BackendProbeFailed(name, err)
+					}
+					m.backendKeys.DeleteLabelValues(labels...)
+					return
+				}
+				m.backendKeys.WithLabelValues(labels...).Set(keys)
+			}(name, c, server)
+		}
+	}
+	wg.Wait()
+}
+
+// queryBackendKeys of a server, DBSIZE of the db of the pool for redis, curr_items for memcache.
+// The connection and the password of the pool come from the prober
+func queryBackendKeys(ctx context.Context, prober Prober, name string, c config.Config, server config.Server) (float64, error) {
+	ctx, cancel := context.WithTimeout(ctx, backendProbeTimeout)
+	defer cancel()
+	network, address := backendAddress(server)
+	conn, err := prober.DialBackend(ctx, name, network, address)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	r := bufio.NewReader(conn)
+	if c.Protocol == config.ProtocolMemcache {
+		return memcacheItems(conn, r)
+	}
+	password, err := prober.Password(name, c)
+	if err != nil {
+		return 0, err
+	}
+	err = redisAuth(conn, r, password)
+	if err != nil {
+		return 0, err
+	}
+	if c.RedisDB != 0 {
+		_, err = redisCommand(conn, r, "SELECT", strconv.Itoa(c.RedisDB))
+		if err != nil {
+			return 0, err
+		}
+	}
+	reply, err := redisCommand(conn, r, "DBSIZE")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimPrefix(reply, ":"), 64)
+}
+
+// redisAuth of the connection, nothing to do without a password
+func redisAuth(conn net.Conn, r *bufio.Reader, password string) error {
+	if password == "" {
+		return nil
+	}
+	_, err := redisCommand(conn, r, "AUTH", password)
+	return err
+}
+
+// redisCommand send the command and read its single line reply, an error reply is an error
+func redisCommand(conn net.Conn, r *bufio.Reader, args ...string) (string, error) {
+	command := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		command += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := conn.Write([]byte(command))
+	if err != nil {
+		return "", err
+	}
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "-") {
+		return "", fmt.Errorf("%s replied %s", args[0], line[1:])
+	}
+	return line, nil
+}
+
+// memcacheItems is curr_items of the memcache stats
+func memcacheItems(conn net.Conn, r *bufio.Reader) (float64, error) {
+	_, err := conn.Write([]byte("stats\r\n"))
+	if err != nil {
+		return 0, err
+	}
+	items := -1.0
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 1 && fields[0] == "END" {
+			break
+		}
+		if len(fields) == 3 && fields[0] == "STAT" && fields[1] == "curr_items" {
+			items, err = strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				return 0, err
+			}
+		}
+		if len(fields) > 0 && strings.HasSuffix(fields[0], "ERROR") {
+			return 0, fmt.Errorf("stats replied %s", strings.TrimSpace(line))
+		}
+	}
+	if items < 0 {
+		return 0, fmt.Errorf("stats without curr_items")
+	}
+	return items, nil
+}
@@ -0,0 +1,152 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// startBackend answers every line read with the reply of a new handler per connection
+func startBackend(t *testing.T, newHandler func() func(line string) string) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				handler := newHandler()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if reply := handler(strings.TrimRight(line, "\r\n")); reply != "" {
+						conn.Write([]byte(reply))
+					}
+				}
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+func backendServer(t *testing.T, addr, alias string) config.Server {
+	server, err := config.ParseServer(addr + ":1 " + alias)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestProbeBackendKeys(t *testing.T) {
+	// answer DBSIZE only once the db of the pool is selected, the arguments come one per line
+	redis := startBackend(t, func() func(string) string {
+		selected := false
+		return func(line string) string {
+			switch line {
+			case "3":
+				selected = true
+				return "+OK\r\n"
+			case "DBSIZE":
+				if selected {
+					return ":42\r\n"
+				}
+				return "-ERR no db selected\r\n"
+			}
+			return ""
+		}
+	})
+	defer redis.Close()
+	memcache := startBackend(t, func() func(string) string {
+		return func(line string) string {
+			if line == "stats" {
+				return "STAT pid 1\r\nSTAT curr_items 7\r\nEND\r\n"
+			}
+			return ""
+		}
+	})
+	defer memcache.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	m, _ := NewMonitor(nil, "127.0.0.1:22222")
+	m.probeBackends = true
+	m.probeBackendKeys(context.Background(), map[string]config.Config{
+		"redis": {Protocol: config.ProtocolRedis, RedisDB: 3, Servers: []config.Server{
+			backendServer(t, redis.Addr().String(), "alpha"),
+			backendServer(t, closed.Addr().String(), "down"),
+		}},
+		"memcache": {Protocol: config.ProtocolMemcache, Servers: []config.Server{backendServer(t, memcache.Addr().String(), "beta")}},
+	})
+	if value := testutil.GatherValue(t, m.backendKeys.WithLabelValues(m.instance, "redis", "alpha"), "twemproxy_server_keys"); value != 42 {
+		t.Errorf("Expected 42 keys on alpha, got %f", value)
+	}
+	if value := testutil.GatherValue(t, m.backendKeys.WithLabelValues(m.instance, "memcache", "beta"), "twemproxy_server_keys"); value != 7 {
+		t.Errorf("Expected 7 items on beta, got %f", value)
+	}
+	if m.backendKeys.DeleteLabelValues(m.instance, "redis", "down") {
+		t.Errorf("Expected no keys of the unreachable server")
+	}
+}
+
+// passwordProber override the redis_auth of every pool
+type passwordProber struct {
+	directProber
+	password string
+}
+
+func (p passwordProber) Password(pool string, c config.Config) (string, error) {
+	return p.password, nil
+}
+
+func TestProbeBackendKeysAuth(t *testing.T) {
+	// the arguments of the commands come one per line, DBSIZE is only answered once authenticated
+	redis := startBackend(t, func() func(string) string {
+		authenticated := false
+		return func(line string) string {
+			switch line {
+			case "secret":
+				authenticated = true
+				return "+OK\r\n"
+			case "wrong":
+				return "-WRONGPASS invalid password\r\n"
+			case "DBSIZE":
+				if authenticated {
+					return ":5\r\n"
+				}
+				return "-NOAUTH Authentication required.\r\n"
+			}
+			return ""
+		}
+	})
+	defer redis.Close()
+	server := backendServer(t, redis.Addr().String(), "alpha")
+
+	keys, err := queryBackendKeys(context.Background(), directProber{}, "sessions", config.Config{Protocol: config.ProtocolRedis, RedisAuth: config.NewSecret("secret")}, server)
+	if err != nil || keys != 5 {
+		t.Errorf("Expected 5 keys with the redis_auth of the pool, got %f %v", keys, err)
+	}
+	_, err = queryBackendKeys(context.Background(), directProber{}, "sessions", config.Config{Protocol: config.ProtocolRedis}, server)
+	if err == nil {
+		t.Errorf("Expected an error without a password")
+	}
+	_, err = queryBackendKeys(context.Background(), passwordProber{password: "wrong"}, "sessions", config.Config{Protocol: config.ProtocolRedis, RedisAuth: config.NewSecret("secret")}, server)
+	if err == nil {
+		t.Errorf("Expected the prober password to override the redis_auth of the pool")
+	}
+}
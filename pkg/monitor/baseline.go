@@ -0,0 +1,24 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+// Baseline of a target, the last successful scrape its rates are computed from.
+// Persisted so a restarted exporter compute its first rates from where it stopped instead of starting over
+type Baseline struct {
+	Time  time.Time            `json:"time"`
+	Stats stats.TwemproxyStats `json:"stats"`
+}
+
+// Baseline of the monitor, false until the target has been scraped successfully
+func (m *Monitor) Baseline() (Baseline, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.prevTime.IsZero() {
+		return Baseline{}, false
+	}
+	return Baseline{Time: m.prevTime, Stats: m.prevStats}, true
+}
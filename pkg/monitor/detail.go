@@ -0,0 +1,73 @@
+package monitor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// detail levels of the metrics of a pool, see WithPoolDetail
+const (
+	DetailFull         = "full"              // every metric
+	DetailSummary      = "summary"           // the pool aggregates, no per server series
+	DetailAvailability = "availability-only" // twemproxy_server_up and twemproxy_pool_listen_reachable only
+)
+
+// ParsePoolDetail of comma separated pool=level, the pools not listed are full
+func ParsePoolDetail(s string) (map[string]string, error) {
+	detail := map[string]string{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid pool detail %q, expected pool=level", item)
+		}
+		switch parts[1] {
+		case DetailFull, DetailSummary, DetailAvailability:
+			detail[parts[0]] = parts[1]
+		default:
+			return nil, fmt.Errorf("Unknown detail level %q of pool %s, expected %s, %s or %s", parts[1], parts[0], DetailFull, DetailSummary, DetailAvailability)
+		}
+	}
+	return detail, nil
+}
+
+// detail level of a pool
+func (m *Monitor) detail(pool string) string {
+	if level, ok := m.poolDetail[pool]; ok {
+		return level
+	}
+	return DetailFull
+}
+
+// reduceDetail drop the series of the pools below full detail once the scrape updated them, series are the labels of
+// the server series of the scrape
+func (m *Monitor) reduceDetail(series map[string][]string, pools map[string]bool) {
+	for _, labels := range series {
+		switch m.detail(labels[1]) {
+		case DetailSummary:
+			m.deleteServerSeries(labels)
+		case DetailAvailability:
+			for name, metric := range m.serverMetrics {
+				if name != "up" {
+					metric.DeleteLabelValues(labels...)
+				}
+			}
+			m.rateMetrics.DeleteLabelValues(labels...)
+			m.backendKeys.DeleteLabelValues(labels...)
+		}
+	}
+	for pool := range pools {
+		if m.detail(pool) != DetailAvailability {
+			continue
+		}
+		for name, metric := range m.poolMetrics {
+			if name != "listen_reachable" {
+				metric.DeleteLabelValues(m.instance, pool)
+			}
+		}
+		m.poolRateMetrics.DeleteLabelValues(m.instance, pool)
+	}
+}
@@ -0,0 +1,18 @@
+package monitor
+
+import "testing"
+
+func TestParsePoolDetail(t *testing.T) {
+	detail, err := ParsePoolDetail("sessions=availability-only, payments=full")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(detail) != 2 || detail["sessions"] != DetailAvailability || detail["payments"] != DetailFull {
+		t.Errorf("Unexpected detail %+v", detail)
+	}
+	for _, invalid := range []string{"sessions", "sessions=verbose", "=summary"} {
+		if _, err := ParsePoolDetail(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
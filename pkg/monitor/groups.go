@@ -0,0 +1,37 @@
+package monitor
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+)
+
+// groups of metrics, each can be disabled with WithDisabledGroups.
+// twemproxy_up, the service metrics and the exporter own metrics are always exported
+const (
+	GroupPool   = "pool"   // servers configured and reported, mismatch, imbalance and pool info
+	GroupServer = "server" // up, connections, ejections, timeouts, request and error rates and hot backends
+	GroupBytes  = "bytes"  // request and response bytes of the pools and the servers, totals and rates
+	GroupQueues = "queues" // in queue requests and bytes of the servers
+	GroupProbes = "probes" // listen address probes of the pools and keys of the backend servers, not run when disabled
+)
+
+// group of the metrics of a collector.Metrics by key, the other keys are in the group of the whole set
+var (
+	poolMetricGroups   = map[string]string{"listen_reachable": GroupProbes, "listen_connect_seconds": GroupProbes, "request_bytes": GroupBytes, "response_bytes": GroupBytes}
+	serverMetricGroups = map[string]string{"in_queue": GroupQueues, "in_queue_bytes": GroupQueues}
+	rateMetricGroups   = map[string]string{"request_bytes": GroupBytes, "response_bytes": GroupBytes}
+)
+
+// collectGroup send the metrics of ms whose group is enabled
+func (m *Monitor) collectGroup(ch chan<- prometheus.Metric, ms collector.Metrics, group string, groups map[string]string) {
+	for key, metric := range ms {
+		g := group
+		if override, ok := groups[key]; ok {
+			g = override
+		}
+		if !m.disabled[g] {
+			metric.Collect(ch)
+		}
+	}
+}
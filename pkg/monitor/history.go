@@ -0,0 +1,64 @@
+package monitor
+
+import (
+	"sync"
+	"time"
+)
+
+// ScrapeRecord of one scrape of a target
+type ScrapeRecord struct {
+	Time     time.Time `json:"time"`
+	Status   string    `json:"status"` // ok or error
+	Duration float64   `json:"duration_seconds"`
+	Bytes    int       `json:"bytes"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// scrapeHistory keep the last scrapes of a target, so an intermittent failure can be looked at afterwards
+type scrapeHistory struct {
+	size    int
+	records []ScrapeRecord
+	next    int // oldest record once full
+	mu      sync.Mutex
+}
+
+func newScrapeHistory(size int) *scrapeHistory {
+	return &scrapeHistory{size: size}
+}
+
+// add a scrape, a nil history keep nothing
+func (h *scrapeHistory) add(start time.Time, bytes int, err error) {
+	if h == nil || h.size <= 0 {
+		return
+	}
+	record := ScrapeRecord{Time: start, Status: "ok", Duration: time.Since(start).Seconds(), Bytes: bytes}
+	if err != nil {
+		record.Status, record.Error = "error", err.Error()
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.records) < h.size {
+		h.records = append(h.records, record)
+		return
+	}
+	h.records[h.next] = record
+	h.next = (h.next + 1) % h.size
+}
+
+// records oldest first
+func (h *scrapeHistory) list() []ScrapeRecord {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	records := make([]ScrapeRecord, 0, len(h.records))
+	records = append(records, h.records[h.next:]...)
+	return append(records, h.records[:h.next]...)
+}
+
+// History of the last scrapes of the target oldest first, nil without WithHistory
+func (m *Monitor) History() []ScrapeRecord {
+	return m.history.list()
+}
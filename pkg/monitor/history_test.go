@@ -0,0 +1,31 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestScrapeHistory(t *testing.T) {
+	h := newScrapeHistory(3)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		var err error
+		if i == 3 {
+			err = errors.New("connection refused")
+		}
+		h.add(start.Add(time.Duration(i)*time.Second), i, err)
+	}
+	records := h.list()
+	if len(records) != 3 {
+		t.Fatalf("Expected the last 3 scrapes, got %+v", records)
+	}
+	for i, record := range records {
+		if record.Bytes != i+2 {
+			t.Errorf("Expected the scrapes oldest first, got %+v", records)
+		}
+	}
+	if records[1].Status != "error" || records[1].Error != "connection refused" {
+		t.Errorf("Expected the failed scrape, got %+v", records[1])
+	}
+}
@@ -0,0 +1,84 @@
+package monitor
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/twemproxy"
+)
+
+// Hooks of the embedding program into the scrapes, e.g. to trace, archive or count them. Nil hooks are skipped
+type Hooks struct {
+	// Trace start a span of a step of the scrape, child of the span of ctx. attrs are key, value pairs and end is
+	// called once with the error of the step
+	Trace func(ctx context.Context, name string, attrs ...string) (_ context.Context, end func(err error))
+	// Scraped payload of every successful scrape, started at start
+	Scraped func(address string, start time.Time, payload []byte)
+	// ParseFailed on a payload that could not be parsed
+	ParseFailed func(address string, payload []byte, err error)
+	// ScrapeFailed on every failed scrape, a scrape canceled by Stop is not a failure
+	ScrapeFailed func(address string, err error)
+	// BackendProbeFailed on every failed query of the keys of a backend server of the pool
+	BackendProbeFailed func(pool string, err error)
+}
+
+// trace a step, a no-op without the Trace hook
+func (h Hooks) trace(ctx context.Context, name string, attrs ...string) (context.Context, func(err error)) {
+	if h.Trace == nil {
+		return ctx, func(error) {}
+	}
+	return h.Trace(ctx, name, attrs...)
+}
+
+// traceFetch of the stats, with a span for the dial and one for the read once connected
+func (h Hooks) traceFetch(ctx context.Context) (context.Context, func(err error)) {
+	if h.Trace == nil {
+		return ctx, func(error) {}
+	}
+	ctx, fetched := h.Trace(ctx, "fetch")
+	_, dialed := h.Trace(ctx, "dial")
+	var read func(err error)
+	ctx = twemproxy.WithClientTrace(ctx, &twemproxy.ClientTrace{ConnectDone: func(err error) {
+		dialed(err)
+		if err == nil {
+			_, read = h.Trace(ctx, "read")
+		}
+	}})
+	return ctx, func(err error) {
+		// sources without dial, e.g. HTTP or files, have no dial nor read span
+		if read != nil {
+			read(err)
+		}
+		fetched(err)
+	}
+}
+
+// Prober connect the listen and backend probes to the pools, for pools whose servers need a password or TLS
+type Prober interface {
+	// DialListen connect to the listen address of the pool
+	DialListen(ctx context.Context, pool string, network string, address string) (net.Conn, error)
+	// DialBackend connect to a backend server of the pool
+	DialBackend(ctx context.Context, pool string, network string, address string) (net.Conn, error)
+	// Password of the redis servers of the pool, empty without AUTH
+	Password(pool string, c config.Config) (string, error)
+}
+
+// directProber dial without TLS and authenticate with the redis_auth of the pool
+type directProber struct{}
+
+func (directProber) DialListen(ctx context.Context, pool string, network string, address string) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext(ctx, network, address)
+}
+
+func (directProber) DialBackend(ctx context.Context, pool string, network string, address string) (net.Conn, error) {
+	return (&net.Dialer{}).DialContext(ctx, network, address)
+}
+
+func (directProber) Password(pool string, c config.Config) (string, error) {
+	if c.RedisAuth != nil {
+		return c.RedisAuth.Get()
+	}
+	return "", nil
+}
@@ -0,0 +1,117 @@
+package monitor
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+	"github.com/albert-widi/twemproxy_exporter/pkg/rate"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+// signals the backends can be ranked by
+const (
+	HotByRequests = "requests"
+	HotByErrors   = "errors"
+	HotByInQueue  = "in_queue"
+)
+
+var hotSignals = []string{HotByRequests, HotByErrors, HotByInQueue}
+
+// HotServer of a pool with the value it was ranked by
+type HotServer struct {
+	Server string  `json:"server"`
+	Value  float64 `json:"value"`
+}
+
+// HotPool is the top servers of a pool of a target
+type HotPool struct {
+	Target   string      `json:"target"`
+	Instance string      `json:"instance"`
+	Pool     string      `json:"pool"`
+	Servers  []HotServer `json:"servers"`
+}
+
+// hotServers of the pool, the n highest by request rate, error rate or in queue requests.
+// Rates are missing until the second scrape, ranking by them return nothing until then
+func hotServers(st stats.TwemproxyStats, rates map[string]rate.PoolRates, pool string, by string, n int) []HotServer {
+	var servers []HotServer
+	for name, server := range st.Services[pool].Servers {
+		hot := HotServer{Server: server.HostAlias}
+		switch by {
+		case HotByInQueue:
+			hot.Value = server.InQueue
+		default:
+			r, ok := rates[pool].Servers[name]
+			if !ok {
+				continue
+			}
+			hot.Value = r.Requests
+			if by == HotByErrors {
+				hot.Value = r.Errors
+			}
+		}
+		servers = append(servers, hot)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if servers[i].Value != servers[j].Value {
+			return servers[i].Value > servers[j].Value
+		}
+		return servers[i].Server < servers[j].Server
+	})
+	if len(servers) > n {
+		servers = servers[:n]
+	}
+	return servers
+}
+
+// Hot servers of every pool of the target over the last scrape interval, ranked by one of the HotBy signals
+func (m *Monitor) Hot(by string, n int) []HotPool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	pools := make([]string, 0, len(m.prevStats.Services))
+	for name := range m.prevStats.Services {
+		pools = append(pools, name)
+	}
+	sort.Strings(pools)
+
+	var hot []HotPool
+	for _, pool := range pools {
+		hot = append(hot, HotPool{
+			Target:   m.tcpHost,
+			Instance: m.instance,
+			Pool:     pool,
+			Servers:  hotServers(m.prevStats, m.rates, pool, by, n),
+		})
+	}
+	return hot
+}
+
+// newHotMetric of one target, only the top servers of every pool have a series so its cardinality is bounded
+func newHotMetric(constLabels prometheus.Labels, labels collector.ServerLabels) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace:   collector.Namespace,
+			Name:        "pool_hot_server",
+			Help:        "Top servers of the pool by request rate, error rate or in queue requests over the last scrape interval, the value is the one ranked by",
+			ConstLabels: constLabels,
+		},
+		append(labels.Names(), "by"),
+	)
+}
+
+// setHotMetric to the top n servers of every pool, the previous top are dropped
+func (m *Monitor) setHotMetric(st stats.TwemproxyStats, rates map[string]rate.PoolRates, n int) {
+	m.hotMetric.Reset()
+	for pool := range st.Services {
+		if m.detail(pool) != DetailFull {
+			continue
+		}
+		for _, by := range hotSignals {
+			for _, hot := range hotServers(st, rates, pool, by, n) {
+				m.hotMetric.WithLabelValues(m.instance, pool, hot.Server, by).Set(hot.Value)
+			}
+		}
+	}
+}
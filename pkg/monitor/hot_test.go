@@ -0,0 +1,38 @@
+package monitor
+
+import (
+	"testing"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/rate"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+func TestHotServers(t *testing.T) {
+	st := stats.TwemproxyStats{Services: map[string]stats.ServiceStats{
+		"pool": {Servers: map[string]stats.ServerStats{
+			"a": {HostAlias: "a", InQueue: 1},
+			"b": {HostAlias: "b", InQueue: 9},
+			"c": {HostAlias: "c", InQueue: 5},
+		}},
+	}}
+	rates := map[string]rate.PoolRates{
+		"pool": {Servers: map[string]rate.ServerRates{
+			"a": {Requests: 300},
+			"b": {Requests: 100},
+			"c": {Requests: 200, Errors: 1},
+		}},
+	}
+
+	hot := hotServers(st, rates, "pool", HotByRequests, 2)
+	if len(hot) != 2 || hot[0].Server != "a" || hot[1].Server != "c" {
+		t.Errorf("Unexpected top servers by requests %+v", hot)
+	}
+	hot = hotServers(st, rates, "pool", HotByInQueue, 1)
+	if len(hot) != 1 || hot[0].Server != "b" || hot[0].Value != 9 {
+		t.Errorf("Unexpected top servers by in_queue %+v", hot)
+	}
+	hot = hotServers(st, nil, "pool", HotByErrors, 3)
+	if len(hot) != 0 {
+		t.Errorf("Expected no ranking by rate before the second scrape, got %+v", hot)
+	}
+}
@@ -0,0 +1,81 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// listenProbeTimeout of a dial to the listen address of a pool
+const listenProbeTimeout = time.Second * 2
+
+// listenPings sent by WithListenProbe, twemproxy answer PING itself while the get goes to a memcache server
+var listenPings = map[string]string{
+	config.ProtocolRedis:    "PING\r\n",
+	config.ProtocolMemcache: "get twemproxy_exporter_probe\r\n",
+}
+
+// probeListens dial the listen address of every pool concurrently, setting listen_reachable and listen_connect_seconds.
+// twemproxy can report healthy stats while a pool refuse clients, e.g. when it ran out of file descriptors
+func (m *Monitor) probeListens(ctx context.Context, conf map[string]config.Config) {
+	var wg sync.WaitGroup
+	for name, c := range conf {
+		if c.Listen == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, c config.Config) {
+			defer wg.Done()
+			ctx, end := m.hooks.trace(ctx, "probe_listen", "pool", name, "listen", c.Listen)
+			start := time.Now()
+			err := m.dialListen(ctx, name, c)
+			end(err)
+			if err != nil {
+				m.poolMetrics["listen_reachable"].WithLabelValues(m.instance, name).Set(0)
+				m.poolMetrics["listen_connect_seconds"].DeleteLabelValues(m.instance, name)
+				return
+			}
+			m.poolMetrics["listen_reachable"].WithLabelValues(m.instance, name).Set(1)
+			m.poolMetrics["listen_connect_seconds"].WithLabelValues(m.instance, name).Set(time.Since(start).Seconds())
+		}(name, c)
+	}
+	wg.Wait()
+}
+
+// dialListen connect to the listen address of the pool, with the listen ping a reply to the ping is expected.
+// Any reply line to the ping will do, but pools with a password are authenticated first and a rejected AUTH is down
+func (m *Monitor) dialListen(ctx context.Context, name string, c config.Config) error {
+	ctx, cancel := context.WithTimeout(ctx, listenProbeTimeout)
+	defer cancel()
+	conn, err := m.prober.DialListen(ctx, name, c.ListenNetwork, c.ListenAddress(m.tcpHost))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ping, ok := listenPings[c.Protocol]
+	if !m.listenPing || !ok {
+		return nil
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	r := bufio.NewReader(conn)
+	if c.Protocol == config.ProtocolRedis {
+		password, err := m.prober.Password(name, c)
+		if err != nil {
+			return err
+		}
+		err = redisAuth(conn, r, password)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = conn.Write([]byte(ping))
+	if err != nil {
+		return err
+	}
+	_, err = r.ReadString('\n')
+	return err
+}
@@ -0,0 +1,122 @@
+package monitor
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func TestProbeListens(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	m, _ := NewMonitor(nil, "127.0.0.1:22222")
+	m.probeListens(context.Background(), map[string]config.Config{
+		"up":   {Listen: listener.Addr().String(), ListenNetwork: "tcp"},
+		"down": {Listen: closed.Addr().String(), ListenNetwork: "tcp"},
+	})
+	if value := testutil.GatherValue(t, m.poolMetrics["listen_reachable"].WithLabelValues(m.instance, "up"), "twemproxy_pool_listen_reachable"); value != 1 {
+		t.Errorf("Expected pool up to be up, got %f", value)
+	}
+	if value := testutil.GatherValue(t, m.poolMetrics["listen_reachable"].WithLabelValues(m.instance, "down"), "twemproxy_pool_listen_reachable"); value != 0 {
+		t.Errorf("Expected pool down to be down, got %f", value)
+	}
+}
+
+func TestProbeListensPing(t *testing.T) {
+	// accept connections without ever answering, like a wedged twemproxy
+	wedged, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wedged.Close()
+	redis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redis.Close()
+	go func() {
+		for {
+			conn, err := redis.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				if line == "PING\r\n" {
+					conn.Write([]byte("+PONG\r\n"))
+				}
+			}(conn)
+		}
+	}()
+
+	m, _ := New(WithHost("127.0.0.1:22222"))
+	m.listenPing = true
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	m.probeListens(ctx, map[string]config.Config{
+		"up":     {Listen: redis.Addr().String(), ListenNetwork: "tcp", Protocol: config.ProtocolRedis},
+		"wedged": {Listen: wedged.Addr().String(), ListenNetwork: "tcp", Protocol: config.ProtocolRedis},
+	})
+	if value := testutil.GatherValue(t, m.poolMetrics["listen_reachable"].WithLabelValues(m.instance, "up"), "twemproxy_pool_listen_reachable"); value != 1 {
+		t.Errorf("Expected pool up to answer the ping, got %f", value)
+	}
+	if value := testutil.GatherValue(t, m.poolMetrics["listen_reachable"].WithLabelValues(m.instance, "wedged"), "twemproxy_pool_listen_reachable"); value != 0 {
+		t.Errorf("Expected the wedged pool down, got %f", value)
+	}
+	if value := testutil.GatherValue(t, m.poolMetrics["listen_connect_seconds"].WithLabelValues(m.instance, "up"), "twemproxy_pool_listen_connect_seconds"); value <= 0 {
+		t.Errorf("Expected the connect latency of pool up, got %f", value)
+	}
+}
+
+// countingProber count the dials of the probes
+type countingProber struct {
+	directProber
+	dials int32
+}
+
+func (p *countingProber) DialListen(ctx context.Context, pool string, network string, address string) (net.Conn, error) {
+	atomic.AddInt32(&p.dials, 1)
+	return p.directProber.DialListen(ctx, pool, network, address)
+}
+
+func (p *countingProber) DialBackend(ctx context.Context, pool string, network string, address string) (net.Conn, error) {
+	atomic.AddInt32(&p.dials, 1)
+	return p.directProber.DialBackend(ctx, pool, network, address)
+}
+
+func TestDisabledProbesNotRun(t *testing.T) {
+	listener := serveStats(t, "127.0.0.1:0", 0)
+	defer listener.Close()
+	for _, disabled := range []bool{true, false} {
+		prober := &countingProber{}
+		m, _ := New(WithConfig(loadConfig(t)), WithHost(listener.Addr().String()), WithListenProbe(false), WithBackendProbe(),
+			WithProber(prober), WithDisabledGroups(map[string]bool{GroupProbes: disabled}))
+		err := m.Run(context.Background())
+		if err != nil {
+			t.Fatal("Failed to run monitor: ", err.Error())
+		}
+		dials := atomic.LoadInt32(&prober.dials)
+		if disabled && dials != 0 {
+			t.Errorf("Expected no probe with the probes group disabled, got %d dials", dials)
+		}
+		if !disabled && dials == 0 {
+			t.Error("Expected the listen and backend probes run")
+		}
+	}
+}
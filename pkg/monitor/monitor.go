@@ -0,0 +1,555 @@
+// Package monitor scrape one twemproxy on its own ticker and keep its metrics, the scrape loop of the exporter
+package monitor
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/rate"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+	"github.com/albert-widi/twemproxy_exporter/pkg/twemproxy"
+)
+
+// DefaultHost is the nutcracker default stats port on the same host
+const DefaultHost = "localhost:22222"
+
+// Monitor object
+type Monitor struct {
+	conf      map[string]config.Config // of every pool, see SetConfig
+	tcpHost   string
+	instance  string                // value of the instance label
+	tlsConfig *tls.Config           // nil when the stats endpoint is plain TCP
+	source    twemproxy.StatsSource // of the stats, the TCP stats port at tcpHost when nil
+	dialer    twemproxy.ContextDialer
+	interval  time.Duration
+	timeout   time.Duration // of the stats fetch, stats.Timeout when zero
+	liveness  time.Duration // interval of the TCP connect check driving twemproxy_up, disabled when zero
+	// consecutive failed scrapes after which the target is only scraped every quarantineInterval, disabled when zero
+	quarantineAfter    int
+	quarantineInterval time.Duration
+	quarantined        int32
+	series             map[string][]string
+	pools              map[string]bool
+	poolFilter         []string     // pools of the config collected, every pool when empty
+	observe            func(Result) // called after every scrape, nil when nobody observe
+	hooks              Hooks        // of the embedding program
+	prober             Prober       // of the listen and backend probes
+	logger             *log.Logger
+	labels             map[string]string // of every metric of the target
+	serverLabels       collector.ServerLabels
+	// metrics of this target only, labeled with the target labels
+	twemproxyMetrics collector.Metrics
+	statusMetrics    collector.Metrics
+	poolMetrics      collector.Metrics
+	poolInfo         *prometheus.GaugeVec
+	serverMetrics    collector.Metrics
+	rateMetrics      collector.Metrics
+	poolRateMetrics  collector.Metrics
+	withRates        bool              // export rateMetrics
+	disabled         map[string]bool   // groups of metrics not exported
+	poolDetail       map[string]string // detail level by pool, full when missing
+	hotMetric        *prometheus.GaugeVec
+	fetchDuration    *prometheus.HistogramVec
+	topN             int // servers per pool in hotMetric, disabled when 0
+	probeListen      bool
+	listenPing       bool // send a request of the pool protocol to the listen address once connected
+	probeBackends    bool // query the keys of every backend server into backendKeys
+	backendKeys      *prometheus.GaugeVec
+	history          *scrapeHistory
+	dropStale        bool // drop the values of the target when a scrape fail instead of keeping the last good ones
+	// previous successful scrape, to compute the rates
+	prevStats stats.TwemproxyStats
+	prevTime  time.Time
+	rates     map[string]rate.PoolRates
+	lastErr   error
+	lastLoop  int64
+	lastOK    int64
+	ctx       context.Context // canceled by Stop, nil until Start
+	cancel    context.CancelFunc
+	done      chan struct{}
+	mu        sync.RWMutex
+	scrapeMu  sync.Mutex // one scrape at a time, of the loop or forced through Scrape
+}
+
+// Result of one scrape, passed to the observer of the monitor
+type Result struct {
+	Address string
+	Stats   stats.TwemproxyStats
+	Rates   map[string]rate.PoolRates // nil until the target has been scraped successfully twice
+	Err     error
+	Time    time.Time
+}
+
+// NewMonitor of host with the pools of conf.
+//
+// Deprecated: use New with WithConfig and WithHost
+func NewMonitor(conf map[string]config.Config, host string) (*Monitor, error) {
+	// set host to localhost:2222 if host is not exists (default port of nutcracker)
+	if host == "" {
+		host = DefaultHost
+	}
+	return New(WithConfig(conf), WithHost(host))
+}
+
+// SetLabels attached to every metric of the target, must be called before Start
+func (m *Monitor) SetLabels(labels map[string]string) {
+	m.labels = labels
+	m.twemproxyMetrics = collector.NewTwemproxyMetrics(labels)
+	m.statusMetrics = collector.NewStatusMetrics(labels)
+	m.poolMetrics = collector.NewPoolMetrics(labels)
+	m.poolInfo = collector.NewPoolInfoMetric(labels)
+	m.serverMetrics = collector.NewServerMetrics(labels, m.serverLabels)
+	m.rateMetrics = collector.NewRateMetrics(labels, m.serverLabels)
+	m.poolRateMetrics = collector.NewPoolRateMetrics(labels)
+	m.hotMetric = newHotMetric(labels, m.serverLabels)
+	m.backendKeys = newBackendKeysMetric(labels, m.serverLabels)
+	m.fetchDuration = newFetchDurationMetric(labels)
+}
+
+// newFetchDurationMetric of one target, the fetch of the stats payload only, without parsing and updating the metrics
+func newFetchDurationMetric(constLabels prometheus.Labels) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace:   collector.Namespace,
+		Subsystem:   "exporter",
+		Name:        "target_fetch_duration_seconds",
+		Help:        "Duration of the fetch of the stats of the target, failed fetches included",
+		Buckets:     prometheus.ExponentialBuckets(0.001, 2, 15),
+		ConstLabels: constLabels,
+	}, []string{"instance"})
+}
+
+// Describe nothing, the series of the target depend on its config and stats so the monitor is an unchecked collector
+func (m *Monitor) Describe(ch chan<- *prometheus.Desc) {}
+
+// Collect the target metrics
+func (m *Monitor) Collect(ch chan<- prometheus.Metric) {
+	if success := m.LastSuccess(); !success.IsZero() {
+		m.statusMetrics["data_age_seconds"].WithLabelValues(m.instance).Set(time.Since(success).Seconds())
+	}
+	m.statusMetrics.Collect(ch)
+	m.twemproxyMetrics.Collect(ch)
+	m.collectGroup(ch, m.poolMetrics, GroupPool, poolMetricGroups)
+	if !m.disabled[GroupPool] {
+		m.poolInfo.Collect(ch)
+	}
+	m.collectGroup(ch, m.serverMetrics, GroupServer, serverMetricGroups)
+	m.fetchDuration.Collect(ch)
+	if m.withRates {
+		m.collectGroup(ch, m.rateMetrics, GroupServer, rateMetricGroups)
+		m.collectGroup(ch, m.poolRateMetrics, GroupBytes, nil)
+	}
+	if m.topN > 0 && !m.disabled[GroupServer] {
+		m.hotMetric.Collect(ch)
+	}
+	if m.probeBackends && !m.disabled[GroupProbes] {
+		m.backendKeys.Collect(ch)
+	}
+}
+
+// Address of the stats port of the target
+func (m *Monitor) Address() string {
+	return m.tcpHost
+}
+
+// Instance is the value of the instance label of the target metrics
+func (m *Monitor) Instance() string {
+	return m.instance
+}
+
+// LastStats of the last successful scrape, up is false when the last scrape failed
+func (m *Monitor) LastStats() (st stats.TwemproxyStats, up bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.prevStats, m.lastErr == nil && !m.prevTime.IsZero()
+}
+
+// Rates over the last scrape interval, nil until the target has been scraped successfully twice
+func (m *Monitor) Rates() map[string]rate.PoolRates {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.rates
+}
+
+// SetConfig replace the config used on the next run
+func (m *Monitor) SetConfig(conf map[string]config.Config) {
+	m.mu.Lock()
+	m.conf = conf
+	m.mu.Unlock()
+}
+
+// config of the pools collected from the target
+func (m *Monitor) config() map[string]config.Config {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if len(m.poolFilter) == 0 {
+		return m.conf
+	}
+	filtered := make(map[string]config.Config)
+	for _, name := range m.poolFilter {
+		if c, ok := m.conf[name]; ok {
+			filtered[name] = c
+		}
+	}
+	return filtered
+}
+
+// Start running the monitor every interval until Stop is called
+func (m *Monitor) Start(interval time.Duration) {
+	m.interval = interval
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	m.done = make(chan struct{})
+	ctx := m.ctx
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		failures := 0
+		if m.quarantineAfter > 0 {
+			m.setQuarantined(false)
+		}
+		// nil channel never fire when the liveness check is disabled
+		var liveness <-chan time.Time
+		if m.liveness > 0 {
+			livenessTicker := time.NewTicker(m.liveness)
+			defer livenessTicker.Stop()
+			liveness = livenessTicker.C
+		}
+		for {
+			atomic.StoreInt64(&m.lastLoop, time.Now().UnixNano())
+			select {
+			case <-liveness:
+				m.checkLiveness(ctx)
+			case <-ticker.C:
+				result := m.Scrape(ctx)
+				if ctx.Err() != nil {
+					// stopped during the scrape, not a failure of the target
+					return
+				}
+				err := result.Err
+				if err != nil {
+					m.logger.Printf("Error when running monitor %s: %s", m.tcpHost, err.Error())
+					failures++
+					if m.quarantineAfter > 0 && failures == m.quarantineAfter {
+						m.logger.Printf("Quarantining %s after %d failed scrapes, retrying every %s", m.tcpHost, failures, m.quarantineInterval)
+						m.setQuarantined(true)
+						ticker.Reset(m.quarantineInterval)
+					}
+				} else {
+					if m.Quarantined() {
+						m.logger.Printf("Restoring %s from quarantine", m.tcpHost)
+						m.setQuarantined(false)
+						ticker.Reset(interval)
+					}
+					failures = 0
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Scrape the target now and notify the observer, unless ctx is canceled during the scrape.
+// It wait for the scrape of the loop in flight, if any
+func (m *Monitor) Scrape(ctx context.Context) Result {
+	st, err := m.scrape(ctx)
+	result := Result{Address: m.tcpHost, Stats: st, Err: err, Time: time.Now()}
+	if ctx.Err() != nil {
+		return result
+	}
+	m.mu.Lock()
+	m.lastErr = err
+	m.mu.Unlock()
+	if err == nil {
+		result.Rates = m.Rates()
+	}
+	if m.observe != nil {
+		m.observe(result)
+	}
+	return result
+}
+
+// Stop the monitor loop, its metrics stay until the monitor is dropped
+func (m *Monitor) Stop() {
+	m.cancel()
+	<-m.done
+}
+
+// Context of the monitor loop, canceled by Stop. The background until Start
+func (m *Monitor) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// Quarantined is true while the target is scraped at the quarantine interval
+func (m *Monitor) Quarantined() bool {
+	return atomic.LoadInt32(&m.quarantined) == 1
+}
+
+func (m *Monitor) setQuarantined(quarantined bool) {
+	value := 0.0
+	if quarantined {
+		value = 1
+		atomic.StoreInt32(&m.quarantined, 1)
+	} else {
+		atomic.StoreInt32(&m.quarantined, 0)
+	}
+	m.statusMetrics["target_quarantined"].WithLabelValues(m.instance).Set(value)
+}
+
+// LastLoop is the last time the monitor loop ticked
+func (m *Monitor) LastLoop() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&m.lastLoop))
+}
+
+// LastSuccess is the last time the target was scraped successfully, zero when it never was
+func (m *Monitor) LastSuccess() time.Time {
+	ok := atomic.LoadInt64(&m.lastOK)
+	if ok == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ok)
+}
+
+// Healthy is true when the monitor loop has ticked in the last two intervals and a scrape timeout,
+// a wedged loop make it false
+func (m *Monitor) Healthy() bool {
+	timeout := m.timeout
+	if timeout == 0 {
+		timeout = stats.Timeout
+	}
+	interval := m.interval
+	if m.Quarantined() {
+		interval = m.quarantineInterval
+	}
+	return time.Since(m.LastLoop()) <= interval*2+timeout
+}
+
+func (m *Monitor) deleteServerSeries(labels []string) {
+	m.serverMetrics.DeleteLabelValues(labels...)
+	m.rateMetrics.DeleteLabelValues(labels...)
+	m.backendKeys.DeleteLabelValues(labels...)
+}
+
+// setPoolInfo of the pools of the config, pools removed by a reload are dropped
+func (m *Monitor) setPoolInfo(conf map[string]config.Config) {
+	m.poolInfo.Reset()
+	for name, c := range conf {
+		if m.detail(name) == DetailAvailability {
+			continue
+		}
+		m.poolInfo.WithLabelValues(m.instance, name, c.Protocol, c.Listen, c.Hash, c.HashTag, c.Distribution, strconv.Itoa(c.Timeout)).Set(1)
+	}
+}
+
+// statsSource of the target
+func (m *Monitor) statsSource() twemproxy.StatsSource {
+	if m.source == nil {
+		return &twemproxy.TCPSource{Address: m.tcpHost, TLSConfig: m.tlsConfig, Dialer: m.dialer}
+	}
+	return m.source
+}
+
+// Run monitoring once
+func (m *Monitor) Run(ctx context.Context) error {
+	_, err := m.scrape(ctx)
+	return err
+}
+
+// scrape the target stats and update its metrics
+func (m *Monitor) scrape(ctx context.Context) (stats.TwemproxyStats, error) {
+	m.scrapeMu.Lock()
+	defer m.scrapeMu.Unlock()
+	ctx, end := m.hooks.trace(ctx, "scrape", "target", m.tcpHost, "instance", m.instance)
+	start := time.Now()
+	client := &twemproxy.Client{Source: m.statsSource(), Timeout: m.timeout}
+	fetchCtx, fetched := m.hooks.traceFetch(ctx)
+	reply, err := client.FetchRaw(fetchCtx)
+	fetched(err)
+	if ctx.Err() != context.Canceled {
+		m.fetchDuration.WithLabelValues(m.instance).Observe(time.Since(start).Seconds())
+	}
+	st := stats.TwemproxyStats{}
+	if err == nil {
+		st, err = m.update(ctx, reply)
+		if err != nil && m.hooks.ParseFailed != nil {
+			m.hooks.ParseFailed(m.tcpHost, reply, err)
+		}
+	}
+	if err == nil && m.hooks.Scraped != nil {
+		m.hooks.Scraped(m.tcpHost, start, reply)
+	}
+	m.history.add(start, len(reply), err)
+	if err == nil {
+		atomic.StoreInt64(&m.lastOK, time.Now().UnixNano())
+	} else if ctx.Err() != context.Canceled && m.hooks.ScrapeFailed != nil {
+		m.hooks.ScrapeFailed(m.tcpHost, err)
+	}
+	m.setStatus(err)
+	end(err)
+	return st, err
+}
+
+// setStatus of the last scrape, the values of a failed scrape are the last good ones unless dropStale
+func (m *Monitor) setStatus(err error) {
+	up, stale := 1.0, 0.0
+	if err != nil {
+		up = 0
+		if m.dropStale {
+			m.dropSeries()
+		} else if !m.LastSuccess().IsZero() {
+			stale = 1
+		}
+	}
+	m.statusMetrics["up"].WithLabelValues(m.instance).Set(up)
+	m.statusMetrics["data_stale"].WithLabelValues(m.instance).Set(stale)
+}
+
+// checkLiveness of the stats port with a connect, much cheaper than a full scrape so it can run every few seconds.
+// It only drive twemproxy_up, the stats and their staleness are updated by the scrapes.
+// Sources without a connection to check, HTTP and files, are left to the scrapes
+func (m *Monitor) checkLiveness(ctx context.Context) {
+	pinger, ok := m.statsSource().(twemproxy.Pinger)
+	if !ok {
+		return
+	}
+	timeout := m.timeout
+	if timeout == 0 {
+		timeout = stats.Timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	up := 1.0
+	err := pinger.Ping(ctx)
+	if err != nil {
+		up = 0
+		m.logger.Printf("Liveness check of %s failed: %s", m.tcpHost, err.Error())
+	}
+	m.statusMetrics["up"].WithLabelValues(m.instance).Set(up)
+}
+
+// dropSeries of every twemproxy, pool and server metric of the target
+func (m *Monitor) dropSeries() {
+	for _, ms := range []collector.Metrics{m.twemproxyMetrics, m.poolMetrics, m.serverMetrics, m.rateMetrics, m.poolRateMetrics} {
+		ms.Reset()
+	}
+	m.poolInfo.Reset()
+	m.hotMetric.Reset()
+	m.backendKeys.Reset()
+	m.mu.Lock()
+	m.series = make(map[string][]string)
+	m.pools = make(map[string]bool)
+	m.mu.Unlock()
+}
+
+// update the metrics from the stats payload
+func (m *Monitor) update(ctx context.Context, reply []byte) (stats.TwemproxyStats, error) {
+	conf := m.config()
+	_, parsed := m.hooks.trace(ctx, "parse", "bytes", strconv.Itoa(len(reply)))
+	st, err := stats.Parse(reply, conf)
+	parsed(err)
+	if err != nil {
+		return stats.TwemproxyStats{}, err
+	}
+	// the probes of a disabled group are not run at all, they cost a connection per pool and server
+	if m.probeListen && !m.disabled[GroupProbes] {
+		m.probeListens(ctx, conf)
+	}
+	if m.probeBackends && !m.disabled[GroupProbes] {
+		m.probeBackendKeys(ctx, conf)
+	}
+	_, published := m.hooks.trace(ctx, "publish")
+	defer published(nil)
+	m.setPoolInfo(conf)
+	now := time.Now()
+	var rates map[string]rate.PoolRates
+	if !m.prevTime.IsZero() {
+		rates = rate.Compute(m.prevStats, st, now.Sub(m.prevTime))
+	}
+	collector.Update(m.instance, st, m.twemproxyMetrics, m.serverMetrics, m.serverLabels)
+	series := make(map[string][]string)
+	pools := make(map[string]bool)
+	for name, pool := range conf {
+		// a pool missing from the stats is reported with 0 servers
+		service := st.Services[name]
+		mismatch := 0.0
+		if service.Missing > 0 || service.Reported != len(pool.Servers) {
+			mismatch = 1
+		}
+		m.poolMetrics["servers_configured"].WithLabelValues(m.instance, name).Set(float64(len(pool.Servers)))
+		m.poolMetrics["servers_reported"].WithLabelValues(m.instance, name).Set(float64(service.Reported))
+		m.poolMetrics["servers_mismatch"].WithLabelValues(m.instance, name).Set(mismatch)
+		m.poolMetrics["unknown_servers"].WithLabelValues(m.instance, name).Set(float64(len(service.Unknown)))
+		pools[name] = true
+	}
+	for serviceName, service := range st.Services {
+		pools[serviceName] = true
+		if r, ok := rates[serviceName]; ok {
+			m.poolMetrics["request_imbalance"].WithLabelValues(m.instance, serviceName).Set(r.RequestImbalance)
+			m.poolRateMetrics["request_bytes"].WithLabelValues(m.instance, serviceName).Set(r.RequestBytes)
+			m.poolRateMetrics["response_bytes"].WithLabelValues(m.instance, serviceName).Set(r.ResponseBytes)
+		}
+		requestBytes, responseBytes := 0.0, 0.0
+		for _, server := range service.Servers {
+			requestBytes += server.RequestBytes
+			responseBytes += server.ResponseBytes
+		}
+		m.poolMetrics["request_bytes"].WithLabelValues(m.instance, serviceName).Set(requestBytes)
+		m.poolMetrics["response_bytes"].WithLabelValues(m.instance, serviceName).Set(responseBytes)
+		for _, server := range service.Servers {
+			labels := m.serverLabels.Values(m.instance, serviceName, server)
+			series[strings.Join(labels, "\xff")] = labels
+		}
+		if m.serverLabels.Configured {
+			for _, server := range service.Unknown {
+				labels := m.serverLabels.Values(m.instance, serviceName, server)
+				series[strings.Join(labels, "\xff")] = labels
+			}
+		}
+		for name, r := range rates[serviceName].Servers {
+			labels := m.serverLabels.Values(m.instance, serviceName, service.Servers[name])
+			m.rateMetrics["requests"].WithLabelValues(labels...).Set(r.Requests)
+			m.rateMetrics["request_bytes"].WithLabelValues(labels...).Set(r.RequestBytes)
+			m.rateMetrics["response_bytes"].WithLabelValues(labels...).Set(r.ResponseBytes)
+			m.rateMetrics["errors"].WithLabelValues(labels...).Set(r.Errors)
+		}
+	}
+
+	if m.topN > 0 {
+		m.setHotMetric(st, rates, m.topN)
+	}
+	if len(m.poolDetail) > 0 {
+		m.reduceDetail(series, pools)
+	}
+
+	// pools and servers removed from the config since the last run
+	m.mu.Lock()
+	for key, labels := range m.series {
+		if _, ok := series[key]; !ok {
+			m.deleteServerSeries(labels)
+		}
+	}
+	m.series = series
+	for pool := range m.pools {
+		if !pools[pool] {
+			m.poolMetrics.DeleteLabelValues(m.instance, pool)
+			m.poolRateMetrics.DeleteLabelValues(m.instance, pool)
+		}
+	}
+	m.pools = pools
+	m.prevStats, m.prevTime, m.rates = st, now, rates
+	m.mu.Unlock()
+	return st, nil
+}
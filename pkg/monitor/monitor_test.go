@@ -0,0 +1,222 @@
+package monitor
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/albert-widi/twemproxy_exporter/internal/testutil"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+func testSeriesCount(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric, 100)
+	c.Collect(ch)
+	close(ch)
+	return len(ch)
+}
+
+// serveStats of the example payload on address after delay, like the stats port of twemproxy
+func serveStats(t *testing.T, address string, delay time.Duration) net.Listener {
+	payload, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Fatal("Failed to read json example: ", err.Error())
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatal("Failed to serve stats: ", err.Error())
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				time.Sleep(delay)
+				conn.Write(payload)
+			}(conn)
+		}
+	}()
+	return listener
+}
+
+func loadConfig(t *testing.T) map[string]config.Config {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	return conf
+}
+
+func TestNewMonitor(t *testing.T) {
+	m, err := NewMonitor(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Address() != DefaultHost {
+		t.Errorf("Expected the default stats port, got %s", m.Address())
+	}
+	m, _ = New(WithHost("10.0.0.1:22222"), WithInstance("proxy-1"))
+	if m.Address() != "10.0.0.1:22222" || m.Instance() != "proxy-1" {
+		t.Errorf("Expected the options applied, got %s %s", m.Address(), m.Instance())
+	}
+}
+
+func TestPools(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker-include.yml")
+	if err != nil {
+		t.Fatal("Failed to load config: ", err.Error())
+	}
+	m, _ := New(WithConfig(conf))
+	if len(m.config()) != len(conf) {
+		t.Error("Expected every pool without WithPools")
+	}
+	m, _ = New(WithConfig(conf), WithPools([]string{"wallet-oauth-token", "missing"}))
+	filtered := m.config()
+	if _, ok := filtered["wallet-oauth-token"]; !ok || len(filtered) != 1 {
+		t.Errorf("Expected only wallet-oauth-token, got %v", filtered)
+	}
+}
+
+func TestStaleData(t *testing.T) {
+	conf := loadConfig(t)
+	for _, dropStale := range []bool{false, true} {
+		listener := serveStats(t, "127.0.0.1:0", 0)
+		m, _ := New(WithConfig(conf), WithHost(listener.Addr().String()), WithDropStale(dropStale))
+		err := m.Run(context.Background())
+		if err != nil {
+			t.Fatal("Failed to run monitor: ", err.Error())
+		}
+		if value := testutil.GatherValue(t, m.statusMetrics["up"], "twemproxy_up"); value != 1 {
+			t.Errorf("Expected twemproxy up, got %f", value)
+		}
+
+		listener.Close()
+		if m.Run(context.Background()) == nil {
+			t.Fatal("Expected the scrape of the closed stats port to fail")
+		}
+		if value := testutil.GatherValue(t, m.statusMetrics["up"], "twemproxy_up"); value != 0 {
+			t.Errorf("Expected twemproxy down, got %f", value)
+		}
+		stale := testutil.GatherValue(t, m.statusMetrics["data_stale"], "twemproxy_exporter_data_stale")
+		series := testSeriesCount(m.serverMetrics["in_queue"])
+		if !dropStale && (stale != 1 || series == 0) {
+			t.Errorf("Expected the last values flagged stale, got stale %f with %d series", stale, series)
+		}
+		if dropStale && (stale != 0 || series != 0) {
+			t.Errorf("Expected the values dropped, got stale %f with %d series", stale, series)
+		}
+	}
+}
+
+func TestLivenessCheck(t *testing.T) {
+	listener := serveStats(t, "127.0.0.1:0", 0)
+	m, _ := New(WithConfig(loadConfig(t)), WithHost(listener.Addr().String()), WithLiveness(time.Millisecond*10))
+	m.Start(time.Hour)
+	defer m.Stop()
+
+	// the full scrape is an hour away, only the liveness check run
+	time.Sleep(time.Millisecond * 100)
+	if value := testutil.GatherValue(t, m.statusMetrics["up"], "twemproxy_up"); value != 1 {
+		t.Errorf("Expected twemproxy up from the liveness check, got %f", value)
+	}
+	listener.Close()
+	time.Sleep(time.Millisecond * 100)
+	if value := testutil.GatherValue(t, m.statusMetrics["up"], "twemproxy_up"); value != 0 {
+		t.Errorf("Expected twemproxy down from the liveness check, got %f", value)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	listener := serveStats(t, "127.0.0.1:0", 0)
+	address := listener.Addr().String()
+	listener.Close()
+
+	m, _ := New(WithConfig(loadConfig(t)), WithHost(address), WithQuarantine(3, time.Millisecond*50))
+	m.Start(time.Millisecond * 10)
+	defer m.Stop()
+
+	time.Sleep(time.Millisecond * 100)
+	if !m.Quarantined() {
+		t.Fatal("Expected the failing target quarantined")
+	}
+	if value := testutil.GatherValue(t, m.statusMetrics["target_quarantined"], "twemproxy_exporter_target_quarantined"); value != 1 {
+		t.Errorf("Expected twemproxy_exporter_target_quarantined 1, got %f", value)
+	}
+	if !m.Healthy() {
+		t.Error("Expected a quarantined loop ticking at the quarantine interval to be healthy")
+	}
+
+	// the target is back on the same address
+	restored := serveStats(t, address, 0)
+	defer restored.Close()
+	time.Sleep(time.Millisecond * 200)
+	if m.Quarantined() {
+		t.Error("Expected the target restored after a successful scrape")
+	}
+}
+
+func TestStopCancelScrape(t *testing.T) {
+	listener := serveStats(t, "127.0.0.1:0", time.Second*5)
+	defer listener.Close()
+
+	failures := int32(0)
+	m, _ := New(WithConfig(loadConfig(t)), WithHost(listener.Addr().String()), WithObserver(func(result Result) {
+		if result.Err != nil {
+			atomic.AddInt32(&failures, 1)
+		}
+	}))
+	m.Start(time.Millisecond * 10)
+	// the first scrape is waiting for the payload
+	time.Sleep(time.Millisecond * 100)
+
+	start := time.Now()
+	m.Stop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Stop to cancel the scrape in flight, took %s", elapsed)
+	}
+	if n := atomic.LoadInt32(&failures); n != 0 {
+		t.Errorf("Expected the canceled scrape not to be reported, got %d failures", n)
+	}
+}
+
+func TestFetchDuration(t *testing.T) {
+	listener := serveStats(t, "127.0.0.1:0", time.Millisecond*50)
+	m, _ := New(WithHost(listener.Addr().String()))
+	m.Run(context.Background())
+	listener.Close()
+	m.Run(context.Background())
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(m.fetchDuration)
+	families, err := registry.Gather()
+	if err != nil || len(families) != 1 {
+		t.Fatalf("Expected the fetch duration, got %v %v", families, err)
+	}
+	histogram := families[0].GetMetric()[0].GetHistogram()
+	if histogram.GetSampleCount() != 2 || histogram.GetSampleSum() < 0.05 {
+		t.Errorf("Expected the successful and failed fetches observed, got %d in %fs", histogram.GetSampleCount(), histogram.GetSampleSum())
+	}
+}
+
+func TestHooks(t *testing.T) {
+	listener := serveStats(t, "127.0.0.1:0", 0)
+	var scraped, failed []string
+	m, _ := New(WithHost(listener.Addr().String()), WithHooks(Hooks{
+		Scraped:      func(address string, start time.Time, payload []byte) { scraped = append(scraped, address) },
+		ScrapeFailed: func(address string, err error) { failed = append(failed, address) },
+	}))
+	m.Run(context.Background())
+	listener.Close()
+	m.Run(context.Background())
+	if len(scraped) != 1 || len(failed) != 1 || scraped[0] != m.Address() || failed[0] != m.Address() {
+		t.Errorf("Expected one scraped and one failed scrape of %s, got %v and %v", m.Address(), scraped, failed)
+	}
+}
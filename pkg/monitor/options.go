@@ -0,0 +1,215 @@
+package monitor
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/collector"
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/twemproxy"
+)
+
+// Option of a Monitor
+type Option func(*Monitor)
+
+// WithConfig of the nutcracker pools, none by default
+func WithConfig(conf map[string]config.Config) Option {
+	return func(m *Monitor) {
+		m.conf = conf
+	}
+}
+
+// WithHost of the stats port, localhost:22222 by default
+func WithHost(host string) Option {
+	return func(m *Monitor) {
+		m.tcpHost = host
+	}
+}
+
+// WithInstance value of the instance label, the hostname by default
+func WithInstance(instance string) Option {
+	return func(m *Monitor) {
+		m.instance = instance
+	}
+}
+
+// WithTimeout of the stats fetch, stats.Timeout by default
+func WithTimeout(timeout time.Duration) Option {
+	return func(m *Monitor) {
+		m.timeout = timeout
+	}
+}
+
+// WithLabels attached to every metric of the target
+func WithLabels(labels map[string]string) Option {
+	return func(m *Monitor) {
+		m.labels = labels
+	}
+}
+
+// WithLogger of the monitor, the standard logger by default
+func WithLogger(logger *log.Logger) Option {
+	return func(m *Monitor) {
+		m.logger = logger
+	}
+}
+
+// WithSource of the stats, the TCP stats port of the host by default
+func WithSource(source twemproxy.StatsSource) Option {
+	return func(m *Monitor) {
+		m.source = source
+	}
+}
+
+// WithTLS of the TCP stats port
+func WithTLS(tlsConfig *tls.Config) Option {
+	return func(m *Monitor) {
+		m.tlsConfig = tlsConfig
+	}
+}
+
+// WithDialer of the TCP stats port of the host, ignored with WithSource
+func WithDialer(dialer twemproxy.ContextDialer) Option {
+	return func(m *Monitor) {
+		m.dialer = dialer
+	}
+}
+
+// WithPools of the config collected from the target, every pool by default
+func WithPools(pools []string) Option {
+	return func(m *Monitor) {
+		m.poolFilter = pools
+	}
+}
+
+// WithServerLabels of the server metrics, the exporter defaults by default
+func WithServerLabels(labels collector.ServerLabels) Option {
+	return func(m *Monitor) {
+		m.serverLabels = labels
+	}
+}
+
+// WithLiveness check of the stats port every interval between the scrapes, driving twemproxy_up only
+func WithLiveness(interval time.Duration) Option {
+	return func(m *Monitor) {
+		m.liveness = interval
+	}
+}
+
+// WithQuarantine of the target after failures scrapes in a row, it is then only scraped every interval until a
+// scrape succeeds
+func WithQuarantine(failures int, interval time.Duration) Option {
+	return func(m *Monitor) {
+		m.quarantineAfter = failures
+		m.quarantineInterval = interval
+	}
+}
+
+// WithRates export the request, byte and error rates of the servers and the pools
+func WithRates(enabled bool) Option {
+	return func(m *Monitor) {
+		m.withRates = enabled
+	}
+}
+
+// WithTopN servers of every pool in twemproxy_pool_hot_server, disabled when 0
+func WithTopN(n int) Option {
+	return func(m *Monitor) {
+		m.topN = n
+	}
+}
+
+// WithDisabledGroups of metrics not exported, see the Group constants
+func WithDisabledGroups(groups map[string]bool) Option {
+	return func(m *Monitor) {
+		m.disabled = groups
+	}
+}
+
+// WithPoolDetail levels by pool, the pools not listed are DetailFull
+func WithPoolDetail(detail map[string]string) Option {
+	return func(m *Monitor) {
+		m.poolDetail = detail
+	}
+}
+
+// WithListenProbe dial the listen address of every pool on every scrape, with ping the pool must answer a request
+// of its protocol as well
+func WithListenProbe(ping bool) Option {
+	return func(m *Monitor) {
+		m.probeListen = true
+		m.listenPing = ping
+	}
+}
+
+// WithBackendProbe query the keys of every backend server on every scrape
+func WithBackendProbe() Option {
+	return func(m *Monitor) {
+		m.probeBackends = true
+	}
+}
+
+// WithProber of the listen and backend probes, plain connections and the redis_auth of the pools by default
+func WithProber(prober Prober) Option {
+	return func(m *Monitor) {
+		m.prober = prober
+	}
+}
+
+// WithHistory of the last size scrapes, see History
+func WithHistory(size int) Option {
+	return func(m *Monitor) {
+		m.history = newScrapeHistory(size)
+	}
+}
+
+// WithDropStale drop the values of the target when a scrape fail, instead of keeping the last good ones flagged stale
+func WithDropStale(drop bool) Option {
+	return func(m *Monitor) {
+		m.dropStale = drop
+	}
+}
+
+// WithBaseline the first rates are computed from, e.g. the last scrape before a restart
+func WithBaseline(b Baseline) Option {
+	return func(m *Monitor) {
+		m.prevStats, m.prevTime = b.Stats, b.Time
+	}
+}
+
+// WithObserver called with the result of every scrape
+func WithObserver(observe func(Result)) Option {
+	return func(m *Monitor) {
+		m.observe = observe
+	}
+}
+
+// WithHooks of the embedding program into the scrapes
+func WithHooks(hooks Hooks) Option {
+	return func(m *Monitor) {
+		m.hooks = hooks
+	}
+}
+
+// New monitor of one twemproxy, not started
+func New(opts ...Option) (*Monitor, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown_host"
+	}
+	m := &Monitor{
+		tcpHost:  DefaultHost,
+		instance: hostname,
+		prober:   directProber{},
+		logger:   log.Default(),
+		series:   make(map[string][]string),
+		pools:    make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.SetLabels(m.labels)
+	return m, nil
+}
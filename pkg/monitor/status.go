@@ -0,0 +1,41 @@
+package monitor
+
+import (
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/rate"
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+// Status of a target, the last successful scrape with its rates
+type Status struct {
+	Target      string                    `json:"target"`
+	Instance    string                    `json:"instance"`
+	Labels      map[string]string         `json:"labels,omitempty"`
+	LastSuccess *time.Time                `json:"last_success,omitempty"`
+	Error       string                    `json:"error,omitempty"`
+	Stats       *stats.TwemproxyStats     `json:"stats,omitempty"`
+	Rates       map[string]rate.PoolRates `json:"rates,omitempty"`
+}
+
+// Status of the target, the error is the one of the last scrape
+func (m *Monitor) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	status := Status{
+		Target:   m.tcpHost,
+		Instance: m.instance,
+		Labels:   m.labels,
+		Rates:    m.rates,
+	}
+	if m.lastErr != nil {
+		status.Error = m.lastErr.Error()
+	}
+	if !m.prevTime.IsZero() {
+		lastSuccess := m.prevTime
+		st := m.prevStats
+		status.LastSuccess = &lastSuccess
+		status.Stats = &st
+	}
+	return status
+}
@@ -0,0 +1,94 @@
+// Package rate compute the per second rates of the servers and pools between two scrapes
+package rate
+
+import (
+	"math"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+// ServerRates per second of a server over the last scrape interval
+type ServerRates struct {
+	Requests      float64 `json:"requests"`
+	RequestBytes  float64 `json:"request_bytes"`
+	ResponseBytes float64 `json:"response_bytes"`
+	// Errors are server_err and server_timedout
+	Errors float64 `json:"errors"`
+}
+
+// PoolRates per second of a pool and its servers over the last scrape interval
+type PoolRates struct {
+	ClientErrors  float64 `json:"client_errors"`
+	ForwardErrors float64 `json:"forward_errors"`
+	// RequestBytes and ResponseBytes of every server of the pool
+	RequestBytes  float64 `json:"request_bytes"`
+	ResponseBytes float64 `json:"response_bytes"`
+	// RequestImbalance is the coefficient of variation of the server request rates, 0 when balanced
+	RequestImbalance float64                `json:"request_imbalance"`
+	Servers          map[string]ServerRates `json:"servers"`
+}
+
+// counterRate per second, 0 when the counter was reset in between
+func counterRate(prev, cur float64, elapsed time.Duration) float64 {
+	if cur < prev || elapsed <= 0 {
+		return 0
+	}
+	return (cur - prev) / elapsed.Seconds()
+}
+
+// Compute the rates from two successive scrapes, pools and servers missing from either are skipped
+func Compute(prev, cur stats.TwemproxyStats, elapsed time.Duration) map[string]PoolRates {
+	rates := make(map[string]PoolRates)
+	for poolName, pool := range cur.Services {
+		prevPool, ok := prev.Services[poolName]
+		if !ok {
+			continue
+		}
+		poolRates := PoolRates{
+			ClientErrors:  counterRate(prevPool.ClientErr, pool.ClientErr, elapsed),
+			ForwardErrors: counterRate(prevPool.ForwardError, pool.ForwardError, elapsed),
+			Servers:       make(map[string]ServerRates),
+		}
+		for name, server := range pool.Servers {
+			prevServer, ok := prevPool.Servers[name]
+			if !ok {
+				continue
+			}
+			serverRates := ServerRates{
+				Requests:      counterRate(prevServer.Requests, server.Requests, elapsed),
+				RequestBytes:  counterRate(prevServer.RequestBytes, server.RequestBytes, elapsed),
+				ResponseBytes: counterRate(prevServer.ResponseBytes, server.ResponseBytes, elapsed),
+				Errors:        counterRate(prevServer.ServerErr+prevServer.ServerTimedout, server.ServerErr+server.ServerTimedout, elapsed),
+			}
+			poolRates.Servers[name] = serverRates
+			poolRates.RequestBytes += serverRates.RequestBytes
+			poolRates.ResponseBytes += serverRates.ResponseBytes
+		}
+		poolRates.RequestImbalance = requestImbalance(poolRates.Servers)
+		rates[poolName] = poolRates
+	}
+	return rates
+}
+
+// requestImbalance of the servers, the standard deviation of their request rates over the mean.
+// Ketama skew or a bad hash_tag show as a high imbalance while every server counter look normal
+func requestImbalance(servers map[string]ServerRates) float64 {
+	if len(servers) < 2 {
+		return 0
+	}
+	mean := 0.0
+	for _, r := range servers {
+		mean += r.Requests
+	}
+	mean /= float64(len(servers))
+	if mean == 0 {
+		return 0
+	}
+	variance := 0.0
+	for _, r := range servers {
+		variance += (r.Requests - mean) * (r.Requests - mean)
+	}
+	variance /= float64(len(servers))
+	return math.Sqrt(variance) / mean
+}
@@ -0,0 +1,58 @@
+package rate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/stats"
+)
+
+func TestCompute(t *testing.T) {
+	prev := stats.TwemproxyStats{Services: map[string]stats.ServiceStats{
+		"pool": {ClientErr: 10, Servers: map[string]stats.ServerStats{
+			"alpha": {Requests: 100, RequestBytes: 1000, ServerErr: 1, ServerTimedout: 1},
+			"beta":  {Requests: 500},
+		}},
+	}}
+	cur := stats.TwemproxyStats{Services: map[string]stats.ServiceStats{
+		"pool": {ClientErr: 30, Servers: map[string]stats.ServerStats{
+			"alpha": {Requests: 300, RequestBytes: 3000, ServerErr: 3, ServerTimedout: 9},
+			// restarted twemproxy
+			"beta":  {Requests: 50},
+			"gamma": {Requests: 50},
+		}},
+	}}
+
+	rates := Compute(prev, cur, time.Second*10)
+	pool := rates["pool"]
+	if pool.ClientErrors != 2 {
+		t.Errorf("Expected 2 client errors/s, got %f", pool.ClientErrors)
+	}
+	if pool.Servers["alpha"].Requests != 20 || pool.Servers["alpha"].Errors != 1 {
+		t.Errorf("Unexpected alpha rates %+v", pool.Servers["alpha"])
+	}
+	if pool.RequestBytes != 200 {
+		t.Errorf("Expected the request bytes/s of the servers summed, got %f", pool.RequestBytes)
+	}
+	if pool.Servers["beta"].Requests != 0 {
+		t.Errorf("Expected no rate across a counter reset, got %f", pool.Servers["beta"].Requests)
+	}
+	if _, ok := pool.Servers["gamma"]; ok {
+		t.Error("Expected no rate for a server without previous scrape")
+	}
+}
+
+func TestRequestImbalance(t *testing.T) {
+	balanced := map[string]ServerRates{"a": {Requests: 100}, "b": {Requests: 100}, "c": {Requests: 100}}
+	if imbalance := requestImbalance(balanced); imbalance != 0 {
+		t.Errorf("Expected no imbalance, got %f", imbalance)
+	}
+	// one hot key on a
+	skewed := map[string]ServerRates{"a": {Requests: 300}, "b": {Requests: 100}}
+	if imbalance := requestImbalance(skewed); imbalance != 0.5 {
+		t.Errorf("Expected an imbalance of 0.5, got %f", imbalance)
+	}
+	if imbalance := requestImbalance(map[string]ServerRates{"a": {Requests: 300}}); imbalance != 0 {
+		t.Errorf("Expected no imbalance for a single server, got %f", imbalance)
+	}
+}
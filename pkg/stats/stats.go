@@ -0,0 +1,190 @@
+// Package stats fetch and decode the stats of twemproxy, the JSON document written on its stats port
+package stats
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+	"github.com/albert-widi/twemproxy_exporter/pkg/twemproxy"
+)
+
+// Timeout is the maximum time spent dialing and reading the stats port by default
+const Timeout = time.Second * 10
+
+// TwemproxyStats to export to prometheus
+type TwemproxyStats struct {
+	Service            string
+	Source             string
+	Uptime             float64
+	Timestamp          float64
+	TotalConnections   float64
+	CurrentConnections float64
+	ExpectedAvailable  int
+	NotAvailable       int
+	Services           map[string]ServiceStats
+}
+
+// ServiceStats for twemproxy
+type ServiceStats struct {
+	Name              string
+	ClientEOF         float64
+	ClientErr         float64
+	ClientConnections float64
+	ServerEjects      float64
+	ForwardError      float64
+	Fragments         float64
+	ExpectedAvailable int
+	NotAvailable      int
+	Reported          int // servers in the stats of the pool, configured or not
+	Missing           int // servers of the config absent from the stats
+	Servers           map[string]ServerStats
+	// Unknown servers in the stats but not in the config, by their name in the stats, nil when there is none
+	Unknown map[string]ServerStats
+}
+
+// ServerStats for connection stats
+type ServerStats struct {
+	Host              string
+	HostAlias         string
+	Unknown           bool    // in the stats but not in the config, HostAlias is its name in the stats
+	Ejected           bool    // ejected by twemproxy and not retried yet
+	ServerEOF         float64 `json:"server_eof,omitempty"`
+	ServerErr         float64 `json:"server_err,omitempty"`
+	ServerTimedout    float64 `json:"server_timeout,omitempty"`
+	ServerConnections float64 `json:"server_connections,omitempty"`
+	ServerEjectedAt   float64 `json:"server_ejected_at,omitempty"`
+	Requests          float64 `json:"requests,omitempty"`
+	RequestBytes      float64 `json:"request_bytes,omitempty"`
+	Responses         float64 `json:"responses,omitempty"`
+	ResponseBytes     float64 `json:"response_bytes,omitempty"`
+	InQueue           float64 `json:"in_queue,omitempty"`
+	InQueueBytes      float64 `json:"in_queue_bytes,omitempty"`
+	OutQueue          float64 `json:"out_queue,omitempty"`
+	OutQueueBytes     float64 `json:"out_queue_bytes,omitempty"`
+}
+
+// Fetch read the whole stats payload from twemproxy, host is any address of twemproxy.NewSource.
+// A zero timeout use Timeout, ctx cancel the fetch
+func Fetch(ctx context.Context, host string, tlsConfig *tls.Config, timeout time.Duration) ([]byte, error) {
+	if timeout == 0 {
+		timeout = Timeout
+	}
+	source, err := twemproxy.NewSource(host, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	client := &twemproxy.Client{Source: source, Timeout: timeout}
+	return client.FetchRaw(ctx)
+}
+
+// Parse the stats payload, only the pools and servers of the config are kept.
+// Errors are the ones of twemproxy.Decode
+func Parse(statsContent []byte, conf map[string]config.Config) (TwemproxyStats, error) {
+	stats, err := twemproxy.Decode(statsContent)
+	if err != nil {
+		return TwemproxyStats{}, err
+	}
+
+	// set the main stats for twemproxy
+	twemp := TwemproxyStats{
+		Service:            stats.Service,
+		Source:             stats.Source,
+		Uptime:             stats.Uptime,
+		Timestamp:          stats.Timestamp,
+		TotalConnections:   stats.TotalConnections,
+		CurrentConnections: stats.CurrConnections,
+		Services:           make(map[string]ServiceStats),
+	}
+
+	for key := range conf {
+		serviceStats := ServiceStats{
+			Name:              key,
+			ExpectedAvailable: len(conf[key].Servers),
+			Servers:           make(map[string]ServerStats),
+		}
+		service, ok := stats.Pools[key]
+		if !ok {
+			continue
+		}
+		twemp.ExpectedAvailable += len(conf[key].Servers)
+
+		// extract vars for service stats
+		serviceStats.ClientEOF = service.ClientEOF
+		serviceStats.ClientErr = service.ClientErr
+		serviceStats.ClientConnections = service.ClientConnections
+		serviceStats.ServerEjects = service.ServerEjects
+		serviceStats.ForwardError = service.ForwardError
+		serviceStats.Fragments = service.Fragments
+		serviceStats.Reported = len(service.Servers)
+
+		for _, val := range conf[key].Servers {
+			host := val.Name()
+			hostAlias := val.IP
+			srv, ok := service.Servers[host]
+			if !ok {
+				twemp.NotAvailable++
+				serviceStats.NotAvailable++
+				serviceStats.Missing++
+				continue
+			}
+			serverStats := newServerStats(host, hostAlias, srv)
+			serverStats.Ejected = ejected(conf[key], srv.ServerEjectedAt, stats.Timestamp)
+			serviceStats.Servers[host] = serverStats
+
+			// means there is no connection to the server
+			if serverStats.ServerConnections < 1 {
+				twemp.NotAvailable++
+				serviceStats.NotAvailable++
+			}
+		}
+		for name, srv := range service.Servers {
+			if _, ok := serviceStats.Servers[name]; ok {
+				continue
+			}
+			if serviceStats.Unknown == nil {
+				serviceStats.Unknown = make(map[string]ServerStats)
+			}
+			serverStats := newServerStats(name, name, srv)
+			serverStats.Unknown = true
+			serviceStats.Unknown[name] = serverStats
+		}
+		twemp.Services[key] = serviceStats
+	}
+	return twemp, nil
+}
+
+// ejected when the server was ejected less than server_retry_timeout before the stats were written,
+// server_ejected_at is in microseconds and the timestamp in seconds
+func ejected(conf config.Config, ejectedAt float64, timestamp float64) bool {
+	if !conf.AutoEjectHosts || ejectedAt <= 0 || timestamp <= 0 {
+		return false
+	}
+	return ejectedAt/1e6+float64(conf.ServerRetryTimeout)/1e3 > timestamp
+}
+
+// Up when twemproxy has a connection to the server and did not eject it
+func (s ServerStats) Up() bool {
+	return s.ServerConnections >= 1 && !s.Ejected
+}
+
+func newServerStats(host string, hostAlias string, srv twemproxy.Server) ServerStats {
+	return ServerStats{
+		Host:              host,
+		HostAlias:         hostAlias,
+		ServerEOF:         srv.ServerEOF,
+		ServerErr:         srv.ServerErr,
+		ServerTimedout:    srv.ServerTimedout,
+		ServerConnections: srv.ServerConnections,
+		ServerEjectedAt:   srv.ServerEjectedAt,
+		Requests:          srv.Requests,
+		RequestBytes:      srv.RequestBytes,
+		Responses:         srv.Responses,
+		ResponseBytes:     srv.ResponseBytes,
+		InQueue:           srv.InQueue,
+		InQueueBytes:      srv.InQueueBytes,
+		OutQueue:          srv.OutQueue,
+		OutQueueBytes:     srv.OutQueueBytes,
+	}
+}
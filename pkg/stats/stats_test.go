@@ -0,0 +1,139 @@
+package stats
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/albert-widi/twemproxy_exporter/pkg/config"
+)
+
+// stats payloads whose fields differ: the example of 0.4.1, 0.3.0 without the connection totals and a fork renaming
+// server_timedout. Versions writing the same fields as the example have no fixture of their own
+var schemaFixtures = []struct {
+	file             string
+	totalConnections float64
+	alphaTimedout    float64
+}{
+	{"../../files/example.json", 64592, 19},
+	{"../../files/schema/twemproxy-0.3.0.json", 0, 19},
+	{"../../files/schema/fork-server_timeout.json", 64592, 19},
+}
+
+func TestParseStatsSchemas(t *testing.T) {
+	conf, err := config.LoadConfig("../../files/nutcracker.yml")
+	if err != nil {
+		t.Fatal("Failed to read config: ", err.Error())
+	}
+
+	for _, fixture := range schemaFixtures {
+		resp, err := ioutil.ReadFile(fixture.file)
+		if err != nil {
+			t.Errorf("%s: failed to read fixture: %s", fixture.file, err.Error())
+			continue
+		}
+
+		st, err := Parse(resp, conf)
+		if err != nil {
+			t.Errorf("%s: failed to parse stats: %s", fixture.file, err.Error())
+			continue
+		}
+		if st.TotalConnections != fixture.totalConnections {
+			t.Errorf("%s: expected total connections %v, got %v", fixture.file, fixture.totalConnections, st.TotalConnections)
+		}
+
+		service, ok := st.Services["wallet-oauth-token"]
+		if !ok {
+			t.Errorf("%s: pool wallet-oauth-token is missing", fixture.file)
+			continue
+		}
+		if len(service.Servers) != 2 || service.NotAvailable != 0 {
+			t.Errorf("%s: expected 2 available servers, got %d with %d not available", fixture.file, len(service.Servers), service.NotAvailable)
+		}
+		alpha := service.Servers["alpha"]
+		if alpha.ServerTimedout != fixture.alphaTimedout {
+			t.Errorf("%s: expected server_timedout %v, got %v", fixture.file, fixture.alphaTimedout, alpha.ServerTimedout)
+		}
+	}
+}
+
+func TestParseServerCounts(t *testing.T) {
+	// gamma added to the config, twemproxy not reloaded
+	conf, err := config.ParseConfig([]byte(`wallet-oauth-token:
+  listen: 0.0.0.0:6381
+  servers:
+   - redis:6379:1 alpha
+   - redis3:6379:1 gamma
+`))
+	if err != nil {
+		t.Fatal("Failed to parse config: ", err.Error())
+	}
+	resp, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := Parse(resp, conf)
+	if err != nil {
+		t.Fatal("Failed to parse stats: ", err.Error())
+	}
+	service := st.Services["wallet-oauth-token"]
+	if service.ExpectedAvailable != 2 || service.Reported != 2 || service.Missing != 1 {
+		t.Errorf("Expected 2 servers configured, 2 reported and 1 missing, got %d, %d and %d", service.ExpectedAvailable, service.Reported, service.Missing)
+	}
+}
+
+func TestParseUnknownServers(t *testing.T) {
+	conf, err := config.ParseConfig([]byte(`wallet-oauth-token:
+  listen: 0.0.0.0:6381
+  servers:
+   - redis:6379:1 alpha
+`))
+	if err != nil {
+		t.Fatal("Failed to parse config: ", err.Error())
+	}
+	resp, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := Parse(resp, conf)
+	if err != nil {
+		t.Fatal("Failed to parse stats: ", err.Error())
+	}
+	service := st.Services["wallet-oauth-token"]
+	beta, ok := service.Unknown["beta"]
+	if len(service.Unknown) != 1 || !ok || !beta.Unknown || beta.HostAlias != "beta" || beta.InQueue != 1 {
+		t.Errorf("Expected beta as the only unknown server, got %+v", service.Unknown)
+	}
+	if _, ok := service.Servers["beta"]; ok {
+		t.Error("Expected the unknown server out of the configured servers")
+	}
+}
+
+func TestParseEjected(t *testing.T) {
+	// beta was ejected a day before the stats, alpha a week before
+	conf, err := config.ParseConfig([]byte(`wallet-oauth-token:
+  listen: 0.0.0.0:6381
+  auto_eject_hosts: true
+  server_retry_timeout: 172800000
+  servers:
+   - redis:6379:1 alpha
+   - redis2:6379:1 beta
+`))
+	if err != nil {
+		t.Fatal("Failed to parse config: ", err.Error())
+	}
+	resp, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := Parse(resp, conf)
+	if err != nil {
+		t.Fatal("Failed to parse stats: ", err.Error())
+	}
+	servers := st.Services["wallet-oauth-token"].Servers
+	if !servers["beta"].Ejected || servers["beta"].Up() {
+		t.Errorf("Expected beta ejected within the retry timeout, got %+v", servers["beta"])
+	}
+	if servers["alpha"].Ejected || !servers["alpha"].Up() {
+		t.Errorf("Expected alpha up, got %+v", servers["alpha"])
+	}
+}
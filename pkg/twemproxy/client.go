@@ -0,0 +1,50 @@
+// Package twemproxy is a client of the twemproxy stats port, independent of prometheus
+package twemproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+)
+
+// DefaultTimeout of a fetch when neither the client nor the context set one
+const DefaultTimeout = time.Second * 10
+
+// Client of the stats port of one twemproxy
+type Client struct {
+	Address   string        // host:port of the stats port, 22222 by default in twemproxy
+	TLSConfig *tls.Config   // nil when the stats port is plain TCP
+	Timeout   time.Duration // of the whole fetch, DefaultTimeout when zero
+	// Source of the payload, the TCP stats port at Address when nil
+	Source StatsSource
+}
+
+// NewClient of the stats port at address
+func NewClient(address string) *Client {
+	return &Client{Address: address}
+}
+
+// Fetch and decode the stats
+func (c *Client) Fetch(ctx context.Context) (*Stats, error) {
+	payload, err := c.FetchRaw(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(payload)
+}
+
+// FetchRaw read the whole stats payload from the source, within the timeout of the client
+func (c *Client) FetchRaw(ctx context.Context) ([]byte, error) {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	source := c.Source
+	if source == nil {
+		source = &TCPSource{Address: c.Address, TLSConfig: c.TLSConfig}
+	}
+	return source.FetchRaw(ctx)
+}
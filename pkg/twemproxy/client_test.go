@@ -0,0 +1,129 @@
+package twemproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+)
+
+// serve payload on every connection, in two writes like a slow twemproxy, nil payload never write
+func serve(t *testing.T, payload []byte) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if payload == nil {
+				continue
+			}
+			half := len(payload) / 2
+			conn.Write(payload[:half])
+			time.Sleep(time.Millisecond * 20)
+			conn.Write(payload[half:])
+			conn.Close()
+		}
+	}()
+	return listener
+}
+
+func TestClientFetch(t *testing.T) {
+	payload, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Fatal("Failed to read json example: ", err.Error())
+	}
+	listener := serve(t, payload)
+	defer listener.Close()
+
+	stats, err := NewClient(listener.Addr().String()).Fetch(context.Background())
+	if err != nil {
+		t.Fatal("Failed to fetch stats: ", err.Error())
+	}
+	if stats.TotalConnections != 64592 || stats.CurrConnections != 5 {
+		t.Errorf("Unexpected connections %+v", stats)
+	}
+	pool, ok := stats.Pools["wallet-oauth-token"]
+	if !ok || len(stats.Pools) != 1 {
+		t.Fatalf("Expected only pool wallet-oauth-token, got %+v", stats.Pools)
+	}
+	if pool.Servers["alpha"].ServerTimedout != 19 || pool.Servers["beta"].InQueue != 1 {
+		t.Errorf("Unexpected servers %+v", pool.Servers)
+	}
+}
+
+func TestClientFetchCanceled(t *testing.T) {
+	listener := serve(t, nil)
+	defer listener.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	_, err := NewClient(listener.Addr().String()).Fetch(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the fetch to stop with the context, took %s", elapsed)
+	}
+}
+
+func TestDecodeAliases(t *testing.T) {
+	payload, err := ioutil.ReadFile("../../files/schema/fork-server_timeout.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := Decode(payload)
+	if err != nil {
+		t.Fatal("Failed to decode: ", err.Error())
+	}
+	if timedout := stats.Pools["wallet-oauth-token"].Servers["alpha"].ServerTimedout; timedout != 19 {
+		t.Errorf("Expected server_timeout to be read as server_timedout, got %f", timedout)
+	}
+
+	if _, err := Decode(payload[:len(payload)/2]); !errors.Is(err, ErrTruncatedStats) {
+		t.Errorf("Expected ErrTruncatedStats for a truncated payload, got %v", err)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	listener := serve(t, nil)
+	address := listener.Addr().String()
+	listener.Close()
+	_, err := NewClient(address).Fetch(context.Background())
+	if !errors.Is(err, ErrTargetUnreachable) {
+		t.Errorf("Expected ErrTargetUnreachable, got %v", err)
+	}
+
+	// e.g. -twemphost pointing to redis or to the HTTP port of another exporter
+	for _, payload := range []string{"-ERR unknown command\r\n", "[1, 2]", `{"db0": {"keys": 1}}`} {
+		if _, err := Decode([]byte(payload)); !errors.Is(err, ErrSchemaMismatch) {
+			t.Errorf("Expected ErrSchemaMismatch for %q, got %v", payload, err)
+		}
+	}
+	if _, err := Decode([]byte("-ERR unknown command\r\n")); !errors.Is(err, ErrInvalidJSON) {
+		t.Errorf("Expected ErrInvalidJSON for a redis reply, got %v", err)
+	}
+}
+
+func TestClientTrace(t *testing.T) {
+	listener := serve(t, []byte(`{"service": "nutcracker"}`))
+	defer listener.Close()
+
+	var connected []error
+	ctx := WithClientTrace(context.Background(), &ClientTrace{ConnectDone: func(err error) {
+		connected = append(connected, err)
+	}})
+	if _, err := NewClient(listener.Addr().String()).Fetch(ctx); err != nil {
+		t.Fatal("Failed to fetch stats: ", err.Error())
+	}
+	if len(connected) != 1 || connected[0] != nil {
+		t.Errorf("Expected one successful connection traced, got %v", connected)
+	}
+}
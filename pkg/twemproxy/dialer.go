@@ -0,0 +1,233 @@
+package twemproxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ContextDialer open the connections of a source, implemented by net.Dialer and Dialer
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Dialer of the stats port from a local address, optionally through a SOCKS5 or HTTP CONNECT proxy
+type Dialer struct {
+	LocalAddr net.IP // source address of the connections, chosen by the system when nil
+	// Proxy is socks5://[user:password@]host:port or http://[user:password@]host:port, direct connections when nil
+	Proxy *url.URL
+	// ProxyPassword of the proxy user, called on every handshake so rotated passwords are picked up.
+	// The password of the url is used when nil
+	ProxyPassword func() (string, error)
+}
+
+// NewDialer with the proxy url, direct connections when empty
+func NewDialer(localAddr net.IP, proxy string) (*Dialer, error) {
+	d := &Dialer{LocalAddr: localAddr}
+	if proxy == "" {
+		return d, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid proxy url %s. Error: %w", proxy, err)
+	}
+	switch u.Scheme {
+	case "socks5", "socks5h", "http":
+	default:
+		return nil, fmt.Errorf("Unknown proxy scheme %s in %s, expected socks5 or http", u.Scheme, proxy)
+	}
+	if u.Port() == "" {
+		return nil, fmt.Errorf("Proxy %s without port", proxy)
+	}
+	d.Proxy = u
+	return d, nil
+}
+
+// DialContext the address, through the proxy when there is one. Only tcp can be proxied
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{}
+	if network == "unix" {
+		return dialer.DialContext(ctx, network, address)
+	}
+	if d.LocalAddr != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: d.LocalAddr}
+	}
+	if d.Proxy == nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", d.Proxy.Host)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot connect to proxy %s. Error: %w", d.Proxy.Host, err)
+	}
+	// the handshake is bound to the context like the dial
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if d.Proxy.Scheme == "http" {
+		err = d.connect(conn, address)
+	} else {
+		err = d.socks5(conn, address)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// the sources set their own deadline
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// connect ask the HTTP proxy for a tunnel to the address
+func (d *Dialer) connect(conn net.Conn, address string) error {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if user := d.Proxy.User; user != nil {
+		password, err := d.proxyPassword()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	err := req.Write(conn)
+	if err != nil {
+		return err
+	}
+	// twemproxy write the stats as soon as the tunnel is up, read the response header byte by byte to leave them
+	header := make([]byte, 0, 256)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(header, []byte("\r\n\r\n")) {
+		if len(header) > 8192 {
+			return fmt.Errorf("CONNECT response of proxy %s is too long", d.Proxy.Host)
+		}
+		if _, err = io.ReadFull(conn, b); err != nil {
+			return fmt.Errorf("Cannot read the CONNECT response of proxy %s. Error: %w", d.Proxy.Host, err)
+		}
+		header = append(header, b[0])
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(header)), req)
+	if err != nil {
+		return fmt.Errorf("Cannot read the CONNECT response of proxy %s. Error: %w", d.Proxy.Host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Proxy %s refused the tunnel to %s: %s", d.Proxy.Host, address, resp.Status)
+	}
+	return nil
+}
+
+// proxyPassword from ProxyPassword, or from the url
+func (d *Dialer) proxyPassword() (string, error) {
+	if d.ProxyPassword == nil {
+		password, _ := d.Proxy.User.Password()
+		return password, nil
+	}
+	password, err := d.ProxyPassword()
+	if err != nil {
+		return "", fmt.Errorf("Cannot read the password of proxy %s. Error: %w", d.Proxy.Host, err)
+	}
+	return password, nil
+}
+
+// socks5 handshake of RFC 1928, with the username and password of RFC 1929 when the url has them
+func (d *Dialer) socks5(conn net.Conn, address string) error {
+	host, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return fmt.Errorf("Invalid port of %s", address)
+	}
+	method := byte(0x00)
+	if d.Proxy.User != nil {
+		method = 0x02
+	}
+	_, err = conn.Write([]byte{0x05, 0x01, method})
+	if err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 || reply[1] != method {
+		return fmt.Errorf("Proxy %s refused the authentication method", d.Proxy.Host)
+	}
+	if method == 0x02 {
+		password, err := d.proxyPassword()
+		if err != nil {
+			return err
+		}
+		user := d.Proxy.User.Username()
+		if len(user) > 255 || len(password) > 255 {
+			return errors.New("SOCKS5 username and password are limited to 255 bytes")
+		}
+		auth := append([]byte{0x01, byte(len(user))}, user...)
+		auth = append(append(auth, byte(len(password))), password...)
+		if _, err = conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return fmt.Errorf("Proxy %s refused the username and password", d.Proxy.Host)
+		}
+	}
+
+	request := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		request = append(append(request, 0x01), ip.To4()...)
+	} else if ip != nil {
+		request = append(append(request, 0x04), ip.To16()...)
+	} else {
+		if len(host) > 255 {
+			return fmt.Errorf("Host %s is too long for SOCKS5", host)
+		}
+		// resolved by the proxy, the target may only be known in its zone
+		request = append(append(request, 0x03, byte(len(host))), host...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err = conn.Write(request); err != nil {
+		return err
+	}
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("Proxy %s refused the connection to %s, reply %d", d.Proxy.Host, address, header[1])
+	}
+	// skip the bound address
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len + 2
+	case 0x04:
+		skip = net.IPv6len + 2
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err = io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0]) + 2
+	default:
+		return fmt.Errorf("Invalid SOCKS5 reply of proxy %s", d.Proxy.Host)
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
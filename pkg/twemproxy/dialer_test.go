@@ -0,0 +1,163 @@
+package twemproxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+// listenStats write the payload to every connection and close it, like twemproxy
+func listenStats(t *testing.T, payload []byte) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write(payload)
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+// listenProxy accept one connection, run the handshake which return the target address, and tunnel to it
+func listenProxy(t *testing.T, handshake func(conn net.Conn) string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				address := handshake(conn)
+				if address == "" {
+					return
+				}
+				target, err := net.Dial("tcp", address)
+				if err != nil {
+					return
+				}
+				defer target.Close()
+				io.Copy(conn, target)
+			}()
+		}
+	}()
+	return l
+}
+
+func socks5Handshake(conn net.Conn) string {
+	greeting := make([]byte, 3)
+	io.ReadFull(conn, greeting)
+	conn.Write([]byte{0x05, 0x02})
+	auth := make([]byte, 2)
+	io.ReadFull(conn, auth)
+	user := make([]byte, auth[1]+1)
+	io.ReadFull(conn, user)
+	password := make([]byte, user[len(user)-1])
+	io.ReadFull(conn, password)
+	if string(user[:len(user)-1]) != "scraper" || string(password) != "secret" {
+		conn.Write([]byte{0x01, 0x01})
+		return ""
+	}
+	conn.Write([]byte{0x01, 0x00})
+	request := make([]byte, 4)
+	io.ReadFull(conn, request)
+	var host string
+	switch request[3] {
+	case 0x01:
+		ip := make([]byte, 4)
+		io.ReadFull(conn, ip)
+		host = net.IP(ip).String()
+	case 0x03:
+		length := make([]byte, 1)
+		io.ReadFull(conn, length)
+		name := make([]byte, length[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	}
+	port := make([]byte, 2)
+	io.ReadFull(conn, port)
+	conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0, 0})
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+}
+
+func connectHandshake(conn net.Conn) string {
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil || req.Method != http.MethodConnect {
+		return ""
+	}
+	if req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte("scraper:secret")) {
+		conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+		return ""
+	}
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	return req.Host
+}
+
+func TestDialerProxy(t *testing.T) {
+	payload := []byte(`{"service":"nutcracker"}`)
+	stats := listenStats(t, payload)
+	defer stats.Close()
+
+	socks := listenProxy(t, socks5Handshake)
+	defer socks.Close()
+	connect := listenProxy(t, connectHandshake)
+	defer connect.Close()
+
+	tests := []struct {
+		proxy string
+		ok    bool
+	}{
+		{"", true},
+		{"socks5://scraper:secret@" + socks.Addr().String(), true},
+		{"socks5://scraper:wrong@" + socks.Addr().String(), false},
+		{"http://scraper:secret@" + connect.Addr().String(), true},
+		{"http://" + connect.Addr().String(), false},
+	}
+	for _, test := range tests {
+		dialer, err := NewDialer(net.ParseIP("127.0.0.1"), test.proxy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		source := &TCPSource{Address: stats.Addr().String(), Dialer: dialer}
+		got, err := source.FetchRaw(context.Background())
+		if test.ok && (err != nil || string(got) != string(payload)) {
+			t.Errorf("Expected the payload through %q, got %q %v", test.proxy, got, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("Expected an error through %q", test.proxy)
+		}
+	}
+
+	dialer, err := NewDialer(nil, "socks5://scraper@"+socks.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dialer.ProxyPassword = func() (string, error) { return "secret", nil }
+	source := &TCPSource{Address: stats.Addr().String(), Dialer: dialer}
+	if got, err := source.FetchRaw(context.Background()); err != nil || string(got) != string(payload) {
+		t.Errorf("Expected the payload with the password of ProxyPassword, got %q %v", got, err)
+	}
+
+	for _, invalid := range []string{"ftp://proxy:21", "socks5://proxy", "http://%zz"} {
+		if _, err := NewDialer(nil, invalid); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package twemproxy
+
+import (
+	"errors"
+)
+
+// Errors of a fetch, wrapped with the address and the cause, test them with errors.Is
+var (
+	// ErrTargetUnreachable when the stats port refuse or drop the connection
+	ErrTargetUnreachable = errors.New("twemproxy unreachable")
+	// ErrTruncatedStats when the payload end before the JSON document does, e.g. the connection was reset
+	ErrTruncatedStats = errors.New("truncated stats")
+	// ErrSchemaMismatch when the payload is not the stats of twemproxy, e.g. the address is not a stats port
+	ErrSchemaMismatch = errors.New("stats schema mismatch")
+	// ErrInvalidJSON when the payload is not JSON at all, always with ErrSchemaMismatch
+	ErrInvalidJSON = errors.New("not JSON")
+)
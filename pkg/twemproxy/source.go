@@ -0,0 +1,268 @@
+package twemproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatsSource of the stats payload of one twemproxy, the transport is up to the source.
+// FetchRaw return the whole JSON document, canceled with ctx
+type StatsSource interface {
+	FetchRaw(ctx context.Context) ([]byte, error)
+}
+
+// Pinger is implemented by the sources able to check the proxy is up without fetching the stats
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// SourceFunc is a StatsSource calling the function, e.g. to mock twemproxy in tests
+type SourceFunc func(ctx context.Context) ([]byte, error)
+
+// FetchRaw call the function
+func (f SourceFunc) FetchRaw(ctx context.Context) ([]byte, error) {
+	return f(ctx)
+}
+
+// NewSource of the address, selected by its scheme:
+//
+//	host:port or tcp://host:port         the stats port, with TLS when tlsConfig is set
+//	unix:///var/run/nutcracker.sock      the stats port bound to a unix socket
+//	http://host/stats, https://host/stats the stats served over HTTP, e.g. by a sidecar
+//	file:///path/stats.json              a captured payload, a directory or glob is replayed in order
+func NewSource(address string, tlsConfig *tls.Config) (StatsSource, error) {
+	i := strings.Index(address, "://")
+	if i < 0 {
+		return &TCPSource{Address: address, TLSConfig: tlsConfig}, nil
+	}
+	switch scheme := address[:i]; scheme {
+	case "tcp":
+		return &TCPSource{Address: address[i+3:], TLSConfig: tlsConfig}, nil
+	case "unix":
+		return &UnixSource{Path: address[i+3:]}, nil
+	case "http", "https":
+		if _, err := url.Parse(address); err != nil {
+			return nil, fmt.Errorf("Invalid stats url %s. Error: %w", address, err)
+		}
+		source := &HTTPSource{URL: address}
+		if tlsConfig != nil {
+			source.Client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		}
+		return source, nil
+	case "file":
+		return NewFileSource(address[i+3:])
+	default:
+		return nil, fmt.Errorf("Unknown stats source %s in %s, expected tcp, unix, http, https or file", scheme, address)
+	}
+}
+
+// TCPSource is the stats port of twemproxy
+type TCPSource struct {
+	Address   string
+	TLSConfig *tls.Config   // nil when the stats port is plain TCP
+	Dialer    ContextDialer // of the connections, a net.Dialer when nil
+}
+
+// FetchRaw read the whole stats payload.
+// twemproxy write the stats and close the connection, so read until EOF instead of a single read
+func (s *TCPSource) FetchRaw(ctx context.Context) ([]byte, error) {
+	var conn net.Conn
+	var err error
+	dialer := contextDialer(s.Dialer)
+	if s.TLSConfig != nil {
+		conn, err = dialTLS(ctx, dialer, s.Address, s.TLSConfig)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", s.Address)
+	}
+	connectDone(ctx, err)
+	if err != nil {
+		return nil, dialError(ctx, s.Address, err)
+	}
+	return readAll(ctx, conn, s.Address)
+}
+
+// Ping connect to the stats port, much cheaper than a fetch
+func (s *TCPSource) Ping(ctx context.Context) error {
+	return ping(ctx, contextDialer(s.Dialer), "tcp", s.Address)
+}
+
+// UnixSource is the stats port of twemproxy bound to a unix socket
+type UnixSource struct {
+	Path string
+}
+
+// FetchRaw read the whole stats payload
+func (s *UnixSource) FetchRaw(ctx context.Context) ([]byte, error) {
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "unix", s.Path)
+	connectDone(ctx, err)
+	if err != nil {
+		return nil, dialError(ctx, s.Path, err)
+	}
+	return readAll(ctx, conn, s.Path)
+}
+
+// Ping connect to the socket
+func (s *UnixSource) Ping(ctx context.Context) error {
+	return ping(ctx, &net.Dialer{}, "unix", s.Path)
+}
+
+// HTTPSource read the stats from an HTTP endpoint, any non 200 response is an error
+type HTTPSource struct {
+	URL    string
+	Client *http.Client // http.DefaultClient when nil
+}
+
+// FetchRaw get the URL
+func (s *HTTPSource) FetchRaw(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, dialError(ctx, s.URL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w %s, returned %s", ErrTargetUnreachable, s.URL, resp.Status)
+	}
+	payload, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w after %d bytes from %s. Error: %w", ErrTruncatedStats, len(payload), s.URL, err)
+	}
+	return payload, nil
+}
+
+// FileSource replay captured payloads, one file per fetch in name order and starting over after the last one
+type FileSource struct {
+	Paths []string
+	next  int
+	mu    sync.Mutex
+}
+
+// NewFileSource of a file, a directory of .json files or a glob
+func NewFileSource(path string) (*FileSource, error) {
+	pattern := path
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		pattern = filepath.Join(path, "*.json")
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid stats file %s. Error: %w", path, err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("Cannot open: %s. No stats file found", path)
+	}
+	sort.Strings(paths)
+	return &FileSource{Paths: paths}, nil
+}
+
+// FetchRaw read the next file
+func (s *FileSource) FetchRaw(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	path := s.Paths[s.next%len(s.Paths)]
+	s.next++
+	s.mu.Unlock()
+
+	payload, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s. Error: %w", ErrTargetUnreachable, path, err)
+	}
+	return payload, nil
+}
+
+// dialError of a source, the error of ctx when it is done
+func dialError(ctx context.Context, address string, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("%w %s. Error: %w", ErrTargetUnreachable, address, err)
+}
+
+func ping(ctx context.Context, dialer ContextDialer, network string, address string) error {
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return dialError(ctx, address, err)
+	}
+	return conn.Close()
+}
+
+// readAll of the connection until twemproxy close it, the connection is closed
+func readAll(ctx context.Context, conn net.Conn, address string) ([]byte, error) {
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	err := conn.SetDeadline(deadline)
+	if err != nil {
+		return nil, err
+	}
+	// unblock the read when the context is canceled before the deadline
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	payload, err := ioutil.ReadAll(conn)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		// the deadline of the connection is the one of the context, which may fire a bit later
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w after %d bytes from %s. Error: %w", ErrTruncatedStats, len(payload), address, err)
+	}
+	return payload, nil
+}
+
+// contextDialer is the dialer of a source, a net.Dialer when nil
+func contextDialer(dialer ContextDialer) ContextDialer {
+	if dialer == nil {
+		return &net.Dialer{}
+	}
+	return dialer
+}
+
+func dialTLS(ctx context.Context, dialer ContextDialer, address string, config *tls.Config) (net.Conn, error) {
+	raw, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err == nil {
+			config = config.Clone()
+			config.ServerName = host
+		}
+	}
+	conn := tls.Client(raw, config)
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := conn.Handshake(); err != nil {
+		raw.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,157 @@
+package twemproxy
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewSource(t *testing.T) {
+	tests := []struct {
+		address string
+		source  StatsSource
+	}{
+		{"localhost:22222", &TCPSource{Address: "localhost:22222"}},
+		{"tcp://localhost:22222", &TCPSource{Address: "localhost:22222"}},
+		{"unix:///var/run/nutcracker.sock", &UnixSource{Path: "/var/run/nutcracker.sock"}},
+		{"http://localhost:8080/stats", &HTTPSource{URL: "http://localhost:8080/stats"}},
+	}
+	for _, test := range tests {
+		source, err := NewSource(test.address, nil)
+		if err != nil {
+			t.Errorf("Failed to create source of %s: %s", test.address, err.Error())
+			continue
+		}
+		if source == nil || !equalSource(source, test.source) {
+			t.Errorf("Expected %+v for %s, got %+v", test.source, test.address, source)
+		}
+	}
+	if _, err := NewSource("udp://localhost:22222", nil); err == nil {
+		t.Error("Expected an error for an unknown scheme")
+	}
+}
+
+func equalSource(a, b StatsSource) bool {
+	switch a := a.(type) {
+	case *TCPSource:
+		b, ok := b.(*TCPSource)
+		return ok && a.Address == b.Address
+	case *UnixSource:
+		b, ok := b.(*UnixSource)
+		return ok && a.Path == b.Path
+	case *HTTPSource:
+		b, ok := b.(*HTTPSource)
+		return ok && a.URL == b.URL
+	}
+	return false
+}
+
+func TestUnixSource(t *testing.T) {
+	payload, err := ioutil.ReadFile("../../files/example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "twemproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write(payload)
+			conn.Close()
+		}
+	}()
+
+	source, err := NewSource("unix://"+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := (&Client{Source: source}).Fetch(context.Background())
+	if err != nil {
+		t.Fatal("Failed to fetch stats: ", err.Error())
+	}
+	if stats.TotalConnections != 64592 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if err := source.(Pinger).Ping(context.Background()); err != nil {
+		t.Errorf("Expected the socket to be up, got %s", err.Error())
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, "../../files/example.json")
+	}))
+	defer server.Close()
+
+	stats, err := (&Client{Source: &HTTPSource{URL: server.URL + "/stats"}}).Fetch(context.Background())
+	if err != nil {
+		t.Fatal("Failed to fetch stats: ", err.Error())
+	}
+	if stats.TotalConnections != 64592 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	_, err = (&HTTPSource{URL: server.URL + "/missing"}).FetchRaw(context.Background())
+	if !errors.Is(err, ErrTargetUnreachable) {
+		t.Errorf("Expected ErrTargetUnreachable for a 404, got %v", err)
+	}
+}
+
+func TestFileSourceReplay(t *testing.T) {
+	source, err := NewSource("file://../../files/schema", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := source.(*FileSource).Paths
+	if len(files) < 2 {
+		t.Fatalf("Expected every payload of the directory, got %v", files)
+	}
+	versions := make(map[string]bool)
+	for range files {
+		stats, err := (&Client{Source: source}).Fetch(context.Background())
+		if err != nil {
+			t.Fatal("Failed to replay stats: ", err.Error())
+		}
+		versions[stats.Version] = true
+	}
+	if len(versions) < 2 {
+		t.Errorf("Expected the payloads replayed in turn, got versions %v", versions)
+	}
+
+	if _, err := NewSource("file://../../files/missing.json", nil); err == nil {
+		t.Error("Expected an error without any file")
+	}
+}
+
+func TestSourceFunc(t *testing.T) {
+	calls := 0
+	source := SourceFunc(func(ctx context.Context) ([]byte, error) {
+		calls++
+		return []byte(`{"service": "nutcracker", "total_connections": 3}`), nil
+	})
+	stats, err := (&Client{Source: source}).Fetch(context.Background())
+	if err != nil || stats.TotalConnections != 3 || calls != 1 {
+		t.Errorf("Expected the stats of the function, got %+v %v", stats, err)
+	}
+}
@@ -0,0 +1,153 @@
+package twemproxy
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Stats of one twemproxy, as written on its stats port
+type Stats struct {
+	Service          string
+	Source           string
+	Version          string
+	Uptime           float64
+	Timestamp        float64
+	TotalConnections float64
+	CurrConnections  float64
+	Pools            map[string]Pool
+}
+
+// Pool stats, every server of the pool by name
+type Pool struct {
+	ClientEOF         float64
+	ClientErr         float64
+	ClientConnections float64
+	ServerEjects      float64
+	ForwardError      float64
+	Fragments         float64
+	Servers           map[string]Server
+}
+
+// Server stats of a backend server of a pool
+type Server struct {
+	ServerEOF         float64
+	ServerErr         float64
+	ServerTimedout    float64
+	ServerConnections float64
+	ServerEjectedAt   float64
+	Requests          float64
+	RequestBytes      float64
+	Responses         float64
+	ResponseBytes     float64
+	InQueue           float64
+	InQueueBytes      float64
+	OutQueue          float64
+	OutQueueBytes     float64
+}
+
+// fieldAliases list the names a field is known by across twemproxy versions and forks
+var fieldAliases = map[string][]string{
+	"server_timedout": {"server_timedout", "server_timeout"},
+}
+
+// number return the numeric field by its name or any of its aliases
+// missing fields are reported as 0, older twemproxy versions simply don't have them
+func number(m map[string]interface{}, key string) float64 {
+	names, ok := fieldAliases[key]
+	if !ok {
+		names = []string{key}
+	}
+	for _, name := range names {
+		if val, ok := m[name].(float64); ok {
+			return val
+		}
+	}
+	return 0
+}
+
+func str(m map[string]interface{}, key string) string {
+	val, _ := m[key].(string)
+	return val
+}
+
+// hasAny of the keys
+func hasAny(m map[string]interface{}, keys ...string) bool {
+	for _, key := range keys {
+		if _, ok := m[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Decode the stats payload, every JSON object at the top level is a pool and every object in a pool a server
+func Decode(payload []byte) (*Stats, error) {
+	var value interface{}
+	err := json.Unmarshal(payload, &value)
+	if err != nil {
+		if syntaxErr, ok := err.(*json.SyntaxError); ok && syntaxErr.Offset >= int64(len(payload)) {
+			return nil, fmt.Errorf("%w, %d bytes. Error: %w", ErrTruncatedStats, len(payload), err)
+		}
+		return nil, fmt.Errorf("%w, %w. Error: %w", ErrSchemaMismatch, ErrInvalidJSON, err)
+	}
+	doc, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w, expected a JSON object", ErrSchemaMismatch)
+	}
+	if !hasAny(doc, "service", "uptime", "timestamp", "total_connections") {
+		return nil, fmt.Errorf("%w, missing service, uptime and timestamp", ErrSchemaMismatch)
+	}
+
+	stats := &Stats{
+		Service:          str(doc, "service"),
+		Source:           str(doc, "source"),
+		Version:          str(doc, "version"),
+		Uptime:           number(doc, "uptime"),
+		Timestamp:        number(doc, "timestamp"),
+		TotalConnections: number(doc, "total_connections"),
+		CurrConnections:  number(doc, "curr_connections"),
+		Pools:            make(map[string]Pool),
+	}
+	for name, value := range doc {
+		pool, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		stats.Pools[name] = decodePool(pool)
+	}
+	return stats, nil
+}
+
+func decodePool(pool map[string]interface{}) Pool {
+	p := Pool{
+		ClientEOF:         number(pool, "client_eof"),
+		ClientErr:         number(pool, "client_err"),
+		ClientConnections: number(pool, "client_connections"),
+		ServerEjects:      number(pool, "server_ejects"),
+		ForwardError:      number(pool, "forward_error"),
+		Fragments:         number(pool, "fragments"),
+		Servers:           make(map[string]Server),
+	}
+	for name, value := range pool {
+		srv, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		p.Servers[name] = Server{
+			ServerEOF:         number(srv, "server_eof"),
+			ServerErr:         number(srv, "server_err"),
+			ServerTimedout:    number(srv, "server_timedout"),
+			ServerConnections: number(srv, "server_connections"),
+			ServerEjectedAt:   number(srv, "server_ejected_at"),
+			Requests:          number(srv, "requests"),
+			RequestBytes:      number(srv, "request_bytes"),
+			Responses:         number(srv, "responses"),
+			ResponseBytes:     number(srv, "response_bytes"),
+			InQueue:           number(srv, "in_queue"),
+			InQueueBytes:      number(srv, "in_queue_bytes"),
+			OutQueue:          number(srv, "out_queue"),
+			OutQueueBytes:     number(srv, "out_queue_bytes"),
+		}
+	}
+	return p
+}
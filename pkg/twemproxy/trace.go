@@ -0,0 +1,31 @@
+package twemproxy
+
+import (
+	"context"
+)
+
+// ClientTrace hooks of a fetch, set on its context with WithClientTrace like net/http/httptrace.
+// Any hook may be nil
+type ClientTrace struct {
+	// ConnectDone after the connection to the stats port, the TLS handshake included, err is the one of the dial
+	ConnectDone func(err error)
+}
+
+type clientTraceKey struct{}
+
+// WithClientTrace return a copy of ctx calling the hooks of trace during the fetches made with it
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	return context.WithValue(ctx, clientTraceKey{}, trace)
+}
+
+// ContextClientTrace of ctx, nil when there is none
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceKey{}).(*ClientTrace)
+	return trace
+}
+
+func connectDone(ctx context.Context, err error) {
+	if trace := ContextClientTrace(ctx); trace != nil && trace.ConnectDone != nil {
+		trace.ConnectDone(err)
+	}
+}